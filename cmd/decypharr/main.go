@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
 	"github.com/sirrobot01/decypharr/pkg/qbit"
 	"github.com/sirrobot01/decypharr/pkg/server"
 	"github.com/sirrobot01/decypharr/pkg/store"
@@ -17,6 +19,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"sync"
+	"time"
 )
 
 func Start(ctx context.Context) error {
@@ -72,6 +75,7 @@ func Start(ctx context.Context) error {
 			"/webdav": webdavRoutes,
 		}
 		srv := server.New(handlers)
+		request.PruneRateLimiters() // The clients are built, the limiters left are those of the previous config only
 
 		done := make(chan struct{})
 		go func(ctx context.Context) {
@@ -107,6 +111,9 @@ func Start(ctx context.Context) error {
 	}
 }
 
+// shutdownGrace is waited for on top of shutdown_timeout, for the services to save their state once drained
+const shutdownGrace = 10 * time.Second
+
 func startServices(ctx context.Context, cancelSvc context.CancelFunc, wd *webdav.WebDav, srv *server.Server) error {
 	var wg sync.WaitGroup
 	errChan := make(chan error)
@@ -189,5 +196,33 @@ func startServices(ctx context.Context, cancelSvc context.CancelFunc, wd *webdav
 	// Wait for context cancellation
 	<-ctx.Done()
 	_log.Debug().Msg("Services context cancelled")
+
+	// The services drain within shutdown_timeout, in-flight WebDav streams and repair jobs included
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(config.Get().GetShutdownTimeout() + shutdownGrace):
+		_log.Warn().Msg("Services still running after the shutdown timeout, stopping anyway")
+	}
+	return nil
+}
+
+// OAuthLogin runs the OAuth device flow of a debrid from the terminal
+func OAuthLogin(ctx context.Context, name string) error {
+	dc, ok := config.Get().GetDebrid(name)
+	if !ok {
+		return fmt.Errorf("debrid %s not found", name)
+	}
+	err := debrid.OAuthLogin(ctx, dc, func(verificationURL, userCode string) {
+		fmt.Printf("Open %s and enter the code %s\n", verificationURL, userCode)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s logged in, the token is saved to %s\n", name, config.Get().OAuthFile())
 	return nil
 }
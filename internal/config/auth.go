@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrAuthConfigured is returned by SetupAuth once the credentials are set
+var ErrAuthConfigured = errors.New("credentials are already set")
+
+// authSetupMu makes SetupAuth accept a single setup, even when requests race
+var authSetupMu sync.Mutex
+
+// SetupAuth sets the first credentials of the UI, hashing the password. It fails with ErrAuthConfigured once
+// credentials are set, they are then only changed by editing auth.json.
+func (c *Config) SetupAuth(username, password string) error {
+	authSetupMu.Lock()
+	defer authSetupMu.Unlock()
+	if !c.NeedsAuth() {
+		return ErrAuthConfigured
+	}
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if err := c.CheckPassword(password); err != nil {
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("invalid password: %w", err)
+	}
+	return c.SaveAuth(&Auth{Username: username, Password: string(hashed)})
+}
+
+// GetPasswordMinLength returns PasswordMinLength, falling back to 8
+func (c *Config) GetPasswordMinLength() int {
+	if c.PasswordMinLength <= 0 {
+		return 8
+	}
+	return c.PasswordMinLength
+}
+
+// CheckPassword reports whether a password meets password_min_length and password_min_classes
+func (c *Config) CheckPassword(password string) error {
+	minLength := c.GetPasswordMinLength()
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	}
+	if len(password) > 72 {
+		// bcrypt ignores the bytes past 72
+		return fmt.Errorf("password must be at most 72 bytes")
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if minClasses := c.PasswordMinClasses; classes < minClasses {
+		return fmt.Errorf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", minClasses)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		minLength  int
+		minClasses int
+		password   string
+		valid      bool
+	}{
+		{0, 0, "abcdefgh", true},
+		{0, 0, "abcdefg", false},
+		{4, 0, "abcd", true},
+		{0, 3, "abcdefgh", false},
+		{0, 3, "abcdEFG1", true},
+		{0, 4, "abcdEF1!", true},
+		{0, 0, strings.Repeat("a", 73), false}, // Past what bcrypt hashes
+		{0, 0, "ééééééé", false},               // Length counted in characters, not bytes
+	}
+	for _, tt := range tests {
+		c := &Config{PasswordMinLength: tt.minLength, PasswordMinClasses: tt.minClasses}
+		if err := c.CheckPassword(tt.password); (err == nil) != tt.valid {
+			t.Errorf("CheckPassword(%q) with minimums %d, %d: %v, want valid %v", tt.password, tt.minLength, tt.minClasses, err, tt.valid)
+		}
+	}
+}
+
+// Only one of concurrent first-run setups is accepted
+func TestSetupAuth(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{Path: dir, UseAuth: true}
+	if err := c.SetupAuth("admin", "short"); err == nil {
+		t.Error("SetupAuth() accepted a password under password_min_length")
+	}
+	if err := c.SetupAuth(" ", "password1"); err == nil {
+		t.Error("SetupAuth() accepted a blank username")
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted []string
+	)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.SetupAuth(username, "password1")
+			if err != nil && !errors.Is(err, ErrAuthConfigured) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				accepted = append(accepted, username)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(accepted) != 1 {
+		t.Fatalf("accepted setups %v, want one", accepted)
+	}
+
+	// The saved credentials are read back with a hashed password
+	saved := (&Config{Path: dir, UseAuth: true}).GetAuth()
+	if saved.Username != accepted[0] || bcrypt.CompareHashAndPassword([]byte(saved.Password), []byte("password1")) != nil {
+		t.Errorf("saved credentials = %s, want %s with the hashed password", saved.Username, accepted[0])
+	}
+}
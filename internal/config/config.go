@@ -1,15 +1,21 @@
 package config
 
 import (
+	"bytes"
 	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
-	"runtime"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type RepairStrategy string
@@ -19,26 +25,323 @@ const (
 	RepairStrategyPerTorrent RepairStrategy = "per_torrent"
 )
 
+type DebridRole string
+
+const (
+	DebridRolePrimary DebridRole = "primary"
+	DebridRoleStandby DebridRole = "standby" // Only used when all primary debrids fail
+)
+
+// StreamingBehavior is how the repair worker yields to active WebDav streams
+type StreamingBehavior string
+
+const (
+	StreamingPause    StreamingBehavior = "pause"    // Wait until streaming quiets down
+	StreamingThrottle StreamingBehavior = "throttle" // Keep going, one item at a time with a delay
+)
+
+// DeleteDuringRepair is what a deletion does to a torrent being reinserted by the repair
+type DeleteDuringRepair string
+
+const (
+	DeleteDuringRepairAbort DeleteDuringRepair = "abort" // Abort the reinsert, its new copy is removed, then delete the torrent
+	DeleteDuringRepairWait  DeleteDuringRepair = "wait"  // Let the reinsert finish, then delete the torrent, its new copy included
+)
+
+// ReInsertFailure is what happens to a torrent whose reinsertion failed. It is kept either way, the new copy is removed
+type ReInsertFailure string
+
+const (
+	ReInsertFailureBad  ReInsertFailure = "bad"  // Move the torrent to the __bad__ folder
+	ReInsertFailureKeep ReInsertFailure = "keep" // Leave the torrent listed where it was
+)
+
+// LogFormat is how the log lines are written, to the console and to the log file
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text" // Human readable lines
+	LogFormatJSON LogFormat = "json" // One JSON object per line, for log shippers
+)
+
+// RateLimitReload is what happens to the rate limiters of the debrids when the services are restarted for a config change
+type RateLimitReload string
+
+const (
+	RateLimitReloadCarry RateLimitReload = "carry" // Keep the limiters, set to the new rates, their waiting requests and accounting carry over
+	RateLimitReloadReset RateLimitReload = "reset" // Start new limiters, with their full slack
+)
+
+// DuplicateDebridNames is what happens when several debrids share the same name
+type DuplicateDebridNames string
+
+const (
+	DuplicateDebridError  DuplicateDebridNames = "error"  // Refuse the config
+	DuplicateDebridRename DuplicateDebridNames = "rename" // Suffix the duplicates, e.g realdebrid-2
+)
+
+// CachedCheckStrategy is how a debrid confirms that a torrent is cached when check_cached is enabled
+type CachedCheckStrategy string
+
+const (
+	CachedCheckAPI       CachedCheckStrategy = "api"        // Trust the availability endpoint of the provider
+	CachedCheckProbeLink CachedCheckStrategy = "probe-link" // Generate a download link once added, for providers with an unreliable availability endpoint
+	CachedCheckHybrid    CachedCheckStrategy = "hybrid"     // Check the availability endpoint, then confirm with a download link
+)
+
+// RateLimitScope is what the rate limits of a debrid with several API keys apply to
+type RateLimitScope string
+
+const (
+	RateLimitScopeKey     RateLimitScope = "key"     // Each API key has its own limit
+	RateLimitScopeAccount RateLimitScope = "account" // All the API keys share the limit, e.g. keys of the same account
+)
+
+// IPLockedLinks is how links of a debrid that IP-locks them are served when WebDav redirects clients to the debrid(serve_from_rclone)
+type IPLockedLinks string
+
+const (
+	IPLockedLinksProxy      IPLockedLinks = "proxy"      // Stream the file through Decypharr instead of redirecting
+	IPLockedLinksRegenerate IPLockedLinks = "regenerate" // Generate a link for the client IP and redirect to it, proxy if the provider can't
+	IPLockedLinksError      IPLockedLinks = "error"      // Refuse the request with an error explaining the link is IP-locked
+)
+
+// ChecksumMismatch is what happens to a downloaded file whose checksum doesn't match the one reported by the debrid
+type ChecksumMismatch string
+
+const (
+	ChecksumMismatchError      ChecksumMismatch = "error"      // Mark the torrent as errored
+	ChecksumMismatchRedownload ChecksumMismatch = "redownload" // Download the file once more, mark the torrent as errored if it still doesn't match
+)
+
+// StaleHandles is what happens to the WebDav streams of a torrent when the repair reinserts it, their links
+// belong to the replaced torrent
+type StaleHandles string
+
+const (
+	StaleHandlesResolve   StaleHandles = "resolve"   // Keep streaming, a stream failing afterward resumes from a link of the reinserted torrent
+	StaleHandlesTerminate StaleHandles = "terminate" // End the streams, clients retry and open the reinserted torrent
+)
+
+// MissingContentLength is what the WebDav answers for a stream the provider sends without a Content-Length, e.g. a
+// chunked response, players can't seek a stream of unknown length
+type MissingContentLength string
+
+const (
+	MissingContentLengthMetadata MissingContentLength = "metadata" // Report the file size known from the torrent, cutting the stream at it
+	MissingContentLengthChunked  MissingContentLength = "chunked"  // Stream the response without a length, as the provider sent it
+)
+
+// ExpiryAction is what happens to a torrent the provider reports it will soon delete, e.g. for inactivity
+type ExpiryAction string
+
+const (
+	ExpiryActionKeepAlive ExpiryAction = "keep_alive" // Access the torrent so the provider sees it as active, notify if that fails
+	ExpiryActionNotify    ExpiryAction = "notify"     // Only notify, so the torrent can be saved by hand
+	ExpiryActionNone      ExpiryAction = "none"       // Let the provider delete it
+)
+
+// APIKeyChange is what happens when the api_key of an existing debrid is changed from the UI
+type APIKeyChange string
+
+const (
+	APIKeyChangeValidate APIKeyChange = "validate" // Check the new key against the provider, the change is rejected if it fails
+	APIKeyChangeAccept   APIKeyChange = "accept"   // Save the new key without checking it
+)
+
+// ArchiveFilter is what allowed_file_types and the file size limits are checked against for the archives unpacked
+// with unpack_rar
+type ArchiveFilter string
+
+const (
+	ArchiveFilterContents ArchiveFilter = "contents" // The files unpacked from the archive, the ones not allowed are left out
+	ArchiveFilterArchive  ArchiveFilter = "archive"  // The archive alone, all of its files are exposed
+)
+
+// DiscordOverflow is what happens to a Discord notification longer than Discord accepts
+type DiscordOverflow string
+
+const (
+	DiscordOverflowTruncate DiscordOverflow = "truncate" // Cut the message, ending it with an ellipsis
+	DiscordOverflowSplit    DiscordOverflow = "split"    // Send the message as several notifications
+)
+
+// NotificationDedup is what happens to a notification identical to one sent within the notification_dedup_window
+type NotificationDedup string
+
+const (
+	NotificationDedupSuppress  NotificationDedup = "suppress"  // Drop the repeats
+	NotificationDedupSummarize NotificationDedup = "summarize" // Drop the repeats, then send how many there were once the window ends
+)
+
+// ArrSearchMode is how the arrs are asked to search again for the files deleted by the repair
+type ArrSearchMode string
+
+const (
+	ArrSearchItems   ArrSearchMode = "items"   // Search for the seasons and movies of the deleted files
+	ArrSearchMissing ArrSearchMode = "missing" // A single search for everything missing in the arr
+)
+
+// NamelessMagnetPolicy is the name given to a magnet without a display name(dn), until the debrid reports one
+type NamelessMagnetPolicy string
+
+const (
+	NamelessMagnetInfoHash NamelessMagnetPolicy = "infohash" // Use the infohash
+	NamelessMagnetTemplate NamelessMagnetPolicy = "template" // Use NamelessMagnetTemplate
+	NamelessMagnetMetadata NamelessMagnetPolicy = "metadata" // Wait for the debrid metadata before creating symlinks or WebDav folders, the infohash is shown meanwhile
+)
+
+// ArrSourcePreference is the source whose host and token win when an arr is both configured and auto-detected
+type ArrSourcePreference string
+
+const (
+	ArrSourceConfig ArrSourcePreference = "config" // Configured values win, detected values fill the gaps
+	ArrSourceAuto   ArrSourcePreference = "auto"   // Detected values win
+)
+
+// UnknownSizePolicy is how files whose size the debrid hasn't reported yet are checked against the file size limits
+type UnknownSizePolicy string
+
+const (
+	UnknownSizeOptimistic  UnknownSizePolicy = "optimistic"  // Allow the files, they aren't checked again
+	UnknownSizePessimistic UnknownSizePolicy = "pessimistic" // Allow the files until downloaded, then check their sizes and fail the torrent if none fits
+)
+
+// MaintenanceAdds is how torrents added while Decypharr is in maintenance mode are handled
+type MaintenanceAdds string
+
+const (
+	MaintenanceAddsQueue  MaintenanceAdds = "queue"  // Accept the torrent as queued, it is sent to the debrid when maintenance ends, in the order it was added
+	MaintenanceAddsReject MaintenanceAdds = "reject" // Refuse the torrent, the arr sees the add fail
+	MaintenanceAddsHold   MaintenanceAdds = "hold"   // Accept the torrent as paused, it is sent to the debrid once resumed
+)
+
+// NoVideoPolicy is what happens to a torrent with no playable video file once added
+type NoVideoPolicy string
+
+const (
+	NoVideoAllow  NoVideoPolicy = "allow"  // Process the torrent as usual
+	NoVideoFlag   NoVideoPolicy = "flag"   // Process the torrent, but log and notify about it
+	NoVideoReject NoVideoPolicy = "reject" // Mark the torrent as failed, the arr will blocklist it and search again
+)
+
+// checkCachedBatchLimits is the maximum number of hashes per availability request of each provider
+var checkCachedBatchLimits = map[string]int{
+	"realdebrid": 200,
+	"torbox":     100,
+	"debridlink": 100,
+}
+
+// ErrReadOnly is returned when trying to persist a config that was loaded from a read-only file
+var ErrReadOnly = errors.New("config file is read-only")
+
 var (
 	instance   *Config
-	once       sync.Once
+	instanceMu sync.RWMutex // Guards instance and once, swapped by a reload
+	once       = &sync.Once{}
+	saveMu     sync.Mutex // Serializes updates and saves of every instance, so a save never writes a half-applied update
 	configPath string
+
+	lenientLoad bool // Load as much as possible of a malformed config file instead of failing
+
+	// Initial config creation
+	templatePath string // Config file used as the initial config instead of the defaults
+	skipAuth     bool   // Create the initial config without authentication
 )
 
 type Debrid struct {
-	Name              string   `json:"name,omitempty"`
-	APIKey            string   `json:"api_key,omitempty"`
-	DownloadAPIKeys   []string `json:"download_api_keys,omitempty"`
-	Folder            string   `json:"folder,omitempty"`
-	DownloadUncached  bool     `json:"download_uncached,omitempty"`
-	CheckCached       bool     `json:"check_cached,omitempty"`
-	RateLimit         string   `json:"rate_limit,omitempty"` // 200/minute or 10/second
-	RepairRateLimit   string   `json:"repair_rate_limit,omitempty"`
-	DownloadRateLimit string   `json:"download_rate_limit,omitempty"`
-	Proxy             string   `json:"proxy,omitempty"`
-	UnpackRar         bool     `json:"unpack_rar,omitempty"`
-	AddSamples        bool     `json:"add_samples,omitempty"`
-	MinimumFreeSlot   int      `json:"minimum_free_slot,omitempty"` // Minimum active pots to use this debrid
+	Name               string     `json:"name,omitempty"`
+	APIKey             string     `json:"api_key,omitempty"`
+	DownloadAPIKeys    []string   `json:"download_api_keys,omitempty"`
+	Folder             string     `json:"folder,omitempty"`
+	DownloadUncached   bool       `json:"download_uncached,omitempty"`
+	CheckCached        bool       `json:"check_cached,omitempty"`
+	RateLimit          string     `json:"rate_limit,omitempty"` // 200/minute or 10/second
+	RepairRateLimit    string     `json:"repair_rate_limit,omitempty"`
+	DownloadRateLimit  string     `json:"download_rate_limit,omitempty"`
+	Proxy              string     `json:"proxy,omitempty"`
+	ForbiddenProxy     string     `json:"forbidden_proxy,omitempty"` // Proxy a request answered 403 is retried through once
+	UnpackRar          bool       `json:"unpack_rar,omitempty"`
+	AddSamples         bool       `json:"add_samples,omitempty"`
+	MinimumFreeSlot    int        `json:"minimum_free_slot,omitempty"` // Minimum active pots to use this debrid, counted on the api_key account holding the torrents
+	Role               DebridRole `json:"role,omitempty"`
+	FullDeleteOnRemove bool       `json:"full_delete_on_remove,omitempty"` // Remove the torrent from the debrid when an arr deletes it with its files
+
+	RateLimitScope RateLimitScope `json:"rate_limit_scope,omitempty"` // What the rate limits apply to with several API keys
+
+	UncachedFolder          string `json:"uncached_folder,omitempty"`           // Download folder of uncached torrents, defaults to the qbittorrent download folder
+	CachedPromotionInterval string `json:"cached_promotion_interval,omitempty"` // How often uncached downloads are checked for becoming cached, disabled if empty
+
+	CachedCheckStrategy  CachedCheckStrategy `json:"cached_check_strategy,omitempty"`   // How check_cached confirms a torrent is cached
+	CheckCachedBatchSize int                 `json:"check_cached_batch_size,omitempty"` // Hashes per availability request, defaults to the provider maximum
+	CheckCachedWindow    string              `json:"check_cached_window,omitempty"`     // Concurrent cached checks within it are sent as one request
+	CheckCachedTTL       string              `json:"check_cached_ttl,omitempty"`        // How long a cached check result is reused, 0 disables it
+
+	// Links only usable from the IP that generated them
+	IPLocked         bool          `json:"ip_locked,omitempty"`
+	IPLockedBehavior IPLockedLinks `json:"ip_locked_behavior,omitempty"`
+
+	// Hosts a download link is retried on, with the same path, when its CDN host fails mid-stream
+	CDNFailoverHosts []string `json:"cdn_failover_hosts,omitempty"`
+
+	// Torrents with thousands of files
+	LargeTorrentFiles      int `json:"large_torrent_files,omitempty"`       // Files from which a torrent is large, -1 disables it
+	LargeTorrentCheckLimit int `json:"large_torrent_check_limit,omitempty"` // Files of a large torrent checked at once by the repair
+
+	// Verify downloaded files against the checksums reported by the debrid, if it reports any
+	VerifyChecksums  bool             `json:"verify_checksums,omitempty"`
+	ChecksumMismatch ChecksumMismatch `json:"checksum_mismatch,omitempty"`
+
+	StaleHandles StaleHandles `json:"stale_handles,omitempty"` // WebDav streams of a torrent reinserted by the repair
+
+	MissingContentLength MissingContentLength `json:"missing_content_length,omitempty"` // WebDav streams the provider sends without a Content-Length
+
+	Endpoint string `json:"endpoint,omitempty"` // Base URL of the provider API, e.g. a regional endpoint, instead of the default one
+
+	// File size limits of the torrents of this debrid, override the global ones when set
+	MinFileSize string `json:"min_file_size,omitempty"`
+	MaxFileSize string `json:"max_file_size,omitempty"`
+
+	// Torrents the provider reports it will delete, only providers reporting an expiry
+	ExpiryAction  ExpiryAction `json:"expiry_action,omitempty"`
+	ExpiryWarning string       `json:"expiry_warning,omitempty"` // How long before the expiry the action is taken
+
+	APIKeyChange APIKeyChange `json:"api_key_change,omitempty"` // A new api_key set from the UI
+
+	OAuthClientID string `json:"oauth_client_id,omitempty"` // Log in with the OAuth device flow instead of api_key, the tokens are kept in OAuthFile
+
+	// HTTP connection pool
+	MaxIdleConns    int    `json:"max_idle_conns,omitempty"`
+	MaxConnsPerHost int    `json:"max_conns_per_host,omitempty"` // 0 means no limit
+	IdleConnTimeout string `json:"idle_conn_timeout,omitempty"`
+
+	PremiumCheckInterval string `json:"premium_check_interval,omitempty"` // How often to re-check for an expired premium, 0 disables it
+	HealthCheckInterval  string `json:"health_check_interval,omitempty"`  // How often the provider API is pinged for /api/health, 0 disables it
+
+	// Slow-start providers, links may not be generatable right after a torrent is downloaded
+	ReadinessDelay   string `json:"readiness_delay,omitempty"`   // Wait before generating links
+	ReadinessTimeout string `json:"readiness_timeout,omitempty"` // Poll the status until downloaded, then probe a link, for up to this long. Disabled if empty
+
+	// Retries of the transient errors getting a download link, hoster unavailable, traffic exceeded or rate limited
+	RetryAttempts  int    `json:"retry_attempts,omitempty"`   // Attempts, the first one included, -1 disables the retries
+	RetryBaseDelay string `json:"retry_base_delay,omitempty"` // Delay before the first retry, doubled on each retry
+
+	// Soft ban protection, slows down after repeated rate limits
+	SoftBanThreshold int    `json:"soft_ban_threshold,omitempty"` // Rate limited responses within SoftBanWindow, -1 disables it
+	SoftBanWindow    string `json:"soft_ban_window,omitempty"`
+	SoftBanCooldown  string `json:"soft_ban_cooldown,omitempty"`
+	SoftBanRateLimit string `json:"soft_ban_rate_limit,omitempty"` // Rate limit used until the cooldown passes
+
+	CircuitBreaker CircuitBreaker `json:"circuit_breaker,omitempty"`
+
+	// Traffic budget, new downloads avoid the debrid once most of it is used
+	TrafficBudget          string `json:"traffic_budget,omitempty"`           // Bytes served and downloaded within TrafficBudgetWindow, 500GB etc. No budget if empty
+	TrafficBudgetWindow    string `json:"traffic_budget_window,omitempty"`    // Rolling window of the budget, 24h or 720h
+	TrafficBudgetThreshold int    `json:"traffic_budget_threshold,omitempty"` // Percentage of the budget from which the debrid is avoided
+
+	// Unlock quota, new downloads avoid the debrid once most of its daily download links are generated
+	UnlockQuota          int `json:"unlock_quota,omitempty"`           // Download links generated per day, reset at midnight UTC. No quota if 0
+	UnlockQuotaThreshold int `json:"unlock_quota_threshold,omitempty"` // Percentage of the quota from which the debrid is avoided
 
 	UseWebDav bool `json:"use_webdav,omitempty"`
 	WebDav
@@ -53,6 +356,34 @@ type QBitTorrent struct {
 	RefreshInterval int      `json:"refresh_interval,omitempty"`
 	SkipPreCache    bool     `json:"skip_pre_cache,omitempty"`
 	MaxDownloads    int      `json:"max_downloads,omitempty"`
+	MaindataHistory int      `json:"maindata_history,omitempty"` // sync/maindata responses kept to answer with what changed since, -1 always sends everything
+
+	CategorySettings map[string]CategoryConfig `json:"category_settings,omitempty"` // Overrides of the settings above, by category
+}
+
+// CategoryConfig overrides the qbittorrent settings for the torrents of a category, unset fields keep the global ones
+type CategoryConfig struct {
+	DownloadFolder string `json:"download_folder,omitempty"`
+	MaxDownloads   int    `json:"max_downloads,omitempty"` // Files of the category downloaded at once, instead of counting toward max_downloads
+	SkipPreCache   *bool  `json:"skip_pre_cache,omitempty"`
+}
+
+// CategoryDownloadFolder returns the download folder of the torrents of category
+func (q QBitTorrent) CategoryDownloadFolder(category string) string {
+	return cmp.Or(q.CategorySettings[category].DownloadFolder, q.DownloadFolder)
+}
+
+// CategoryMaxDownloads returns the files of category downloaded at once, 0 if they count toward max_downloads
+func (q QBitTorrent) CategoryMaxDownloads(category string) int {
+	return q.CategorySettings[category].MaxDownloads
+}
+
+// CategorySkipPreCache reports whether the files of category aren't pre-cached
+func (q QBitTorrent) CategorySkipPreCache(category string) bool {
+	if skip := q.CategorySettings[category].SkipPreCache; skip != nil {
+		return *skip
+	}
+	return q.SkipPreCache
 }
 
 type Arr struct {
@@ -64,6 +395,44 @@ type Arr struct {
 	DownloadUncached *bool  `json:"download_uncached,omitempty"`
 	SelectedDebrid   string `json:"selected_debrid,omitempty"`
 	Source           string `json:"source,omitempty"` // The source of the arr, e.g. "auto", "config", "". Auto means it was automatically detected from the arr
+
+	// WebDav exposure
+	MainFileOnly      bool `json:"main_file_only,omitempty"`      // Only expose the largest video file of a torrent
+	KeepSubtitleFiles bool `json:"keep_subtitle_files,omitempty"` // Also expose subtitles when MainFileOnly is set
+
+	DebridPriority []string `json:"debrid_priority,omitempty"` // Debrids tried first for torrents of this category, in order
+
+	// File size limits of the torrents of this arr, override the debrid and global ones when set
+	MinFileSize string `json:"min_file_size,omitempty"`
+	MaxFileSize string `json:"max_file_size,omitempty"`
+
+	RepairStrategy RepairStrategy `json:"repair_strategy,omitempty"` // Repair strategy of the torrents of this arr, overrides repair.strategy when set
+}
+
+// CircuitBreaker stops sending requests to a debrid failing repeatedly, e.g. with hoster unavailable errors,
+// then tries a single request once the cooldown passed
+type CircuitBreaker struct {
+	Threshold int    `json:"threshold,omitempty"` // Failures in a row within Window opening the breaker, -1 disables it
+	Window    string `json:"window,omitempty"`
+	Cooldown  string `json:"cooldown,omitempty"` // How long the breaker stays open before a trial request
+}
+
+// GetWindow returns the parsed Window, falling back to 1 minute
+func (b CircuitBreaker) GetWindow() time.Duration {
+	window, err := time.ParseDuration(b.Window)
+	if err != nil || window <= 0 {
+		return time.Minute
+	}
+	return window
+}
+
+// GetCooldown returns the parsed Cooldown, falling back to 2 minutes
+func (b CircuitBreaker) GetCooldown() time.Duration {
+	cooldown, err := time.ParseDuration(b.Cooldown)
+	if err != nil || cooldown <= 0 {
+		return 2 * time.Minute
+	}
+	return cooldown
 }
 
 type Repair struct {
@@ -75,126 +444,1132 @@ type Repair struct {
 	Workers     int            `json:"workers,omitempty"`
 	ReInsert    bool           `json:"reinsert,omitempty"`
 	Strategy    RepairStrategy `json:"strategy,omitempty"`
+
+	MinRepairInterval string `json:"min_repair_interval,omitempty"` // Minimum time between repairs of the same torrent, e.g 6h
+
+	ReInsertFailure ReInsertFailure `json:"reinsert_failure,omitempty"`
+
+	DeleteDuringRepair DeleteDuringRepair `json:"delete_during_repair,omitempty"`
+
+	DryRun bool `json:"dry_run,omitempty"` // Report what the repair would do without changing anything
+
+	// Yield to WebDav streaming
+	StreamingThreshold   int               `json:"streaming_threshold,omitempty"`    // Active streams from which the repair yields, 0 disables it
+	StreamingBehavior    StreamingBehavior `json:"streaming_behavior,omitempty"`     // pause or throttle
+	StreamingResumeAfter string            `json:"streaming_resume_after,omitempty"` // Quiet time after the last stream before the repair resumes
+}
+
+// NamelessMagnetName returns the name of a torrent whose magnet has no display name, until the debrid reports one
+func (c *Config) NamelessMagnetName(infohash string) string {
+	infohash = strings.ToLower(infohash)
+	if c.NamelessMagnetPolicy == NamelessMagnetTemplate {
+		return strings.ReplaceAll(cmp.Or(c.NamelessMagnetTemplate, "Unnamed {hash}"), "{hash}", infohash)
+	}
+	return infohash
+}
+
+// GetArrTimeout returns the parsed ArrTimeout, falling back to 60 seconds
+func (c *Config) GetArrTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.ArrTimeout)
+	if err != nil || timeout <= 0 {
+		return 60 * time.Second
+	}
+	return timeout
+}
+
+// GetArrSearchBatchWindow returns the parsed ArrSearchBatchWindow, 0 if the re-searches are not batched
+func (c *Config) GetArrSearchBatchWindow() time.Duration {
+	window, err := time.ParseDuration(c.ArrSearchBatchWindow)
+	if err != nil || window < 0 {
+		return 0
+	}
+	return window
+}
+
+// GetCompactTorrentsInterval returns the parsed CompactTorrentsInterval, 0 if the scheduled compaction is disabled
+func (c *Config) GetCompactTorrentsInterval() time.Duration {
+	interval, err := time.ParseDuration(c.CompactTorrentsInterval)
+	if err != nil || interval < 0 {
+		return 0
+	}
+	return interval
+}
+
+// GetFailedTorrentsRetention returns the parsed FailedTorrentsRetention, 0 if failed torrents are kept
+func (c *Config) GetFailedTorrentsRetention() time.Duration {
+	retention, err := time.ParseDuration(c.FailedTorrentsRetention)
+	if err != nil || retention < 0 {
+		return 0
+	}
+	return retention
+}
+
+// GetDebridFullCooldown returns the parsed DebridFullCooldown, falling back to 15 minutes
+func (c *Config) GetDebridFullCooldown() time.Duration {
+	cooldown, err := time.ParseDuration(c.DebridFullCooldown)
+	if err != nil || cooldown < 0 {
+		return 15 * time.Minute
+	}
+	return cooldown
+}
+
+// GetShutdownTimeout returns the parsed ShutdownTimeout, falling back to 30 seconds
+func (c *Config) GetShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || timeout < 0 {
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// GetNotificationDedupWindow returns the parsed NotificationDedupWindow, 0 if deduplication is disabled
+func (c *Config) GetNotificationDedupWindow() time.Duration {
+	window, err := time.ParseDuration(c.NotificationDedupWindow)
+	if err != nil || window < 0 {
+		return 0
+	}
+	return window
+}
+
+// GetMinRepairInterval returns the parsed MinRepairInterval, 0 means no cooldown
+func (r *Repair) GetMinRepairInterval() time.Duration {
+	if r.MinRepairInterval == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(r.MinRepairInterval)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// GetStreamingResumeAfter returns the parsed StreamingResumeAfter, falling back to 1 minute
+func (r *Repair) GetStreamingResumeAfter() time.Duration {
+	d, err := time.ParseDuration(r.StreamingResumeAfter)
+	if err != nil || d < 0 {
+		return time.Minute
+	}
+	return d
+}
+
+type Auth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type Config struct {
+	ConfigVersion int `json:"config_version,omitempty"` // Version of the config schema, older configs are migrated on load
+
+	// server
+	BindAddress string `json:"bind_address,omitempty"`
+	URLBase     string `json:"url_base,omitempty"`
+	Port        string `json:"port,omitempty"`
+
+	LogLevel           string      `json:"log_level,omitempty"`
+	LogFormat          LogFormat   `json:"log_format,omitempty"` // text or json, defaults to text
+	Debrids            []Debrid    `json:"debrids,omitempty"`
+	QBitTorrent        QBitTorrent `json:"qbittorrent,omitempty"`
+	Arrs               []Arr       `json:"arrs,omitempty"`
+	Repair             Repair      `json:"repair,omitempty"`
+	WebDav             WebDav      `json:"webdav,omitempty"`
+	AllowedExt         []string    `json:"allowed_file_types,omitempty"`
+	allowedExt         []string    // AllowedExt with DefaultExtensionsToken expanded
+	MinFileSize        string      `json:"min_file_size,omitempty"` // Minimum file size to download, 10MB, 1GB, etc
+	MaxFileSize        string      `json:"max_file_size,omitempty"` // Maximum file size to download (0 means no limit)
+	Path               string      `json:"-"`                       // Path to save the config file
+	UseAuth            bool        `json:"use_auth,omitempty"`
+	Auth               *Auth       `json:"-"`
+	DiscordWebhook     string      `json:"discord_webhook_url,omitempty"`
+	RemoveStalledAfter string      `json:"remove_stalled_after,omitzero"`
+
+	ArchiveFilter ArchiveFilter `json:"archive_filter,omitempty"`
+
+	// Password of the first-run auth setup
+	PasswordMinLength  int `json:"password_min_length,omitempty"`  // Defaults to 8
+	PasswordMinClasses int `json:"password_min_classes,omitempty"` // Character classes to mix, out of lowercase, uppercase, digits and symbols
+
+	WorkerMultiplier int  `json:"worker_multiplier,omitempty"` // WebDav workers per CPU, shared across debrids
+	ResolveSymlinks  bool `json:"resolve_symlinks,omitempty"`  // Resolve symlinked download/debrid folders to their real path on load
+
+	DiscordOverflow DiscordOverflow `json:"discord_overflow,omitempty"` // Notifications longer than Discord accepts
+
+	EnableMetrics bool `json:"enable_metrics,omitempty"` // Serve Prometheus metrics on /metrics
+
+	NotificationDedup       NotificationDedup `json:"notification_dedup,omitempty"`
+	NotificationDedupWindow string            `json:"notification_dedup_window,omitempty"` // Identical notifications within it are deduplicated, disabled if empty
+
+	Notifications []NotificationTarget `json:"notifications,omitempty"` // discord_webhook_url is added as a Discord target
+
+	NoVideoPolicy NoVideoPolicy `json:"no_video_policy,omitempty"` // Torrents with no video file, after filtering
+
+	UnknownSizePolicy UnknownSizePolicy `json:"unknown_size_policy,omitempty"` // Files of an unknown size, against min_file_size and max_file_size
+
+	MaintenanceAdds MaintenanceAdds `json:"maintenance_adds,omitempty"` // Torrents added while in maintenance mode
+
+	DuplicateDebridNames DuplicateDebridNames `json:"duplicate_debrid_names,omitempty"` // Debrids sharing the same name
+
+	ArrSourcePreference ArrSourcePreference `json:"arr_source_preference,omitempty"`
+
+	NamelessMagnetPolicy   NamelessMagnetPolicy `json:"nameless_magnet_policy,omitempty"`
+	NamelessMagnetTemplate string               `json:"nameless_magnet_template,omitempty"` // {hash} is replaced by the infohash
+
+	StorageMode StorageMode `json:"storage_mode,omitempty"` // How files of the config directory are written, network for NFS/SMB mounts
+
+	// Arr HTTP calls
+	ArrTimeout    string `json:"arr_timeout,omitempty"`
+	ArrMaxRetries int    `json:"arr_max_retries,omitempty"`
+
+	ArrRescanRetries int `json:"arr_rescan_retries,omitempty"` // Attempts of a failed rescan before giving up, -1 disables the retries
+
+	// Re-searches triggered within the window are batched into one per arr, triggered right away if empty
+	ArrSearchBatchWindow string        `json:"arr_search_batch_window,omitempty"`
+	ArrSearchMode        ArrSearchMode `json:"arr_search_mode,omitempty"`
+
+	DebridFullCooldown string `json:"debrid_full_cooldown,omitempty"` // How long a debrid refusing torrents for too many active downloads is tried last
+
+	RateLimitReload RateLimitReload `json:"rate_limit_reload,omitempty"` // What a config change does to the running rate limiters
+
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty"` // How long in-flight WebDav streams and repair jobs are waited for on shutdown
+
+	// torrents.json compaction, also run on demand with POST /api/torrents/compact
+	CompactTorrentsInterval string `json:"compact_torrents_interval,omitempty"` // How often torrents.json is compacted, disabled if empty
+	FailedTorrentsRetention string `json:"failed_torrents_retention,omitempty"` // Failed torrents older than this are pruned by the compaction, kept if empty
+
+	readOnly     bool          // Set when the config file can't be written, config is kept in-memory
+	envOverrides []envOverride // Fields set from the environment, saved with their file value
+}
+
+func (c *Config) AuthFile() string {
+	return filepath.Join(c.Path, "auth.json")
+}
+
+// OAuthFile holds the OAuth tokens of the debrids logged in with the device flow
+func (c *Config) OAuthFile() string {
+	return filepath.Join(c.Path, "oauth.json")
+}
+
+func (c *Config) TorrentsFile() string {
+	return filepath.Join(c.Path, "torrents.json")
+}
+
+func (c *Config) loadConfig() error {
+	// Load the config file
+	if configPath == "" {
+		return fmt.Errorf("config path not set")
+	}
+	c.Path = configPath
+	if found := existingConfigFiles(c.Path); len(found) > 1 {
+		fmt.Printf("WARNING: several config files found, using %s and ignoring the others\n", found[0])
+	}
+	configFile := c.ConfigFile()
+	raw, err := os.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("Config file not found, creating a new one at %s\n", configFile)
+			// Create a default config file if it doesn't exist
+			if err := c.createConfig(c.Path); err != nil {
+				if !isReadOnlyError(err) {
+					return fmt.Errorf("failed to create config file: %w", err)
+				}
+				c.readOnly = true
+			}
+			c.applyEnvOverrides()
+			if err := c.Save(); err != nil {
+				if !isReadOnlyError(err) {
+					return err
+				}
+				c.readOnly = true
+			}
+			if c.readOnly {
+				fmt.Printf("Config path %s is read-only, running with an in-memory config\n", c.Path)
+			}
+			return nil
+		}
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	file, err := configToJSON(configFile, raw)
+	if err != nil {
+		return fmt.Errorf("error reading config file %s: %w", configFile, err)
+	}
+	if err := json.Unmarshal(file, &c); err != nil {
+		if !lenientLoad {
+			return fmt.Errorf("error unmarshaling config: %w", err)
+		}
+		c.loadLenient(raw, file, err)
+	}
+	if err := c.migrate(); err != nil {
+		return err
+	}
+	c.applyEnvOverrides()
+	if !isWritable(configFile) {
+		c.readOnly = true
+		fmt.Printf("Config file %s is read-only, config changes will not be saved\n", configFile)
+	}
+	if hint := c.storageModeHint(); hint != "" {
+		fmt.Println(hint)
+	}
+	c.setDefaults()
+	if c.ResolveSymlinks {
+		c.resolveSymlinks()
+	}
+	return nil
+}
+
+// migratePort moves the deprecated qbittorrent.port to the top-level port, it is dropped from the file on the next save.
+// Port wins if both are set. It returns a warning to show if the deprecated field was set.
+func (c *Config) migratePort() string {
+	deprecated := c.QBitTorrent.Port
+	if deprecated == "" {
+		return ""
+	}
+	c.QBitTorrent.Port = ""
+	if c.Port == "" {
+		c.Port = deprecated
+		return fmt.Sprintf("qbittorrent.port is deprecated, using it as port(%s). Set port instead", deprecated)
+	}
+	if c.Port != deprecated {
+		return fmt.Sprintf("WARNING: qbittorrent.port(%s) conflicts with port(%s), using port(%s). qbittorrent.port is deprecated, remove it", deprecated, c.Port, c.Port)
+	}
+	return "qbittorrent.port is deprecated and ignored, port is already set"
+}
+
+// resolveSymlinks replaces the download and debrid folders by their canonical path,
+// so paths sent to the arrs match the paths they see on disk
+func (c *Config) resolveSymlinks() {
+	c.QBitTorrent.DownloadFolder = resolvePath(c.QBitTorrent.DownloadFolder)
+	for category, settings := range c.QBitTorrent.CategorySettings {
+		settings.DownloadFolder = resolvePath(settings.DownloadFolder)
+		c.QBitTorrent.CategorySettings[category] = settings
+	}
+	for i := range c.Debrids {
+		c.Debrids[i].Folder = resolvePath(c.Debrids[i].Folder)
+	}
+}
+
+// resolvePath returns the canonical path of p, keeping its trailing separator. p is returned as is if it can't be resolved
+func resolvePath(p string) string {
+	if p == "" {
+		return p
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		fmt.Printf("Failed to resolve path %s: %v\n", p, err)
+		return p
+	}
+	if strings.HasSuffix(p, string(os.PathSeparator)) && !strings.HasSuffix(resolved, string(os.PathSeparator)) {
+		resolved += string(os.PathSeparator)
+	}
+	if resolved != p {
+		fmt.Printf("Resolved symlinked path %s to %s\n", p, resolved)
+	}
+	return resolved
+}
+
+// loadLenient loads what it can of a malformed config file, after backing up its original content.
+// data is the file converted to JSON. Fields that fail to load keep their defaults.
+func (c *Config) loadLenient(original, data []byte, cause error) {
+	fmt.Printf("Error unmarshaling config: %v, loading it leniently\n", cause)
+	backup := fmt.Sprintf("%s.%s.bak", c.ConfigFile(), time.Now().Format("20060102150405"))
+	if err := os.WriteFile(backup, original, 0644); err != nil {
+		fmt.Printf("Failed to back up config file: %v\n", err)
+	} else {
+		fmt.Printf("Config file backed up to %s\n", backup)
+	}
+
+	// Drop whatever the failed unmarshal left behind
+	*c = Config{Path: c.Path}
+	failed, err := c.unmarshalLenient(data)
+	if err != nil {
+		fmt.Printf("Config file is not valid JSON, using defaults: %v\n", err)
+		return
+	}
+	for _, field := range failed {
+		fmt.Printf("Config field %q could not be loaded, using its default\n", field)
+	}
+}
+
+// unmarshalLenient unmarshals every top-level field of data on its own, then every sub-field of the objects that failed.
+// It returns the fields that could not be loaded.
+func (c *Config) unmarshalLenient(data []byte) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	failed := make([]string, 0)
+	for key, raw := range fields {
+		if err := c.unmarshalField(key, raw); err == nil {
+			continue
+		}
+		var subFields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &subFields); err != nil {
+			failed = append(failed, key)
+			continue
+		}
+		for subKey, subRaw := range subFields {
+			wrapped, err := json.Marshal(map[string]json.RawMessage{subKey: subRaw})
+			if err != nil || c.unmarshalField(key, wrapped) != nil {
+				failed = append(failed, key+"."+subKey)
+			}
+		}
+	}
+	slices.Sort(failed)
+	return failed, nil
+}
+
+func (c *Config) unmarshalField(key string, raw json.RawMessage) error {
+	data, err := json.Marshal(map[string]json.RawMessage{key: raw})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+// IsReadOnly reports whether the config file could not be written to on load.
+// Config-editing endpoints should be disabled in that case.
+func (c *Config) IsReadOnly() bool {
+	return c.readOnly
+}
+
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+func isWritable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return !isReadOnlyError(err)
+	}
+	_ = f.Close()
+	return true
+}
+
+// GetEndpoint returns the base URL of the provider API, Endpoint if set, else fallback
+func (d Debrid) GetEndpoint(fallback string) string {
+	if d.Endpoint == "" {
+		return fallback
+	}
+	return strings.TrimSuffix(d.Endpoint, "/")
+}
+
+// GetIdleConnTimeout returns the parsed IdleConnTimeout, falling back to 90 seconds
+func (d Debrid) GetIdleConnTimeout() time.Duration {
+	timeout, err := time.ParseDuration(d.IdleConnTimeout)
+	if err != nil || timeout <= 0 {
+		return 90 * time.Second
+	}
+	return timeout
+}
+
+// GetPremiumCheckInterval returns the parsed PremiumCheckInterval, 0 if premium checks are disabled
+func (d Debrid) GetPremiumCheckInterval() time.Duration {
+	interval, err := time.ParseDuration(d.PremiumCheckInterval)
+	if err != nil || interval < 0 {
+		return 0
+	}
+	return interval
+}
+
+// GetHealthCheckInterval returns the parsed HealthCheckInterval, 0 if health checks are disabled
+func (d Debrid) GetHealthCheckInterval() time.Duration {
+	interval, err := time.ParseDuration(d.HealthCheckInterval)
+	if err != nil || interval < 0 {
+		return 0
+	}
+	return interval
+}
+
+// GetCachedPromotionInterval returns the parsed CachedPromotionInterval, 0 if promotion is disabled
+func (d Debrid) GetCachedPromotionInterval() time.Duration {
+	interval, err := time.ParseDuration(d.CachedPromotionInterval)
+	if err != nil || interval < 0 {
+		return 0
+	}
+	return interval
+}
+
+// GetCheckCachedBatchSize returns the number of hashes per availability request, capped to the provider maximum
+func (d Debrid) GetCheckCachedBatchSize() int {
+	limit := cmp.Or(checkCachedBatchLimits[d.Provider()], 100)
+	if d.CheckCachedBatchSize <= 0 || d.CheckCachedBatchSize > limit {
+		return limit
+	}
+	return d.CheckCachedBatchSize
+}
+
+// GetCheckCachedWindow returns the parsed CheckCachedWindow, falling back to 100 milliseconds
+func (d Debrid) GetCheckCachedWindow() time.Duration {
+	window, err := time.ParseDuration(d.CheckCachedWindow)
+	if err != nil || window < 0 {
+		return 100 * time.Millisecond
+	}
+	return window
+}
+
+// GetCheckCachedTTL returns the parsed CheckCachedTTL, 0 if the results are not reused, falling back to 5 minutes
+func (d Debrid) GetCheckCachedTTL() time.Duration {
+	ttl, err := time.ParseDuration(d.CheckCachedTTL)
+	if err != nil || ttl < 0 {
+		return 5 * time.Minute
+	}
+	return ttl
+}
+
+// IsLargeTorrent reports whether a torrent with that many files is handled as a large torrent
+func (d Debrid) IsLargeTorrent(files int) bool {
+	return d.LargeTorrentFiles > 0 && files >= d.LargeTorrentFiles
+}
+
+// GetExpiryWarning returns the parsed ExpiryWarning, falling back to 24 hours
+func (d Debrid) GetExpiryWarning() time.Duration {
+	warning, err := time.ParseDuration(d.ExpiryWarning)
+	if err != nil || warning <= 0 {
+		return 24 * time.Hour
+	}
+	return warning
+}
+
+// GetReadinessDelay returns the parsed ReadinessDelay, 0 if there is none
+func (d Debrid) GetReadinessDelay() time.Duration {
+	delay, err := time.ParseDuration(d.ReadinessDelay)
+	if err != nil || delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// GetReadinessTimeout returns the parsed ReadinessTimeout, 0 if readiness polling is disabled
+func (d Debrid) GetReadinessTimeout() time.Duration {
+	timeout, err := time.ParseDuration(d.ReadinessTimeout)
+	if err != nil || timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// GetTrafficBudget returns the parsed TrafficBudget in bytes, 0 if there is no budget
+func (d Debrid) GetTrafficBudget() int64 {
+	if d.TrafficBudget == "" {
+		return 0
+	}
+	budget, err := ParseSize(d.TrafficBudget)
+	if err != nil || budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// GetTrafficBudgetWindow returns the parsed TrafficBudgetWindow, falling back to 24 hours
+func (d Debrid) GetTrafficBudgetWindow() time.Duration {
+	window, err := time.ParseDuration(d.TrafficBudgetWindow)
+	if err != nil || window < time.Hour {
+		return 24 * time.Hour
+	}
+	return window
+}
+
+// GetRetryAttempts returns the attempts of a download link on transient errors, 1 if the retries are disabled
+func (d Debrid) GetRetryAttempts() int {
+	return max(d.RetryAttempts, 1)
+}
+
+// GetRetryBaseDelay returns the parsed RetryBaseDelay, falling back to 1 second
+func (d Debrid) GetRetryBaseDelay() time.Duration {
+	delay, err := time.ParseDuration(d.RetryBaseDelay)
+	if err != nil || delay < 0 {
+		return time.Second
+	}
+	return delay
+}
+
+// GetSoftBanWindow returns the parsed SoftBanWindow, falling back to 1 minute
+func (d Debrid) GetSoftBanWindow() time.Duration {
+	window, err := time.ParseDuration(d.SoftBanWindow)
+	if err != nil || window <= 0 {
+		return time.Minute
+	}
+	return window
+}
+
+// GetSoftBanCooldown returns the parsed SoftBanCooldown, falling back to 15 minutes
+func (d Debrid) GetSoftBanCooldown() time.Duration {
+	cooldown, err := time.ParseDuration(d.SoftBanCooldown)
+	if err != nil || cooldown <= 0 {
+		return 15 * time.Minute
+	}
+	return cooldown
+}
+
+func isValidFileSortOrder(order FileSortOrder) bool {
+	switch order {
+	case "", FileSortByName, FileSortByNatural, FileSortByIndex, FileSortBySize:
+		return true
+	}
+	return false
+}
+
+// validateDebrids returns the errors of all debrids, each one naming the debrid and its index in the config
+func validateDebrids(debrids []Debrid) []error {
+	if len(debrids) == 0 {
+		return []error{errors.New("no debrids configured")}
+	}
+
+	var errs []error
+	names := make(map[string]struct{}, len(debrids))
+	for i, debrid := range debrids {
+		prefix := fmt.Sprintf("debrid %s(#%d)", debrid.Name, i)
+		if _, ok := names[debrid.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s is configured more than once, set duplicate_debrid_names to rename to suffix duplicates", prefix))
+		}
+		names[debrid.Name] = struct{}{}
+
+		// Basic field validation
+		if debrid.APIKey == "" && debrid.OAuthClientID == "" {
+			errs = append(errs, fmt.Errorf("%s: api key is required", prefix))
+		}
+		if debrid.OAuthClientID != "" && debrid.Provider() != "realdebrid" {
+			errs = append(errs, fmt.Errorf("%s: oauth_client_id is only supported by realdebrid", prefix))
+		}
+		if debrid.Folder == "" {
+			errs = append(errs, fmt.Errorf("%s: folder is required", prefix))
+		}
+		for _, f := range []struct{ field, value string }{
+			{"rate_limit", debrid.RateLimit},
+			{"repair_rate_limit", debrid.RepairRateLimit},
+			{"download_rate_limit", debrid.DownloadRateLimit},
+			{"soft_ban_rate_limit", debrid.SoftBanRateLimit},
+		} {
+			if f.value == "" {
+				continue
+			}
+			if _, _, err := ParseRateLimit(f.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid %s: %w", prefix, f.field, err))
+			}
+		}
+		if debrid.RangeCoalesceWindow != "" {
+			if _, err := time.ParseDuration(debrid.RangeCoalesceWindow); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid range_coalesce_window: %w", prefix, err))
+			}
+		}
+		if debrid.ReadAheadSize != "" {
+			if _, err := ParseSize(debrid.ReadAheadSize); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid read_ahead_size: %w", prefix, err))
+			}
+		}
+		if debrid.CacheSize != "" {
+			if _, err := ParseSize(debrid.CacheSize); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid cache_size: %w", prefix, err))
+			}
+		}
+		if debrid.UncachedFolder != "" {
+			if err := validateWritableDir(debrid.UncachedFolder); err != nil {
+				errs = append(errs, fmt.Errorf("%s: uncached folder: %w", prefix, err))
+			}
+		}
+		if debrid.Role != "" && debrid.Role != DebridRolePrimary && debrid.Role != DebridRoleStandby {
+			errs = append(errs, fmt.Errorf("%s: invalid role %q", prefix, debrid.Role))
+		}
+		if debrid.CheckCachedBatchSize < 0 {
+			errs = append(errs, fmt.Errorf("%s: check_cached_batch_size must be positive", prefix))
+		}
+		if limit, ok := checkCachedBatchLimits[debrid.Provider()]; ok && debrid.CheckCachedBatchSize > limit {
+			errs = append(errs, fmt.Errorf("%s: check_cached_batch_size can't exceed %d for %s", prefix, limit, debrid.Provider()))
+		}
+		switch debrid.RateLimitScope {
+		case "", RateLimitScopeKey, RateLimitScopeAccount:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid rate_limit_scope %q", prefix, debrid.RateLimitScope))
+		}
+		switch debrid.CachedCheckStrategy {
+		case "", CachedCheckAPI, CachedCheckProbeLink, CachedCheckHybrid:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid cached_check_strategy %q", prefix, debrid.CachedCheckStrategy))
+		}
+		switch debrid.IPLockedBehavior {
+		case "", IPLockedLinksProxy, IPLockedLinksRegenerate, IPLockedLinksError:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid ip_locked_behavior %q", prefix, debrid.IPLockedBehavior))
+		}
+		switch debrid.ChecksumMismatch {
+		case "", ChecksumMismatchError, ChecksumMismatchRedownload:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid checksum_mismatch %q", prefix, debrid.ChecksumMismatch))
+		}
+		switch debrid.APIKeyChange {
+		case "", APIKeyChangeValidate, APIKeyChangeAccept:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid api_key_change %q", prefix, debrid.APIKeyChange))
+		}
+		switch debrid.StaleHandles {
+		case "", StaleHandlesResolve, StaleHandlesTerminate:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid stale_handles %q", prefix, debrid.StaleHandles))
+		}
+		switch debrid.MissingContentLength {
+		case "", MissingContentLengthMetadata, MissingContentLengthChunked:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid missing_content_length %q", prefix, debrid.MissingContentLength))
+		}
+		switch debrid.ExpiryAction {
+		case "", ExpiryActionKeepAlive, ExpiryActionNotify, ExpiryActionNone:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid expiry_action %q", prefix, debrid.ExpiryAction))
+		}
+		if debrid.LargeTorrentCheckLimit < 0 {
+			errs = append(errs, fmt.Errorf("%s: large_torrent_check_limit must be positive", prefix))
+		}
+		if debrid.MaxIdleConns < 0 || debrid.MaxConnsPerHost < 0 {
+			errs = append(errs, fmt.Errorf("%s: connection pool sizes can't be negative", prefix))
+		}
+		if debrid.IdleConnTimeout != "" {
+			if _, err := time.ParseDuration(debrid.IdleConnTimeout); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid idle_conn_timeout: %w", prefix, err))
+			}
+		}
+		for _, f := range []struct{ field, value string }{
+			{"circuit_breaker.window", debrid.CircuitBreaker.Window},
+			{"circuit_breaker.cooldown", debrid.CircuitBreaker.Cooldown},
+			{"soft_ban_window", debrid.SoftBanWindow},
+			{"soft_ban_cooldown", debrid.SoftBanCooldown},
+			{"readiness_delay", debrid.ReadinessDelay},
+			{"readiness_timeout", debrid.ReadinessTimeout},
+			{"retry_base_delay", debrid.RetryBaseDelay},
+			{"expiry_warning", debrid.ExpiryWarning},
+			{"check_cached_window", debrid.CheckCachedWindow},
+			{"check_cached_ttl", debrid.CheckCachedTTL},
+		} {
+			if f.value == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(f.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid %s: %w", prefix, f.field, err))
+			}
+		}
+		if !isValidFileSortOrder(debrid.FileSortOrder) {
+			errs = append(errs, fmt.Errorf("%s: invalid file_sort_order %q", prefix, debrid.FileSortOrder))
+		}
+		switch debrid.IncompleteDownloads {
+		case "", IncompleteDownloadsHide, IncompleteDownloadsProgressive:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid incomplete_downloads %q", prefix, debrid.IncompleteDownloads))
+		}
+		switch debrid.FileListChanges {
+		case "", FileListPin, FileListFollow:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid file_list_changes %q", prefix, debrid.FileListChanges))
+		}
+		switch debrid.UnlockedFilenames {
+		case "", UnlockedFilenamesPreserve, UnlockedFilenamesProvider:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid unlocked_filenames %q", prefix, debrid.UnlockedFilenames))
+		}
+		switch debrid.PropfindErrors {
+		case "", PropfindErrorsSkip, PropfindErrorsMark, PropfindErrorsFail:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid propfind_errors %q", prefix, debrid.PropfindErrors))
+		}
+		switch debrid.DirectoryGet {
+		case "", DirectoryGetIndex, DirectoryGetReject:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid directory_get %q", prefix, debrid.DirectoryGet))
+		}
+		switch debrid.DirectoryIndexFiles {
+		case "", DirectoryIndexFilesAll, DirectoryIndexFilesAllowed:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid directory_index_files %q", prefix, debrid.DirectoryIndexFiles))
+		}
+		if err := validateFolderNamePrecedence(debrid.FolderNamePrecedence); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+		for name, dir := range debrid.Directories {
+			if !isValidFileSortOrder(dir.FileSortOrder) {
+				errs = append(errs, fmt.Errorf("%s: directory %s: invalid file_sort_order %q", prefix, name, dir.FileSortOrder))
+			}
+			for _, filterType := range []string{"regex", "not_regex"} {
+				if pattern, ok := dir.Filters[filterType]; ok {
+					if _, err := regexp.Compile(pattern); err != nil {
+						errs = append(errs, fmt.Errorf("%s: directory %s: invalid %s filter: %w", prefix, name, filterType, err))
+					}
+				}
+			}
+		}
+		if debrid.CachedPromotionInterval != "" {
+			if interval, err := time.ParseDuration(debrid.CachedPromotionInterval); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid cached_promotion_interval: %w", prefix, err))
+			} else if interval > 0 && interval < time.Minute {
+				errs = append(errs, fmt.Errorf("%s: cached_promotion_interval must be at least 1m", prefix))
+			}
+		}
+		if debrid.PremiumCheckInterval != "" {
+			if _, err := time.ParseDuration(debrid.PremiumCheckInterval); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid premium_check_interval: %w", prefix, err))
+			}
+		}
+		if debrid.HealthCheckInterval != "" {
+			if _, err := time.ParseDuration(debrid.HealthCheckInterval); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid health_check_interval: %w", prefix, err))
+			}
+		}
+		if err := validateProxy("proxy", debrid.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+		if err := validateProxy("forbidden_proxy", debrid.ForbiddenProxy); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+		if debrid.Endpoint != "" {
+			if u, err := url.Parse(debrid.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s: endpoint must be an http(s) URL, got %q", prefix, debrid.Endpoint))
+			}
+		}
+		errs = append(errs, validateFileSizes(prefix, debrid.MinFileSize, debrid.MaxFileSize)...)
+		if debrid.TrafficBudget != "" {
+			if _, err := ParseSize(debrid.TrafficBudget); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid traffic_budget: %w", prefix, err))
+			}
+		}
+		if debrid.TrafficBudgetWindow != "" {
+			if window, err := time.ParseDuration(debrid.TrafficBudgetWindow); err != nil || window < time.Hour {
+				errs = append(errs, fmt.Errorf("%s: traffic_budget_window must be a duration of at least 1h", prefix))
+			}
+		}
+		if debrid.TrafficBudgetThreshold < 1 || debrid.TrafficBudgetThreshold > 100 {
+			errs = append(errs, fmt.Errorf("%s: traffic_budget_threshold must be between 1 and 100", prefix))
+		}
+		if debrid.UnlockQuota < 0 {
+			errs = append(errs, fmt.Errorf("%s: unlock_quota must be positive", prefix))
+		}
+		if debrid.UnlockQuotaThreshold < 1 || debrid.UnlockQuotaThreshold > 100 {
+			errs = append(errs, fmt.Errorf("%s: unlock_quota_threshold must be between 1 and 100", prefix))
+		}
+	}
+
+	return errs
+}
+
+// ParseRateLimit parses a rate limit of the form count/unit, e.g. 200/minute, 10/second, 5/s or 100/m.
+// The unit is second(s, sec), minute(m, min), hour(h, hr) or day(d), optionally plural.
+func ParseRateLimit(rate string) (int, time.Duration, error) {
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not of the form count/unit, e.g. 200/minute", rate)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("%q must start with a positive count", rate)
+	}
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	if len(unit) > 1 {
+		unit = strings.TrimSuffix(unit, "s")
+	}
+	switch unit {
+	case "second", "sec", "s":
+		return n, time.Second, nil
+	case "minute", "min", "m":
+		return n, time.Minute, nil
+	case "hour", "hr", "h":
+		return n, time.Hour, nil
+	case "day", "d":
+		return n, 24 * time.Hour, nil
+	}
+	return 0, 0, fmt.Errorf("%q has an unknown unit, use second, minute, hour or day", rate)
 }
 
-type Auth struct {
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
+// validateWritableDir checks that dir exists and is writable
+func validateWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".decypharr-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
 }
 
-type Config struct {
-	// server
-	BindAddress string `json:"bind_address,omitempty"`
-	URLBase     string `json:"url_base,omitempty"`
-	Port        string `json:"port,omitempty"`
+func validateQbitTorrent(config *QBitTorrent) []error {
+	if config.DownloadFolder == "" {
+		return []error{errors.New("qbittorent download folder is required")}
+	}
+	if _, err := os.Stat(config.DownloadFolder); os.IsNotExist(err) {
+		return []error{fmt.Errorf("qbittorent download folder(%s) does not exist", config.DownloadFolder)}
+	}
+	var errs []error
+	for category, settings := range config.CategorySettings {
+		if settings.DownloadFolder != "" {
+			if _, err := os.Stat(settings.DownloadFolder); os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("qbittorrent category %s: download folder(%s) does not exist", category, settings.DownloadFolder))
+			}
+		}
+		if settings.MaxDownloads < 0 {
+			errs = append(errs, fmt.Errorf("qbittorrent category %s: max_downloads can't be negative", category))
+		}
+	}
+	return errs
+}
 
-	LogLevel           string      `json:"log_level,omitempty"`
-	Debrids            []Debrid    `json:"debrids,omitempty"`
-	QBitTorrent        QBitTorrent `json:"qbittorrent,omitempty"`
-	Arrs               []Arr       `json:"arrs,omitempty"`
-	Repair             Repair      `json:"repair,omitempty"`
-	WebDav             WebDav      `json:"webdav,omitempty"`
-	AllowedExt         []string    `json:"allowed_file_types,omitempty"`
-	MinFileSize        string      `json:"min_file_size,omitempty"` // Minimum file size to download, 10MB, 1GB, etc
-	MaxFileSize        string      `json:"max_file_size,omitempty"` // Maximum file size to download (0 means no limit)
-	Path               string      `json:"-"`                       // Path to save the config file
-	UseAuth            bool        `json:"use_auth,omitempty"`
-	Auth               *Auth       `json:"-"`
-	DiscordWebhook     string      `json:"discord_webhook_url,omitempty"`
-	RemoveStalledAfter string      `json:"remove_stalled_after,omitzero"`
+func validateArrs(arrs []Arr, debrids []Debrid) []error {
+	var errs []error
+	names := make(map[string]struct{}, len(debrids))
+	for _, d := range debrids {
+		names[d.Name] = struct{}{}
+	}
+	for _, a := range arrs {
+		for _, name := range a.DebridPriority {
+			if _, ok := names[name]; !ok {
+				errs = append(errs, fmt.Errorf("arr %s: debrid_priority references unknown debrid %s", a.Name, name))
+			}
+		}
+		errs = append(errs, validateFileSizes("arr "+a.Name, a.MinFileSize, a.MaxFileSize)...)
+		switch a.RepairStrategy {
+		case "", RepairStrategyPerFile, RepairStrategyPerTorrent:
+		default:
+			errs = append(errs, fmt.Errorf("arr %s: invalid repair_strategy %q", a.Name, a.RepairStrategy))
+		}
+	}
+	return errs
 }
 
-func (c *Config) JsonFile() string {
-	return filepath.Join(c.Path, "config.json")
+// validateFileSizes checks a min_file_size and a max_file_size, either may be empty
+func validateFileSizes(prefix, minSize, maxSize string) []error {
+	var errs []error
+	for _, f := range []struct{ field, value string }{
+		{"min_file_size", minSize},
+		{"max_file_size", maxSize},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if _, err := ParseSize(f.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid %s: %w", prefix, f.field, err))
+		}
+	}
+	return errs
 }
-func (c *Config) AuthFile() string {
-	return filepath.Join(c.Path, "auth.json")
+
+func validateRepair(config *Repair) []error {
+	if !config.Enabled {
+		return nil
+	}
+	var errs []error
+	if config.Interval == "" {
+		errs = append(errs, errors.New("repair interval is required"))
+	}
+	if config.MinRepairInterval != "" {
+		if _, err := time.ParseDuration(config.MinRepairInterval); err != nil {
+			errs = append(errs, fmt.Errorf("invalid repair min_repair_interval: %w", err))
+		}
+	}
+	switch config.ReInsertFailure {
+	case "", ReInsertFailureBad, ReInsertFailureKeep:
+	default:
+		errs = append(errs, fmt.Errorf("invalid repair reinsert_failure %q", config.ReInsertFailure))
+	}
+	switch config.DeleteDuringRepair {
+	case "", DeleteDuringRepairAbort, DeleteDuringRepairWait:
+	default:
+		errs = append(errs, fmt.Errorf("invalid repair delete_during_repair %q", config.DeleteDuringRepair))
+	}
+	if config.StreamingThreshold < 0 {
+		errs = append(errs, errors.New("repair streaming_threshold must be positive"))
+	}
+	switch config.StreamingBehavior {
+	case "", StreamingPause, StreamingThrottle:
+	default:
+		errs = append(errs, fmt.Errorf("invalid repair streaming_behavior %q", config.StreamingBehavior))
+	}
+	if config.StreamingResumeAfter != "" {
+		if _, err := time.ParseDuration(config.StreamingResumeAfter); err != nil {
+			errs = append(errs, fmt.Errorf("invalid repair streaming_resume_after: %w", err))
+		}
+	}
+	return errs
 }
 
-func (c *Config) TorrentsFile() string {
-	return filepath.Join(c.Path, "torrents.json")
+// ValidateConfig returns the first error of the config, see Config.Validate
+func ValidateConfig(config *Config) error {
+	err := config.Validate()
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()[0]
+	}
+	return err
 }
 
-func (c *Config) loadConfig() error {
-	// Load the config file
-	if configPath == "" {
-		return fmt.Errorf("config path not set")
+// Validate checks the whole config and returns all its errors joined, nil if it is valid
+func (c *Config) Validate() error {
+	var errs []error
+	if c.WorkerMultiplier < 0 {
+		errs = append(errs, errors.New("worker multiplier must be positive"))
 	}
-	c.Path = configPath
-	file, err := os.ReadFile(c.JsonFile())
-	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Printf("Config file not found, creating a new one at %s\n", c.JsonFile())
-			// Create a default config file if it doesn't exist
-			if err := c.createConfig(c.Path); err != nil {
-				return fmt.Errorf("failed to create config file: %w", err)
-			}
-			return c.Save()
+
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("invalid log_format %q", c.LogFormat))
+	}
+
+	switch c.WebDav.RootLayout {
+	case "", RootLayoutDebrid, RootLayoutMerged, RootLayoutCategory:
+	default:
+		errs = append(errs, fmt.Errorf("invalid webdav root_layout %q", c.WebDav.RootLayout))
+	}
+	switch c.WebDav.DirectoryGet {
+	case "", DirectoryGetIndex, DirectoryGetReject:
+	default:
+		errs = append(errs, fmt.Errorf("invalid webdav directory_get %q", c.WebDav.DirectoryGet))
+	}
+	switch c.WebDav.DirectoryIndexFiles {
+	case "", DirectoryIndexFilesAll, DirectoryIndexFilesAllowed:
+	default:
+		errs = append(errs, fmt.Errorf("invalid webdav directory_index_files %q", c.WebDav.DirectoryIndexFiles))
+	}
+	if err := validateFolderNamePrecedence(c.WebDav.FolderNamePrecedence); err != nil {
+		errs = append(errs, fmt.Errorf("webdav: %w", err))
+	}
+	for _, proxy := range c.WebDav.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid webdav trusted_proxies entry %q: %w", proxy, err))
 		}
-		return fmt.Errorf("error reading config file: %w", err)
 	}
 
-	if err := json.Unmarshal(file, &c); err != nil {
-		return fmt.Errorf("error unmarshaling config: %w", err)
+	if c.DebridFullCooldown != "" {
+		if _, err := time.ParseDuration(c.DebridFullCooldown); err != nil {
+			errs = append(errs, fmt.Errorf("invalid debrid_full_cooldown: %w", err))
+		}
 	}
-	c.setDefaults()
-	return nil
-}
 
-func validateDebrids(debrids []Debrid) error {
-	if len(debrids) == 0 {
-		return errors.New("no debrids configured")
+	if c.ShutdownTimeout != "" {
+		if timeout, err := time.ParseDuration(c.ShutdownTimeout); err != nil || timeout < 0 {
+			errs = append(errs, fmt.Errorf("shutdown_timeout must be a positive duration"))
+		}
 	}
 
-	for _, debrid := range debrids {
-		// Basic field validation
-		if debrid.APIKey == "" {
-			return errors.New("debrid api key is required")
+	switch c.ArchiveFilter {
+	case "", ArchiveFilterContents, ArchiveFilterArchive:
+	default:
+		errs = append(errs, fmt.Errorf("invalid archive_filter %q", c.ArchiveFilter))
+	}
+	switch c.DiscordOverflow {
+	case "", DiscordOverflowTruncate, DiscordOverflowSplit:
+	default:
+		errs = append(errs, fmt.Errorf("invalid discord_overflow %q", c.DiscordOverflow))
+	}
+
+	for _, f := range []struct{ field, value string }{
+		{"compact_torrents_interval", c.CompactTorrentsInterval},
+		{"failed_torrents_retention", c.FailedTorrentsRetention},
+	} {
+		if f.value == "" {
+			continue
 		}
-		if debrid.Folder == "" {
-			return errors.New("debrid folder is required")
+		if _, err := time.ParseDuration(f.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", f.field, err))
 		}
 	}
 
-	return nil
-}
+	switch c.ArrSearchMode {
+	case "", ArrSearchItems, ArrSearchMissing:
+	default:
+		errs = append(errs, fmt.Errorf("invalid arr_search_mode %q", c.ArrSearchMode))
+	}
+	if c.ArrSearchBatchWindow != "" {
+		if _, err := time.ParseDuration(c.ArrSearchBatchWindow); err != nil {
+			errs = append(errs, fmt.Errorf("invalid arr_search_batch_window: %w", err))
+		}
+	}
 
-func validateQbitTorrent(config *QBitTorrent) error {
-	if config.DownloadFolder == "" {
-		return errors.New("qbittorent download folder is required")
+	if c.PasswordMinLength < 0 {
+		errs = append(errs, fmt.Errorf("password_min_length can't be negative"))
 	}
-	if _, err := os.Stat(config.DownloadFolder); os.IsNotExist(err) {
-		return fmt.Errorf("qbittorent download folder(%s) does not exist", config.DownloadFolder)
+	if c.PasswordMinClasses < 0 || c.PasswordMinClasses > 4 {
+		errs = append(errs, fmt.Errorf("password_min_classes must be between 0 and 4"))
+	}
+	errs = append(errs, validateNotifications(c.Notifications)...)
+	switch c.NotificationDedup {
+	case "", NotificationDedupSuppress, NotificationDedupSummarize:
+	default:
+		errs = append(errs, fmt.Errorf("invalid notification_dedup %q", c.NotificationDedup))
+	}
+	if c.NotificationDedupWindow != "" {
+		if _, err := time.ParseDuration(c.NotificationDedupWindow); err != nil {
+			errs = append(errs, fmt.Errorf("invalid notification_dedup_window: %w", err))
+		}
 	}
-	return nil
-}
 
-func validateRepair(config *Repair) error {
-	if !config.Enabled {
-		return nil
+	switch c.DuplicateDebridNames {
+	case "", DuplicateDebridError, DuplicateDebridRename:
+	default:
+		errs = append(errs, fmt.Errorf("invalid duplicate_debrid_names %q", c.DuplicateDebridNames))
 	}
-	if config.Interval == "" {
-		return errors.New("repair interval is required")
+
+	switch c.RateLimitReload {
+	case "", RateLimitReloadCarry, RateLimitReloadReset:
+	default:
+		errs = append(errs, fmt.Errorf("invalid rate_limit_reload %q", c.RateLimitReload))
 	}
-	return nil
-}
 
-func ValidateConfig(config *Config) error {
-	// Run validations concurrently
+	switch c.ArrSourcePreference {
+	case "", ArrSourceConfig, ArrSourceAuto:
+	default:
+		errs = append(errs, fmt.Errorf("invalid arr_source_preference %q", c.ArrSourcePreference))
+	}
 
-	if err := validateDebrids(config.Debrids); err != nil {
-		return err
+	switch c.NoVideoPolicy {
+	case "", NoVideoAllow, NoVideoFlag, NoVideoReject:
+	default:
+		errs = append(errs, fmt.Errorf("invalid no_video_policy %q", c.NoVideoPolicy))
 	}
 
-	if err := validateQbitTorrent(&config.QBitTorrent); err != nil {
-		return err
+	switch c.UnknownSizePolicy {
+	case "", UnknownSizeOptimistic, UnknownSizePessimistic:
+	default:
+		errs = append(errs, fmt.Errorf("invalid unknown_size_policy %q", c.UnknownSizePolicy))
 	}
 
-	if err := validateRepair(&config.Repair); err != nil {
-		return err
+	switch c.NamelessMagnetPolicy {
+	case "", NamelessMagnetInfoHash, NamelessMagnetMetadata:
+	case NamelessMagnetTemplate:
+		if c.NamelessMagnetTemplate != "" && !strings.Contains(c.NamelessMagnetTemplate, "{hash}") {
+			errs = append(errs, errors.New("nameless_magnet_template must contain {hash}, to keep names unique"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid nameless_magnet_policy %q", c.NamelessMagnetPolicy))
 	}
 
-	return nil
+	switch c.MaintenanceAdds {
+	case "", MaintenanceAddsQueue, MaintenanceAddsReject, MaintenanceAddsHold:
+	default:
+		errs = append(errs, fmt.Errorf("invalid maintenance_adds %q", c.MaintenanceAdds))
+	}
+
+	switch c.StorageMode {
+	case "", StorageLocal, StorageNetwork:
+	default:
+		errs = append(errs, fmt.Errorf("invalid storage_mode %q", c.StorageMode))
+	}
+
+	errs = append(errs, validateDebrids(c.Debrids)...)
+	errs = append(errs, validateQbitTorrent(&c.QBitTorrent)...)
+	errs = append(errs, validateArrs(c.Arrs, c.Debrids)...)
+	errs = append(errs, validateRepair(&c.Repair)...)
+
+	return errors.Join(errs...)
+}
+
+// SetLenient makes loading a malformed config file keep the fields that load instead of failing
+func SetLenient(lenient bool) {
+	lenientLoad = lenient
+}
+
+// SetInitialConfig sets how the config is created when none exists: from the template file if set, and without auth if skipAuth
+func SetInitialConfig(template string, noAuth bool) {
+	templatePath = template
+	skipAuth = noAuth
 }
 
 func SetConfigPath(path string) {
@@ -202,48 +1577,86 @@ func SetConfigPath(path string) {
 }
 
 func Get() *Config {
-	once.Do(func() {
-		instance = &Config{} // Initialize instance first
-		if err := instance.loadConfig(); err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "configuration Error: %v\n", err)
-			os.Exit(1)
+	for {
+		instanceMu.RLock()
+		c, loaded := instance, once
+		instanceMu.RUnlock()
+		if c != nil {
+			return c
 		}
-	})
-	return instance
+		loaded.Do(func() {
+			c := &Config{}
+			if err := c.loadConfig(); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "configuration Error: %v\n", err)
+				os.Exit(1)
+			}
+			instanceMu.Lock()
+			defer instanceMu.Unlock()
+			if once == loaded {
+				// Not reloaded meanwhile
+				instance = c
+			}
+		})
+	}
 }
 
 func (c *Config) GetMinFileSize() int64 {
-	// 0 means no limit
-	if c.MinFileSize == "" {
+	return parseFileSize(c.MinFileSize)
+}
+
+func (c *Config) GetMaxFileSize() int64 {
+	return parseFileSize(c.MaxFileSize)
+}
+
+// parseFileSize parses a file size limit, 0 means no limit
+func parseFileSize(size string) int64 {
+	if size == "" {
 		return 0
 	}
-	s, err := ParseSize(c.MinFileSize)
+	s, err := ParseSize(size)
 	if err != nil {
 		return 0
 	}
 	return s
 }
 
-func (c *Config) GetMaxFileSize() int64 {
-	// 0 means no limit
-	if c.MaxFileSize == "" {
-		return 0
+// FileSizeLimits returns the min and max file sizes of the torrents of debrid added by arr, 0 for no limit.
+// Each limit comes from the most specific setting it: the arr, then the debrid, then the global one.
+// An arr or debrid setting a limit to 0 lifts it. Either name may be empty.
+func (c *Config) FileSizeLimits(debrid, arr string) (int64, int64) {
+	minSize, maxSize := c.MinFileSize, c.MaxFileSize
+	if d, ok := c.GetDebrid(debrid); ok && debrid != "" {
+		minSize, maxSize = cmp.Or(d.MinFileSize, minSize), cmp.Or(d.MaxFileSize, maxSize)
 	}
-	s, err := ParseSize(c.MaxFileSize)
-	if err != nil {
-		return 0
+	if a, ok := c.GetArr(arr); ok && arr != "" {
+		minSize, maxSize = cmp.Or(a.MinFileSize, minSize), cmp.Or(a.MaxFileSize, maxSize)
 	}
-	return s
+	return parseFileSize(minSize), parseFileSize(maxSize)
+}
+
+// RepairStrategyFor returns the repair strategy of the torrents of arr, its repair_strategy if set, else repair.strategy
+func (c *Config) RepairStrategyFor(arr string) RepairStrategy {
+	if a, ok := c.GetArr(arr); ok && arr != "" && a.RepairStrategy != "" {
+		return a.RepairStrategy
+	}
+	return c.Repair.Strategy
 }
 
+// IsSizeAllowed checks a file size against the global limits
 func (c *Config) IsSizeAllowed(size int64) bool {
+	return c.IsSizeAllowedFor(size, "", "")
+}
+
+// IsSizeAllowedFor checks a file size against the limits of the torrents of debrid added by arr, see FileSizeLimits
+func (c *Config) IsSizeAllowedFor(size int64, debrid, arr string) bool {
 	if size == 0 {
 		return true // Maybe the debrid hasn't reported the size yet
 	}
-	if c.GetMinFileSize() > 0 && size < c.GetMinFileSize() {
+	minSize, maxSize := c.FileSizeLimits(debrid, arr)
+	if minSize > 0 && size < minSize {
 		return false
 	}
-	if c.GetMaxFileSize() > 0 && size > c.GetMaxFileSize() {
+	if maxSize > 0 && size > maxSize {
 		return false
 	}
 	return true
@@ -271,11 +1684,116 @@ func (c *Config) SaveAuth(auth *Auth) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(c.AuthFile(), data, 0644)
+	return c.WriteFile(c.AuthFile(), data)
+}
+
+// GetDebrid returns the debrid config with the given name
+func (c *Config) GetDebrid(name string) (Debrid, bool) {
+	for _, d := range c.Debrids {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Debrid{}, false
+}
+
+// renameDuplicateDebrids suffixes debrids sharing a name with their occurrence, realdebrid, realdebrid-2...
+// Names are stable as long as the order of the debrids doesn't change.
+func (c *Config) renameDuplicateDebrids() {
+	names := make(map[string]struct{}, len(c.Debrids))
+	for _, d := range c.Debrids {
+		names[d.Name] = struct{}{}
+	}
+	seen := make(map[string]int, len(c.Debrids))
+	for i, d := range c.Debrids {
+		seen[d.Name]++
+		if seen[d.Name] == 1 {
+			continue
+		}
+		name := d.Name
+		for n := seen[d.Name]; ; n++ {
+			name = fmt.Sprintf("%s-%d", d.Name, n)
+			if _, ok := names[name]; !ok {
+				break
+			}
+		}
+		names[name] = struct{}{}
+		c.Debrids[i].Name = name
+		fmt.Printf("Debrid %s is configured more than once, renamed to %s\n", d.Name, name)
+	}
+}
+
+// Provider returns the debrid service of the debrid, its name without the suffix added to duplicate names
+func (d Debrid) Provider() string {
+	i := strings.LastIndex(d.Name, "-")
+	if i <= 0 {
+		return d.Name
+	}
+	if _, err := strconv.Atoi(d.Name[i+1:]); err != nil {
+		return d.Name
+	}
+	return d.Name[:i]
+}
+
+// GetArr returns the arr config with the given name(category)
+func (c *Config) GetArr(name string) (Arr, bool) {
+	for _, a := range c.Arrs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Arr{}, false
+}
+
+// AddCategoryDirectory creates the WebDav directory of a new category when AutoCategoryDirectories is set,
+// and persists it. It returns false if nothing was added.
+func (c *Config) AddCategoryDirectory(category string) (WebdavDirectories, bool, error) {
+	if category == "" {
+		return WebdavDirectories{}, false, nil
+	}
+	var dir WebdavDirectories
+	added := false
+	// Checked and added under the save lock, saving also merges the directory into each debrid
+	err := c.updateIf(func(c *Config) bool {
+		if !c.WebDav.AutoCategoryDirectories {
+			return false
+		}
+		if _, exists := c.WebDav.Directories[category]; exists {
+			return false
+		}
+		if c.WebDav.Directories == nil {
+			c.WebDav.Directories = make(map[string]WebdavDirectories)
+		}
+		dir = c.WebDav.categoryDirectory(category)
+		c.WebDav.Directories[category] = dir
+		added = true
+		return true
+	})
+	if err != nil && !errors.Is(err, ErrReadOnly) {
+		return dir, added, err
+	}
+	return dir, added, nil
+}
+
+// validateProxy checks the scheme of a proxy URL, empty if unset
+func validateProxy(field, proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// The parse error would show the credentials of the URL
+		return fmt.Errorf("invalid %s URL", field)
+	}
+	if !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) || u.Host == "" {
+		return fmt.Errorf("unsupported %s %q, use an http://, https://, socks5:// or socks5h:// URL", field, u.Redacted())
+	}
+	return nil
 }
 
+// NeedsSetup returns all the errors of the config, nil once it is complete
 func (c *Config) NeedsSetup() error {
-	return ValidateConfig(c)
+	return c.Validate()
 }
 
 func (c *Config) NeedsAuth() bool {
@@ -285,20 +1803,90 @@ func (c *Config) NeedsAuth() bool {
 	return false
 }
 
-func (c *Config) updateDebrid(d Debrid) Debrid {
-	workers := runtime.NumCPU() * 50
-	perDebrid := workers / len(c.Debrids)
+// defaultWorkers returns the WebDav workers of a debrid without Workers set:
+// the available CPUs (GOMAXPROCS, within the CPU quota of the container) * WorkerMultiplier, split evenly across debrids
+func (c *Config) defaultWorkers() int {
+	multiplier := cmp.Or(c.WorkerMultiplier, 50)
+	return max(availableCPUs()*multiplier/max(len(c.Debrids), 1), 1)
+}
+
+// dedupeKeys returns the keys without duplicates and blanks, in their original order.
+// A duplicated key would get more than its share of the downloads, and its accounting mixed up.
+func dedupeKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, key)
+	}
+	return unique
+}
 
-	var downloadKeys []string
+func (c *Config) updateDebrid(d Debrid) Debrid {
 
-	if len(d.DownloadAPIKeys) > 0 {
-		downloadKeys = d.DownloadAPIKeys
-	} else {
+	downloadKeys := dedupeKeys(d.DownloadAPIKeys)
+	if removed := len(d.DownloadAPIKeys) - len(downloadKeys); removed > 0 {
+		fmt.Printf("Debrid %s: ignoring %d duplicate or empty download API keys\n", d.Name, removed)
+	}
+	if len(downloadKeys) == 0 {
 		// If no download API keys are specified, use the main API key
 		downloadKeys = []string{d.APIKey}
 	}
 	d.DownloadAPIKeys = downloadKeys
 
+	if d.Role == "" {
+		d.Role = DebridRolePrimary
+	}
+	d.CachedCheckStrategy = cmp.Or(d.CachedCheckStrategy, CachedCheckAPI)
+	d.IPLockedBehavior = cmp.Or(d.IPLockedBehavior, IPLockedLinksProxy)
+	d.RateLimitScope = cmp.Or(d.RateLimitScope, RateLimitScopeKey)
+	if d.LargeTorrentFiles == 0 {
+		d.LargeTorrentFiles = 1000
+	}
+	d.LargeTorrentCheckLimit = cmp.Or(d.LargeTorrentCheckLimit, 50)
+	d.ChecksumMismatch = cmp.Or(d.ChecksumMismatch, ChecksumMismatchError)
+	d.StaleHandles = cmp.Or(d.StaleHandles, StaleHandlesResolve)
+	d.MissingContentLength = cmp.Or(d.MissingContentLength, MissingContentLengthMetadata)
+	d.ExpiryAction = cmp.Or(d.ExpiryAction, ExpiryActionKeepAlive)
+	d.ExpiryWarning = cmp.Or(d.ExpiryWarning, "24h")
+	d.APIKeyChange = cmp.Or(d.APIKeyChange, APIKeyChangeValidate)
+
+	if d.MaxIdleConns == 0 {
+		d.MaxIdleConns = 100 // Keep plenty of warm connections around for concurrent streams
+	}
+	d.IdleConnTimeout = cmp.Or(d.IdleConnTimeout, "90s")
+	d.PremiumCheckInterval = cmp.Or(d.PremiumCheckInterval, "1h")
+	d.HealthCheckInterval = cmp.Or(d.HealthCheckInterval, "5m")
+
+	if d.CircuitBreaker.Threshold == 0 {
+		d.CircuitBreaker.Threshold = 5
+	}
+	if d.SoftBanThreshold == 0 {
+		d.SoftBanThreshold = 5
+	}
+	if d.RetryAttempts == 0 {
+		d.RetryAttempts = 3
+	}
+	d.RetryBaseDelay = cmp.Or(d.RetryBaseDelay, "1s")
+	d.SoftBanWindow = cmp.Or(d.SoftBanWindow, "1m")
+	d.SoftBanCooldown = cmp.Or(d.SoftBanCooldown, "15m")
+	d.SoftBanRateLimit = cmp.Or(d.SoftBanRateLimit, "6/minute")
+
+	d.TrafficBudgetWindow = cmp.Or(d.TrafficBudgetWindow, "24h")
+	if d.TrafficBudgetThreshold == 0 {
+		d.TrafficBudgetThreshold = 90
+	}
+	if d.UnlockQuotaThreshold == 0 {
+		d.UnlockQuotaThreshold = 90
+	}
+
 	if !d.UseWebDav {
 		return d
 	}
@@ -310,7 +1898,7 @@ func (c *Config) updateDebrid(d Debrid) Debrid {
 		d.DownloadLinksRefreshInterval = cmp.Or(c.WebDav.DownloadLinksRefreshInterval, "40m") // 40 minutes
 	}
 	if d.Workers == 0 {
-		d.Workers = perDebrid
+		d.Workers = c.defaultWorkers()
 	}
 	if d.FolderNaming == "" {
 		d.FolderNaming = cmp.Or(c.WebDav.FolderNaming, "original_no_ext")
@@ -318,6 +1906,28 @@ func (c *Config) updateDebrid(d Debrid) Debrid {
 	if d.AutoExpireLinksAfter == "" {
 		d.AutoExpireLinksAfter = cmp.Or(c.WebDav.AutoExpireLinksAfter, "3d") // 2 days
 	}
+	d.PreWarmAvailability = d.PreWarmAvailability || c.WebDav.PreWarmAvailability
+	d.CaseInsensitiveNames = d.CaseInsensitiveNames || c.WebDav.CaseInsensitiveNames
+	d.DedupFiles = d.DedupFiles || c.WebDav.DedupFiles
+	d.RangeCoalesceWindow = cmp.Or(d.RangeCoalesceWindow, c.WebDav.RangeCoalesceWindow)
+	d.ReadAheadSize = cmp.Or(d.ReadAheadSize, c.WebDav.ReadAheadSize, "4MB")
+	d.CacheSize = cmp.Or(d.CacheSize, c.WebDav.CacheSize)
+	d.FileSortOrder = cmp.Or(d.FileSortOrder, c.WebDav.FileSortOrder, FileSortByName)
+	d.IncompleteDownloads = cmp.Or(d.IncompleteDownloads, c.WebDav.IncompleteDownloads, IncompleteDownloadsHide)
+	d.PropfindErrors = cmp.Or(d.PropfindErrors, c.WebDav.PropfindErrors, PropfindErrorsSkip)
+	d.DirectoryGet = cmp.Or(d.DirectoryGet, c.WebDav.DirectoryGet, DirectoryGetIndex)
+	d.DirectoryIndexFiles = cmp.Or(d.DirectoryIndexFiles, c.WebDav.DirectoryIndexFiles, DirectoryIndexFilesAll)
+	if len(d.FolderNamePrecedence) == 0 {
+		d.FolderNamePrecedence = slices.Clone(c.WebDav.FolderNamePrecedence)
+	}
+	if len(d.FolderNamePrecedence) == 0 {
+		d.FolderNamePrecedence = []FolderNameSource{FolderNameDebrid}
+	}
+	d.FileListChanges = cmp.Or(d.FileListChanges, c.WebDav.FileListChanges, FileListPin)
+	d.UnlockedFilenames = cmp.Or(d.UnlockedFilenames, c.WebDav.UnlockedFilenames, UnlockedFilenamesPreserve)
+	if d.PreWarmWorkers <= 0 {
+		d.PreWarmWorkers = cmp.Or(c.WebDav.PreWarmWorkers, 2)
+	}
 
 	// Merge debrid specified directories with global directories
 
@@ -339,15 +1949,20 @@ func (c *Config) updateDebrid(d Debrid) Debrid {
 }
 
 func (c *Config) setDefaults() {
+	c.DuplicateDebridNames = cmp.Or(c.DuplicateDebridNames, DuplicateDebridError)
+	c.RateLimitReload = cmp.Or(c.RateLimitReload, RateLimitReloadCarry)
+	c.WebDav.RootLayout = cmp.Or(c.WebDav.RootLayout, RootLayoutDebrid)
+	if c.DuplicateDebridNames == DuplicateDebridRename {
+		c.renameDuplicateDebrids()
+	}
 	for i, debrid := range c.Debrids {
 		c.Debrids[i] = c.updateDebrid(debrid)
 	}
 
-	if len(c.AllowedExt) == 0 {
-		c.AllowedExt = getDefaultExtensions()
-	}
+	// The configured list is saved as is, so DefaultExtensionsToken or an empty list follow the defaults of each version
+	c.allowedExt = expandExtensions(c.AllowedExt)
 
-	c.Port = cmp.Or(c.Port, c.QBitTorrent.Port)
+	c.migratePort()
 
 	if c.URLBase == "" {
 		c.URLBase = "/"
@@ -360,37 +1975,93 @@ func (c *Config) setDefaults() {
 		c.URLBase += "/"
 	}
 
+	c.ArrTimeout = cmp.Or(c.ArrTimeout, "60s")
+	c.ArrSearchMode = cmp.Or(c.ArrSearchMode, ArrSearchItems)
+	if c.ArrMaxRetries == 0 {
+		c.ArrMaxRetries = 3
+	}
+	if c.ArrRescanRetries == 0 {
+		c.ArrRescanRetries = 10
+	}
+	c.DebridFullCooldown = cmp.Or(c.DebridFullCooldown, "15m")
+	c.ShutdownTimeout = cmp.Or(c.ShutdownTimeout, "30s")
+
+	c.NoVideoPolicy = cmp.Or(c.NoVideoPolicy, NoVideoAllow)
+	c.UnknownSizePolicy = cmp.Or(c.UnknownSizePolicy, UnknownSizeOptimistic)
+	c.ArchiveFilter = cmp.Or(c.ArchiveFilter, ArchiveFilterContents)
+	c.DiscordOverflow = cmp.Or(c.DiscordOverflow, DiscordOverflowTruncate)
+	c.NotificationDedup = cmp.Or(c.NotificationDedup, NotificationDedupSummarize)
+	c.MaintenanceAdds = cmp.Or(c.MaintenanceAdds, MaintenanceAddsQueue)
+	c.StorageMode = cmp.Or(c.StorageMode, StorageLocal)
+	c.NamelessMagnetPolicy = cmp.Or(c.NamelessMagnetPolicy, NamelessMagnetInfoHash)
+	c.NamelessMagnetTemplate = cmp.Or(c.NamelessMagnetTemplate, "Unnamed {hash}")
+	c.ArrSourcePreference = cmp.Or(c.ArrSourcePreference, ArrSourceConfig)
+	c.QBitTorrent.MaindataHistory = cmp.Or(c.QBitTorrent.MaindataHistory, 20)
+
 	// Set repair defaults
 	if c.Repair.Strategy == "" {
 		c.Repair.Strategy = RepairStrategyPerTorrent
 	}
+	c.Repair.StreamingBehavior = cmp.Or(c.Repair.StreamingBehavior, StreamingPause)
+	c.Repair.ReInsertFailure = cmp.Or(c.Repair.ReInsertFailure, ReInsertFailureBad)
+	c.Repair.DeleteDuringRepair = cmp.Or(c.Repair.DeleteDuringRepair, DeleteDuringRepairAbort)
 
 	// Load the auth file
 	c.Auth = c.GetAuth()
 }
 
+// Save writes the config to disk, use Update to change it
 func (c *Config) Save() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	return c.save()
+}
+
+// Update applies fn to the config and saves it. Updates and saves are serialized, so concurrent updates of
+// different fields are all persisted. The changes are kept in memory if the config is read-only.
+func (c *Config) Update(fn func(c *Config)) error {
+	return c.updateIf(func(c *Config) bool {
+		fn(c)
+		return true
+	})
+}
 
+// updateIf is Update, only saving if fn reports a change
+func (c *Config) updateIf(fn func(c *Config) bool) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if !fn(c) {
+		return nil
+	}
+	return c.save()
+}
+
+func (c *Config) save() error {
 	c.setDefaults()
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	data, err := c.fileJSON()
 	if err != nil {
 		return err
 	}
+	configFile := c.ConfigFile()
+	original, _ := os.ReadFile(configFile)
+	if data, err = configFromJSON(configFile, data, original); err != nil {
+		return err
+	}
 
-	if err := os.WriteFile(c.JsonFile(), data, 0644); err != nil {
+	if err := c.WriteFile(configFile, data); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *Config) createConfig(path string) error {
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
-	}
-
 	c.Path = path
+	c.ConfigVersion = CurrentConfigVersion
 	c.URLBase = "/"
 	c.Port = "8282"
 	c.LogLevel = "info"
@@ -400,13 +2071,55 @@ func (c *Config) createConfig(path string) error {
 		Categories:      []string{"sonarr", "radarr"},
 		RefreshInterval: 15,
 	}
+	if skipAuth {
+		c.UseAuth = false
+	}
+	if templatePath != "" {
+		if err := c.applyTemplate(templatePath); err != nil {
+			return err
+		}
+	}
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	return nil
+}
+
+// applyTemplate overlays the template file on the default config. Unknown fields are rejected, to catch typos,
+// and the resulting config must be valid.
+func (c *Config) applyTemplate(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config template: %w", err)
+	}
+	if data, err = configToJSON(filename, data); err != nil {
+		return fmt.Errorf("invalid config template %s: %w", filename, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid config template %s: %w", filename, err)
+	}
+	if skipAuth {
+		c.UseAuth = false
+	}
+
+	c.setDefaults()
+	if err := ValidateConfig(c); err != nil {
+		return fmt.Errorf("invalid config template %s: %w", filename, err)
+	}
+	fmt.Printf("Created config from template %s\n", filename)
 	return nil
 }
 
 // Reload forces a reload of the configuration from disk
 func Reload() {
+	instanceMu.Lock()
+	defer instanceMu.Unlock()
 	instance = nil
-	once = sync.Once{}
+	once = &sync.Once{}
 }
 
 func DefaultFreeSlot() int {
@@ -0,0 +1,841 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testDebrid returns a valid debrid with its defaults set, modified by opts
+func testDebrid(opts ...func(*Debrid)) Debrid {
+	d := Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__"}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return (&Config{}).updateDebrid(d)
+}
+
+// testConfig returns a valid config with a single debrid, modified by opts
+func testConfig(opts ...func(*Config)) *Config {
+	c := &Config{
+		QBitTorrent: QBitTorrent{DownloadFolder: os.TempDir()},
+		Debrids:     []Debrid{testDebrid()},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func TestCachedPromotionInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+		valid    bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"5m", 5 * time.Minute, true},
+		{"30s", 30 * time.Second, false},
+		{"-1m", 0, true},
+		{"often", 0, false},
+	}
+	for _, tt := range tests {
+		d := testDebrid(func(d *Debrid) { d.CachedPromotionInterval = tt.interval })
+		if got := d.GetCachedPromotionInterval(); got != tt.want {
+			t.Errorf("GetCachedPromotionInterval(%q) = %v, want %v", tt.interval, got, tt.want)
+		}
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != tt.valid {
+			t.Errorf("validateDebrids with cached_promotion_interval %q: %v, want valid %v", tt.interval, errs, tt.valid)
+		}
+	}
+}
+
+func TestNamelessMagnetName(t *testing.T) {
+	const hash = "0123456789ABCDEF0123456789ABCDEF01234567"
+	tests := []struct {
+		policy   NamelessMagnetPolicy
+		template string
+		want     string
+	}{
+		{"", "", "0123456789abcdef0123456789abcdef01234567"},
+		{NamelessMagnetInfoHash, "Unnamed {hash}", "0123456789abcdef0123456789abcdef01234567"},
+		{NamelessMagnetMetadata, "", "0123456789abcdef0123456789abcdef01234567"},
+		{NamelessMagnetTemplate, "", "Unnamed 0123456789abcdef0123456789abcdef01234567"},
+		{NamelessMagnetTemplate, "Magnet {hash} ({hash})", "Magnet 0123456789abcdef0123456789abcdef01234567 (0123456789abcdef0123456789abcdef01234567)"},
+	}
+	for _, tt := range tests {
+		c := &Config{NamelessMagnetPolicy: tt.policy, NamelessMagnetTemplate: tt.template}
+		if got := c.NamelessMagnetName(hash); got != tt.want {
+			t.Errorf("NamelessMagnetName with %s %q = %q, want %q", tt.policy, tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestNamelessMagnetValidation(t *testing.T) {
+	tests := []struct {
+		policy   NamelessMagnetPolicy
+		template string
+		valid    bool
+	}{
+		{"", "", true},
+		{NamelessMagnetMetadata, "", true},
+		{NamelessMagnetTemplate, "Unnamed {hash}", true},
+		{NamelessMagnetTemplate, "Unnamed", false},
+		{"hash", "", false},
+	}
+	for _, tt := range tests {
+		c := testConfig(func(c *Config) {
+			c.NamelessMagnetPolicy = tt.policy
+			c.NamelessMagnetTemplate = tt.template
+		})
+		if err := c.Validate(); (err == nil) != tt.valid {
+			t.Errorf("nameless_magnet_policy %q with template %q: %v, want valid %v", tt.policy, tt.template, err, tt.valid)
+		}
+	}
+}
+
+func TestCheckCachedBatchSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		want      int
+		valid     bool
+	}{
+		{"realdebrid", 0, 200, true},
+		{"realdebrid", 50, 50, true},
+		{"realdebrid", 201, 200, false},
+		{"torbox", 0, 100, true},
+		{"torbox", 150, 100, false},
+		{"realdebrid", -1, 200, false},
+	}
+	for _, tt := range tests {
+		d := testDebrid(func(d *Debrid) {
+			d.Name = tt.name
+			d.CheckCachedBatchSize = tt.batchSize
+		})
+		if got := d.GetCheckCachedBatchSize(); got != tt.want {
+			t.Errorf("%s GetCheckCachedBatchSize with %d = %d, want %d", tt.name, tt.batchSize, got, tt.want)
+		}
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != tt.valid {
+			t.Errorf("%s check_cached_batch_size %d: %v, want valid %v", tt.name, tt.batchSize, errs, tt.valid)
+		}
+	}
+}
+
+func TestReadinessDurations(t *testing.T) {
+	d := testDebrid(func(d *Debrid) {
+		d.ReadinessDelay = "30s"
+		d.ReadinessTimeout = "5m"
+	})
+	if d.GetReadinessDelay() != 30*time.Second || d.GetReadinessTimeout() != 5*time.Minute {
+		t.Errorf("readiness = %s, %s, want 30s, 5m", d.GetReadinessDelay(), d.GetReadinessTimeout())
+	}
+	if errs := validateDebrids([]Debrid{d}); len(errs) > 0 {
+		t.Errorf("validateDebrids() = %v, want no errors", errs)
+	}
+
+	d.ReadinessTimeout = "soon"
+	if d.GetReadinessTimeout() != 0 {
+		t.Errorf("GetReadinessTimeout() = %s for an invalid duration, want 0", d.GetReadinessTimeout())
+	}
+	if errs := validateDebrids([]Debrid{d}); len(errs) == 0 {
+		t.Error("validateDebrids() accepted an invalid readiness_timeout")
+	}
+}
+
+func TestRepairStreamingValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(*Repair)
+		valid bool
+	}{
+		{"disabled", func(r *Repair) {}, true},
+		{"pause", func(r *Repair) { r.StreamingThreshold, r.StreamingBehavior = 2, StreamingPause }, true},
+		{"throttle", func(r *Repair) { r.StreamingThreshold, r.StreamingBehavior = 2, StreamingThrottle }, true},
+		{"negative threshold", func(r *Repair) { r.StreamingThreshold = -1 }, false},
+		{"unknown behavior", func(r *Repair) { r.StreamingBehavior = "stop" }, false},
+		{"invalid resume after", func(r *Repair) { r.StreamingResumeAfter = "later" }, false},
+	}
+	for _, tt := range tests {
+		r := Repair{Enabled: true, Interval: "1h"}
+		tt.set(&r)
+		if errs := validateRepair(&r); (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: validateRepair() = %v, want valid %v", tt.name, errs, tt.valid)
+		}
+	}
+
+	r := Repair{StreamingResumeAfter: "30s"}
+	if got := r.GetStreamingResumeAfter(); got != 30*time.Second {
+		t.Errorf("GetStreamingResumeAfter() = %s, want 30s", got)
+	}
+	if got := (&Repair{}).GetStreamingResumeAfter(); got != time.Minute {
+		t.Errorf("GetStreamingResumeAfter() = %s by default, want 1m", got)
+	}
+}
+
+func TestDuplicateDownloadAPIKeys(t *testing.T) {
+	d := testDebrid(func(d *Debrid) { d.DownloadAPIKeys = []string{"a", "b", " a ", "", "c", "b"} })
+	if got := strings.Join(d.DownloadAPIKeys, ","); got != "a,b,c" {
+		t.Errorf("DownloadAPIKeys = %s, want a,b,c", got)
+	}
+
+	d = testDebrid(func(d *Debrid) { d.DownloadAPIKeys = []string{"", " "} })
+	if got := strings.Join(d.DownloadAPIKeys, ","); got != "key" {
+		t.Errorf("DownloadAPIKeys = %s with only blank keys, want the main API key", got)
+	}
+}
+
+func TestIPLockedBehavior(t *testing.T) {
+	if d := testDebrid(); d.IPLockedBehavior != IPLockedLinksProxy {
+		t.Errorf("IPLockedBehavior = %q by default, want proxy", d.IPLockedBehavior)
+	}
+	for behavior, valid := range map[IPLockedLinks]bool{
+		IPLockedLinksProxy:      true,
+		IPLockedLinksRegenerate: true,
+		IPLockedLinksError:      true,
+		"redirect":              false,
+	} {
+		d := testDebrid(func(d *Debrid) { d.IPLocked, d.IPLockedBehavior = true, behavior })
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != valid {
+			t.Errorf("validateDebrids with ip_locked_behavior %q: %v, want valid %v", behavior, errs, valid)
+		}
+	}
+}
+
+// Concurrent updates of different fields are all saved
+func TestConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	data, err := json.Marshal(map[string]any{
+		"qbittorrent": map[string]any{"download_folder": t.TempDir()},
+		"debrids":     []Debrid{{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__"}},
+		"webdav":      map[string]any{"auto_category_directories": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigPath(dir)
+	Reload()
+	c := Get()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.AddCategoryDirectory(fmt.Sprintf("category%d", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := c.Update(func(c *Config) {
+				c.QBitTorrent.Categories = append(c.QBitTorrent.Categories, fmt.Sprintf("arr%d", i))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	Reload()
+	saved := Get()
+	if len(saved.WebDav.Directories) != 10 || len(saved.QBitTorrent.Categories) != 10 {
+		t.Errorf("saved %d directories and %d categories, want 10 of each", len(saved.WebDav.Directories), len(saved.QBitTorrent.Categories))
+	}
+}
+
+func TestArchiveFilterValidation(t *testing.T) {
+	for filter, valid := range map[ArchiveFilter]bool{
+		"":                    true,
+		ArchiveFilterContents: true,
+		ArchiveFilterArchive:  true,
+		"files":               false,
+	} {
+		c := testConfig(func(c *Config) { c.ArchiveFilter = filter })
+		if err := c.Validate(); (err == nil) != valid {
+			t.Errorf("archive_filter %q: %v, want valid %v", filter, err, valid)
+		}
+	}
+}
+
+func TestExpiryValidation(t *testing.T) {
+	d := testDebrid()
+	if d.ExpiryAction != ExpiryActionKeepAlive || d.GetExpiryWarning() != 24*time.Hour {
+		t.Errorf("expiry = %q, %s by default, want keep_alive, 24h", d.ExpiryAction, d.GetExpiryWarning())
+	}
+	tests := []struct {
+		action  ExpiryAction
+		warning string
+		valid   bool
+	}{
+		{ExpiryActionNotify, "2h", true},
+		{ExpiryActionNone, "", true},
+		{"delete", "", false},
+		{ExpiryActionKeepAlive, "a day", false},
+	}
+	for _, tt := range tests {
+		d := testDebrid(func(d *Debrid) { d.ExpiryAction, d.ExpiryWarning = tt.action, tt.warning })
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != tt.valid {
+			t.Errorf("expiry_action %q with expiry_warning %q: %v, want valid %v", tt.action, tt.warning, errs, tt.valid)
+		}
+	}
+}
+
+func TestFileSizeLimits(t *testing.T) {
+	size := func(s string) int64 {
+		n, err := ParseSize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	c := &Config{
+		MinFileSize: "10MB",
+		MaxFileSize: "50GB",
+		Debrids: []Debrid{
+			{Name: "realdebrid", MinFileSize: "100MB"},
+			{Name: "torbox", MaxFileSize: "0"},
+		},
+		Arrs: []Arr{
+			{Name: "sonarr", MaxFileSize: "5GB"},
+			{Name: "radarr", MinFileSize: "0"},
+		},
+	}
+	tests := []struct {
+		debrid, arr string
+		min, max    int64
+	}{
+		{"", "", size("10MB"), size("50GB")},
+		{"alldebrid", "lidarr", size("10MB"), size("50GB")}, // Neither configured
+		{"realdebrid", "", size("100MB"), size("50GB")},
+		{"torbox", "", size("10MB"), 0}, // 0 lifts the global limit
+		{"realdebrid", "sonarr", size("100MB"), size("5GB")},
+		{"realdebrid", "radarr", 0, size("50GB")}, // The arr wins over the debrid
+		{"torbox", "sonarr", size("10MB"), size("5GB")},
+	}
+	for _, tt := range tests {
+		minSize, maxSize := c.FileSizeLimits(tt.debrid, tt.arr)
+		if minSize != tt.min || maxSize != tt.max {
+			t.Errorf("FileSizeLimits(%q, %q) = %d, %d, want %d, %d", tt.debrid, tt.arr, minSize, maxSize, tt.min, tt.max)
+		}
+	}
+	if c.IsSizeAllowedFor(size("20MB"), "realdebrid", "") || !c.IsSizeAllowedFor(size("20MB"), "realdebrid", "radarr") {
+		t.Error("IsSizeAllowedFor() didn't follow the limits of the debrid and arr")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	const fallback = "https://api.real-debrid.com/rest/1.0"
+	tests := []struct {
+		endpoint string
+		want     string
+		valid    bool
+	}{
+		{"", fallback, true},
+		{"https://eu.example.com/rest/1.0/", "https://eu.example.com/rest/1.0", true},
+		{"http://localhost:8080", "http://localhost:8080", true},
+		{"eu.example.com", "", false},
+		{"ftp://eu.example.com", "", false},
+	}
+	for _, tt := range tests {
+		d := testDebrid(func(d *Debrid) { d.Endpoint = tt.endpoint })
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != tt.valid {
+			t.Errorf("endpoint %q: %v, want valid %v", tt.endpoint, errs, tt.valid)
+		}
+		if got := d.GetEndpoint(fallback); tt.valid && got != tt.want {
+			t.Errorf("GetEndpoint() = %q with endpoint %q, want %q", got, tt.endpoint, tt.want)
+		}
+	}
+}
+
+func TestUnknownSizePolicyValidation(t *testing.T) {
+	for policy, valid := range map[UnknownSizePolicy]bool{
+		"":                     true,
+		UnknownSizeOptimistic:  true,
+		UnknownSizePessimistic: true,
+		"strict":               false,
+	} {
+		c := testConfig(func(c *Config) { c.UnknownSizePolicy = policy })
+		if err := c.Validate(); (err == nil) != valid {
+			t.Errorf("unknown_size_policy %q: %v, want valid %v", policy, err, valid)
+		}
+	}
+}
+
+func TestRepairStrategyFor(t *testing.T) {
+	c := testConfig(func(c *Config) {
+		c.Repair.Strategy = RepairStrategyPerFile
+		c.Arrs = []Arr{{Name: "sonarr", RepairStrategy: RepairStrategyPerTorrent}, {Name: "radarr"}}
+	})
+	for arr, want := range map[string]RepairStrategy{"": RepairStrategyPerFile, "sonarr": RepairStrategyPerTorrent, "radarr": RepairStrategyPerFile, "lidarr": RepairStrategyPerFile} {
+		if got := c.RepairStrategyFor(arr); got != want {
+			t.Errorf("RepairStrategyFor(%q) = %s, want %s", arr, got, want)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c.Arrs[0].RepairStrategy = "per_season"
+	if err := c.Validate(); err == nil {
+		t.Error("Validate() accepted an invalid repair_strategy of an arr")
+	}
+}
+
+func TestRateLimitScopeValidation(t *testing.T) {
+	if d := testDebrid(); d.RateLimitScope != RateLimitScopeKey {
+		t.Errorf("RateLimitScope = %q by default, want key", d.RateLimitScope)
+	}
+	for scope, valid := range map[RateLimitScope]bool{
+		RateLimitScopeKey:     true,
+		RateLimitScopeAccount: true,
+		"global":              false,
+	} {
+		d := testDebrid(func(d *Debrid) { d.RateLimitScope = scope })
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != valid {
+			t.Errorf("validateDebrids with rate_limit_scope %q: %v, want valid %v", scope, errs, valid)
+		}
+	}
+}
+
+// A debrid without directory_get or directory_index_files inherits the webdav ones
+func TestDirectoryGetDefaults(t *testing.T) {
+	if d := testDebrid(func(d *Debrid) { d.UseWebDav = true }); d.DirectoryGet != DirectoryGetIndex || d.DirectoryIndexFiles != DirectoryIndexFilesAll {
+		t.Errorf("directory GET = %q, %q by default, want index, all", d.DirectoryGet, d.DirectoryIndexFiles)
+	}
+	c := &Config{WebDav: WebDav{DirectoryGet: DirectoryGetReject, DirectoryIndexFiles: DirectoryIndexFilesAllowed}}
+	if d := c.updateDebrid(Debrid{Name: "realdebrid", UseWebDav: true}); d.DirectoryGet != DirectoryGetReject || d.DirectoryIndexFiles != DirectoryIndexFilesAllowed {
+		t.Errorf("directory GET = %q, %q, want the webdav reject, allowed", d.DirectoryGet, d.DirectoryIndexFiles)
+	}
+	d := Debrid{Name: "realdebrid", UseWebDav: true}
+	d.DirectoryGet = DirectoryGetIndex
+	if d := c.updateDebrid(d); d.DirectoryGet != DirectoryGetIndex {
+		t.Errorf("directory_get = %q, want the index of the debrid", d.DirectoryGet)
+	}
+
+	for _, set := range []func(*Config){
+		func(c *Config) { c.WebDav.DirectoryGet = "redirect" },
+		func(c *Config) { c.WebDav.DirectoryIndexFiles = "none" },
+		func(c *Config) { c.Debrids[0].DirectoryGet = "redirect" },
+	} {
+		if err := testConfig(set).Validate(); err == nil {
+			t.Error("Validate() accepted an invalid directory GET setting")
+		}
+	}
+}
+
+func TestUnlockQuotaValidation(t *testing.T) {
+	if d := testDebrid(); d.UnlockQuotaThreshold != 90 {
+		t.Errorf("UnlockQuotaThreshold = %d by default, want 90", d.UnlockQuotaThreshold)
+	}
+	tests := []struct {
+		quota, threshold int
+		valid            bool
+	}{
+		{100, 80, true},
+		{100, 100, true},
+		{-1, 90, false},
+		{100, 101, false},
+		{100, -5, false},
+	}
+	for _, tt := range tests {
+		d := testDebrid(func(d *Debrid) { d.UnlockQuota, d.UnlockQuotaThreshold = tt.quota, tt.threshold })
+		if errs := validateDebrids([]Debrid{d}); (len(errs) == 0) != tt.valid {
+			t.Errorf("unlock_quota %d with unlock_quota_threshold %d: %v, want valid %v", tt.quota, tt.threshold, errs, tt.valid)
+		}
+	}
+}
+
+func TestFolderNamePrecedence(t *testing.T) {
+	d := testDebrid(func(d *Debrid) { d.UseWebDav = true })
+	if len(d.FolderNamePrecedence) != 1 || d.FolderNamePrecedence[0] != FolderNameDebrid {
+		t.Errorf("FolderNamePrecedence = %v by default, want [debrid]", d.FolderNamePrecedence)
+	}
+	c := &Config{WebDav: WebDav{FolderNamePrecedence: []FolderNameSource{FolderNameArr, FolderNameDebrid}}}
+	if d := c.updateDebrid(Debrid{Name: "realdebrid", UseWebDav: true}); len(d.FolderNamePrecedence) != 2 || d.FolderNamePrecedence[0] != FolderNameArr {
+		t.Errorf("FolderNamePrecedence = %v, want the webdav [arr debrid]", d.FolderNamePrecedence)
+	}
+
+	tests := []struct {
+		sources []FolderNameSource
+		valid   bool
+	}{
+		{nil, true},
+		{[]FolderNameSource{FolderNameMagnet, FolderNameArr, FolderNameDebrid}, true},
+		{[]FolderNameSource{FolderNameArr, "torrent"}, false},
+		{[]FolderNameSource{FolderNameArr, FolderNameArr}, false},
+	}
+	for _, tt := range tests {
+		if err := validateFolderNamePrecedence(tt.sources); (err == nil) != tt.valid {
+			t.Errorf("validateFolderNamePrecedence(%v) = %v, want valid %v", tt.sources, err, tt.valid)
+		}
+	}
+}
+
+func TestMissingContentLengthValidation(t *testing.T) {
+	if d := testDebrid(func(d *Debrid) { d.UseWebDav = true }); d.MissingContentLength != MissingContentLengthMetadata {
+		t.Errorf("MissingContentLength = %q by default, want metadata", d.MissingContentLength)
+	}
+	d := testDebrid(func(d *Debrid) { d.MissingContentLength = "guess" })
+	if errs := validateDebrids([]Debrid{d}); len(errs) == 0 {
+		t.Error("validateDebrids() accepted an invalid missing_content_length")
+	}
+}
+
+// The errors of fields checked together come out in the order the fields are listed
+func TestValidationErrorOrder(t *testing.T) {
+	d := testDebrid(func(d *Debrid) {
+		d.RateLimit, d.DownloadRateLimit, d.SoftBanRateLimit = "fast", "faster", "fastest"
+	})
+	for range 20 {
+		errs := validateDebrids([]Debrid{d})
+		if len(errs) != 3 || !strings.Contains(errs[0].Error(), "invalid rate_limit") || !strings.Contains(errs[2].Error(), "soft_ban_rate_limit") {
+			t.Fatalf("validateDebrids() = %v, want the rate_limit error first", errs)
+		}
+	}
+}
+
+func TestReadOnlyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"read-only filesystem", &os.PathError{Op: "open", Path: "config.json", Err: syscall.EROFS}, true},
+		{"permission denied", &os.PathError{Op: "open", Path: "config.json", Err: syscall.EACCES}, true},
+		{"wrapped", fmt.Errorf("failed to create config directory: %w", os.ErrPermission), true},
+		{"missing", &os.PathError{Op: "open", Path: "config.json", Err: syscall.ENOENT}, false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyError(tt.err); got != tt.want {
+			t.Errorf("%s: isReadOnlyError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"debrids":[]}`), 0444); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Path: dir, readOnly: true}
+	if err := c.Update(func(c *Config) { c.Port = "9090" }); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Update() on a read-only config = %v, want ErrReadOnly", err)
+	}
+	if data, _ := os.ReadFile(configFile); string(data) != `{"debrids":[]}` {
+		t.Errorf("read-only config file was written: %s", data)
+	}
+
+	if isWritable(configFile) {
+		t.Skip("the config file is writable despite its mode, e.g. when running as root")
+	}
+	SetConfigPath(dir)
+	Reload()
+	if !Get().IsReadOnly() {
+		t.Error("IsReadOnly() = false after loading a read-only config file")
+	}
+}
+
+func TestConnectionPoolValidation(t *testing.T) {
+	d := testDebrid()
+	if d.MaxIdleConns != 100 || d.MaxConnsPerHost != 0 || d.GetIdleConnTimeout() != 90*time.Second {
+		t.Errorf("pool defaults = %d idle, %d per host, %v, want 100, 0 and 90s", d.MaxIdleConns, d.MaxConnsPerHost, d.GetIdleConnTimeout())
+	}
+	tests := []struct {
+		name  string
+		opt   func(*Debrid)
+		valid bool
+	}{
+		{"pool", func(d *Debrid) { d.MaxIdleConns, d.MaxConnsPerHost, d.IdleConnTimeout = 20, 8, "2m" }, true},
+		{"negative max_idle_conns", func(d *Debrid) { d.MaxIdleConns = -1 }, false},
+		{"negative max_conns_per_host", func(d *Debrid) { d.MaxConnsPerHost = -1 }, false},
+		{"invalid idle_conn_timeout", func(d *Debrid) { d.IdleConnTimeout = "soon" }, false},
+	}
+	for _, tt := range tests {
+		if errs := validateDebrids([]Debrid{testDebrid(tt.opt)}); (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: validateDebrids() = %v, want valid %v", tt.name, errs, tt.valid)
+		}
+	}
+}
+
+// A new category gets a WebDav directory, saved to the config file and merged into the debrids
+func TestAddCategoryDirectory(t *testing.T) {
+	tests := []struct {
+		name        string
+		auto        bool
+		template    map[string]string
+		category    string
+		wantAdded   bool
+		wantFilters map[string]string
+	}{
+		{"disabled", false, nil, "sonarr", false, nil},
+		{"default filters", true, nil, "sonarr", true, map[string]string{"category": "sonarr"}},
+		{"template", true, map[string]string{"regex": "^{category} ", "size_gt": "1GB"}, "tv.4k", true, map[string]string{"regex": `^tv\.4k `, "size_gt": "1GB"}},
+		{"existing directory", true, nil, "radarr", false, map[string]string{"category": "movies"}},
+		{"no category", true, nil, "", false, nil},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		data, err := json.Marshal(map[string]any{
+			"qbittorrent": map[string]any{"download_folder": t.TempDir()},
+			"debrids":     []Debrid{{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__", UseWebDav: true}},
+			"webdav": map[string]any{
+				"auto_category_directories":  tt.auto,
+				"category_directory_filters": tt.template,
+				"directories":                map[string]WebdavDirectories{"radarr": {Filters: map[string]string{"category": "movies"}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		SetConfigPath(dir)
+		Reload()
+		_, added, err := Get().AddCategoryDirectory(tt.category)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if added != tt.wantAdded {
+			t.Errorf("%s: added = %v, want %v", tt.name, added, tt.wantAdded)
+		}
+
+		Reload()
+		saved, ok := Get().WebDav.Directories[tt.category]
+		if ok != (tt.wantFilters != nil) || !maps.Equal(saved.Filters, tt.wantFilters) {
+			t.Errorf("%s: saved directory %v, want %v", tt.name, saved.Filters, tt.wantFilters)
+		}
+		if db, _ := Get().GetDebrid("realdebrid"); tt.wantAdded && !maps.Equal(db.Directories[tt.category].Filters, tt.wantFilters) {
+			t.Errorf("%s: debrid directory %v, want %v", tt.name, db.Directories[tt.category].Filters, tt.wantFilters)
+		}
+	}
+}
+
+func TestLenientLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		wantFailed []string
+		wantErr    bool // Not a JSON object, nothing can be loaded
+	}{
+		{"valid", `{"port": "9090", "log_level": "debug"}`, []string{}, false},
+		{"malformed top-level field", `{"port": 9090, "log_level": "debug"}`, []string{"port"}, false},
+		{"malformed nested field", `{"port": "9090", "qbittorrent": {"download_folder": "/downloads", "categories": "tv"}}`, []string{"qbittorrent.categories"}, false},
+		{"malformed nested and top-level fields", `{"port": true, "qbittorrent": {"download_folder": 1, "categories": ["tv"]}}`, []string{"port", "qbittorrent.download_folder"}, false},
+		{"not an object", `["port"]`, nil, true},
+	}
+	for _, tt := range tests {
+		c := &Config{}
+		failed, err := c.unmarshalLenient([]byte(tt.config))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unmarshalLenient() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(failed, tt.wantFailed) {
+			t.Errorf("%s: failed fields = %v, want %v", tt.name, failed, tt.wantFailed)
+		}
+	}
+
+	// The fields that load are kept, the malformed ones keep their defaults
+	c := &Config{}
+	if _, err := c.unmarshalLenient([]byte(`{"port": 9090, "log_level": "debug", "qbittorrent": {"download_folder": "/downloads", "categories": "tv"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "" || c.LogLevel != "debug" || c.QBitTorrent.DownloadFolder != "/downloads" || c.QBitTorrent.Categories != nil {
+		t.Errorf("lenient load = port %q, log level %q, download folder %q, categories %v", c.Port, c.LogLevel, c.QBitTorrent.DownloadFolder, c.QBitTorrent.Categories)
+	}
+
+	// A malformed file fails to load unless lenient, which backs it up first
+	dir := t.TempDir()
+	malformed := `{"port": 9090, "log_level": "debug"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(malformed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigPath(dir)
+	defer SetLenient(false)
+	for _, lenient := range []bool{false, true} {
+		SetLenient(lenient)
+		c := &Config{}
+		err := c.loadConfig()
+		if (err == nil) != lenient {
+			t.Errorf("lenient %v: loadConfig() error = %v", lenient, err)
+		}
+		if lenient && c.LogLevel != "debug" {
+			t.Errorf("lenient: log level = %q, want debug", c.LogLevel)
+		}
+		backups, _ := filepath.Glob(filepath.Join(dir, "config.json.*.bak"))
+		if got := len(backups) == 1; got != lenient {
+			t.Errorf("lenient %v: backups = %v", lenient, backups)
+		}
+		for _, backup := range backups {
+			if data, _ := os.ReadFile(backup); string(data) != malformed {
+				t.Errorf("backup = %s, want %s", data, malformed)
+			}
+		}
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	real = filepath.Join(real, "real")
+	for _, sub := range []string{"downloads/tv", "mount"} {
+		if err := os.MkdirAll(filepath.Join(real, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	sep := string(os.PathSeparator)
+	missing := filepath.Join(dir, "missing") + sep
+
+	tests := []struct {
+		name         string
+		resolve      bool
+		wantDownload string
+		wantCategory string
+		wantDebrid   string
+	}{
+		{"resolved", true, filepath.Join(real, "downloads") + sep, filepath.Join(real, "downloads", "tv"), filepath.Join(real, "mount")},
+		{"preserved", false, filepath.Join(link, "downloads") + sep, filepath.Join(link, "downloads", "tv"), filepath.Join(link, "mount")},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(map[string]any{
+			"resolve_symlinks": tt.resolve,
+			"qbittorrent": map[string]any{
+				"download_folder": filepath.Join(link, "downloads") + sep,
+				"category_settings": map[string]any{
+					"tv":     map[string]any{"download_folder": filepath.Join(link, "downloads", "tv")},
+					"movies": map[string]any{"download_folder": missing},
+				},
+			},
+			"debrids": []map[string]any{{"name": "realdebrid", "api_key": "key", "folder": filepath.Join(link, "mount")}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		SetConfigPath(dir)
+		c := &Config{}
+		if err := c.loadConfig(); err != nil {
+			t.Fatalf("%s: loadConfig() error = %v", tt.name, err)
+		}
+		if got := c.QBitTorrent.DownloadFolder; got != tt.wantDownload {
+			t.Errorf("%s: download folder = %q, want %q", tt.name, got, tt.wantDownload)
+		}
+		if got := c.QBitTorrent.CategoryDownloadFolder("tv"); got != tt.wantCategory {
+			t.Errorf("%s: tv download folder = %q, want %q", tt.name, got, tt.wantCategory)
+		}
+		if got := c.QBitTorrent.CategoryDownloadFolder("movies"); got != missing {
+			t.Errorf("%s: unresolvable download folder = %q, want it kept as %q", tt.name, got, missing)
+		}
+		if len(c.Debrids) != 1 || c.Debrids[0].Folder != tt.wantDebrid {
+			t.Errorf("%s: debrid folders = %+v, want %q", tt.name, c.Debrids, tt.wantDebrid)
+		}
+	}
+}
+
+func TestUncachedFolderValidation(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		folder string
+		valid  bool
+	}{
+		{"not set", "", true},
+		{"writable folder", dir, true},
+		{"missing folder", filepath.Join(dir, "missing"), false},
+		{"not a folder", file, false},
+	}
+	for _, tt := range tests {
+		if errs := validateDebrids([]Debrid{testDebrid(func(d *Debrid) { d.UncachedFolder = tt.folder })}); (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: validateDebrids() = %v, want valid %v", tt.name, errs, tt.valid)
+		}
+	}
+}
+
+func TestDuplicateDebridNames(t *testing.T) {
+	named := func(name string) Debrid { return testDebrid(func(d *Debrid) { d.Name = name }) }
+	tests := []struct {
+		name      string
+		debrids   []string
+		policy    DuplicateDebridNames
+		wantNames []string // nil if the config is rejected
+	}{
+		{"unique", []string{"realdebrid", "torbox"}, DuplicateDebridError, []string{"realdebrid", "torbox"}},
+		{"rejected", []string{"realdebrid", "torbox", "realdebrid"}, DuplicateDebridError, nil},
+		{"renamed", []string{"realdebrid", "realdebrid", "realdebrid"}, DuplicateDebridRename, []string{"realdebrid", "realdebrid-2", "realdebrid-3"}},
+		{"renamed past a taken suffix", []string{"realdebrid", "realdebrid-2", "realdebrid"}, DuplicateDebridRename, []string{"realdebrid", "realdebrid-2", "realdebrid-3"}},
+	}
+	for _, tt := range tests {
+		c := testConfig(func(c *Config) {
+			c.DuplicateDebridNames = tt.policy
+			c.Debrids = nil
+			for _, name := range tt.debrids {
+				c.Debrids = append(c.Debrids, named(name))
+			}
+		})
+		c.setDefaults()
+		err := c.Validate()
+		if (err != nil) != (tt.wantNames == nil) {
+			t.Errorf("%s: Validate() = %v, want rejected %v", tt.name, err, tt.wantNames == nil)
+			continue
+		}
+		if tt.wantNames == nil {
+			continue
+		}
+		var names []string
+		for _, d := range c.Debrids {
+			names = append(names, d.Name)
+			if d.Provider() != strings.SplitN(d.Name, "-", 2)[0] {
+				t.Errorf("%s: provider of %s = %s", tt.name, d.Name, d.Provider())
+			}
+		}
+		if !slices.Equal(names, tt.wantNames) {
+			t.Errorf("%s: debrids = %v, want %v", tt.name, names, tt.wantNames)
+		}
+	}
+}
+
+func TestDebridPriorityValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority []string
+		valid    bool
+	}{
+		{"none", nil, true},
+		{"known debrid", []string{"realdebrid"}, true},
+		{"unknown debrid", []string{"realdebrid", "torbox"}, false},
+	}
+	for _, tt := range tests {
+		errs := validateArrs([]Arr{{Name: "radarr", DebridPriority: tt.priority}}, []Debrid{testDebrid()})
+		if (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: validateArrs() = %v, want valid %v", tt.name, errs, tt.valid)
+		}
+	}
+}
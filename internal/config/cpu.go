@@ -0,0 +1,64 @@
+package config
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Files the CPU quota of the container is read from, cgroup v2 then v1
+var (
+	cgroupCPUMax      = "/sys/fs/cgroup/cpu.max"
+	cgroupCFSQuota    = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupCFSPeriod   = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+	gomaxprocsFromEnv = func() bool { return os.Getenv("GOMAXPROCS") != "" }
+)
+
+// availableCPUs returns GOMAXPROCS, lowered to the CPU quota of the container rounded up when it has one. Go 1.24
+// sizes GOMAXPROCS from the host CPUs, a GOMAXPROCS environment variable is followed as is.
+func availableCPUs() int {
+	procs := runtime.GOMAXPROCS(0)
+	if gomaxprocsFromEnv() {
+		return procs
+	}
+	if quota, ok := cgroupCPUQuota(); ok {
+		return max(min(procs, int(math.Ceil(quota))), 1)
+	}
+	return procs
+}
+
+// cgroupCPUQuota returns the CPUs the container may use, false if it has no quota
+func cgroupCPUQuota() (float64, bool) {
+	if data, err := os.ReadFile(cgroupCPUMax); err == nil {
+		// "<quota> <period>", the quota is "max" without a limit
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0, false
+		}
+		return parseCPUQuota(fields[0], fields[1])
+	}
+	quota, err := os.ReadFile(cgroupCFSQuota)
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(cgroupCFSPeriod)
+	if err != nil {
+		return 0, false
+	}
+	// The quota is -1 without a limit
+	return parseCPUQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func parseCPUQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAvailableCPUs(t *testing.T) {
+	paths := []*string{&cgroupCPUMax, &cgroupCFSQuota, &cgroupCFSPeriod}
+	saved := []string{cgroupCPUMax, cgroupCFSQuota, cgroupCFSPeriod}
+	fromEnv := gomaxprocsFromEnv
+	t.Cleanup(func() {
+		for i, p := range paths {
+			*p = saved[i]
+		}
+		gomaxprocsFromEnv = fromEnv
+	})
+	gomaxprocsFromEnv = func() bool { return false }
+	procs := runtime.GOMAXPROCS(0)
+
+	tests := []struct {
+		name                  string
+		cpuMax, quota, period string // Empty when the file doesn't exist
+		want                  int
+	}{
+		{name: "no cgroup", want: procs},
+		{name: "v2 unlimited", cpuMax: "max 100000", want: procs},
+		{name: "v2 one CPU", cpuMax: "100000 100000", want: 1},
+		{name: "v2 half a CPU", cpuMax: "50000 100000", want: 1},
+		{name: "v2 above the host", cpuMax: "100000000 100000", want: procs},
+		{name: "v1 unlimited", quota: "-1", period: "100000", want: procs},
+		{name: "v1 one CPU", quota: "100000", period: "100000", want: 1},
+		{name: "invalid", cpuMax: "lots", want: procs},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for i, content := range []string{tt.cpuMax, tt.quota, tt.period} {
+			*paths[i] = filepath.Join(dir, filepath.Base(saved[i]))
+			if content == "" {
+				continue
+			}
+			if err := os.WriteFile(*paths[i], []byte(content+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := availableCPUs(); got != tt.want {
+			t.Errorf("%s: availableCPUs() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// An explicit GOMAXPROCS wins over the quota
+	if err := os.WriteFile(cgroupCPUMax, []byte("100000 100000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gomaxprocsFromEnv = func() bool { return true }
+	if got := availableCPUs(); got != procs {
+		t.Errorf("availableCPUs() = %d with GOMAXPROCS set, want %d", got, procs)
+	}
+}
+
+func TestDefaultWorkers(t *testing.T) {
+	saved, fromEnv := cgroupCPUMax, gomaxprocsFromEnv
+	t.Cleanup(func() { cgroupCPUMax, gomaxprocsFromEnv = saved, fromEnv })
+	// A single CPU, whatever the host
+	gomaxprocsFromEnv = func() bool { return false }
+	cgroupCPUMax = filepath.Join(t.TempDir(), "cpu.max")
+	if err := os.WriteFile(cgroupCPUMax, []byte("100000 100000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		multiplier int
+		debrids    int
+		workers    int // Set on the debrids
+		want       int
+	}{
+		{name: "default multiplier", debrids: 1, want: 50},
+		{name: "split across two debrids", debrids: 2, want: 25},
+		{name: "split across three debrids", multiplier: 10, debrids: 3, want: 3},
+		{name: "at least one worker", multiplier: 1, debrids: 4, want: 1},
+		{name: "workers set", multiplier: 10, debrids: 2, workers: 7, want: 7},
+	}
+	for _, tt := range tests {
+		c := &Config{WorkerMultiplier: tt.multiplier, Debrids: make([]Debrid, tt.debrids)}
+		for i := range c.Debrids {
+			d := Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt", UseWebDav: true}
+			d.Workers = tt.workers
+			c.Debrids[i] = c.updateDebrid(d)
+			if got := c.Debrids[i].Workers; got != tt.want {
+				t.Errorf("%s: workers of debrid %d = %d, want %d", tt.name, i, got, tt.want)
+			}
+		}
+	}
+
+	if err := testConfig(func(c *Config) { c.WorkerMultiplier = -1 }).Validate(); err == nil {
+		t.Error("Validate() accepted a negative worker multiplier")
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables overriding config fields, e.g. DECYPHARR_PORT.
+// Nested fields join the Go field names, DECYPHARR_QBITTORRENT_DOWNLOADFOLDER, and slice elements their index,
+// DECYPHARR_DEBRIDS_0_APIKEY. Maps, like webdav directories, can't be overridden and are only read from the file.
+const envPrefix = "DECYPHARR"
+
+// envOverride is a config field set from the environment, with the value it had before.
+// path holds the field indexes of structs and the elements of slices leading to it.
+type envOverride struct {
+	name     string
+	value    string // Value of the variable
+	path     []pathStep
+	original reflect.Value
+	grown    bool     // The override grew the slice at path, original holds its length before
+	added    []string // Names of the elements the override added to the slice, if they have one
+}
+
+// pathStep is a field index, or a slice element. Elements with a Name, like debrids, are found by name when the
+// config is saved, so an element reordered or removed since doesn't get the file value of another.
+type pathStep struct {
+	index int
+	name  string
+}
+
+// applyEnvOverrides sets the config fields from their environment variables.
+// The overridden fields keep their file value when the config is saved, so secrets passed through the environment
+// are never written to disk.
+func (c *Config) applyEnvOverrides() {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, envPrefix+"_") {
+			env[name] = value
+		}
+	}
+	if len(env) == 0 {
+		return
+	}
+	c.envOverrides = nil
+	c.applyEnv(reflect.ValueOf(c).Elem(), envPrefix, nil, env)
+	for _, o := range c.envOverrides {
+		if !o.grown {
+			fmt.Printf("Config field overridden by %s\n", o.name)
+		}
+	}
+}
+
+func (c *Config) applyEnv(v reflect.Value, name string, path []pathStep, env map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		fieldPath := append(path[:len(path):len(path)], pathStep{index: i})
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// Embedded fields are flattened, like in the JSON
+			c.applyEnv(v.Field(i), name, fieldPath, env)
+			continue
+		}
+		c.applyEnvField(v.Field(i), name+"_"+strings.ToUpper(field.Name), fieldPath, env)
+	}
+}
+
+func (c *Config) applyEnvField(f reflect.Value, name string, path []pathStep, env map[string]string) {
+	switch {
+	case f.Kind() == reflect.Struct:
+		c.applyEnv(f, name, path, env)
+		return
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+		c.applyEnvSlice(f, name, path, env)
+		return
+	}
+
+	value, ok := env[name]
+	if !ok {
+		return
+	}
+	original := reflect.New(f.Type()).Elem()
+	original.Set(f)
+	if err := setFromEnv(f, value); err != nil {
+		fmt.Printf("Ignoring %s: %v\n", name, err)
+		return
+	}
+	c.envOverrides = append(c.envOverrides, envOverride{name: name, value: value, path: path, original: original})
+}
+
+// applyEnvSlice overrides the elements of a slice of structs, growing it up to the highest index set in the environment
+func (c *Config) applyEnvSlice(f reflect.Value, name string, path []pathStep, env map[string]string) {
+	length, fileLength := f.Len(), f.Len()
+	for key := range env {
+		rest, ok := strings.CutPrefix(key, name+"_")
+		if !ok {
+			continue
+		}
+		index, _, _ := strings.Cut(rest, "_")
+		if i, err := strconv.Atoi(index); err == nil && i >= length {
+			length = i + 1
+		}
+	}
+	grownAt := -1
+	if length > f.Len() {
+		grownAt = len(c.envOverrides)
+		c.envOverrides = append(c.envOverrides, envOverride{name: name, path: path, original: reflect.ValueOf(f.Len()), grown: true})
+		grown := reflect.MakeSlice(f.Type(), length, length)
+		reflect.Copy(grown, f)
+		f.Set(grown)
+	}
+	for i := 0; i < f.Len(); i++ {
+		first := len(c.envOverrides)
+		elemPath := append(path[:len(path):len(path)], pathStep{index: i})
+		c.applyEnv(f.Index(i), name+"_"+strconv.Itoa(i), elemPath, env)
+		// The element is named once its own overrides are applied, the name may be one of them
+		elemName := elementName(f.Index(i))
+		for j := first; j < len(c.envOverrides); j++ {
+			c.envOverrides[j].path[len(path)].name = elemName
+		}
+		if grownAt >= 0 && i >= fileLength && elemName != "" {
+			c.envOverrides[grownAt].added = append(c.envOverrides[grownAt].added, elemName)
+		}
+	}
+}
+
+// elementName returns the Name of a slice element, "" if it has none
+func elementName(v reflect.Value) string {
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Name"); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// setFromEnv parses value into f. Strings, booleans, numbers and string slices(comma-separated) are supported.
+func setFromEnv(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", f.Type())
+		}
+		items := reflect.MakeSlice(f.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(f.Type().Elem()))
+			}
+		}
+		f.Set(items)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+// fileJSON returns the config as written to the config file, with the fields overridden by the environment
+// set back to their file value
+func (c *Config) fileJSON() ([]byte, error) {
+	if len(c.envOverrides) == 0 {
+		return json.MarshalIndent(c, "", "  ")
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var file Config
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	root := reflect.ValueOf(&file).Elem()
+
+	// Find all the fields first, restoring a name would hide its element from the following overrides.
+	// Then restore the fields and shrink the grown slices last, deepest slices first.
+	overrides := make([]envOverride, len(c.envOverrides))
+	copy(overrides, c.envOverrides)
+	sort.SliceStable(overrides, func(i, j int) bool {
+		if overrides[i].grown != overrides[j].grown {
+			return !overrides[i].grown
+		}
+		return len(overrides[i].path) > len(overrides[j].path)
+	})
+	fields := make([]reflect.Value, len(overrides))
+	dropped := make([][]int, len(overrides)) // Indexes of the elements added by the grown slices
+	for i, o := range overrides {
+		// Invalid if the element it was in was removed since
+		fields[i], _ = fieldAt(root, o.path)
+		if o.grown && len(o.added) > 0 && fields[i].IsValid() {
+			for j := 0; j < fields[i].Len(); j++ {
+				if slices.Contains(o.added, elementName(fields[i].Index(j))) {
+					dropped[i] = append(dropped[i], j)
+				}
+			}
+		}
+	}
+	for i, o := range overrides {
+		f := fields[i]
+		if !f.IsValid() {
+			continue
+		}
+		if !o.grown {
+			f.Set(o.original)
+			continue
+		}
+		if len(o.added) > 0 {
+			kept := reflect.MakeSlice(f.Type(), 0, f.Len())
+			for j := 0; j < f.Len(); j++ {
+				if !slices.Contains(dropped[i], j) {
+					kept = reflect.Append(kept, f.Index(j))
+				}
+			}
+			f.Set(kept)
+		} else if n := int(o.original.Int()); f.Len() > n {
+			f.Set(f.Slice(0, n))
+		}
+	}
+
+	// Download API keys default to the API key, don't save it there either
+	fromEnv := make(map[string]struct{})
+	for _, o := range c.envOverrides {
+		if !o.grown {
+			fromEnv[o.value] = struct{}{}
+		}
+	}
+	for i := range file.Debrids {
+		file.Debrids[i].DownloadAPIKeys = slices.DeleteFunc(file.Debrids[i].DownloadAPIKeys, func(key string) bool {
+			_, ok := fromEnv[key]
+			return ok
+		})
+	}
+	return json.MarshalIndent(&file, "", "  ")
+}
+
+// fieldAt follows path from v, it reports false if a slice element is no longer there
+func fieldAt(v reflect.Value, path []pathStep) (reflect.Value, bool) {
+	for _, step := range path {
+		switch v.Kind() {
+		case reflect.Struct:
+			v = v.Field(step.index)
+		case reflect.Slice:
+			i := step.index
+			if step.name != "" {
+				i = -1
+				for j := 0; j < v.Len(); j++ {
+					if elementName(v.Index(j)) == step.name {
+						i = j
+						break
+					}
+				}
+			}
+			if i < 0 || i >= v.Len() {
+				return reflect.Value{}, false
+			}
+			v = v.Index(i)
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFiles are the names the config file can have, looked up in this order. New setups get config.json.
+// The format follows the extension, YAML and TOML are converted from and to JSON so the json tags apply to all of them.
+var configFiles = []string{"config.yaml", "config.yml", "config.toml", "config.json"}
+
+// ConfigFile returns the config file of the config directory, the first of configFiles that exists, config.json if none
+func (c *Config) ConfigFile() string {
+	if found := existingConfigFiles(c.Path); len(found) > 0 {
+		return found[0]
+	}
+	return filepath.Join(c.Path, "config.json")
+}
+
+// existingConfigFiles returns the config files in dir, in the order of configFiles
+func existingConfigFiles(dir string) []string {
+	var found []string
+	for _, name := range configFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			found = append(found, file)
+		}
+	}
+	return found
+}
+
+// configToJSON converts the content of a config file to JSON, according to its extension
+func configToJSON(filename string, data []byte) ([]byte, error) {
+	var v any
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+	default:
+		return data, nil
+	}
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+// configFromJSON converts the JSON of a config to the format of filename, according to its extension.
+// A YAML config patches original, the current content of the file if any, so its comments and the order of its
+// fields are kept. TOML is written anew, without comments.
+func configFromJSON(filename string, data, original []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		// JSON is YAML, decoding it to a node keeps the order of the fields
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		blockStyle(&node)
+		var current yaml.Node
+		if yaml.Unmarshal(original, &current) == nil && len(current.Content) == 1 && len(node.Content) == 1 &&
+			current.Content[0].Kind == yaml.MappingNode {
+			mergeNode(current.Content[0], node.Content[0])
+			node = current
+		}
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var v map[string]any
+		if err := decoder.Decode(&v); err != nil {
+			return nil, err
+		}
+		if err := toml.NewEncoder(&buf).Encode(tomlValue(v)); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle turns the JSON style of node into the YAML block style, with unquoted strings.
+// The encoder still quotes the strings that would be read as another type, like "8282".
+func blockStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!str" {
+		node.Style = 0
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// mergeNode updates dst to the values of src, keeping the comments of dst. Mapping keys keep their order in dst,
+// the keys only in src are appended, and sequences are updated element by element.
+func mergeNode(dst, src *yaml.Node) {
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		values := make(map[string]*yaml.Node, len(src.Content)/2)
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			values[src.Content[i].Value] = src.Content[i+1]
+		}
+		content := make([]*yaml.Node, 0, len(src.Content))
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			key := dst.Content[i].Value
+			value, ok := values[key]
+			if !ok {
+				// Removed, or unset
+				continue
+			}
+			mergeNode(dst.Content[i+1], value)
+			content = append(content, dst.Content[i], dst.Content[i+1])
+			delete(values, key)
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if _, ok := values[src.Content[i].Value]; ok {
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+		dst.Content = content
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		for i := 0; i < min(len(dst.Content), len(src.Content)); i++ {
+			mergeNode(dst.Content[i], src.Content[i])
+		}
+		if len(dst.Content) > len(src.Content) {
+			dst.Content = dst.Content[:len(src.Content)]
+		} else {
+			dst.Content = append(dst.Content, src.Content[len(dst.Content):]...)
+		}
+	default:
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+	}
+}
+
+// tomlValue prepares a value decoded from JSON for the TOML encoder: numbers become integers when they are,
+// and nulls, which TOML doesn't have, are dropped
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = tomlValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = tomlValue(value)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormats(t *testing.T) {
+	want := map[string]any{"port": "8282", "use_auth": true, "qbittorrent": map[string]any{"categories": []any{"sonarr", "radarr"}}}
+	files := map[string]string{
+		"config.json": `{"port": "8282", "use_auth": true, "qbittorrent": {"categories": ["sonarr", "radarr"]}}`,
+		"config.yaml": "port: \"8282\"\nuse_auth: true\nqbittorrent:\n  categories: [sonarr, radarr]\n",
+		"config.toml": "port = \"8282\"\nuse_auth = true\n[qbittorrent]\ncategories = [\"sonarr\", \"radarr\"]\n",
+	}
+	for name, content := range files {
+		data, err := configToJSON(name, []byte(content))
+		if err != nil {
+			t.Fatalf("%s: configToJSON() error = %v", name, err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if g, w := mustJSON(t, got), mustJSON(t, want); g != w {
+			t.Errorf("%s: configToJSON() = %s, want %s", name, g, w)
+		}
+
+		// Written back in its own format, it reads the same
+		written, err := configFromJSON(name, data, []byte(content))
+		if err != nil {
+			t.Fatalf("%s: configFromJSON() error = %v", name, err)
+		}
+		again, err := configToJSON(name, written)
+		if err != nil {
+			t.Fatalf("%s: configToJSON() of the written config error = %v", name, err)
+		}
+		if err := json.Unmarshal(again, &got); err != nil || mustJSON(t, got) != mustJSON(t, want) {
+			t.Errorf("%s: written config = %s, want %s", name, written, mustJSON(t, want))
+		}
+	}
+
+	if _, err := configToJSON("config.yaml", []byte("port: [")); err == nil {
+		t.Error("configToJSON() accepted invalid YAML")
+	}
+}
+
+// YAML configs keep their comments and field order when saved
+func TestConfigFromJSONKeepsYAMLComments(t *testing.T) {
+	original := "# Decypharr\nport: \"8282\" # web UI\nlog_level: info\n"
+	written, err := configFromJSON("config.yaml", []byte(`{"log_level": "debug", "port": "9000", "use_auth": true}`), []byte(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Decypharr\nport: \"9000\" # web UI\nlog_level: debug\nuse_auth: true\n"
+	if string(written) != want {
+		t.Errorf("configFromJSON() = %q, want %q", written, want)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{Path: dir}
+	if got := c.ConfigFile(); got != filepath.Join(dir, "config.json") {
+		t.Errorf("ConfigFile() = %s without a config file, want config.json", got)
+	}
+	for _, name := range []string{"config.json", "config.toml", "config.yml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.ConfigFile(); !strings.HasSuffix(got, name) {
+			t.Errorf("ConfigFile() = %s, want %s first", got, name)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// CurrentConfigVersion is the config_version of the configs written by this version, older configs are migrated on load
+const CurrentConfigVersion = 2
+
+// migration upgrades the config from the previous version to version
+type migration struct {
+	version     int
+	description string
+	migrate     func(c *Config) error
+}
+
+// migrations upgrade the config one version at a time, in order. Configs without a config_version are version 0.
+var migrations = []migration{
+	{1, "moved the deprecated qbittorrent.port to port", migrateV1},
+	{2, "normalized the rate limits to count/unit", migrateV2},
+}
+
+// migrate runs the migrations from the version of the config to CurrentConfigVersion, the version is saved with
+// the config on the next save
+func (c *Config) migrate() error {
+	if c.ConfigVersion > CurrentConfigVersion {
+		fmt.Printf("WARNING: config_version %d is newer than this version of Decypharr supports(%d), settings may be ignored\n", c.ConfigVersion, CurrentConfigVersion)
+		return nil
+	}
+	for _, m := range migrations {
+		if m.version <= c.ConfigVersion {
+			continue
+		}
+		if err := m.migrate(c); err != nil {
+			return fmt.Errorf("migrating the config to version %d: %w", m.version, err)
+		}
+		c.ConfigVersion = m.version
+		fmt.Printf("Config migrated to version %d: %s\n", m.version, m.description)
+	}
+	return nil
+}
+
+// migrateV1 moves the deprecated qbittorrent.port to port
+func migrateV1(c *Config) error {
+	if warning := c.migratePort(); warning != "" {
+		fmt.Println(warning)
+	}
+	return nil
+}
+
+// migrateV2 rewrites the rate limits of the debrids in their canonical form, e.g. 200/m to 200/minute.
+// Invalid rate limits are kept as is, for Validate to report them.
+func migrateV2(c *Config) error {
+	for i := range c.Debrids {
+		d := &c.Debrids[i]
+		for _, rate := range []*string{&d.RateLimit, &d.RepairRateLimit, &d.DownloadRateLimit, &d.SoftBanRateLimit} {
+			*rate = normalizeRateLimit(*rate)
+		}
+	}
+	return nil
+}
+
+// normalizeRateLimit returns rate as count/unit with the unit spelled out, rate itself if it can't be parsed
+func normalizeRateLimit(rate string) string {
+	if rate == "" {
+		return rate
+	}
+	count, per, err := ParseRateLimit(rate)
+	if err != nil {
+		return rate
+	}
+	unit := map[time.Duration]string{
+		time.Second:    "second",
+		time.Minute:    "minute",
+		time.Hour:      "hour",
+		24 * time.Hour: "day",
+	}[per]
+	return fmt.Sprintf("%d/%s", count, unit)
+}
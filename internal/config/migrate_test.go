@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigratePort(t *testing.T) {
+	tests := []struct {
+		name        string
+		port        string
+		deprecated  string
+		wantPort    string
+		wantWarning string // Part of the warning, empty for none
+	}{
+		{"port only", "8282", "", "8282", ""},
+		{"deprecated only", "", "9000", "9000", "is deprecated, using it as port(9000)"},
+		{"both, same", "9000", "9000", "9000", "ignored, port is already set"},
+		{"both, conflicting", "8282", "9000", "8282", "WARNING: qbittorrent.port(9000) conflicts with port(8282)"},
+	}
+	for _, tt := range tests {
+		c := &Config{Port: tt.port, QBitTorrent: QBitTorrent{Port: tt.deprecated}}
+		warning := c.migratePort()
+		if c.Port != tt.wantPort || c.QBitTorrent.Port != "" {
+			t.Errorf("%s: port = %q, qbittorrent.port = %q, want %q and empty", tt.name, c.Port, c.QBitTorrent.Port, tt.wantPort)
+		}
+		if (warning == "") != (tt.wantWarning == "") || !strings.Contains(warning, tt.wantWarning) {
+			t.Errorf("%s: warning = %q, want it to contain %q", tt.name, warning, tt.wantWarning)
+		}
+	}
+
+	// The deprecated field is dropped from the file on save, with the config version
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"qbittorrent": {"port": "9000", "download_folder": "/downloads"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigPath(dir)
+	c := &Config{}
+	if err := c.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "9000" || c.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("loaded port %q at version %d, want 9000 at version %d", c.Port, c.ConfigVersion, CurrentConfigVersion)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Port        string         `json:"port"`
+		QBitTorrent map[string]any `json:"qbittorrent"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.QBitTorrent["port"]; ok || saved.Port != "9000" {
+		t.Errorf("saved config = %s, want port 9000 without qbittorrent.port", data)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"strings"
 )
 
+// DefaultExtensionsToken in allowed_file_types stands for the default extensions of the running version
+const DefaultExtensionsToken = "@defaults"
+
 func (c *Config) IsAllowedFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
 	if ext == "" {
@@ -15,7 +18,11 @@ func (c *Config) IsAllowedFile(filename string) bool {
 	// Remove the leading dot
 	ext = ext[1:]
 
-	for _, allowed := range c.AllowedExt {
+	allowedExt := c.allowedExt
+	if allowedExt == nil {
+		allowedExt = expandExtensions(c.AllowedExt)
+	}
+	for _, allowed := range allowedExt {
 		if ext == allowed {
 			return true
 		}
@@ -23,6 +30,35 @@ func (c *Config) IsAllowedFile(filename string) bool {
 	return false
 }
 
+// expandExtensions returns the extensions allowed by the configured list, with DefaultExtensionsToken replaced by the
+// default extensions. An empty list allows the default extensions.
+func expandExtensions(configured []string) []string {
+	if len(configured) == 0 {
+		return getDefaultExtensions()
+	}
+	seen := make(map[string]struct{})
+	var exts []string
+	add := func(ext string) {
+		ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+		if _, ok := seen[ext]; ext == "" || ok {
+			return
+		}
+		seen[ext] = struct{}{}
+		exts = append(exts, ext)
+	}
+	for _, ext := range configured {
+		if strings.TrimSpace(ext) == DefaultExtensionsToken {
+			for _, d := range getDefaultExtensions() {
+				add(d)
+			}
+			continue
+		}
+		add(ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
 func getDefaultExtensions() []string {
 	videoExts := strings.Split("webm,m4v,3gp,nsv,ty,strm,rm,rmvb,m3u,ifo,mov,qt,divx,xvid,bivx,nrg,pva,wmv,asf,asx,ogm,ogv,m2v,avi,bin,dat,dvr-ms,mpg,mpeg,mp4,avc,vp3,svq3,nuv,viv,dv,fli,flv,wpl,img,iso,vob,mkv,mk3d,ts,wtv,m2ts'", ",")
 	musicExts := strings.Split("MP3,WAV,FLAC,OGG,WMA,AIFF,ALAC,M4A,APE,AC3,DTS,M4P,MID,MIDI,MKA,MP2,MPA,RA,VOC,WV,AMR", ",")
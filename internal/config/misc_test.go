@@ -0,0 +1,28 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExpandExtensions(t *testing.T) {
+	defaults := getDefaultExtensions()
+	if got := expandExtensions(nil); !slices.Equal(got, defaults) {
+		t.Errorf("expandExtensions(nil) = %v, want the defaults", got)
+	}
+	if got := expandExtensions([]string{".MKV", " srt ", "mkv", ""}); !slices.Equal(got, []string{"mkv", "srt"}) {
+		t.Errorf("expandExtensions() = %v, want mkv and srt", got)
+	}
+
+	got := expandExtensions([]string{"srt", DefaultExtensionsToken, "mkv"})
+	if len(got) != len(defaults)+1 || !slices.Contains(got, "srt") || !slices.IsSorted(got) {
+		t.Errorf("expandExtensions() with %s = %v, want the sorted defaults and srt", DefaultExtensionsToken, got)
+	}
+
+	c := &Config{AllowedExt: []string{DefaultExtensionsToken, "nfo"}}
+	for name, allowed := range map[string]bool{"movie.MKV": true, "movie.nfo": true, "movie.exe": false, "movie": false} {
+		if c.IsAllowedFile(name) != allowed {
+			t.Errorf("IsAllowedFile(%s) = %v, want %v", name, !allowed, allowed)
+		}
+	}
+}
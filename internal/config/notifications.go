@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// NotifierType is the service a notification target sends to
+type NotifierType string
+
+const (
+	NotifierDiscord  NotifierType = "discord"  // A Discord webhook
+	NotifierWebhook  NotifierType = "webhook"  // Any URL, the notification is POSTed as JSON
+	NotifierTelegram NotifierType = "telegram" // A Telegram chat, through a bot
+)
+
+// NotificationEvents are the events notifications are sent for, a target can subscribe to some of them
+var NotificationEvents = []string{
+	"download_complete",
+	"download_failed",
+	"no_video",
+	"torrent_promoted",
+	"torrent_expiring",
+	"repair_complete",
+	"repair_pending",
+	"repair_failed",
+	"debrid_down",
+	"debrid_recovered",
+	"standby_activated",
+	"standby_deactivated",
+	"premium_expired",
+	"premium_restored",
+	"traffic_budget_reached",
+	"traffic_budget_restored",
+	"unlock_quota_reached",
+	"unlock_quota_restored",
+	"arr_rescan_failed",
+}
+
+// NotificationTarget is where notifications are sent
+type NotificationTarget struct {
+	Type     NotifierType `json:"type"`
+	URL      string       `json:"url,omitempty"`       // Discord or generic webhook URL
+	BotToken string       `json:"bot_token,omitempty"` // Telegram bot token
+	ChatID   string       `json:"chat_id,omitempty"`   // Telegram chat the bot sends to
+	Events   []string     `json:"events,omitempty"`    // Events sent to the target, all if empty
+}
+
+// Subscribed reports whether the target receives the notifications of event
+func (t NotificationTarget) Subscribed(event string) bool {
+	return len(t.Events) == 0 || slices.Contains(t.Events, event)
+}
+
+// NotificationTargets returns the notification targets, with discord_webhook_url as a Discord target for all events,
+// unless a Discord target already has its URL
+func (c *Config) NotificationTargets() []NotificationTarget {
+	targets := slices.Clone(c.Notifications)
+	if c.DiscordWebhook != "" && !slices.ContainsFunc(targets, func(t NotificationTarget) bool {
+		return t.Type == NotifierDiscord && t.URL == c.DiscordWebhook
+	}) {
+		targets = append(targets, NotificationTarget{Type: NotifierDiscord, URL: c.DiscordWebhook})
+	}
+	return targets
+}
+
+// validateNotifications checks the notification targets
+func validateNotifications(targets []NotificationTarget) []error {
+	var errs []error
+	for i, target := range targets {
+		prefix := fmt.Sprintf("notifications[%d]", i)
+		switch target.Type {
+		case NotifierDiscord, NotifierWebhook:
+			if target.URL == "" {
+				errs = append(errs, fmt.Errorf("%s: url is required", prefix))
+			}
+		case NotifierTelegram:
+			if target.BotToken == "" || target.ChatID == "" {
+				errs = append(errs, fmt.Errorf("%s: bot_token and chat_id are required", prefix))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid type %q", prefix, target.Type))
+		}
+		for _, event := range target.Events {
+			if !slices.Contains(NotificationEvents, event) {
+				errs = append(errs, fmt.Errorf("%s: unknown event %q", prefix, event))
+			}
+		}
+	}
+	return errs
+}
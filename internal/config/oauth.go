@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// OAuthToken is the token of a debrid logged in with the OAuth device flow.
+// The device flow issues a client id and secret for the user, used with the refresh token to get new access tokens.
+type OAuthToken struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// oauthMu guards OAuthFile, tokens are refreshed from several clients
+var oauthMu sync.Mutex
+
+// GetOAuthToken returns the OAuth token of a debrid, false if it isn't logged in
+func (c *Config) GetOAuthToken(debrid string) (OAuthToken, bool) {
+	oauthMu.Lock()
+	defer oauthMu.Unlock()
+	tokens, err := c.readOAuthTokens()
+	if err != nil {
+		return OAuthToken{}, false
+	}
+	token, ok := tokens[debrid]
+	return token, ok && token.RefreshToken != ""
+}
+
+// SaveOAuthToken saves the OAuth token of a debrid, keeping the other debrids' tokens
+func (c *Config) SaveOAuthToken(debrid string, token OAuthToken) error {
+	oauthMu.Lock()
+	defer oauthMu.Unlock()
+	tokens, err := c.readOAuthTokens()
+	if err != nil {
+		return err
+	}
+	tokens[debrid] = token
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.WriteFile(c.OAuthFile(), data)
+}
+
+// readOAuthTokens reads OAuthFile, oauthMu must be held
+func (c *Config) readOAuthTokens() (map[string]OAuthToken, error) {
+	tokens := make(map[string]OAuthToken)
+	data, err := os.ReadFile(c.OAuthFile())
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", c.OAuthFile(), err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// RedactedValue replaces the secrets of a redacted config. Sent back unchanged, it keeps the current secret.
+const RedactedValue = "********"
+
+// clone returns a deep copy of the config, with the fields not saved in the config file copied as is
+func (c *Config) clone() (*Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	next := &Config{}
+	if err := json.Unmarshal(data, next); err != nil {
+		return nil, err
+	}
+	next.Path = c.Path
+	next.Auth = c.Auth
+	next.allowedExt = c.allowedExt
+	next.readOnly = c.readOnly
+	next.envOverrides = slices.Clone(c.envOverrides)
+	return next, nil
+}
+
+// Redacted returns a copy of the config with its secrets replaced by RedactedValue: the debrid API keys and proxy
+// passwords, the arr tokens, the Discord webhook, the notification URLs and bot tokens, and the auth password.
+// Secrets of a list, the download API keys and the notifications, are followed by their fingerprint, so they are
+// restored to the right value even if the list was reordered.
+func (c *Config) Redacted() (*Config, error) {
+	redacted, err := c.clone()
+	if err != nil {
+		return nil, err
+	}
+	for i := range redacted.Debrids {
+		d := &redacted.Debrids[i]
+		d.APIKey = redact(d.APIKey)
+		for j := range d.DownloadAPIKeys {
+			d.DownloadAPIKeys[j] = redactListed(d.DownloadAPIKeys[j])
+		}
+		d.Proxy = redactProxy(d.Proxy)
+		d.ForbiddenProxy = redactProxy(d.ForbiddenProxy)
+	}
+	for i := range redacted.Arrs {
+		redacted.Arrs[i].Token = redact(redacted.Arrs[i].Token)
+	}
+	redacted.DiscordWebhook = redact(redacted.DiscordWebhook)
+	for i := range redacted.Notifications {
+		n := &redacted.Notifications[i]
+		n.URL = redactListed(n.URL)
+		n.BotToken = redactListed(n.BotToken)
+	}
+	if redacted.Auth != nil {
+		redacted.Auth = &Auth{Username: redacted.Auth.Username, Password: redact(redacted.Auth.Password)}
+	}
+	return redacted, nil
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// redactListed redacts a secret of a list, followed by its fingerprint
+func redactListed(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedValue + fingerprint(secret)
+}
+
+// fingerprint identifies a secret without revealing it
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// redactProxy redacts the password of a proxy URL, the rest of it is kept
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); !ok {
+		return proxy
+	}
+	u.User = url.UserPassword(u.User.Username(), RedactedValue)
+	return u.String()
+}
+
+// restoreProxy sets the password of next, a proxy URL, back to the password of current if it's redacted
+func restoreProxy(next, current string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.User == nil {
+		return next
+	}
+	if password, ok := u.User.Password(); !ok || password != RedactedValue {
+		return next
+	}
+	cu, err := url.Parse(current)
+	if err != nil || cu.User == nil {
+		return next
+	}
+	password, ok := cu.User.Password()
+	if !ok {
+		return next
+	}
+	u.User = url.UserPassword(u.User.Username(), password)
+	return u.String()
+}
+
+// restoreListed returns the secret of current whose fingerprint redacted carries, redacted if there is none
+func restoreListed(redacted string, current ...string) string {
+	fp, ok := strings.CutPrefix(redacted, RedactedValue)
+	if !ok || fp == "" {
+		return redacted
+	}
+	for _, secret := range current {
+		if secret != "" && fingerprint(secret) == fp {
+			return secret
+		}
+	}
+	return redacted
+}
+
+// RestoreRedacted sets the secrets of next still redacted back to their value in c. Debrids and arrs are matched by
+// name, the secrets of lists by their fingerprint. Secrets with no match in c are left redacted.
+func (c *Config) RestoreRedacted(next *Config) {
+	for i := range next.Debrids {
+		d := &next.Debrids[i]
+		current, ok := c.GetDebrid(d.Name)
+		if !ok {
+			continue
+		}
+		if d.APIKey == RedactedValue {
+			d.APIKey = current.APIKey
+		}
+		for j := range d.DownloadAPIKeys {
+			d.DownloadAPIKeys[j] = restoreListed(d.DownloadAPIKeys[j], current.DownloadAPIKeys...)
+		}
+		d.Proxy = restoreProxy(d.Proxy, current.Proxy)
+		d.ForbiddenProxy = restoreProxy(d.ForbiddenProxy, current.ForbiddenProxy)
+	}
+	for i := range next.Arrs {
+		a := &next.Arrs[i]
+		if current, ok := c.GetArr(a.Name); ok && a.Token == RedactedValue {
+			a.Token = current.Token
+		}
+	}
+	if next.DiscordWebhook == RedactedValue {
+		next.DiscordWebhook = c.DiscordWebhook
+	}
+	urls := make([]string, 0, len(c.Notifications))
+	tokens := make([]string, 0, len(c.Notifications))
+	for _, n := range c.Notifications {
+		urls = append(urls, n.URL)
+		tokens = append(tokens, n.BotToken)
+	}
+	for i := range next.Notifications {
+		n := &next.Notifications[i]
+		n.URL = restoreListed(n.URL, urls...)
+		n.BotToken = restoreListed(n.BotToken, tokens...)
+	}
+	if next.Auth != nil && next.Auth.Password == RedactedValue && c.Auth != nil {
+		next.Auth.Password = c.Auth.Password
+	}
+}
+
+// PatchError is returned by Patch when the patched config is invalid
+type PatchError struct {
+	Errors []error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("invalid config: %d errors", len(e.Errors))
+}
+
+// Patch applies a partial JSON config to a copy of the current config: the objects it sets are merged into the
+// current ones, and so are the elements of the arrays it sets, by position, the arrays being cut to the patch length.
+// Redacted secrets keep their current value. The patched config is validated, checked by check if not nil, saved,
+// then replaces the current config; the current *Config is never modified. Returns the patched config and the JSON
+// names of the changed top-level fields. An invalid patched config returns a *PatchError with all the validation
+// errors.
+func Patch(patch []byte, check func(current, next *Config) error) (*Config, []string, error) {
+	current := Get()
+	// Serialized with the updates and saves of the current config, so none of them is lost by the swap
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	next, err := current.clone()
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(next); err != nil {
+		return nil, nil, fmt.Errorf("invalid patch: %w", err)
+	}
+	current.RestoreRedacted(next)
+	next.setDefaults()
+	if err := next.Validate(); err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			return nil, nil, &PatchError{Errors: joined.Unwrap()}
+		}
+		return nil, nil, &PatchError{Errors: []error{err}}
+	}
+	if check != nil {
+		if err := check(current, next); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := next.save(); err != nil {
+		return nil, nil, err
+	}
+
+	instanceMu.Lock()
+	instance = next
+	instanceMu.Unlock()
+	return next, changedSections(current, next), nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StorageMode is how the files of the config directory are written
+type StorageMode string
+
+const (
+	StorageLocal   StorageMode = "local"   // Write to a temp file and rename it over the target
+	StorageNetwork StorageMode = "network" // Also lock, fsync and read back the file, for NFS/SMB mounts
+)
+
+// WriteFile writes the data to a file of the config directory, using the configured StorageMode.
+// The data is written to a temp file renamed over the target, so readers see either the old or the new content.
+func (c *Config) WriteFile(filename string, data []byte) error {
+	if c.StorageMode == StorageNetwork {
+		return writeFileNetwork(filename, data)
+	}
+	return writeFileLocal(filename, data)
+}
+
+// writeFileLocal falls back to overwriting the file in place when the directory isn't writable or the file is bind-mounted
+func writeFileLocal(filename string, data []byte) error {
+	tmp, err := writeTemp(filename, data, false)
+	if err != nil {
+		return os.WriteFile(filename, data, 0644)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		_ = os.Remove(tmp)
+		return os.WriteFile(filename, data, 0644)
+	}
+	return nil
+}
+
+// writeFileNetwork holds a lock on filename.lock while writing, so several instances sharing the mount don't interleave writes.
+// Renames over an existing file fail on some SMB servers, the file is then overwritten in place.
+// The content is read back afterward, as network filesystems may acknowledge writes they didn't persist.
+func writeFileNetwork(filename string, data []byte) error {
+	unlock, err := lockFile(filename + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %w", filename, err)
+	}
+	defer unlock()
+
+	if tmp, err := writeTemp(filename, data, true); err != nil {
+		if err := writeInPlace(filename, data); err != nil {
+			return err
+		}
+	} else if err := os.Rename(tmp, filename); err != nil {
+		_ = os.Remove(tmp)
+		if err := writeInPlace(filename, data); err != nil {
+			return err
+		}
+	}
+	syncDir(filepath.Dir(filename))
+
+	written, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", filename, err)
+	}
+	if !bytes.Equal(written, data) {
+		return fmt.Errorf("failed to verify %s: content differs after write", filename)
+	}
+	return nil
+}
+
+// writeTemp writes data to a temp file next to filename and returns its path
+func writeTemp(filename string, data []byte, sync bool) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+			return "", err
+		}
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+func writeInPlace(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the directory entry of a renamed file, errors are ignored as not all filesystems support it
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// storageModeHint returns a hint to enable the network storage mode if the config directory is on a network filesystem
+func (c *Config) storageModeHint() string {
+	if c.StorageMode == StorageNetwork {
+		return ""
+	}
+	fs := networkFilesystem(c.Path)
+	if fs == "" {
+		return ""
+	}
+	return fmt.Sprintf("Config path %s looks like a %s mount, set storage_mode to \"network\" to avoid corrupting its files", c.Path, fs)
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package config
+
+import "syscall"
+
+// Filesystem magic numbers reported by statfs, they fit in 32 bits
+var networkFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse", // sshfs, rclone mounts and the like
+}
+
+// networkFilesystem returns the type of the network filesystem holding path, empty if it's local or unknown
+func networkFilesystem(path string) string {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return ""
+	}
+	// Type is an int64 on 64-bit architectures and an int32 on 32-bit ones, where the magic numbers with the high bit
+	// set are negative. Its low 32 bits are the magic number on both
+	return networkFilesystems[uint32(stat.Type)]
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package config
+
+// networkFilesystem isn't detected outside Linux
+func networkFilesystem(path string) string {
+	return ""
+}
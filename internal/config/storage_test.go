@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	for _, mode := range []StorageMode{StorageLocal, StorageNetwork} {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "torrents.json")
+			c := &Config{StorageMode: mode}
+			for _, content := range []string{`{"a":1}`, `{"b":2}`} {
+				if err := c.WriteFile(filename, []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+				got, err := os.ReadFile(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != content {
+					t.Errorf("content = %s, want %s", got, content)
+				}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), ".tmp-") {
+					t.Errorf("temp file %s left behind", entry.Name())
+				}
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0644 {
+				t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+// Concurrent writes never leave a file mixing their contents
+func TestWriteFileNetworkConcurrent(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "torrents.json")
+	c := &Config{StorageMode: StorageNetwork}
+	contents := make([][]byte, 8)
+	for i := range contents {
+		contents[i] = bytes.Repeat([]byte(fmt.Sprint(i)), 64<<10)
+	}
+	var wg sync.WaitGroup
+	for _, content := range contents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.WriteFile(filename, content); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range contents {
+		if bytes.Equal(got, content) {
+			return
+		}
+	}
+	t.Errorf("file holds a mix of the written contents")
+}
+
+func TestStorageModeValidation(t *testing.T) {
+	for mode, valid := range map[StorageMode]bool{"": true, StorageLocal: true, StorageNetwork: true, "nfs": false} {
+		c := testConfig(func(c *Config) { c.StorageMode = mode })
+		if err := c.Validate(); (err == nil) != valid {
+			t.Errorf("storage_mode %q: %v, want valid %v", mode, err, valid)
+		}
+	}
+}
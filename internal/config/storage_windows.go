@@ -0,0 +1,8 @@
+//go:build windows
+
+package config
+
+// lockFile is a no-op on Windows, SMB shares lock files opened for writing
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
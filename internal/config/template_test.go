@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplate writes a config template to a temp directory and returns its path
+func writeTemplate(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCreateConfigFromTemplate(t *testing.T) {
+	t.Cleanup(func() { SetInitialConfig("", false) })
+	downloads := t.TempDir()
+
+	template := writeTemplate(t, "template.json", `{
+		"qbittorrent": {"download_folder": "`+downloads+`"},
+		"debrids": [{"name": "realdebrid", "api_key": "key", "folder": "/mnt/remote/realdebrid/__all__"}]
+	}`)
+	SetInitialConfig(template, true)
+	c := &Config{}
+	if err := c.createConfig(t.TempDir()); err != nil {
+		t.Fatalf("createConfig() error = %v", err)
+	}
+	if c.UseAuth {
+		t.Error("UseAuth set, want the config created without auth")
+	}
+	if len(c.Debrids) != 1 || c.Debrids[0].Name != "realdebrid" {
+		t.Errorf("Debrids = %v, want the realdebrid of the template", c.Debrids)
+	}
+	if c.QBitTorrent.DownloadFolder != downloads || c.Port != "8282" {
+		t.Errorf("download folder %s and port %s, want the template folder over the default port", c.QBitTorrent.DownloadFolder, c.Port)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field": `{"debrid": []}`,
+		"invalid":       `{"qbittorrent": {"download_folder": "` + filepath.Join(downloads, "missing") + `"}, "debrids": []}`,
+	} {
+		SetInitialConfig(writeTemplate(t, "template.json", content), false)
+		if err := (&Config{}).createConfig(t.TempDir()); err == nil || !strings.Contains(err.Error(), "invalid config template") {
+			t.Errorf("%s template: createConfig() error = %v, want an invalid config template error", name, err)
+		}
+	}
+
+	SetInitialConfig("", false)
+	c = &Config{}
+	if err := c.createConfig(t.TempDir()); err != nil || !c.UseAuth {
+		t.Errorf("createConfig() without a template = %v with auth %v, want the defaults with auth", err, c.UseAuth)
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// Watch reloads the config from disk on SIGHUP, until ctx is done.
+// The reloaded config replaces the current one only if it is valid, the current one is kept otherwise.
+// The current *Config is never modified by a reload, callers holding it keep a consistent config and see
+// the new one on their next Get. Once ctx is done, e.g. while shutting down, SIGHUP is ignored instead of
+// terminating the process.
+func Watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				signal.Ignore(syscall.SIGHUP)
+				return
+			case <-signals:
+				if err := reloadFromDisk(); err != nil {
+					fmt.Printf("Config not reloaded, keeping the current config: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// reloadFromDisk loads the config file into a new config and swaps it in if it is valid
+func reloadFromDisk() error {
+	current := Get()
+	if _, err := os.Stat(current.ConfigFile()); err != nil {
+		return err
+	}
+	next := &Config{}
+	if err := next.loadConfig(); err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	instanceMu.Lock()
+	instance = next
+	instanceMu.Unlock()
+
+	changed := changedSections(current, next)
+	if len(changed) == 0 {
+		changed = []string{"nothing"}
+	}
+	fmt.Printf("Config reloaded (%s), changed: %s\n", next.summary(), strings.Join(changed, ", "))
+	return nil
+}
+
+// summary describes the config at a high level, for the logs
+func (c *Config) summary() string {
+	repair := "disabled"
+	if c.Repair.Enabled {
+		repair = "enabled"
+	}
+	return fmt.Sprintf("%d debrids, %d arrs, repair %s", len(c.Debrids), len(c.Arrs), repair)
+}
+
+// changedSections returns the JSON names of the top-level config fields that differ between old and next
+func changedSections(old, next *Config) []string {
+	var changed []string
+	oldValue, nextValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -1,7 +1,112 @@
 package config
 
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FileSortOrder is the order of files inside a WebDav torrent folder
+type FileSortOrder string
+
+const (
+	FileSortByName    FileSortOrder = "name"    // Byte-wise comparison of names
+	FileSortByNatural FileSortOrder = "natural" // Numbers are compared by value, Ep2 comes before Ep10
+	FileSortByIndex   FileSortOrder = "index"   // Order of the files in the torrent
+	FileSortBySize    FileSortOrder = "size"    // Largest first
+)
+
+// IncompleteDownloads is how torrents still downloading on the debrid are exposed through WebDav
+type IncompleteDownloads string
+
+const (
+	IncompleteDownloadsHide        IncompleteDownloads = "hide"        // Only expose a torrent once all its files are available
+	IncompleteDownloadsProgressive IncompleteDownloads = "progressive" // Expose the files of a torrent as they become available
+)
+
+// FileListChanges is how a torrent's files are updated when the debrid reports a different file list for it
+type FileListChanges string
+
+const (
+	FileListPin    FileListChanges = "pin"    // Files keep the name they were first listed with, matched by their debrid file id
+	FileListFollow FileListChanges = "follow" // Use the new file list as is
+)
+
+// UnlockedFilenames is what a file is named when the debrid unlocks it under another name,
+// e.g. with an account tag appended or another extension
+type UnlockedFilenames string
+
+const (
+	UnlockedFilenamesPreserve UnlockedFilenames = "preserve" // Keep the torrent's file name, the unlocked name is only logged
+	UnlockedFilenamesProvider UnlockedFilenames = "provider" // Rename the file to the unlocked name
+)
+
+// RootLayout is what the WebDav root lists when several debrids are set up
+type RootLayout string
+
+const (
+	RootLayoutDebrid   RootLayout = "debrid"   // A folder per debrid
+	RootLayoutMerged   RootLayout = "merged"   // The torrents of all debrids together
+	RootLayoutCategory RootLayout = "category" // A folder per category, with the torrents of all debrids
+)
+
+// PropfindErrors is what a listing does with an entry that can't be described, e.g an unusable name
+type PropfindErrors string
+
+const (
+	PropfindErrorsSkip PropfindErrors = "skip" // Leave the entry out of the listing
+	PropfindErrorsMark PropfindErrors = "mark" // List the entry with an error status
+	PropfindErrorsFail PropfindErrors = "fail" // Fail the whole listing
+)
+
+// DirectoryGet is how a GET of a WebDav directory is answered, e.g. from a browser
+type DirectoryGet string
+
+const (
+	DirectoryGetIndex  DirectoryGet = "index"  // An HTML index of the directory, to browse the mount
+	DirectoryGetReject DirectoryGet = "reject" // A 405 Method Not Allowed, directories are only listed with PROPFIND
+)
+
+// DirectoryIndexFiles is which files the HTML index of a WebDav directory lists
+type DirectoryIndexFiles string
+
+const (
+	DirectoryIndexFilesAll     DirectoryIndexFiles = "all"     // Every file of the directory
+	DirectoryIndexFilesAllowed DirectoryIndexFiles = "allowed" // Only the files of an allowed file type
+)
+
+// FolderNameSource is a name a torrent folder can be exposed under, the arr, the magnet and the debrid may each know
+// the torrent under another name
+type FolderNameSource string
+
+const (
+	FolderNameArr    FolderNameSource = "arr"    // The name the arr added the torrent under, the rename field of the qBittorrent API
+	FolderNameMagnet FolderNameSource = "magnet" // The display name (dn) of the magnet, or the name in the torrent file
+	FolderNameDebrid FolderNameSource = "debrid" // The name the debrid reports, per folder_naming
+)
+
+// validateFolderNamePrecedence checks the sources of folder_name_precedence, each listed once
+func validateFolderNamePrecedence(sources []FolderNameSource) error {
+	seen := make(map[FolderNameSource]bool, len(sources))
+	for _, source := range sources {
+		switch source {
+		case FolderNameArr, FolderNameMagnet, FolderNameDebrid:
+		default:
+			return fmt.Errorf("invalid folder_name_precedence source %q", source)
+		}
+		if seen[source] {
+			return fmt.Errorf("folder_name_precedence lists %q twice", source)
+		}
+		seen[source] = true
+	}
+	return nil
+}
+
 type WebdavDirectories struct {
-	Filters map[string]string `json:"filters,omitempty"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	FileSortOrder FileSortOrder     `json:"file_sort_order,omitempty"` // Overrides WebDav.FileSortOrder for this directory
 	//SaveStrms bool              `json:"save_streams,omitempty"`
 }
 
@@ -12,8 +117,34 @@ type WebDav struct {
 	AutoExpireLinksAfter         string `json:"auto_expire_links_after,omitempty"`
 	ServeFromRclone              bool   `json:"serve_from_rclone,omitempty"`
 
+	// Availability pre-warm on startup
+	PreWarmAvailability bool `json:"pre_warm_availability,omitempty"`
+	PreWarmWorkers      int  `json:"pre_warm_workers,omitempty"`
+
 	// Folder
-	FolderNaming string `json:"folder_naming,omitempty"`
+	FolderNaming         string `json:"folder_naming,omitempty"`
+	CaseInsensitiveNames bool   `json:"case_insensitive_names,omitempty"` // Treat names differing only by case as the same folder
+	DedupFiles           bool   `json:"dedup_files,omitempty"`            // Add a __dedup__ folder showing files shared by several torrents once
+
+	FileSortOrder   FileSortOrder   `json:"file_sort_order,omitempty"`
+	FileListChanges FileListChanges `json:"file_list_changes,omitempty"`
+
+	UnlockedFilenames UnlockedFilenames `json:"unlocked_filenames,omitempty"`
+
+	IncompleteDownloads IncompleteDownloads `json:"incomplete_downloads,omitempty"`
+
+	PropfindErrors PropfindErrors `json:"propfind_errors,omitempty"`
+
+	DirectoryGet        DirectoryGet        `json:"directory_get,omitempty"`
+	DirectoryIndexFiles DirectoryIndexFiles `json:"directory_index_files,omitempty"`
+
+	// Sources of the torrent folder names, the first one with a name wins, the debrid name is the last resort
+	FolderNamePrecedence []FolderNameSource `json:"folder_name_precedence,omitempty"`
+
+	// Range requests coalescing
+	RangeCoalesceWindow string `json:"range_coalesce_window,omitempty"` // How long a read-ahead chunk serves the range requests it covers, disabled if empty or 0
+	ReadAheadSize       string `json:"read_ahead_size,omitempty"`       // Size of the chunk fetched for a small range request, 4MB etc
+	CacheSize           string `json:"cache_size,omitempty"`            // Memory kept for the read-ahead chunks, prefetching ahead of sequential reads, disabled if empty or 0
 
 	// Rclone
 	RcUrl         string `json:"rc_url,omitempty"`
@@ -23,4 +154,94 @@ type WebDav struct {
 
 	// Directories
 	Directories map[string]WebdavDirectories `json:"directories,omitempty"`
+
+	RootLayout RootLayout `json:"root_layout,omitempty"` // Global only
+
+	// IPs or CIDRs of the reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted. Global only
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Automatic directories for new categories
+	AutoCategoryDirectories  bool              `json:"auto_category_directories,omitempty"`
+	CategoryDirectoryFilters map[string]string `json:"category_directory_filters,omitempty"` // {category} is replaced by the category name
+}
+
+// categoryDirectory renders CategoryDirectoryFilters for a category. The category is quoted in the regex filters,
+// so its special characters match literally
+func (w WebDav) categoryDirectory(category string) WebdavDirectories {
+	template := w.CategoryDirectoryFilters
+	if len(template) == 0 {
+		template = map[string]string{"category": "{category}"}
+	}
+	filters := make(map[string]string, len(template))
+	for filterType, value := range template {
+		replacement := category
+		if filterType == "regex" || filterType == "not_regex" {
+			replacement = regexp.QuoteMeta(category)
+		}
+		filters[filterType] = strings.ReplaceAll(value, "{category}", replacement)
+	}
+	return WebdavDirectories{Filters: filters}
+}
+
+// GetTrustedProxies returns the parsed TrustedProxies, a single IP is a prefix of its full length.
+// Invalid entries are skipped, Validate reports them.
+func (w WebDav) GetTrustedProxies() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(w.TrustedProxies))
+	for _, proxy := range w.TrustedProxies {
+		if prefix, err := parseTrustedProxy(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// parseTrustedProxy parses an IP or a CIDR
+func parseTrustedProxy(proxy string) (netip.Prefix, error) {
+	proxy = strings.TrimSpace(proxy)
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// GetRangeCoalesceWindow returns the parsed RangeCoalesceWindow, 0 if coalescing is disabled
+func (w WebDav) GetRangeCoalesceWindow() time.Duration {
+	window, err := time.ParseDuration(w.RangeCoalesceWindow)
+	if err != nil || window <= 0 {
+		return 0
+	}
+	return window
+}
+
+// GetCacheSize returns the parsed CacheSize, 0 if the read-ahead cache is disabled
+func (w WebDav) GetCacheSize() int64 {
+	size, err := ParseSize(w.CacheSize)
+	if err != nil || size <= 0 {
+		return 0
+	}
+	return size
+}
+
+// GetAutoExpireLinksAfter returns the parsed AutoExpireLinksAfter, falling back to 48h like the providers do
+func (w WebDav) GetAutoExpireLinksAfter() time.Duration {
+	after, err := time.ParseDuration(w.AutoExpireLinksAfter)
+	if err != nil || after <= 0 {
+		return 48 * time.Hour
+	}
+	return after
+}
+
+// GetReadAheadSize returns the parsed ReadAheadSize, falling back to 4MB
+func (w WebDav) GetReadAheadSize() int64 {
+	size, err := ParseSize(w.ReadAheadSize)
+	if err != nil || size <= 0 {
+		return 4 * 1024 * 1024
+	}
+	return size
 }
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+type correlationIDKey struct{}
+
+// NewCorrelationID returns a new random correlation ID
+func NewCorrelationID() string {
+	return uuid.New().String()
+}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, empty if it has none
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// Ctx returns l with the correlation ID carried by ctx as the correlation_id attribute, l itself if ctx has none
+func Ctx(ctx context.Context, l zerolog.Logger) zerolog.Logger {
+	if id := CorrelationID(ctx); id != "" {
+		return l.With().Str("correlation_id", id).Logger()
+	}
+	return l
+}
@@ -31,7 +31,8 @@ func GetLogPath() string {
 
 func New(prefix string) zerolog.Logger {
 
-	level := config.Get().LogLevel
+	cfg := config.Get()
+	level := cfg.LogLevel
 
 	rotatingLogFile := &lumberjack.Logger{
 		Filename: GetLogPath(),
@@ -82,9 +83,14 @@ func New(prefix string) zerolog.Logger {
 	}
 
 	multi := zerolog.MultiLevelWriter(consoleWriter, fileWriter)
+	with := zerolog.New(multi).With()
+	if cfg.LogFormat == config.LogFormatJSON {
+		// Plain JSON lines, the prefix becomes the component attribute
+		multi = zerolog.MultiLevelWriter(os.Stdout, rotatingLogFile)
+		with = zerolog.New(multi).With().Str("component", prefix)
+	}
 
-	logger := zerolog.New(multi).
-		With().
+	logger := with.
 		Timestamp().
 		Logger().
 		Level(zerolog.InfoLevel)
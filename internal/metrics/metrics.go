@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The metrics are registered on the default prometheus registry, served on /metrics when enable_metrics is set
+
+var (
+	debridRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "decypharr_debrid_requests_total",
+		Help: "Debrid API requests, by debrid and HTTP status code, network_error if the request failed.",
+	}, []string{"debrid", "code"})
+
+	debridRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "decypharr_debrid_request_duration_seconds",
+		Help:    "Duration of the debrid API requests, retries included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"debrid"})
+
+	debridErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "decypharr_debrid_errors_total",
+		Help: "Debrid errors by code, e.g. traffic_exceeded or hoster_unavailable.",
+	}, []string{"debrid", "code"})
+
+	cachedChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "decypharr_cached_checks_total",
+		Help: "Cached checks of the torrents added, by debrid and result, hit if the torrent is cached.",
+	}, []string{"debrid", "result"})
+
+	freeSlots = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "decypharr_debrid_free_slots",
+		Help: "Active download slots left on the debrid, for the debrids reporting them.",
+	}, []string{"debrid"})
+
+	repairDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "decypharr_repair_duration_seconds",
+		Help:    "Duration of the repair runs, by the status they ended with.",
+		Buckets: []float64{10, 30, 60, 300, 600, 1800, 3600, 7200, 21600},
+	}, []string{"status"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// DebridRequest records a debrid API request, statusCode is 0 if it failed without a response
+func DebridRequest(debrid string, statusCode int, duration time.Duration) {
+	code := "network_error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	debridRequests.WithLabelValues(debrid, code).Inc()
+	debridRequestDuration.WithLabelValues(debrid).Observe(duration.Seconds())
+}
+
+// DebridError records a debrid error by its HTTPError code
+func DebridError(debrid string, code string) {
+	debridErrors.WithLabelValues(debrid, code).Inc()
+}
+
+// CachedCheck records whether a torrent checked was cached on the debrid
+func CachedCheck(debrid string, cached bool) {
+	result := "miss"
+	if cached {
+		result = "hit"
+	}
+	cachedChecks.WithLabelValues(debrid, result).Inc()
+}
+
+// SetFreeSlots records the active download slots left on the debrid
+func SetFreeSlots(debrid string, slots int) {
+	freeSlots.WithLabelValues(debrid).Set(float64(slots))
+}
+
+// RepairRun records the duration of a repair run and the status it ended with
+func RepairRun(status string, duration time.Duration) {
+	repairDuration.WithLabelValues(status).Observe(duration.Seconds())
+}
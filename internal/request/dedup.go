@@ -0,0 +1,75 @@
+package request
+
+import (
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"sync"
+	"time"
+)
+
+// notifications deduplicates the Discord notifications
+var notifications = &notificationDedup{entries: make(map[string]*dedupEntry)}
+
+// notificationDedup tracks the notifications sent within the notification_dedup_window, to drop their repeats
+type notificationDedup struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry // event, status and message -> entry
+}
+
+// dedupEntry is a notification sent, with the repeats dropped since
+type dedupEntry struct {
+	sent    time.Time
+	repeats int
+	window  time.Duration
+	policy  config.NotificationDedup
+	event   string
+	status  string
+	message string
+}
+
+// allow reports whether the notification should be sent, false for a repeat of one sent within the window
+func (d *notificationDedup) allow(event, status, message string) bool {
+	cfg := config.Get()
+	window := cfg.GetNotificationDedupWindow()
+	if window <= 0 {
+		return true
+	}
+	key := event + "\x00" + status + "\x00" + message
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[key]; ok && now.Sub(entry.sent) < entry.window {
+		entry.repeats++
+		return false
+	}
+	entry := &dedupEntry{
+		sent:    now,
+		window:  window,
+		policy:  cfg.NotificationDedup,
+		event:   event,
+		status:  status,
+		message: message,
+	}
+	d.entries[key] = entry
+	time.AfterFunc(window, func() {
+		d.expire(key, entry)
+	})
+	return true
+}
+
+// expire forgets the notification once its window ends, sending how many repeats were dropped with the summarize policy
+func (d *notificationDedup) expire(key string, entry *dedupEntry) {
+	d.mu.Lock()
+	if d.entries[key] == entry {
+		delete(d.entries, key)
+	}
+	repeats := entry.repeats
+	d.mu.Unlock()
+
+	if repeats == 0 || entry.policy != config.NotificationDedupSummarize {
+		return
+	}
+	msg := fmt.Sprintf("%s\n\nRepeated %d more times in %s.", entry.message, repeats, entry.window)
+	enqueueNotification(entry.event, entry.status, msg)
+}
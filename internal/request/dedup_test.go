@@ -0,0 +1,68 @@
+package request
+
+import (
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationDedup(t *testing.T) {
+	cfg := setTestConfig(t)
+	cfg.NotificationDedup = config.NotificationDedupSuppress
+	cfg.NotificationDedupWindow = "100ms"
+	d := &notificationDedup{entries: make(map[string]*dedupEntry)}
+
+	if !d.allow("download_failed", "error", "Movie failed") {
+		t.Fatal("first notification dropped")
+	}
+	if d.allow("download_failed", "error", "Movie failed") {
+		t.Error("repeat within the window sent")
+	}
+	if !d.allow("download_failed", "error", "Show failed") || !d.allow("download_complete", "success", "Movie failed") {
+		t.Error("different notification dropped")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !d.allow("download_failed", "error", "Movie failed") {
+		t.Error("notification dropped after the window ended")
+	}
+
+	cfg.NotificationDedupWindow = ""
+	for range 2 {
+		if !d.allow("download_failed", "error", "Show failed") {
+			t.Error("notification dropped with deduplication disabled")
+		}
+	}
+}
+
+func TestNotificationDedupSummarize(t *testing.T) {
+	summaries := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var webhook DiscordWebhook
+		_ = json.NewDecoder(r.Body).Decode(&webhook)
+		summaries <- webhook.Embeds[0].Description
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cfg := setTestConfig(t)
+	cfg.DiscordWebhook = srv.URL
+	cfg.NotificationDedup = config.NotificationDedupSummarize
+	cfg.NotificationDedupWindow = "50ms"
+	d := &notificationDedup{entries: make(map[string]*dedupEntry)}
+	for range 3 {
+		d.allow("download_failed", "error", "Movie failed")
+	}
+
+	select {
+	case summary := <-summaries:
+		if !strings.HasPrefix(summary, "Movie failed") || !strings.Contains(summary, "Repeated 2 more times") {
+			t.Errorf("summary = %q, want the message and its 2 repeats", summary)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no summary sent once the window ended")
+	}
+}
@@ -1,12 +1,9 @@
 package request
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/sirrobot01/decypharr/internal/config"
-	"io"
-	"net/http"
 	"strings"
 )
 
@@ -35,66 +32,78 @@ func getDiscordColor(status string) int {
 	}
 }
 
-func getDiscordHeader(event string) string {
-	switch event {
-	case "download_complete":
-		return "[Decypharr] Download Completed"
-	case "download_failed":
-		return "[Decypharr] Download Failed"
-	case "repair_pending":
-		return "[Decypharr] Repair Completed, Awaiting action"
-	case "repair_complete":
-		return "[Decypharr] Repair Complete"
-	default:
-		// split the event string and capitalize the first letter of each word
-		evs := strings.Split(event, "_")
-		for i, ev := range evs {
-			evs[i] = strings.ToTitle(ev)
-		}
-		return "[Decypharr] %s" + strings.Join(evs, " ")
-	}
+// Discord rejects embeds past these lengths, in characters
+const (
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+)
+
+// discordNotifier sends notifications as embeds to a Discord webhook
+type discordNotifier struct {
+	url string
 }
 
-func SendDiscordMessage(event string, status string, message string) error {
-	cfg := config.Get()
-	webhookURL := cfg.DiscordWebhook
-	if webhookURL == "" {
-		return nil
+func (d *discordNotifier) Notify(ctx context.Context, n Notification) error {
+	title := truncateDiscord(n.Title, discordTitleLimit)
+	parts := []string{truncateDiscord(n.Message, discordDescriptionLimit)}
+	if config.Get().DiscordOverflow == config.DiscordOverflowSplit {
+		parts = splitDiscord(n.Message, discordDescriptionLimit)
 	}
-
-	// Create the proper Discord webhook structure
-
-	webhook := DiscordWebhook{
-		Embeds: []DiscordEmbed{
-			{
-				Title:       getDiscordHeader(event),
-				Description: message,
-				Color:       getDiscordColor(status),
+	for i, part := range parts {
+		partTitle := title
+		if len(parts) > 1 {
+			suffix := fmt.Sprintf(" (%d/%d)", i+1, len(parts))
+			partTitle = truncateDiscord(title, discordTitleLimit-len(suffix)) + suffix
+		}
+		// Create the proper Discord webhook structure
+		webhook := DiscordWebhook{
+			Embeds: []DiscordEmbed{
+				{
+					Title:       partTitle,
+					Description: part,
+					Color:       getDiscordColor(n.Status),
+				},
 			},
-		},
-	}
-
-	payload, err := json.Marshal(webhook)
-	if err != nil {
-		return fmt.Errorf("failed to marshal discord payload: %v", err)
+		}
+		if err := postJSON(ctx, d.url, webhook); err != nil {
+			return fmt.Errorf("failed to send discord message: %w", err)
+		}
 	}
+	return nil
+}
 
-	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(payload))
-	if err != nil {
-		return fmt.Errorf("failed to create discord request: %v", err)
+// truncateDiscord cuts s to limit characters, ending it with an ellipsis if it was longer
+func truncateDiscord(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return string(runes[:limit-1]) + "…"
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send discord message: %v", err)
+// splitDiscord splits s into parts of at most limit characters, between lines when possible.
+// A line longer than limit is cut, its parts ending with an ellipsis.
+func splitDiscord(s string, limit int) []string {
+	var parts []string
+	var current []rune
+	for _, line := range strings.SplitAfter(s, "\n") {
+		runes := []rune(line)
+		if len(current)+len(runes) <= limit {
+			current = append(current, runes...)
+			continue
+		}
+		if len(current) > 0 {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		for len(runes) > limit {
+			parts = append(parts, string(runes[:limit-1])+"…")
+			runes = runes[limit-1:]
+		}
+		current = runes
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("discord returned error status code: %s, body: %s", resp.Status, string(bodyBytes))
+	if len(current) > 0 || len(parts) == 0 {
+		parts = append(parts, string(current))
 	}
-
-	return nil
+	return parts
 }
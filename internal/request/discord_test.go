@@ -0,0 +1,101 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// setTestConfig loads a default config from a temp directory
+func setTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	return config.Get()
+}
+
+func TestTruncateDiscord(t *testing.T) {
+	if got := truncateDiscord("short", 10); got != "short" {
+		t.Errorf("truncateDiscord() = %s, want the string unchanged", got)
+	}
+	got := truncateDiscord(strings.Repeat("é", 20), 10)
+	if utf8.RuneCountInString(got) != 10 || !strings.HasSuffix(got, "…") {
+		t.Errorf("truncateDiscord() = %s, want 10 characters ending with an ellipsis", got)
+	}
+}
+
+func TestSplitDiscord(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  []string
+	}{
+		{"empty", "", 7, []string{""}},
+		{"fits", "a\nb", 7, []string{"a\nb"}},
+		{"between lines", "aaaa\nbbbb\ncc", 7, []string{"aaaa\n", "bbbb\ncc"}},
+		{"long line", "abcdefghijkl", 5, []string{"abcd…", "efgh…", "ijkl"}},
+	}
+	for _, tt := range tests {
+		got := splitDiscord(tt.s, tt.limit)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: splitDiscord() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiscordNotifyOverflow(t *testing.T) {
+	var mu sync.Mutex
+	var embeds []DiscordEmbed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var webhook DiscordWebhook
+		_ = json.NewDecoder(r.Body).Decode(&webhook)
+		mu.Lock()
+		embeds = append(embeds, webhook.Embeds...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cfg := setTestConfig(t)
+	message := strings.Repeat(strings.Repeat("x", 99)+"\n", 100) // 10000 characters
+	notification := Notification{Title: strings.Repeat("t", 300), Message: message, Status: "error"}
+	d := &discordNotifier{url: srv.URL}
+
+	for _, tt := range []struct {
+		overflow config.DiscordOverflow
+		parts    int
+	}{
+		{config.DiscordOverflowTruncate, 1},
+		{config.DiscordOverflowSplit, 3},
+	} {
+		embeds = nil
+		cfg.DiscordOverflow = tt.overflow
+		if err := d.Notify(context.Background(), notification); err != nil {
+			t.Fatal(err)
+		}
+		if len(embeds) != tt.parts {
+			t.Fatalf("%s: sent %d embeds, want %d", tt.overflow, len(embeds), tt.parts)
+		}
+		for _, embed := range embeds {
+			if utf8.RuneCountInString(embed.Title) > discordTitleLimit || utf8.RuneCountInString(embed.Description) > discordDescriptionLimit {
+				t.Errorf("%s: embed of a %d characters title and %d characters description, over the limits", tt.overflow, len(embed.Title), len(embed.Description))
+			}
+		}
+	}
+	if !strings.HasSuffix(embeds[2].Title, "(3/3)") {
+		t.Errorf("title = %s, want the part number", embeds[2].Title)
+	}
+}
@@ -0,0 +1,152 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// lowHeadroom is the remaining requests below which requests are spread until the quota resets
+	lowHeadroom = 5
+
+	// maxRetryAfter caps how long a Retry-After holds the requests, longer waits fail the request instead
+	maxRetryAfter = 5 * time.Minute
+
+	// unknownReset is how long requests pause when the quota is exhausted and its reset isn't given
+	unknownReset = 10 * time.Second
+)
+
+// RateLimitHeadroom is the request quota a provider reported in the rate-limit headers of its last response
+type RateLimitHeadroom struct {
+	Remaining int       `json:"remaining"`
+	Limit     int       `json:"limit,omitempty"`
+	Reset     time.Time `json:"reset,omitzero"`        // When the quota resets, if reported
+	Paused    time.Time `json:"paused_until,omitzero"` // Requests wait until then, after a Retry-After or an exhausted quota
+}
+
+// headroom reads the X-RateLimit-* and Retry-After headers of the responses, to slow the requests down before the
+// provider rate limits them. While few requests remain, they are spread until the quota resets; once none remain,
+// or a 429 or 503 has a Retry-After, requests pause until then.
+type headroom struct {
+	mu        sync.Mutex
+	seen      bool // The provider sends rate-limit headers
+	remaining int
+	limit     int
+	reset     time.Time
+	paused    time.Time
+	next      time.Time // Earliest time of the next request while spreading them
+}
+
+// observe records the rate-limit headers of resp and returns its Retry-After, 0 if none
+func (h *headroom) observe(resp *http.Response) time.Duration {
+	now := time.Now()
+	retryAfter := time.Duration(0)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		h.seen = true
+		h.remaining = remaining
+		h.limit, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+		h.reset = parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset"), now)
+		if remaining <= 0 {
+			switch {
+			case h.reset.After(now.Add(maxRetryAfter)):
+				h.pauseUntil(now.Add(maxRetryAfter))
+			case h.reset.After(now):
+				h.pauseUntil(h.reset)
+			default:
+				h.pauseUntil(now.Add(unknownReset))
+			}
+		}
+	}
+	if retryAfter > 0 {
+		h.pauseUntil(now.Add(min(retryAfter, maxRetryAfter)))
+	}
+	return retryAfter
+}
+
+// pauseUntil holds the requests until t, h.mu must be held
+func (h *headroom) pauseUntil(t time.Time) {
+	if t.After(h.paused) {
+		h.paused = t
+	}
+}
+
+// wait blocks until the next request may be sent, or ctx is done
+func (h *headroom) wait(ctx context.Context) error {
+	h.mu.Lock()
+	now := time.Now()
+	at := h.paused
+	if h.seen && h.remaining > 0 && h.remaining <= lowHeadroom && h.reset.After(now) {
+		// Spread the remaining requests until the reset, counting down so concurrent requests don't all go at once
+		at = maxTime(at, maxTime(h.next, now))
+		h.next = at.Add(min(h.reset.Sub(now)/time.Duration(h.remaining+1), maxRetryAfter))
+		h.remaining--
+	}
+	h.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// snapshot returns the last quota reported, false if the provider doesn't send rate-limit headers
+func (h *headroom) snapshot() (RateLimitHeadroom, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.seen {
+		return RateLimitHeadroom{}, false
+	}
+	s := RateLimitHeadroom{Remaining: h.remaining, Limit: h.limit, Reset: h.reset}
+	if time.Now().Before(h.paused) {
+		s.Paused = h.paused
+	}
+	return s, true
+}
+
+// parseRetryAfter parses a Retry-After in seconds or as an HTTP date, 0 if it is empty or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// parseRateLimitReset parses an X-RateLimit-Reset, which providers send either as a Unix time or as seconds from now
+func parseRateLimitReset(value string, now time.Time) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	if seconds > 1_000_000_000 {
+		return time.Unix(seconds, 0)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,161 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// notificationQueueSize bounds the notifications waiting to be sent, newer ones are dropped once it's full
+	notificationQueueSize = 100
+
+	// notifyTimeout bounds sending a notification to a target
+	notifyTimeout = 30 * time.Second
+)
+
+// Notification is an event sent to the notification targets
+type Notification struct {
+	Event   string    `json:"event"`  // e.g. download_complete or debrid_down
+	Status  string    `json:"status"` // success, error, warning or pending
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier sends notifications to a service
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// notifyClient sends the notifications of all targets
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// newNotifier returns the notifier of a target
+func newNotifier(target config.NotificationTarget) (Notifier, error) {
+	switch target.Type {
+	case config.NotifierDiscord:
+		return &discordNotifier{url: target.URL}, nil
+	case config.NotifierWebhook:
+		return &webhookNotifier{url: target.URL}, nil
+	case config.NotifierTelegram:
+		return &telegramNotifier{botToken: target.BotToken, chatID: target.ChatID}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", target.Type)
+}
+
+var (
+	notificationQueue     chan Notification
+	notificationQueueOnce sync.Once
+)
+
+// Notify queues a notification for the targets subscribed to its event, without waiting for it to be sent.
+// Identical notifications are deduplicated within the notification_dedup_window.
+func Notify(event, status, message string) {
+	if len(config.Get().NotificationTargets()) == 0 {
+		return
+	}
+	if !notifications.allow(event, status, message) {
+		return
+	}
+	enqueueNotification(event, status, message)
+}
+
+// enqueueNotification queues a notification, starting the sender on the first one
+func enqueueNotification(event, status, message string) {
+	notificationQueueOnce.Do(func() {
+		notificationQueue = make(chan Notification, notificationQueueSize)
+		go sendNotifications()
+	})
+	n := Notification{
+		Event:   event,
+		Status:  status,
+		Title:   notificationTitle(event),
+		Message: message,
+		Time:    time.Now(),
+	}
+	select {
+	case notificationQueue <- n:
+	default:
+		_logger := logger.Default()
+		_logger.Warn().Str("event", event).Msg("Notification queue full, dropping the notification")
+	}
+}
+
+// sendNotifications sends the queued notifications, one at a time, to the targets subscribed to them
+func sendNotifications() {
+	_logger := logger.Default()
+	for n := range notificationQueue {
+		for _, target := range config.Get().NotificationTargets() {
+			if !target.Subscribed(n.Event) {
+				continue
+			}
+			notifier, err := newNotifier(target)
+			if err != nil {
+				_logger.Error().Err(err).Msg("Error sending notification")
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			if err := notifier.Notify(ctx, n); err != nil {
+				_logger.Error().Err(err).Str("event", n.Event).Str("target", string(target.Type)).Msg("Error sending notification")
+			}
+			cancel()
+		}
+	}
+}
+
+// notificationTitle returns the title of the notifications of event
+func notificationTitle(event string) string {
+	switch event {
+	case "download_complete":
+		return "[Decypharr] Download Completed"
+	case "download_failed":
+		return "[Decypharr] Download Failed"
+	case "repair_pending":
+		return "[Decypharr] Repair Completed, Awaiting action"
+	case "repair_complete":
+		return "[Decypharr] Repair Complete"
+	case "standby_activated":
+		return "[Decypharr] Standby Debrid Activated"
+	case "standby_deactivated":
+		return "[Decypharr] Standby Debrid Deactivated"
+	case "premium_expired":
+		return "[Decypharr] Premium Expired"
+	case "premium_restored":
+		return "[Decypharr] Premium Restored"
+	case "debrid_down":
+		return "[Decypharr] Debrid Down"
+	case "debrid_recovered":
+		return "[Decypharr] Debrid Recovered"
+	case "traffic_budget_reached":
+		return "[Decypharr] Traffic Budget Reached"
+	case "traffic_budget_restored":
+		return "[Decypharr] Traffic Budget Restored"
+	case "unlock_quota_reached":
+		return "[Decypharr] Unlock Quota Reached"
+	case "unlock_quota_restored":
+		return "[Decypharr] Unlock Quota Restored"
+	case "torrent_promoted":
+		return "[Decypharr] Torrent Became Cached"
+	case "torrent_expiring":
+		return "[Decypharr] Torrent Expiring"
+	case "arr_rescan_failed":
+		return "[Decypharr] Arr Rescan Failed"
+	case "no_video":
+		return "[Decypharr] No Video Files"
+	default:
+		// split the event string and capitalize the first letter of each word
+		evs := strings.Split(event, "_")
+		for i, ev := range evs {
+			if ev != "" {
+				evs[i] = strings.ToUpper(ev[:1]) + ev[1:]
+			}
+		}
+		return "[Decypharr] " + strings.Join(evs, " ")
+	}
+}
@@ -0,0 +1,45 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/url"
+)
+
+// socks5DialContext returns a DialContext connecting through the SOCKS5 proxy of proxyURL, with the credentials
+// of the URL if any. Hostnames are resolved locally with socks5://, and by the proxy with socks5h://.
+func socks5DialContext(proxyURL *url.URL) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS5 dialer doesn't support contexts")
+	}
+	remoteDNS := proxyURL.Scheme == "socks5h"
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !remoteDNS {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			if len(ips) == 0 {
+				return nil, fmt.Errorf("no address found for %s", host)
+			}
+			addr = net.JoinHostPort(ips[0].IP.String(), port)
+		}
+		return contextDialer.DialContext(ctx, network, addr)
+	}, nil
+}
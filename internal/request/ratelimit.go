@@ -0,0 +1,108 @@
+package request
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"go.uber.org/ratelimit"
+	"sync"
+	"time"
+)
+
+// Limiter is a leaky bucket rate limiter whose rate can be changed while it is in use, it implements
+// ratelimit.Limiter. Up to a tenth of the rate can be banked while idle and sent back to back.
+type Limiter struct {
+	mu       sync.Mutex
+	rate     string
+	interval time.Duration // Between two requests
+	slack    int           // Requests banked at most
+	next     time.Time     // Slot of the next request, in the past when requests are banked
+}
+
+func newLimiter(rate string, count int, per time.Duration) *Limiter {
+	l := &Limiter{}
+	l.setRate(rate, count, per)
+	return l
+}
+
+// setRate changes the rate of the limiter. The requests waiting keep their slot and the next one follows the last
+// of them at the new rate, so a change causes neither a burst nor a stall; the time banked while idle is kept and
+// counted at the new rate.
+func (l *Limiter) setRate(rate string, count int, per time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	interval := max(per/time.Duration(count), time.Nanosecond)
+	if !l.next.IsZero() {
+		// next is the last slot plus the old interval
+		l.next = l.next.Add(interval - l.interval)
+	}
+	l.rate = rate
+	l.interval = interval
+	l.slack = count / 10
+}
+
+// Take waits for the next slot of the limiter and returns its time
+func (l *Limiter) Take() time.Time {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if earliest := now.Add(-time.Duration(l.slack) * l.interval); slot.Before(earliest) {
+		slot = earliest
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := slot.Sub(now); wait > 0 {
+		time.Sleep(wait)
+		return slot
+	}
+	return now
+}
+
+// Rate returns the rate of the limiter, e.g. 200/minute
+func (l *Limiter) Rate() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+var rateLimiters = struct {
+	sync.Mutex
+	byName     map[string]*Limiter
+	referenced map[string]struct{} // Names returned since the last PruneRateLimiters
+}{byName: make(map[string]*Limiter), referenced: make(map[string]struct{})}
+
+// RateLimiter returns the limiter named name for a rate limit like 200/minute, nil if rate is empty or invalid.
+// The clients rebuilt on a config change get the limiter of the same name back, set to the new rate, so its
+// waiting requests and accounting carry over; with rate_limit_reload set to reset they get a new limiter instead.
+// The limiters no client asks for again are dropped by PruneRateLimiters.
+func RateLimiter(name, rate string) ratelimit.Limiter {
+	if rate == "" {
+		return nil
+	}
+	count, per, err := config.ParseRateLimit(rate)
+	if err != nil {
+		return nil
+	}
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	rateLimiters.referenced[name] = struct{}{}
+	if l, ok := rateLimiters.byName[name]; ok && config.Get().RateLimitReload != config.RateLimitReloadReset {
+		l.setRate(rate, count, per)
+		return l
+	}
+	l := newLimiter(rate, count, per)
+	rateLimiters.byName[name] = l
+	return l
+}
+
+// PruneRateLimiters drops the limiters not returned by RateLimiter since the previous call, e.g. those of a removed
+// debrid or download API key. It's called once the clients are rebuilt after a config reload.
+func PruneRateLimiters() {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	for name := range rateLimiters.byName {
+		if _, ok := rateLimiters.referenced[name]; !ok {
+			delete(rateLimiters.byName, name)
+		}
+	}
+	rateLimiters.referenced = make(map[string]struct{})
+}
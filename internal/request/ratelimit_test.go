@@ -0,0 +1,62 @@
+package request
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"testing"
+	"time"
+)
+
+// A live rate change makes the next request follow the last one at the new rate, with neither a burst nor a stall
+func TestLimiterRateChange(t *testing.T) {
+	l := newLimiter("1/minute", 1, time.Minute)
+	l.Take()
+	l.setRate("100/second", 100, time.Second)
+	start := time.Now()
+	l.Take()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request after raising the rate waited %s, want the 10ms of the new rate", elapsed)
+	}
+
+	l = newLimiter("100/second", 100, time.Second)
+	for range 11 {
+		// The 10 requests banked, and the first at the rate
+		l.Take()
+	}
+	l.setRate("2/second", 2, time.Second)
+	start = time.Now()
+	l.Take()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Errorf("request after lowering the rate waited %s, want the 500ms of the new rate", elapsed)
+	}
+	if l.Rate() != "2/second" {
+		t.Errorf("Rate() = %s, want 2/second", l.Rate())
+	}
+}
+
+func TestRateLimiterReload(t *testing.T) {
+	cfg := setTestConfig(t)
+	first := RateLimiter("test/reload", "100/minute")
+	if again := RateLimiter("test/reload", "200/minute"); again != first {
+		t.Error("new limiter returned for the same name, want the existing one carried over")
+	}
+	if rate := first.(*Limiter).Rate(); rate != "200/minute" {
+		t.Errorf("rate = %s after the change, want 200/minute", rate)
+	}
+
+	cfg.RateLimitReload = config.RateLimitReloadReset
+	if reset := RateLimiter("test/reload", "200/minute"); reset == first {
+		t.Error("limiter carried over with rate_limit_reload reset")
+	}
+
+	RateLimiter("test/kept", "100/minute")
+	PruneRateLimiters()
+	RateLimiter("test/kept", "100/minute")
+	PruneRateLimiters()
+	rateLimiters.Lock()
+	_, kept := rateLimiters.byName["test/kept"]
+	_, dropped := rateLimiters.byName["test/reload"]
+	rateLimiters.Unlock()
+	if !kept || dropped {
+		t.Errorf("after pruning, referenced limiter kept %v, unreferenced one kept %v", kept, dropped)
+	}
+}
@@ -3,22 +3,25 @@ package request
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/metrics"
 	"go.uber.org/ratelimit"
-	"golang.org/x/net/proxy"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -46,12 +49,24 @@ var (
 	instance *Client
 )
 
+// Authentication states reported by Client.AuthState
+const (
+	AuthStateInvalidKey = "invalid_key" // 401, the API key is wrong or expired
+	AuthStateForbidden  = "forbidden"   // 403, the IP, proxy or account is restricted
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrForbidden  = errors.New("forbidden")
+)
+
 type ClientOption func(*Client)
 
 // Client represents an HTTP client with additional capabilities
 type Client struct {
 	client          *http.Client
 	rateLimiter     ratelimit.Limiter
+	keyLimiters     *keyLimiters // A rate limiter per API key, replacing rateLimiter
 	headers         map[string]string
 	headersMu       sync.RWMutex
 	maxRetries      int
@@ -60,6 +75,30 @@ type Client struct {
 	retryableStatus map[int]struct{}
 	logger          zerolog.Logger
 	proxy           string
+
+	forbiddenProxy  string       // Proxy a 403 is retried through once, not retried if empty
+	forbiddenClient *http.Client // Client going through forbiddenProxy
+
+	// connection pool, zero values keep the net/http defaults
+	maxIdleConns    int
+	maxConnsPerHost int
+	idleConnTimeout time.Duration
+
+	softBan *SoftBanGuard
+
+	metricsName string // Name the requests are recorded under in the metrics, not recorded if empty
+
+	tokenSource TokenSource // Bearer token of the requests, overriding the Authorization header
+
+	authStatus atomic.Int32 // 401 or 403 since the last response, 0 once a request succeeds
+
+	headroom headroom // Rate-limit headers of the provider
+}
+
+// TokenSource provides the access token of the requests, e.g. from an OAuth login
+type TokenSource interface {
+	Token(ctx context.Context) (string, error) // The current access token, refreshed once expired
+	Expire()                                   // Called on a 401, the next Token call refreshes the token
 }
 
 // WithMaxRetries sets the maximum number of retry attempts
@@ -89,6 +128,28 @@ func WithRateLimiter(rl ratelimit.Limiter) ClientOption {
 	}
 }
 
+// WithRateLimit limits the requests to rateStr, e.g. 200/minute, with the limiters named after name, see RateLimiter.
+// With the key scope, each API key, sent in the Authorization header, has its own limit, the requests share a single
+// limit otherwise. The limiters of keys, the API keys known upfront, are created right away so they carry over a
+// config reload; those of other keys on their first request.
+func WithRateLimit(name, rateStr string, scope config.RateLimitScope, keys ...string) ClientOption {
+	return func(c *Client) {
+		if scope == config.RateLimitScopeAccount {
+			c.rateLimiter = RateLimiter(name, rateStr)
+			return
+		}
+		if ParseRateLimit(rateStr) == nil {
+			return
+		}
+		c.keyLimiters = &keyLimiters{name: name, rate: rateStr, limiters: make(map[string]ratelimit.Limiter)}
+		for _, key := range keys {
+			if key != "" {
+				c.keyLimiters.get(keyFingerprint(key))
+			}
+		}
+	}
+}
+
 // WithHeaders sets default headers
 func WithHeaders(headers map[string]string) ClientOption {
 	return func(c *Client) {
@@ -132,18 +193,121 @@ func WithProxy(proxyURL string) ClientOption {
 	}
 }
 
-// doRequest performs a single HTTP request with rate limiting
-func (c *Client) doRequest(req *http.Request) (*http.Response, error) {
-	if c.rateLimiter != nil {
+// WithRetryOn403ViaProxy retries a request answered 403 once through proxyURL, as the provider may only restrict the
+// IP the requests come from. Without it, a 403 is answered as is. It has no effect if proxyURL is empty
+func WithRetryOn403ViaProxy(proxyURL string) ClientOption {
+	return func(c *Client) {
+		c.forbiddenProxy = proxyURL
+	}
+}
+
+// WithConnectionPool configures the connection pool of the default transport.
+// It has no effect if a transport is set with WithTransport
+func WithConnectionPool(maxIdleConns, maxConnsPerHost int, idleConnTimeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxIdleConns = maxIdleConns
+		c.maxConnsPerHost = maxConnsPerHost
+		c.idleConnTimeout = idleConnTimeout
+	}
+}
+
+// WithSoftBanGuard makes the client slow down when the guard is in conservative mode.
+// The same guard can be shared by the clients of a provider
+func WithSoftBanGuard(guard *SoftBanGuard) ClientOption {
+	return func(c *Client) {
+		c.softBan = guard
+	}
+}
+
+// WithTokenSource authorizes the requests with the bearer token of source, a 401 refreshes it and retries once
+func WithTokenSource(source TokenSource) ClientOption {
+	return func(c *Client) {
+		c.tokenSource = source
+	}
+}
+
+// WithMetrics records the requests of the client in the metrics, under the debrid name
+func WithMetrics(name string) ClientOption {
+	return func(c *Client) {
+		c.metricsName = name
+	}
+}
+
+// InConservativeMode reports whether the client is slowed down after repeated rate limits
+func (c *Client) InConservativeMode() bool {
+	return c.softBan.Active()
+}
+
+// RateLimitHeadroom returns the quota the provider reported in its last rate-limit headers, false if it sends none
+func (c *Client) RateLimitHeadroom() (RateLimitHeadroom, bool) {
+	return c.headroom.snapshot()
+}
+
+// AuthState returns AuthStateInvalidKey or AuthStateForbidden if the last response was a 401 or 403, "" otherwise
+func (c *Client) AuthState() string {
+	switch c.authStatus.Load() {
+	case http.StatusUnauthorized:
+		return AuthStateInvalidKey
+	case http.StatusForbidden:
+		return AuthStateForbidden
+	}
+	return ""
+}
+
+// recordAuth tracks 401 and 403 responses, a successful response clears them
+func (c *Client) recordAuth(ctx context.Context, statusCode int) {
+	_logger := logger.Ctx(ctx, c.logger)
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		if c.authStatus.Swap(int32(statusCode)) == int32(statusCode) {
+			return
+		}
+		if statusCode == http.StatusUnauthorized {
+			_logger.Error().Msg("Request unauthorized (401), the API key is invalid or expired")
+			return
+		}
+		msg := "Request forbidden (403), the IP or account may be restricted"
+		if c.proxy == "" && c.forbiddenProxy == "" {
+			msg += ", consider setting a proxy"
+		}
+		_logger.Warn().Msg(msg)
+	case statusCode >= 200 && statusCode < 300:
+		if c.authStatus.Swap(0) != 0 {
+			_logger.Info().Msg("Requests are authorized again")
+		}
+	}
+}
+
+// doRequest performs a single HTTP request with rate limiting, through httpClient
+func (c *Client) doRequest(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	rateLimiter := c.rateLimiter
+	if c.keyLimiters != nil {
+		rateLimiter = c.keyLimiters.get(keyFingerprint(req.Header.Get("Authorization")))
+	}
+	if rateLimiter != nil {
 		select {
 		case <-req.Context().Done():
 			return nil, req.Context().Err()
 		default:
-			c.rateLimiter.Take()
+			rateLimiter.Take()
 		}
 	}
+	c.softBan.wait()
+	if err := c.headroom.wait(req.Context()); err != nil {
+		return nil, err
+	}
 
-	return c.client.Do(req)
+	if c.metricsName == "" {
+		return httpClient.Do(req)
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	statusCode := 0
+	if err == nil {
+		statusCode = resp.StatusCode
+	}
+	metrics.DebridRequest(c.metricsName, statusCode, time.Since(start))
+	return resp, err
 }
 
 // Do performs an HTTP request with retries for certain status codes
@@ -161,7 +325,11 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	}
 
 	backoff := time.Millisecond * 500
+	if c.softBan.Active() {
+		backoff = conservativeBackoff
+	}
 	var resp *http.Response
+	httpClient := c.client // Switched to forbiddenClient after a 403
 
 	for attempt := 0; attempt <= c.maxRetries; attempt++ {
 		// Reset the request body if it exists
@@ -177,8 +345,15 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 			}
 		}
 		c.headersMu.RUnlock()
+		if c.tokenSource != nil {
+			token, err := c.tokenSource.Token(req.Context())
+			if err != nil {
+				return nil, fmt.Errorf("getting access token: %w", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
 
-		resp, err = c.doRequest(req)
+		resp, err = c.doRequest(httpClient, req)
 		if err != nil {
 			// Check if this is a network error that might be worth retrying
 			if isRetryableError(err) && attempt < c.maxRetries {
@@ -200,17 +375,38 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 			return nil, err
 		}
 
+		if resp.StatusCode == http.StatusTooManyRequests {
+			c.softBan.record()
+		}
+		retryAfter := c.headroom.observe(resp)
+		if resp.StatusCode == http.StatusUnauthorized && c.tokenSource != nil && attempt == 0 && c.maxRetries > 0 {
+			// The access token may have been revoked or expired early
+			resp.Body.Close()
+			c.tokenSource.Expire()
+			continue
+		}
+		if resp.StatusCode == http.StatusForbidden && c.forbiddenClient != nil && httpClient != c.forbiddenClient && attempt < c.maxRetries {
+			// The provider may only restrict the IP of the requests
+			resp.Body.Close()
+			_logger := logger.Ctx(req.Context(), c.logger)
+			_logger.Warn().Msg("Request forbidden (403), retrying through the forbidden_proxy")
+			httpClient = c.forbiddenClient
+			continue
+		}
+		c.recordAuth(req.Context(), resp.StatusCode)
+
 		// Check if the status code is retryable
-		if _, ok := c.retryableStatus[resp.StatusCode]; !ok || attempt == c.maxRetries {
+		_, retryable := c.retryableStatus[resp.StatusCode]
+		if !retryable || attempt == c.maxRetries || retryAfter > maxRetryAfter {
 			return resp, nil
 		}
 
 		// Close the response body before retrying
 		resp.Body.Close()
 
-		// Apply backoff with jitter
+		// Apply backoff with jitter, waiting at least as long as the provider asks
 		jitter := time.Duration(rand.Int63n(int64(backoff / 4)))
-		sleepTime := backoff + jitter
+		sleepTime := max(backoff+jitter, retryAfter)
 
 		select {
 		case <-req.Context().Done():
@@ -235,7 +431,8 @@ func (c *Client) MakeRequest(req *http.Request) ([]byte, error) {
 
 	defer func() {
 		if err := res.Body.Close(); err != nil {
-			c.logger.Printf("Failed to close response body: %v", err)
+			_logger := logger.Ctx(req.Context(), c.logger)
+			_logger.Printf("Failed to close response body: %v", err)
 		}
 	}()
 
@@ -244,7 +441,12 @@ func (c *Client) MakeRequest(req *http.Request) ([]byte, error) {
 		return nil, fmt.Errorf("reading response body: %w", err)
 	}
 
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKey, string(bodyBytes))
+	case res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %s", ErrForbidden, string(bodyBytes))
+	case res.StatusCode < 200 || res.StatusCode >= 300:
 		return nil, fmt.Errorf("HTTP error %d: %s", res.StatusCode, string(bodyBytes))
 	}
 
@@ -287,6 +489,7 @@ func New(options ...ClientOption) *Client {
 	for _, option := range options {
 		option(client)
 	}
+	client.client.Timeout = client.timeout
 
 	// Check if transport was set by WithTransport option
 	if client.client.Transport == nil {
@@ -294,42 +497,15 @@ func New(options ...ClientOption) *Client {
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: client.skipTLSVerify,
 			},
-			DisableKeepAlives: false,
+			DisableKeepAlives:   false,
+			MaxIdleConns:        client.maxIdleConns,
+			MaxIdleConnsPerHost: client.maxIdleConns,
+			MaxConnsPerHost:     client.maxConnsPerHost,
+			IdleConnTimeout:     client.idleConnTimeout,
 		}
 
-		// Configure proxy if needed
-		if client.proxy != "" {
-			if strings.HasPrefix(client.proxy, "socks5://") {
-				// Handle SOCKS5 proxy
-				socksURL, err := url.Parse(client.proxy)
-				if err != nil {
-					client.logger.Error().Msgf("Failed to parse SOCKS5 proxy URL: %v", err)
-				} else {
-					auth := &proxy.Auth{}
-					if socksURL.User != nil {
-						auth.User = socksURL.User.Username()
-						password, _ := socksURL.User.Password()
-						auth.Password = password
-					}
-
-					dialer, err := proxy.SOCKS5("tcp", socksURL.Host, auth, proxy.Direct)
-					if err != nil {
-						client.logger.Error().Msgf("Failed to create SOCKS5 dialer: %v", err)
-					} else {
-						transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-							return dialer.Dial(network, addr)
-						}
-					}
-				}
-			} else {
-				proxyURL, err := url.Parse(client.proxy)
-				if err != nil {
-					client.logger.Error().Msgf("Failed to parse proxy URL: %v", err)
-				} else {
-					transport.Proxy = http.ProxyURL(proxyURL)
-				}
-			}
-		} else {
+		client.configureProxy(transport, client.proxy)
+		if client.proxy == "" {
 			transport.Proxy = http.ProxyFromEnvironment
 		}
 
@@ -337,42 +513,83 @@ func New(options ...ClientOption) *Client {
 		client.client.Transport = transport
 	}
 
+	if transport, ok := client.client.Transport.(*http.Transport); ok && client.forbiddenProxy != "" {
+		forbiddenTransport := transport.Clone()
+		forbiddenTransport.Proxy = nil
+		forbiddenTransport.DialContext = nil
+		if client.configureProxy(forbiddenTransport, client.forbiddenProxy) {
+			client.forbiddenClient = &http.Client{
+				Transport:     forbiddenTransport,
+				Timeout:       client.client.Timeout,
+				CheckRedirect: client.client.CheckRedirect,
+			}
+		}
+	}
+
 	return client
 }
 
+// configureProxy routes transport through proxy, an http(s) or socks5(h) URL. It returns false if it isn't set or
+// can't be used
+func (c *Client) configureProxy(transport *http.Transport, proxy string) bool {
+	if proxy == "" {
+		return false
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		c.logger.Error().Msgf("Failed to parse proxy URL: %v", err)
+		return false
+	}
+	if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
+		dialContext, err := socks5DialContext(proxyURL)
+		if err != nil {
+			c.logger.Error().Msgf("Failed to create SOCKS5 dialer: %v", err)
+			return false
+		}
+		transport.DialContext = dialContext
+		return true
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return true
+}
+
+// ParseRateLimit returns a limiter for a rate limit like 200/minute, nil if it is empty or invalid, see config.ParseRateLimit
 func ParseRateLimit(rateStr string) ratelimit.Limiter {
 	if rateStr == "" {
 		return nil
 	}
-	parts := strings.SplitN(rateStr, "/", 2)
-	if len(parts) != 2 {
+	count, per, err := config.ParseRateLimit(rateStr)
+	if err != nil {
 		return nil
 	}
+	return newLimiter(rateStr, count, per)
+}
 
-	// parse count
-	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
-	if err != nil || count <= 0 {
-		return nil
-	}
+// keyFingerprint identifies the API key of an Authorization header without the key, like the fingerprint of its
+// debrid account, so the key isn't kept in the limiters or their names
+func keyFingerprint(authorization string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
 
-	// Set slack size to 10%
-	slackSize := count / 10
-
-	// normalize unit
-	unit := strings.ToLower(strings.TrimSpace(parts[1]))
-	unit = strings.TrimSuffix(unit, "s")
-	switch unit {
-	case "minute", "min":
-		return ratelimit.New(count, ratelimit.Per(time.Minute), ratelimit.WithSlack(slackSize))
-	case "second", "sec":
-		return ratelimit.New(count, ratelimit.Per(time.Second), ratelimit.WithSlack(slackSize))
-	case "hour", "hr":
-		return ratelimit.New(count, ratelimit.Per(time.Hour), ratelimit.WithSlack(slackSize))
-	case "day", "d":
-		return ratelimit.New(count, ratelimit.Per(24*time.Hour), ratelimit.WithSlack(slackSize))
-	default:
-		return nil
+// keyLimiters holds a rate limiter per API key fingerprint, created on the key's first request
+type keyLimiters struct {
+	name     string
+	rate     string
+	mu       sync.Mutex
+	limiters map[string]ratelimit.Limiter
+}
+
+func (k *keyLimiters) get(key string) ratelimit.Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	limiter, ok := k.limiters[key]
+	if !ok {
+		limiter = RateLimiter(k.name+"/"+key, k.rate)
+		k.limiters[key] = limiter
 	}
+	return limiter
 }
 
 func JSONResponse(w http.ResponseWriter, data interface{}, code int) {
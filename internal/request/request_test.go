@@ -0,0 +1,129 @@
+package request
+
+import (
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// With the key scope each API key has its own limit, the limiters are named by a fingerprint of the key
+func TestKeyRateLimits(t *testing.T) {
+	setTestConfig(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := New(WithRateLimit("test/download", "1/minute", config.RateLimitScopeKey, "secret-a", "secret-b"))
+	start := time.Now()
+	for _, key := range []string{"secret-a", "secret-b"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("requests of two keys took %s, want each key limited on its own", elapsed)
+	}
+	if len(c.keyLimiters.limiters) != 2 {
+		t.Errorf("%d limiters, want one per key", len(c.keyLimiters.limiters))
+	}
+	if keyFingerprint("Bearer secret-a") != keyFingerprint("secret-a") {
+		t.Error("fingerprint of a key depends on the Bearer prefix")
+	}
+	rateLimiters.Lock()
+	for name := range rateLimiters.byName {
+		if strings.Contains(name, "secret") {
+			t.Errorf("limiter named %q after the API key", name)
+		}
+	}
+	rateLimiters.Unlock()
+
+	c = New(WithRateLimit("test/account", "1/minute", config.RateLimitScopeAccount, "secret-a", "secret-b"))
+	if c.keyLimiters != nil || c.rateLimiter == nil {
+		t.Error("keys of the account scope not sharing a single limiter")
+	}
+}
+
+// The transport of a client is configured from the connection pool of its debrid
+func TestConnectionPool(t *testing.T) {
+	setTestConfig(t)
+	tests := []struct {
+		name            string
+		options         []ClientOption
+		maxIdleConns    int
+		maxConnsPerHost int
+		idleConnTimeout time.Duration
+	}{
+		{"defaults", nil, 0, 0, 0},
+		{"pool", []ClientOption{WithConnectionPool(100, 16, 90*time.Second)}, 100, 16, 90 * time.Second},
+		{"no limit per host", []ClientOption{WithConnectionPool(10, 0, time.Minute)}, 10, 0, time.Minute},
+	}
+	for _, tt := range tests {
+		transport, ok := New(tt.options...).client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("%s: transport is not an *http.Transport", tt.name)
+		}
+		if transport.MaxIdleConns != tt.maxIdleConns || transport.MaxIdleConnsPerHost != tt.maxIdleConns {
+			t.Errorf("%s: idle conns = %d, %d per host, want %d", tt.name, transport.MaxIdleConns, transport.MaxIdleConnsPerHost, tt.maxIdleConns)
+		}
+		if transport.MaxConnsPerHost != tt.maxConnsPerHost {
+			t.Errorf("%s: MaxConnsPerHost = %d, want %d", tt.name, transport.MaxConnsPerHost, tt.maxConnsPerHost)
+		}
+		if transport.IdleConnTimeout != tt.idleConnTimeout {
+			t.Errorf("%s: IdleConnTimeout = %v, want %v", tt.name, transport.IdleConnTimeout, tt.idleConnTimeout)
+		}
+	}
+
+	// A transport set with WithTransport is kept as is
+	custom := &http.Transport{MaxIdleConns: 1}
+	c := New(WithTransport(custom), WithConnectionPool(100, 16, time.Minute))
+	if c.client.Transport != custom || custom.MaxIdleConns != 1 {
+		t.Error("WithConnectionPool changed the transport set with WithTransport")
+	}
+}
+
+func TestAuthErrors(t *testing.T) {
+	setTestConfig(t)
+	var status atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	c := New(WithMaxRetries(0))
+
+	tests := []struct {
+		status    int
+		wantErr   error // nil for none or another error
+		wantState string
+	}{
+		{http.StatusUnauthorized, ErrInvalidKey, AuthStateInvalidKey},
+		{http.StatusOK, nil, ""},
+		{http.StatusForbidden, ErrForbidden, AuthStateForbidden},
+		{http.StatusInternalServerError, nil, AuthStateForbidden}, // Only a success clears the state
+		{http.StatusOK, nil, ""},
+	}
+	for _, tt := range tests {
+		status.Store(int32(tt.status))
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.MakeRequest(req)
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%d: MakeRequest() error = %v, want %v", tt.status, err, tt.wantErr)
+		}
+		if tt.wantErr == nil && (errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrForbidden)) {
+			t.Errorf("%d: MakeRequest() error = %v, want no auth error", tt.status, err)
+		}
+		if got := c.AuthState(); got != tt.wantState {
+			t.Errorf("%d: AuthState() = %q, want %q", tt.status, got, tt.wantState)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package request
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.uber.org/ratelimit"
+)
+
+// conservativeBackoff is the initial retry backoff used while in conservative mode
+const conservativeBackoff = 5 * time.Second
+
+// SoftBanGuard watches for repeated 429 responses and puts the clients sharing it in a conservative mode,
+// with a much lower request rate and longer backoff, so a provider soft ban isn't extended.
+// A nil guard is never active.
+type SoftBanGuard struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	limiter   ratelimit.Limiter
+	logger    zerolog.Logger
+
+	mu    sync.Mutex
+	hits  []time.Time
+	until time.Time
+}
+
+// NewSoftBanGuard returns a guard entering conservative mode after threshold 429s within window, for cooldown.
+// It returns nil if threshold is not positive.
+func NewSoftBanGuard(threshold int, window, cooldown time.Duration, rateLimit string, logger zerolog.Logger) *SoftBanGuard {
+	if threshold <= 0 {
+		return nil
+	}
+	return &SoftBanGuard{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		limiter:   ParseRateLimit(rateLimit),
+		logger:    logger,
+	}
+}
+
+// record registers a rate-limited response
+func (g *SoftBanGuard) record() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	hits := g.hits[:0]
+	for _, hit := range g.hits {
+		if now.Sub(hit) < g.window {
+			hits = append(hits, hit)
+		}
+	}
+	g.hits = append(hits, now)
+
+	if len(g.hits) < g.threshold {
+		return
+	}
+	if now.Before(g.until) {
+		// Still rate limited while conservative, keep cooling down
+		g.until = now.Add(g.cooldown)
+		return
+	}
+	g.until = now.Add(g.cooldown)
+	g.hits = g.hits[:0]
+	g.logger.Warn().Msgf("Rate limited %d times within %s, entering conservative mode until %s", g.threshold, g.window, g.until.Format(time.RFC3339))
+}
+
+// Active reports whether the guard is in conservative mode
+func (g *SoftBanGuard) Active() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return time.Now().Before(g.until)
+}
+
+// wait blocks on the conservative rate limiter while the guard is active
+func (g *SoftBanGuard) wait() {
+	if g.Active() && g.limiter != nil {
+		g.limiter.Take()
+	}
+}
@@ -0,0 +1,80 @@
+package request
+
+import (
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Repeated 429s within the window put the client in conservative mode until the cooldown passes
+func TestSoftBanGuard(t *testing.T) {
+	setTestConfig(t)
+	tests := []struct {
+		name      string
+		threshold int
+		window    time.Duration
+		cooldown  time.Duration
+		status    int
+		requests  int
+		gap       time.Duration // Between two requests
+		wait      time.Duration // After the requests
+		want      bool
+	}{
+		{"repeated 429s", 3, time.Minute, time.Minute, http.StatusTooManyRequests, 3, 0, 0, true},
+		{"below the threshold", 3, time.Minute, time.Minute, http.StatusTooManyRequests, 2, 0, 0, false},
+		{"spread beyond the window", 3, 20 * time.Millisecond, time.Minute, http.StatusTooManyRequests, 3, 30 * time.Millisecond, 0, false},
+		{"other errors", 3, time.Minute, time.Minute, http.StatusServiceUnavailable, 3, 0, 0, false},
+		{"cooldown passed", 3, time.Minute, 20 * time.Millisecond, http.StatusTooManyRequests, 3, 0, 40 * time.Millisecond, false},
+		{"disabled", 0, time.Minute, time.Minute, http.StatusTooManyRequests, 3, 0, 0, false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		guard := NewSoftBanGuard(tt.threshold, tt.window, tt.cooldown, "", zerolog.Nop())
+		c := New(WithSoftBanGuard(guard), WithMaxRetries(0))
+		for i := range tt.requests {
+			if i > 0 {
+				time.Sleep(tt.gap)
+			}
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			_ = resp.Body.Close()
+		}
+		srv.Close()
+		time.Sleep(tt.wait)
+		if got := c.InConservativeMode(); got != tt.want {
+			t.Errorf("%s: InConservativeMode() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// In conservative mode the requests are sent at the soft_ban_rate_limit
+func TestSoftBanRateLimit(t *testing.T) {
+	setTestConfig(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	guard := NewSoftBanGuard(1, time.Minute, time.Minute, "4/second", zerolog.Nop())
+	c := New(WithSoftBanGuard(guard), WithMaxRetries(0))
+	start := time.Now()
+	for range 3 {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	// The first request enters the mode, the next two are 250ms apart
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("3 requests took %s in conservative mode, want them slowed down to 4/second", elapsed)
+	}
+}
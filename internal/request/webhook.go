@@ -0,0 +1,74 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	gourl "net/url"
+)
+
+// telegramMessageLimit is the longest message Telegram accepts, in characters
+const telegramMessageLimit = 4096
+
+// webhookNotifier POSTs notifications as JSON to any URL
+type webhookNotifier struct {
+	url string
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	if err := postJSON(ctx, w.url, n); err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	return nil
+}
+
+// telegramNotifier sends notifications to a Telegram chat through a bot
+type telegramNotifier struct {
+	botToken string
+	chatID   string
+}
+
+func (t *telegramNotifier) Notify(ctx context.Context, n Notification) error {
+	payload := map[string]string{
+		"chat_id": t.chatID,
+		"text":    truncateDiscord(n.Title+"\n\n"+n.Message, telegramMessageLimit),
+	}
+	if err := postJSON(ctx, "https://api.telegram.org/bot"+t.botToken+"/sendMessage", payload); err != nil {
+		// The URL holds the bot token, keep it out of the logs
+		var urlErr *gourl.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("failed to send telegram message: %w", urlErr.Err)
+		}
+		return fmt.Errorf("failed to send telegram message: %w", err)
+	}
+	return nil
+}
+
+// postJSON POSTs payload as JSON to url, failing on a non-2xx status
+func postJSON(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error status code: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package utils
+
+import "errors"
+
+// FreeSpace is not supported on Windows, callers skip their free space checks
+func FreeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
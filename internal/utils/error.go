@@ -1,17 +1,44 @@
 package utils
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/logger"
+)
 
 type HTTPError struct {
-	StatusCode int
-	Message    string
-	Code       string
+	StatusCode    int
+	Message       string
+	Code          string
+	CorrelationID string // Of the request that failed, empty if unknown
 }
 
 func (e *HTTPError) Error() string {
+	if e.CorrelationID != "" {
+		return e.Message + " (correlation ID " + e.CorrelationID + ")"
+	}
 	return e.Message
 }
 
+// Is matches an HTTPError with the same code, so the copies made by WithCorrelationID still match the errors below
+func (e *HTTPError) Is(target error) bool {
+	t, ok := target.(*HTTPError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// WithCorrelationID returns a copy of err with the correlation ID carried by ctx if err is an *HTTPError, err itself
+// otherwise
+func WithCorrelationID(ctx context.Context, err error) error {
+	httpErr, ok := err.(*HTTPError)
+	id := logger.CorrelationID(ctx)
+	if !ok || id == "" {
+		return err
+	}
+	withID := *httpErr
+	withID.CorrelationID = id
+	return &withID
+}
+
 var HosterUnavailableError = &HTTPError{
 	StatusCode: 503,
 	Message:    "Hoster is unavailable",
@@ -43,5 +70,5 @@ var TooManyActiveDownloadsError = &HTTPError{
 }
 
 func IsTooManyActiveDownloadsError(err error) bool {
-	return errors.As(err, &TooManyActiveDownloadsError)
+	return errors.Is(err, TooManyActiveDownloadsError)
 }
@@ -189,6 +189,12 @@ func ExtractInfoHash(magnetDesc string) string {
 	return hash
 }
 
+// NormalizeInfoHash returns hash as lowercase hex, converting it from base32 if needed, or an error if it isn't a
+// valid infohash
+func NormalizeInfoHash(hash string) (string, error) {
+	return processInfoHash(strings.TrimSpace(hash))
+}
+
 func processInfoHash(input string) (string, error) {
 	// Regular expression for a valid 40-character hex infohash
 
@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"cmp"
+	"strings"
+)
+
 func RemoveItem[S ~[]E, E comparable](s S, values ...E) S {
 	result := make(S, 0, len(s))
 outer:
@@ -22,3 +27,47 @@ func Contains(slice []string, value string) bool {
 	}
 	return false
 }
+
+// NaturalCompare compares two strings the way a human would, number runs are compared by value
+// so "Ep2" sorts before "Ep10". Letters are compared case-insensitively.
+func NaturalCompare(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) && isDigit(cb) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			// Compare by value: longer numbers(without leading zeros) are bigger
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return cmp.Compare(len(ta), len(tb))
+			}
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			// Same value, fewer leading zeros first
+			if len(na) != len(nb) {
+				return cmp.Compare(len(na), len(nb))
+			}
+			a, b = ra, rb
+			continue
+		}
+		if ca != cb {
+			return cmp.Compare(ca, cb)
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	names := []string{"Ep10.mkv", "Ep2.mkv", "ep1.mkv", "Ep1.srt", "Ep01.mkv", "Ep100.mkv", "Ep9.mkv", "Extras"}
+	slices.SortStableFunc(names, NaturalCompare)
+	want := []string{"ep1.mkv", "Ep1.srt", "Ep01.mkv", "Ep2.mkv", "Ep9.mkv", "Ep10.mkv", "Ep100.mkv", "Extras"}
+	if !slices.Equal(names, want) {
+		t.Errorf("sorted = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"Show.S01E02", "Show.S01E10", -1},
+		{"Show.S02E01", "Show.S01E10", 1},
+		{"Ep007", "Ep7", 1},
+		{"EP3", "ep3", 0},
+		{"Ep3", "Ep3 Part 2", -1},
+		{"99999999999999999999", "100000000000000000000", -1},
+	}
+	for _, tt := range tests {
+		if got := NaturalCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("NaturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -7,14 +7,19 @@ import (
 )
 
 var (
-	videoMatch  = "(?i)(\\.)(webm|m4v|3gp|nsv|ty|strm|rm|rmvb|m3u|ifo|mov|qt|divx|xvid|bivx|nrg|pva|wmv|asf|asx|ogm|ogv|m2v|avi|bin|dat|dvr-ms|mpg|mpeg|mp4|avc|vp3|svq3|nuv|viv|dv|fli|flv|wpl|img|iso|vob|mkv|mk3d|ts|wtv|m2ts)$"
-	musicMatch  = "(?i)(\\.)(mp2|mp3|m4a|m4b|m4p|ogg|oga|opus|wma|wav|wv|flac|ape|aif|aiff|aifc)$"
-	sampleMatch = `(?i)(^|[\s/\\])(sample|trailer|thumb|special|extras?)s?[-/]|(\((sample|trailer|thumb|special|extras?)s?\))|(-\s*(sample|trailer|thumb|special|extras?)s?)`
+	videoMatch    = "(?i)(\\.)(webm|m4v|3gp|nsv|ty|strm|rm|rmvb|m3u|ifo|mov|qt|divx|xvid|bivx|nrg|pva|wmv|asf|asx|ogm|ogv|m2v|avi|bin|dat|dvr-ms|mpg|mpeg|mp4|avc|vp3|svq3|nuv|viv|dv|fli|flv|wpl|img|iso|vob|mkv|mk3d|ts|wtv|m2ts)$"
+	musicMatch    = "(?i)(\\.)(mp2|mp3|m4a|m4b|m4p|ogg|oga|opus|wma|wav|wv|flac|ape|aif|aiff|aifc)$"
+	subtitleMatch = "(?i)(\\.)(srt|sub|idx|ass|ssa|vtt|sup|smi)$"
+	rarMatch      = "(?i)(\\.)(rar|r\\d{2,3})$"
+	sampleMatch   = `(?i)(^|[\s/\\])(sample|trailer|thumb|special|extras?)s?[-/]|(\((sample|trailer|thumb|special|extras?)s?\))|(-\s*(sample|trailer|thumb|special|extras?)s?)`
 )
 
 var (
-	mediaRegex  = regexp.MustCompile(videoMatch + "|" + musicMatch)
-	sampleRegex = regexp.MustCompile(sampleMatch)
+	mediaRegex    = regexp.MustCompile(videoMatch + "|" + musicMatch)
+	videoRegex    = regexp.MustCompile(videoMatch)
+	subtitleRegex = regexp.MustCompile(subtitleMatch)
+	sampleRegex   = regexp.MustCompile(sampleMatch)
+	rarRegex      = regexp.MustCompile(rarMatch)
 )
 
 func RegexMatch(re *regexp.Regexp, value string) bool {
@@ -39,20 +44,42 @@ func RemoveInvalidChars(value string) string {
 	}, value)
 }
 
+// RemoveExtension strips the final extension of value if it is a known media extension.
+// Dots elsewhere in the name are left intact(e.g "Movie.2021.1080p.mkv" -> "Movie.2021.1080p"),
+// names without a known media extension are returned unchanged,
+// and a name that is only an extension(e.g ".mkv") is kept as is.
 func RemoveExtension(value string) string {
-	if loc := mediaRegex.FindStringIndex(value); loc != nil {
-		return value[:loc[0]]
+	ext := filepath.Ext(value)
+	if ext == "" || !mediaRegex.MatchString(ext) {
+		return value
 	}
-	return value
+	stripped := strings.TrimSuffix(value, ext)
+	if strings.TrimSpace(stripped) == "" {
+		return value
+	}
+	return stripped
 }
 
 func IsMediaFile(path string) bool {
 	return RegexMatch(mediaRegex, path)
 }
 
+func IsVideoFile(path string) bool {
+	return RegexMatch(videoRegex, path)
+}
+
+func IsSubtitleFile(path string) bool {
+	return RegexMatch(subtitleRegex, path)
+}
+
 func IsSampleFile(path string) bool {
 	if strings.HasSuffix(strings.ToLower(path), "sample.mkv") {
 		return true
 	}
 	return RegexMatch(sampleRegex, path)
 }
+
+// IsRarFile reports whether path is a RAR archive or one of its volumes(.r00, .r01...)
+func IsRarFile(path string) bool {
+	return RegexMatch(rarRegex, path)
+}
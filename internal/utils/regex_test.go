@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestRemoveExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Movie.2021.1080p.mkv", "Movie.2021.1080p"},
+		{"Movie.2021.1080p.MKV", "Movie.2021.1080p"},
+		{"Album.2020.FLAC.flac", "Album.2020.FLAC"},
+		{"Show.S01E01.mp4.part", "Show.S01E01.mp4.part"},
+		{"Movie.2021.1080p", "Movie.2021.1080p"},
+		{"Movie.2021.1080p.nfo", "Movie.2021.1080p.nfo"},
+		{"Movie.mkv.Extras", "Movie.mkv.Extras"},
+		{"NoExtension", "NoExtension"},
+		{".mkv", ".mkv"},
+		{"Movie.", "Movie."},
+	}
+	for _, tt := range tests {
+		if got := RemoveExtension(tt.name); got != tt.want {
+			t.Errorf("RemoveExtension(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryOptions configures RetryHTTP
+type RetryOptions struct {
+	MaxAttempts int           // Attempts, the first one included, at least 1
+	BaseDelay   time.Duration // Delay before the first retry, doubled on each retry, plus up to 25% of jitter
+}
+
+// RetryHTTP calls fn until it succeeds or fails with an error that is not transient, for up to opts.MaxAttempts.
+// Hoster unavailable, traffic exceeded and rate limited(429) HTTPErrors are transient, a broken link or a torrent
+// not found is not. Once the attempts are used or ctx is done, the last error is returned wrapped, errors.As still
+// finds the HTTPError in it.
+func RetryHTTP(ctx context.Context, fn func(ctx context.Context) error, opts RetryOptions) error {
+	attempts := max(opts.MaxAttempts, 1)
+	delay := opts.BaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil || !isTransientHTTPError(err) {
+			return err
+		}
+		if attempt == attempts {
+			if attempts == 1 {
+				return err
+			}
+			return fmt.Errorf("failed after %d attempts: %w", attempts, err)
+		}
+
+		wait := delay
+		if delay > 0 {
+			wait += time.Duration(rand.Int63n(int64(delay/4) + 1))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %d attempts: %w", ctx.Err(), attempt, err)
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientHTTPError reports whether err is an HTTPError worth retrying
+func isTransientHTTPError(err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.Code {
+	case HosterUnavailableError.Code, TrafficExceededError.Code:
+		return true
+	}
+	return httpErr.StatusCode == http.StatusTooManyRequests
+}
@@ -20,14 +20,39 @@ func main() {
 		}
 	}()
 	var configPath string
+	var lenientConfig bool
+	var configTemplate string
+	var noAuth bool
+	var oauthLogin string
 	flag.StringVar(&configPath, "config", "/data", "path to the data folder")
+	flag.BoolVar(&lenientConfig, "lenient-config", false, "load what can be loaded of a malformed config instead of exiting")
+	flag.StringVar(&configTemplate, "config-template", os.Getenv("DECYPHARR_CONFIG_TEMPLATE"), "config file used as the initial config when none exists")
+	flag.BoolVar(&noAuth, "no-auth", os.Getenv("DECYPHARR_NO_AUTH") == "true", "create the initial config without authentication")
+	flag.StringVar(&oauthLogin, "oauth-login", "", "log the named debrid in with the OAuth device flow, then exit")
 	flag.Parse()
 	config.SetConfigPath(configPath)
+	config.SetLenient(lenientConfig)
+	config.SetInitialConfig(configTemplate, noAuth)
 	config.Get()
 
 	// Create a context canceled on SIGINT/SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	go func() {
+		// A second signal while draining exits right away
+		<-ctx.Done()
+		stop()
+	}()
+
+	if oauthLogin != "" {
+		if err := decypharr.OAuthLogin(ctx, oauthLogin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Reload the config on SIGHUP
+	config.Watch(ctx)
 
 	if err := decypharr.Start(ctx); err != nil {
 		log.Fatal(err)
@@ -2,8 +2,8 @@ package arr
 
 import (
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
@@ -14,17 +14,26 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Type is a type of arr
 type Type string
 
-var sharedClient = &http.Client{
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	},
-	Timeout: 60 * time.Second,
+// arrFailureThreshold is the number of consecutive failed calls after which an arr is reported as failing
+const arrFailureThreshold = 3
+
+// newClient returns an HTTP client for arr calls with the current timeout and retry settings. Only network errors and
+// the statuses below are retried, auth errors(401/403) are answered at once
+func newClient() *request.Client {
+	cfg := config.Get()
+	return request.New(
+		request.WithTimeout(cfg.GetArrTimeout()),
+		request.WithMaxRetries(max(cfg.ArrMaxRetries, 0)),
+		request.WithRetryableStatus(http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+		request.WithLogger(logger.New("arr")),
+	)
 }
 
 const (
@@ -34,6 +43,12 @@ const (
 	Readarr Type = "readarr"
 )
 
+// Sources of an arr
+const (
+	SourceAuto   = "auto"   // Detected from the qBittorrent credentials sent by the arr
+	SourceConfig = "config" // Configured in the config file or the UI
+)
+
 type Arr struct {
 	Name             string `json:"name"`
 	Host             string `json:"host"`
@@ -44,6 +59,13 @@ type Arr struct {
 	DownloadUncached *bool  `json:"download_uncached"`
 	SelectedDebrid   string `json:"selected_debrid,omitempty"` // The debrid service selected for this arr
 	Source           string `json:"source,omitempty"`          // The source of the arr, e.g. "auto", "manual". Auto means it was automatically detected from the arr
+
+	failures  atomic.Int32 // Consecutive failed calls
+	lastError atomic.Value // error string of the last failed call
+
+	searches searchBatch // Re-searches waiting for the arr_search_batch_window
+
+	client atomic.Pointer[request.Client] // Created on the first call, arrs are recreated when the config changes
 }
 
 func New(name, host, token string, cleanup, skipRepair bool, downloadUncached *bool, selectedDebrid, source string) *Arr {
@@ -60,6 +82,15 @@ func New(name, host, token string, cleanup, skipRepair bool, downloadUncached *b
 	}
 }
 
+// getClient returns the HTTP client of the arr
+func (a *Arr) getClient() *request.Client {
+	if client := a.client.Load(); client != nil {
+		return client
+	}
+	a.client.CompareAndSwap(nil, newClient())
+	return a.client.Load()
+}
+
 func (a *Arr) Request(method, endpoint string, payload interface{}) (*http.Response, error) {
 	if a.Token == "" || a.Host == "" {
 		return nil, fmt.Errorf("arr not configured")
@@ -84,27 +115,31 @@ func (a *Arr) Request(method, endpoint string, payload interface{}) (*http.Respo
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", a.Token)
 
-	var resp *http.Response
-
-	for attempts := 0; attempts < 5; attempts++ {
-		resp, err = sharedClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
+	resp, err := a.getClient().Do(req)
+	switch {
+	case err != nil:
+		a.recordFailure(err.Error())
+		return nil, err
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode >= 500:
+		a.recordFailure(resp.Status)
+	default:
+		a.failures.Store(0)
+	}
+	return resp, nil
+}
 
-		// If we got a 401, wait briefly and retry
-		if resp.StatusCode == http.StatusUnauthorized {
-			resp.Body.Close() // Don't leak response bodies
-			if attempts < 4 { // Don't sleep on the last attempt
-				time.Sleep(time.Duration(attempts+1) * 100 * time.Millisecond)
-				continue
-			}
-		}
+func (a *Arr) recordFailure(reason string) {
+	a.lastError.Store(reason)
+	a.failures.Add(1)
+}
 
-		return resp, nil
+// Failing reports whether the last calls to the arr kept failing, and the last error
+func (a *Arr) Failing() (bool, string) {
+	if a.failures.Load() < arrFailureThreshold {
+		return false, ""
 	}
-
-	return resp, err
+	reason, _ := a.lastError.Load().(string)
+	return true, reason
 }
 
 func (a *Arr) Validate() error {
@@ -124,9 +159,10 @@ func (a *Arr) Validate() error {
 }
 
 type Storage struct {
-	Arrs   map[string]*Arr // name -> arr
-	mu     sync.Mutex
-	logger zerolog.Logger
+	Arrs    map[string]*Arr // name -> arr
+	mu      sync.Mutex
+	logger  zerolog.Logger
+	rescans *rescanQueue
 }
 
 func (s *Storage) Cleanup() {
@@ -160,8 +196,9 @@ func NewStorage() *Storage {
 		arrs[name] = New(name, a.Host, a.Token, a.Cleanup, a.SkipRepair, a.DownloadUncached, a.SelectedDebrid, a.Source)
 	}
 	return &Storage{
-		Arrs:   arrs,
-		logger: logger.New("arr"),
+		Arrs:    arrs,
+		logger:  logger.New("arr"),
+		rescans: newRescanQueue(rescansFile()),
 	}
 }
 
@@ -174,6 +211,58 @@ func (s *Storage) AddOrUpdate(arr *Arr) {
 	s.Arrs[arr.Name] = arr
 }
 
+// Detect returns the arr of a category, with the host and token the arr sent as qBittorrent credentials.
+// Configured fields win and detected ones only fill the gaps, unless ArrSourcePreference is auto.
+// A new category pointing to the host of a configured arr inherits its token and settings.
+// The returned arr isn't stored, see AddOrUpdate.
+func (s *Storage) Detect(category, host, token string) *Arr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	preferAuto := config.Get().ArrSourcePreference == config.ArrSourceAuto
+
+	a := s.Arrs[category]
+	if a == nil {
+		downloadUncached := false
+		a = New(category, host, token, false, false, &downloadUncached, "", SourceAuto)
+		if configured := s.findConfiguredByHost(host); configured != nil {
+			if !preferAuto || a.Token == "" {
+				a.Token = configured.Token
+			}
+			a.Cleanup = configured.Cleanup
+			a.SkipRepair = configured.SkipRepair
+			a.DownloadUncached = configured.DownloadUncached
+			a.SelectedDebrid = configured.SelectedDebrid
+		}
+		return a
+	}
+
+	if a.Source == SourceAuto || preferAuto {
+		a.Host = cmp.Or(host, a.Host)
+		a.Token = cmp.Or(token, a.Token)
+	} else {
+		a.Host = cmp.Or(a.Host, host)
+		a.Token = cmp.Or(a.Token, token)
+	}
+	if a.Type == "" {
+		a.Type = InferType(a.Host, a.Name)
+	}
+	return a
+}
+
+// findConfiguredByHost returns the configured arr using host, s.mu must be held
+func (s *Storage) findConfiguredByHost(host string) *Arr {
+	if host == "" {
+		return nil
+	}
+	host = strings.TrimRight(strings.ToLower(host), "/")
+	for _, a := range s.Arrs {
+		if a.Source != SourceAuto && strings.TrimRight(strings.ToLower(a.Host), "/") == host {
+			return a
+		}
+	}
+	return nil
+}
+
 func (s *Storage) Get(name string) *Arr {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -191,6 +280,7 @@ func (s *Storage) GetAll() []*Arr {
 }
 
 func (s *Storage) StartSchedule(ctx context.Context) error {
+	go s.retryRescans(ctx)
 
 	ticker := time.NewTicker(10 * time.Second)
 
@@ -221,12 +311,21 @@ func (s *Storage) cleanupArrsQueue() {
 	}
 }
 
-func (a *Arr) Refresh() {
+// Refresh asks the arr to check its downloads, see Storage.Rescan to retry it on failure
+func (a *Arr) Refresh() error {
 	payload := struct {
 		Name string `json:"name"`
 	}{
 		Name: "RefreshMonitoredDownloads",
 	}
 
-	_, _ = a.Request(http.MethodPost, "api/v3/command", payload)
+	resp, err := a.Request(http.MethodPost, "api/v3/command", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("rescan failed: %s", resp.Status)
+	}
+	return nil
 }
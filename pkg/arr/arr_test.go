@@ -0,0 +1,117 @@
+package arr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Transient errors of a flaky arr are retried, auth errors aren't, and an arr failing every call is reported
+func TestRequestFlakyArr(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRetries  int
+		statuses    []int
+		requests    int
+		wantStatus  int
+		wantCalls   int32
+		wantFailing bool
+	}{
+		{"transient error retried", 1, []int{http.StatusServiceUnavailable, http.StatusOK}, 1, http.StatusOK, 2, false},
+		{"unauthorized not retried", 3, []int{http.StatusUnauthorized}, 1, http.StatusUnauthorized, 1, false},
+		{"forbidden not retried", 3, []int{http.StatusForbidden}, 1, http.StatusForbidden, 1, false},
+		{"failing", -1, []int{http.StatusInternalServerError}, arrFailureThreshold, http.StatusInternalServerError, arrFailureThreshold, true},
+		{"recovered", -1, []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}, arrFailureThreshold, http.StatusOK, arrFailureThreshold, false},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, map[string]any{"arr_max_retries": tt.maxRetries})
+		a, calls := newTestArr(t, tt.statuses...)
+		var status int
+		for range tt.requests {
+			resp, err := a.Request(http.MethodGet, "api/v3/system/status", nil)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			_ = resp.Body.Close()
+			status = resp.StatusCode
+		}
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.wantStatus)
+		}
+		if got := calls.Load(); got != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, got, tt.wantCalls)
+		}
+		if failing, reason := a.Failing(); failing != tt.wantFailing {
+			t.Errorf("%s: Failing() = %v (%s), want %v", tt.name, failing, reason, tt.wantFailing)
+		}
+	}
+}
+
+// A slow arr doesn't hang the call past the arr_timeout
+func TestRequestTimeout(t *testing.T) {
+	setTestConfig(t, map[string]any{"arr_timeout": "50ms", "arr_max_retries": -1})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	a := New("sonarr", srv.URL, "token", false, false, nil, "", "")
+	start := time.Now()
+	if _, err := a.Request(http.MethodGet, "api/v3/system/status", nil); err == nil {
+		t.Fatal("Request() to a slow arr succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Request() took %s, want it cut at the arr_timeout", elapsed)
+	}
+}
+
+// An auto-detected arr pointing at the host of a configured one is merged with it, following arr_source_preference
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name        string
+		preference  string
+		noToken     bool // The configured arr has no token
+		category    string
+		host        string
+		token       string
+		wantHost    string
+		wantToken   string
+		wantSource  string
+		wantInherit bool // Settings inherited from the configured arr
+	}{
+		{"configured wins", "config", false, "sonarr", "http://sonarr:8989", "detected", "http://sonarr:8989", "configured", SourceConfig, true},
+		{"detected wins", "auto", false, "sonarr", "http://sonarr:8989", "detected", "http://sonarr:8989", "detected", SourceConfig, true},
+		{"detected fills a gap", "config", true, "sonarr", "http://sonarr:8989", "detected", "http://sonarr:8989", "detected", SourceConfig, true},
+		{"new category on a configured host", "config", false, "sonarr4k", "HTTP://Sonarr:8989/", "detected", "HTTP://Sonarr:8989/", "configured", SourceAuto, true},
+		{"new category on a configured host, detected wins", "auto", false, "sonarr4k", "http://sonarr:8989", "detected", "http://sonarr:8989", "detected", SourceAuto, true},
+		{"auto arr updated", "config", false, "radarr", "http://radarr:7878", "detected", "http://radarr:7878", "detected", SourceAuto, false},
+		{"unknown host", "config", false, "lidarr", "http://lidarr:8686", "detected", "http://lidarr:8686", "detected", SourceAuto, false},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, map[string]any{"arr_source_preference": tt.preference})
+		configured := New("sonarr", "http://sonarr:8989", "configured", true, false, nil, "torbox", SourceConfig)
+		if tt.noToken {
+			configured.Token = ""
+		}
+		s := &Storage{Arrs: map[string]*Arr{
+			"sonarr": configured,
+			"radarr": New("radarr", "http://old:7878", "old", false, false, nil, "", SourceAuto),
+		}}
+
+		a := s.Detect(tt.category, tt.host, tt.token)
+		if a.Name != tt.category || a.Host != tt.wantHost || a.Token != tt.wantToken || a.Source != tt.wantSource {
+			t.Errorf("%s: Detect() = %s at %s with token %s from %s, want %s at %s with token %s from %s",
+				tt.name, a.Name, a.Host, a.Token, a.Source, tt.category, tt.wantHost, tt.wantToken, tt.wantSource)
+		}
+		if inherited := a.Cleanup && a.SelectedDebrid == "torbox"; inherited != tt.wantInherit {
+			t.Errorf("%s: settings inherited = %v, want %v", tt.name, inherited, tt.wantInherit)
+		}
+		if len(s.Arrs) != 2 {
+			t.Errorf("%s: %d arrs stored after Detect(), want the 2 configured", tt.name, len(s.Arrs))
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"golang.org/x/sync/errgroup"
 	"net/http"
 	"strconv"
@@ -233,7 +234,11 @@ func (a *Arr) searchRadarr(files []ContentFile) error {
 	return nil
 }
 
-func (a *Arr) SearchMissing(files []ContentFile) error {
+// search asks the arr to search again for the files, or for everything missing with the missing arr_search_mode
+func (a *Arr) search(files []ContentFile) error {
+	if config.Get().ArrSearchMode == config.ArrSearchMissing {
+		return a.searchAllMissing()
+	}
 	switch a.Type {
 	case Sonarr:
 		return a.searchSonarr(files)
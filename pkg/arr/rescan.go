@@ -0,0 +1,171 @@
+package arr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	rescanRetryInterval = 15 * time.Second // How often the queue is checked for due rescans
+	rescanBaseBackoff   = 30 * time.Second
+	rescanMaxBackoff    = 30 * time.Minute
+)
+
+// rescanEntry is a rescan that failed, one per arr as a rescan picks up all the completed downloads
+type rescanEntry struct {
+	Attempts    int       `json:"attempts"`
+	FirstFailed time.Time `json:"first_failed"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+}
+
+// rescanQueue keeps the failed rescans, persisted to arr_rescans.json so they survive restarts
+type rescanQueue struct {
+	mu       sync.Mutex
+	entries  map[string]*rescanEntry // arr name -> entry
+	filename string
+}
+
+func newRescanQueue(filename string) *rescanQueue {
+	q := &rescanQueue{
+		entries:  make(map[string]*rescanEntry),
+		filename: filename,
+	}
+	if data, err := os.ReadFile(filename); err == nil {
+		_ = json.Unmarshal(data, &q.entries)
+	}
+	return q
+}
+
+// add records a failed rescan of the arr and schedules its next attempt
+func (q *rescanQueue) add(name string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	e, ok := q.entries[name]
+	if !ok {
+		e = &rescanEntry{FirstFailed: now}
+		q.entries[name] = e
+	}
+	e.Attempts++
+	e.LastError = err.Error()
+	e.NextAttempt = now.Add(rescanBackoff(e.Attempts))
+	q.save()
+}
+
+func (q *rescanQueue) remove(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[name]; ok {
+		delete(q.entries, name)
+		q.save()
+	}
+}
+
+// due returns the arrs whose next attempt has come
+func (q *rescanQueue) due() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	names := make([]string, 0)
+	for name, e := range q.entries {
+		if !now.Before(e.NextAttempt) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (q *rescanQueue) attempts(name string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.entries[name]; ok {
+		return e.Attempts
+	}
+	return 0
+}
+
+// save persists the queue, q.mu must be held
+func (q *rescanQueue) save() {
+	data, err := json.Marshal(q.entries)
+	if err != nil {
+		return
+	}
+	_ = config.Get().WriteFile(q.filename, data)
+}
+
+func rescanBackoff(attempts int) time.Duration {
+	backoff := rescanBaseBackoff
+	for i := 1; i < attempts && backoff < rescanMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, rescanMaxBackoff)
+}
+
+// Rescan asks the arr to pick up completed downloads. A failed rescan is retried with a backoff until the arr is back,
+// up to arr_rescan_retries attempts.
+func (s *Storage) Rescan(a *Arr) {
+	if a == nil || a.Host == "" || a.Token == "" {
+		return
+	}
+	err := a.Refresh()
+	if err == nil {
+		s.rescans.remove(a.Name)
+		return
+	}
+	if config.Get().ArrRescanRetries < 0 {
+		s.logger.Error().Err(err).Msgf("Failed to rescan %s", a.Name)
+		return
+	}
+	s.logger.Warn().Err(err).Msgf("Failed to rescan %s, retrying later", a.Name)
+	s.rescans.add(a.Name, err)
+}
+
+// retryRescans retries the due rescans until ctx is done
+func (s *Storage) retryRescans(ctx context.Context) {
+	ticker := time.NewTicker(rescanRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, name := range s.rescans.due() {
+			s.retryRescan(name)
+		}
+	}
+}
+
+func (s *Storage) retryRescan(name string) {
+	a := s.Get(name)
+	err := fmt.Errorf("arr %s not found", name)
+	if a != nil {
+		if err = a.Refresh(); err == nil {
+			s.logger.Info().Msgf("Rescan of %s succeeded after %d failed attempts", name, s.rescans.attempts(name))
+			s.rescans.remove(name)
+			return
+		}
+	}
+	s.rescans.add(name, err)
+	attempts := s.rescans.attempts(name)
+	if attempts < config.Get().ArrRescanRetries {
+		s.logger.Debug().Err(err).Msgf("Rescan of %s failed, attempt %d", name, attempts)
+		return
+	}
+	s.rescans.remove(name)
+	s.logger.Error().Err(err).Msgf("Giving up rescanning %s after %d attempts", name, attempts)
+	msg := fmt.Sprintf("Rescan of %s failed %d times, completed downloads may need a manual import: %v", name, attempts, err)
+	request.Notify("arr_rescan_failed", "error", msg)
+}
+
+func rescansFile() string {
+	return filepath.Join(config.Get().Path, "arr_rescans.json")
+}
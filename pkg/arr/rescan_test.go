@@ -0,0 +1,119 @@
+package arr
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setTestConfig loads a config from a temp directory, with the fields of cfg
+func setTestConfig(t *testing.T, cfg map[string]any) string {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	return dir
+}
+
+// newTestArr returns an arr served by a test server answering the rescans with the statuses given, in turn, the
+// last one repeated
+func newTestArr(t *testing.T, statuses ...int) (*Arr, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1)) - 1
+		w.WriteHeader(statuses[min(call, len(statuses)-1)])
+	}))
+	t.Cleanup(srv.Close)
+	return New("sonarr", srv.URL, "token", false, false, nil, "", ""), &calls
+}
+
+func TestRescanBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  30 * time.Minute,
+		50: 30 * time.Minute,
+	}
+	for attempts, want := range tests {
+		if got := rescanBackoff(attempts); got != want {
+			t.Errorf("rescanBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRescanRetried(t *testing.T) {
+	dir := setTestConfig(t, map[string]any{"arr_max_retries": -1, "arr_rescan_retries": 3})
+	a, calls := newTestArr(t, http.StatusInternalServerError, http.StatusCreated)
+	filename := filepath.Join(dir, "arr_rescans.json")
+	s := &Storage{Arrs: map[string]*Arr{a.Name: a}, logger: zerolog.Nop(), rescans: newRescanQueue(filename)}
+
+	s.Rescan(a)
+	if got := s.rescans.attempts(a.Name); got != 1 {
+		t.Fatalf("attempts after a failed rescan = %d, want 1", got)
+	}
+	if due := s.rescans.due(); len(due) != 0 {
+		t.Errorf("rescan due before its backoff: %v", due)
+	}
+
+	// The failed rescan survives a restart
+	s.rescans = newRescanQueue(filename)
+	if got := s.rescans.attempts(a.Name); got != 1 {
+		t.Fatalf("attempts after a restart = %d, want 1", got)
+	}
+	s.rescans.entries[a.Name].NextAttempt = time.Now()
+	if due := s.rescans.due(); len(due) != 1 || due[0] != a.Name {
+		t.Fatalf("due = %v, want [%s]", due, a.Name)
+	}
+	s.retryRescan(a.Name)
+	if got := s.rescans.attempts(a.Name); got != 0 {
+		t.Errorf("attempts after a successful retry = %d, want 0", got)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("rescans sent = %d, want 2", got)
+	}
+	if got := newRescanQueue(filename).attempts(a.Name); got != 0 {
+		t.Errorf("persisted attempts after a successful retry = %d, want 0", got)
+	}
+}
+
+func TestRescanGivesUp(t *testing.T) {
+	dir := setTestConfig(t, map[string]any{"arr_max_retries": -1, "arr_rescan_retries": 2})
+	a, calls := newTestArr(t, http.StatusInternalServerError)
+	s := &Storage{Arrs: map[string]*Arr{a.Name: a}, logger: zerolog.Nop(), rescans: newRescanQueue(filepath.Join(dir, "arr_rescans.json"))}
+
+	s.Rescan(a)
+	s.retryRescan(a.Name)
+	if got := s.rescans.attempts(a.Name); got != 0 {
+		t.Errorf("rescan still queued after arr_rescan_retries attempts: %d", got)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("rescans sent = %d, want 2", got)
+	}
+}
+
+func TestRescanRetriesDisabled(t *testing.T) {
+	dir := setTestConfig(t, map[string]any{"arr_max_retries": -1, "arr_rescan_retries": -1})
+	a, _ := newTestArr(t, http.StatusInternalServerError)
+	s := &Storage{Arrs: map[string]*Arr{a.Name: a}, logger: zerolog.Nop(), rescans: newRescanQueue(filepath.Join(dir, "arr_rescans.json"))}
+
+	s.Rescan(a)
+	if got := s.rescans.attempts(a.Name); got != 0 {
+		t.Errorf("failed rescan queued with the retries disabled: %d attempts", got)
+	}
+}
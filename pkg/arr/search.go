@@ -0,0 +1,83 @@
+package arr
+
+import (
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// searchBatch collects the re-searches of an arr within the arr_search_batch_window, triggered together once it ends
+type searchBatch struct {
+	mu      sync.Mutex
+	files   []ContentFile
+	pending bool // A flush is scheduled
+}
+
+// add queues the files, scheduling flush at the end of the window if it is the first of the batch
+func (b *searchBatch) add(files []ContentFile, window time.Duration, flush func(files []ContentFile)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files = append(b.files, files...)
+	if b.pending {
+		return
+	}
+	b.pending = true
+	time.AfterFunc(window, func() {
+		flush(b.take())
+	})
+}
+
+// take empties the batch, the next add starts a new one
+func (b *searchBatch) take() []ContentFile {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files := b.files
+	b.files, b.pending = nil, false
+	return files
+}
+
+// SearchMissing asks the arr to search again for the files. With an arr_search_batch_window, the files are queued
+// and searched with the others queued within the window, at most once per window; errors are then only logged.
+func (a *Arr) SearchMissing(files []ContentFile) error {
+	window := config.Get().GetArrSearchBatchWindow()
+	if window <= 0 {
+		return a.search(files)
+	}
+	a.searches.add(files, window, func(files []ContentFile) {
+		_logger := logger.Default()
+		if err := a.search(files); err != nil {
+			_logger.Error().Err(err).Msgf("Failed to search %d files in %s", len(files), a.Name)
+			return
+		}
+		_logger.Info().Msgf("Searched %d files in %s", len(files), a.Name)
+	})
+	return nil
+}
+
+// searchAllMissing triggers a single search for everything missing in the arr
+func (a *Arr) searchAllMissing() error {
+	var command string
+	switch a.Type {
+	case Sonarr:
+		command = "MissingEpisodeSearch"
+	case Radarr:
+		command = "MissingMoviesSearch"
+	default:
+		return fmt.Errorf("unknown arr type: %s", a.Type)
+	}
+	payload := struct {
+		Name string `json:"name"`
+	}{Name: command}
+	resp, err := a.Request(http.MethodPost, "api/v3/command", payload)
+	if err != nil {
+		return fmt.Errorf("failed to automatic search: %v", err)
+	}
+	if statusOk := strconv.Itoa(resp.StatusCode)[0] == '2'; !statusOk {
+		return fmt.Errorf("failed to automatic search. Status Code: %s", resp.Status)
+	}
+	return nil
+}
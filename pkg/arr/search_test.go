@@ -0,0 +1,72 @@
+package arr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSearchBatching re-searches three movies in a row, then a fourth one once the batch of the first ones is searched
+func TestSearchBatching(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+		mode   string
+		want   []string // Commands sent to the arr, in order
+	}{
+		{"no window", "", "items", []string{"MoviesSearch [1]", "MoviesSearch [2]", "MoviesSearch [3]", "MoviesSearch [4]"}},
+		{"window", "100ms", "items", []string{"MoviesSearch [1 2 3]", "MoviesSearch [4]"}},
+		{"no window missing", "", "missing", []string{"MissingMoviesSearch []", "MissingMoviesSearch []", "MissingMoviesSearch []", "MissingMoviesSearch []"}},
+		{"window missing", "100ms", "missing", []string{"MissingMoviesSearch []", "MissingMoviesSearch []"}},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, map[string]any{"arr_search_batch_window": tt.window, "arr_search_mode": tt.mode})
+		var mu sync.Mutex
+		var commands []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload radarrSearch
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			commands = append(commands, fmt.Sprintf("%s %v", payload.Name, payload.MovieIds))
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		a := New("radarr", srv.URL, "token", false, false, nil, "", "")
+		received := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(commands)
+		}
+		// waitSent waits for the arr to receive n commands
+		waitSent := func(n int) {
+			for deadline := time.Now().Add(2 * time.Second); len(received()) < n && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		for id := 1; id <= 3; id++ {
+			if err := a.SearchMissing([]ContentFile{{Id: id}}); err != nil {
+				t.Errorf("%s: search %d: %v", tt.name, id, err)
+			}
+		}
+		if got := received(); tt.window != "" && len(got) != 0 {
+			t.Errorf("%s: searched %v before the window ended", tt.name, got)
+		}
+		waitSent(len(tt.want) - 1)
+		if err := a.SearchMissing([]ContentFile{{Id: 4}}); err != nil {
+			t.Errorf("%s: search 4: %v", tt.name, err)
+		}
+		waitSent(len(tt.want))
+		time.Sleep(200 * time.Millisecond) // Nothing else is searched once the window ends
+		got := received()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: commands = %v, want %v", tt.name, got, tt.want)
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package debrid
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"slices"
+)
+
+// ChangeAPIKeys prepares the debrids of an updated config whose api_key changed, matched to the current ones by name.
+// Their debrid keeps its name, so its torrents, traffic and routing carry over to the new key. Download keys defaulted
+// to the old key move to the new one, and the new key is checked against the provider with the validate
+// api_key_change. An error is returned for the first new key rejected, the config should then not be saved.
+func ChangeAPIKeys(ctx context.Context, current, updated []config.Debrid) error {
+	_logger := logger.Default()
+	for i := range updated {
+		dc := &updated[i]
+		idx := slices.IndexFunc(current, func(c config.Debrid) bool { return c.Name == dc.Name })
+		if idx < 0 || dc.APIKey == "" || current[idx].APIKey == dc.APIKey {
+			continue
+		}
+		oldKey := current[idx].APIKey
+		if slices.Equal(dc.DownloadAPIKeys, []string{oldKey}) {
+			dc.DownloadAPIKeys = []string{dc.APIKey}
+		}
+		if dc.APIKeyChange != config.APIKeyChangeAccept {
+			if err := validateAPIKey(ctx, *dc); err != nil {
+				return fmt.Errorf("%s: new API key rejected: %w", dc.Name, err)
+			}
+		}
+		_logger.Info().Str("debrid", dc.Name).Msg("API key changed, its torrents, traffic and routing are kept")
+	}
+	return nil
+}
+
+// validateAPIKey checks the api_key of the debrid with a health check of a client using it, within healthCheckTimeout.
+// Creating the client can call the provider, with retries, so it is bounded by the timeout too.
+func validateAPIKey(ctx context.Context, dc config.Debrid) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		client, err := createDebridClient(dc)
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- client.HealthCheck(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
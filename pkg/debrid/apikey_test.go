@@ -0,0 +1,52 @@
+package debrid
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestChangeAPIKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			_, _ = w.Write([]byte(`{"success":false,"error":"BAD_TOKEN"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"data":{}}`))
+	}))
+	defer srv.Close()
+
+	current := config.Debrid{Name: "torbox", APIKey: "old", DownloadAPIKeys: []string{"old"}, Folder: "/mnt/remote/torbox", Endpoint: srv.URL}
+	setTestConfig(t, current)
+	changed := func(key string, change config.APIKeyChange) []config.Debrid {
+		dc := current
+		dc.APIKey, dc.APIKeyChange = key, change
+		dc.DownloadAPIKeys = slices.Clone(current.DownloadAPIKeys)
+		return []config.Debrid{dc}
+	}
+
+	updated := changed("good", config.APIKeyChangeValidate)
+	if err := ChangeAPIKeys(context.Background(), []config.Debrid{current}, updated); err != nil {
+		t.Fatalf("ChangeAPIKeys() error = %v for a valid key", err)
+	}
+	if !slices.Equal(updated[0].DownloadAPIKeys, []string{"good"}) {
+		t.Errorf("download keys = %v, want them moved to the new key", updated[0].DownloadAPIKeys)
+	}
+
+	if err := ChangeAPIKeys(context.Background(), []config.Debrid{current}, changed("bad", config.APIKeyChangeValidate)); err == nil {
+		t.Error("ChangeAPIKeys() accepted a key rejected by the provider")
+	}
+	if err := ChangeAPIKeys(context.Background(), []config.Debrid{current}, changed("bad", config.APIKeyChangeAccept)); err != nil {
+		t.Errorf("ChangeAPIKeys() error = %v with api_key_change accept, want the key accepted unchecked", err)
+	}
+
+	// Own download keys are kept
+	updated = changed("good", config.APIKeyChangeValidate)
+	updated[0].DownloadAPIKeys = []string{"download"}
+	if err := ChangeAPIKeys(context.Background(), []config.Debrid{current}, updated); err != nil || !slices.Equal(updated[0].DownloadAPIKeys, []string{"download"}) {
+		t.Errorf("ChangeAPIKeys() = %v with download keys %v, want the download key kept", err, updated[0].DownloadAPIKeys)
+	}
+}
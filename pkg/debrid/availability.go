@@ -0,0 +1,155 @@
+package debrid
+
+import (
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// availabilityBatch is an availability request of several hashes, the callers checking them wait for it
+type availabilityBatch struct {
+	hashes []string
+	done   chan struct{} // Closed once result and err are set
+	result map[string]bool
+	err    error
+}
+
+type availabilityEntry struct {
+	available bool
+	expires   time.Time
+}
+
+// availabilityBatcher coalesces the availability checks of a debrid: the hashes checked within window are sent as one
+// request, a hash already being checked waits for that check, and the results are reused for ttl. A failed request
+// fails all the checks waiting for it and is not cached.
+type availabilityBatcher struct {
+	client    types.Client
+	window    time.Duration
+	ttl       time.Duration
+	batchSize int
+
+	mu       sync.Mutex
+	pending  *availabilityBatch            // Collecting hashes until the window passes
+	inFlight map[string]*availabilityBatch // Hash -> batch checking it, pending or sent
+	cache    map[string]availabilityEntry
+}
+
+func newAvailabilityBatcher(client types.Client, window, ttl time.Duration, batchSize int) *availabilityBatcher {
+	return &availabilityBatcher{
+		client:    client,
+		window:    window,
+		ttl:       ttl,
+		batchSize: max(batchSize, 1),
+		inFlight:  make(map[string]*availabilityBatch),
+		cache:     make(map[string]availabilityEntry),
+	}
+}
+
+// check returns the availability of hashes, keyed by lowercase hash
+func (b *availabilityBatcher) check(hashes []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(hashes))
+	waiting := make(map[*availabilityBatch][]string)
+	now := time.Now()
+
+	b.mu.Lock()
+	for _, hash := range hashes {
+		hash = strings.ToLower(hash)
+		if hash == "" {
+			continue
+		}
+		if entry, ok := b.cache[hash]; ok && now.Before(entry.expires) {
+			result[hash] = entry.available
+			continue
+		}
+		batch, ok := b.inFlight[hash]
+		if !ok {
+			batch = b.enqueue(hash)
+		}
+		waiting[batch] = append(waiting[batch], hash)
+	}
+	b.mu.Unlock()
+
+	for batch, batchHashes := range waiting {
+		<-batch.done
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		for _, hash := range batchHashes {
+			result[hash] = batch.result[hash]
+		}
+	}
+	return result, nil
+}
+
+// enqueue adds hash to the pending batch, sent once the window passes or it is full. b.mu must be held.
+func (b *availabilityBatcher) enqueue(hash string) *availabilityBatch {
+	batch := b.pending
+	if batch == nil {
+		batch = &availabilityBatch{done: make(chan struct{})}
+		b.pending = batch
+		if b.window > 0 {
+			time.AfterFunc(b.window, func() { b.flush(batch) })
+		}
+	}
+	batch.hashes = append(batch.hashes, hash)
+	b.inFlight[hash] = batch
+	if b.window <= 0 || len(batch.hashes) >= b.batchSize {
+		b.pending = nil
+		go b.send(batch)
+	}
+	return batch
+}
+
+// flush sends batch if it is still the pending one
+func (b *availabilityBatcher) flush(batch *availabilityBatch) {
+	b.mu.Lock()
+	if b.pending != batch {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// send requests the availability of the hashes of batch and hands the result to the callers waiting for it
+func (b *availabilityBatcher) send(batch *availabilityBatch) {
+	available, err := b.client.IsAvailable(batch.hashes)
+	result := make(map[string]bool, len(batch.hashes))
+	if err == nil {
+		for _, hash := range batch.hashes {
+			result[hash] = available[hash] || available[strings.ToUpper(hash)] // some providers key by uppercase hashes
+		}
+	}
+
+	b.mu.Lock()
+	expires := time.Now().Add(b.ttl)
+	for _, hash := range batch.hashes {
+		delete(b.inFlight, hash)
+		if err == nil && b.ttl > 0 {
+			b.cache[hash] = availabilityEntry{available: result[hash], expires: expires}
+		}
+	}
+	b.pruneLocked()
+	b.mu.Unlock()
+
+	batch.result, batch.err = result, err
+	close(batch.done)
+}
+
+// pruneLocked drops the expired results. b.mu must be held.
+func (b *availabilityBatcher) pruneLocked() {
+	now := time.Now()
+	for hash, entry := range b.cache {
+		if !now.Before(entry.expires) {
+			delete(b.cache, hash)
+		}
+	}
+}
+
+// IsAvailable returns the availability of hashes on the debrid, keyed by lowercase hash. Concurrent checks are
+// coalesced into batched requests and the results are reused for check_cached_ttl.
+func (de *Debrid) IsAvailable(hashes []string) (map[string]bool, error) {
+	return de.availability.check(hashes)
+}
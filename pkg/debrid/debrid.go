@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/metrics"
+	"github.com/sirrobot01/decypharr/internal/request"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/arr"
 	"github.com/sirrobot01/decypharr/pkg/debrid/providers/alldebrid"
@@ -14,12 +16,33 @@ import (
 	"github.com/sirrobot01/decypharr/pkg/debrid/providers/torbox"
 	"github.com/sirrobot01/decypharr/pkg/debrid/store"
 	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Debrid struct {
 	cache  *store.Cache // Could be nil if not using WebDAV
 	client types.Client // HTTP client for making requests to the debrid service
+	config config.Debrid
+
+	premiumExpired atomic.Bool // Set while the account has no premium, downloads are not routed to it
+
+	traffic    *trafficTracker
+	overBudget atomic.Bool // Set while the traffic budget threshold is reached, downloads avoid it
+
+	unlocks         *unlockTracker
+	overUnlockQuota atomic.Bool // Set while the unlock quota threshold is reached, downloads avoid it
+
+	slotsFullUntil atomic.Int64 // Unix nanoseconds, set when the debrid refuses a torrent for too many active downloads
+
+	health healthState
+
+	breaker *types.CircuitBreaker // Nil when disabled
+
+	availability *availabilityBatcher
 }
 
 func (de *Debrid) Client() types.Client {
@@ -30,10 +53,36 @@ func (de *Debrid) Cache() *store.Cache {
 	return de.cache
 }
 
+func (de *Debrid) Config() config.Debrid {
+	return de.config
+}
+
+func (de *Debrid) IsStandby() bool {
+	return de.config.Role == config.DebridRoleStandby
+}
+
+// CircuitBreaker returns the state of the circuit breaker of the debrid
+func (de *Debrid) CircuitBreaker() types.BreakerStatus {
+	return de.breaker.Status()
+}
+
+// IsPremiumExpired reports whether the last premium check found the account expired
+func (de *Debrid) IsPremiumExpired() bool {
+	return de.premiumExpired.Load()
+}
+
 type Storage struct {
 	debrids  map[string]*Debrid
 	mu       sync.RWMutex
 	lastUsed string
+
+	standbyEngaged atomic.Bool // Set while standby debrids are used because all primaries failed
+
+	traffic *trafficTracker
+	unlocks *unlockTracker
+
+	keysMu    sync.Mutex
+	keysSaved []byte // download_keys.json as last saved
 }
 
 func NewStorage() *Storage {
@@ -42,6 +91,8 @@ func NewStorage() *Storage {
 	_logger := logger.Default()
 
 	debrids := make(map[string]*Debrid)
+	traffic := newTrafficTracker(trafficFile())
+	unlocks := newUnlockTracker(unlocksFile())
 
 	for _, dc := range cfg.Debrids {
 		client, err := createDebridClient(dc)
@@ -51,22 +102,34 @@ func NewStorage() *Storage {
 		}
 		var cache *store.Cache
 		_log := client.Logger()
+		breaker := types.NewCircuitBreaker(dc.CircuitBreaker, _log)
 		if dc.UseWebDav {
-			cache = store.NewDebridCache(dc, client)
+			cache = store.NewDebridCache(dc, client, breaker)
 			_log.Info().Msg("Debrid Service started with WebDAV")
 		} else {
 			_log.Info().Msg("Debrid Service started")
 		}
-		debrids[dc.Name] = &Debrid{
-			cache:  cache,
-			client: client,
+		db := &Debrid{
+			cache:   cache,
+			client:  client,
+			config:  dc,
+			traffic: traffic,
+			unlocks: unlocks,
+			breaker: breaker,
+
+			availability: newAvailabilityBatcher(client, dc.GetCheckCachedWindow(), dc.GetCheckCachedTTL(), dc.GetCheckCachedBatchSize()),
 		}
+		client.Accounts().OnUnlock(db.recordUnlock)
+		debrids[dc.Name] = db
 	}
 
 	d := &Storage{
 		debrids:  debrids,
 		lastUsed: "",
+		traffic:  traffic,
+		unlocks:  unlocks,
 	}
+	d.restoreKeyStates()
 	return d
 }
 
@@ -101,6 +164,11 @@ func (d *Storage) Client(name string) types.Client {
 }
 
 func (d *Storage) Reset() {
+	// Saved before the debrids are dropped, so the storage replacing this one restores the current state
+	if err := d.saveKeyStates(); err != nil {
+		_logger := logger.Default()
+		_logger.Error().Err(err).Msg("Failed to save the download keys state")
+	}
 	d.mu.Lock()
 	d.debrids = make(map[string]*Debrid)
 	d.mu.Unlock()
@@ -143,8 +211,130 @@ func (d *Storage) FilterClients(filter func(types.Client) bool) map[string]types
 	return filteredClients
 }
 
+// StandbyEngaged reports whether standby debrids are currently in use
+func (d *Storage) StandbyEngaged() bool {
+	return d.standbyEngaged.Load()
+}
+
+// splitByRole separates the clients into primary and standby debrids
+func (d *Storage) splitByRole(clients map[string]types.Client) (map[string]types.Client, map[string]types.Client) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	primary := make(map[string]types.Client)
+	standby := make(map[string]types.Client)
+	for name, client := range clients {
+		if db, ok := d.debrids[name]; ok && db.IsStandby() {
+			standby[name] = client
+		} else {
+			primary[name] = client
+		}
+	}
+	return primary, standby
+}
+
+func (d *Storage) engageStandby(reason error) {
+	if d.standbyEngaged.Swap(true) {
+		return
+	}
+	_logger := logger.Default()
+	_logger.Warn().Err(reason).Msg("All primary debrids failed, engaging standby debrids")
+	msg := fmt.Sprintf("All primary debrids failed, standby debrids are now in use.\nReason: %v", reason)
+	request.Notify("standby_activated", "warning", msg)
+}
+
+func (d *Storage) disengageStandby() {
+	if !d.standbyEngaged.Swap(false) {
+		return
+	}
+	_logger := logger.Default()
+	_logger.Info().Msg("Primary debrids recovered, standby debrids disengaged")
+	request.Notify("standby_deactivated", "success", "Primary debrids recovered, standby debrids are no longer in use.")
+}
+
+// StartPremiumCheck periodically checks every debrid account for an expired premium, until ctx is done
+func (d *Storage) StartPremiumCheck(ctx context.Context) {
+	for name, db := range d.Debrids() {
+		interval := db.config.GetPremiumCheckInterval()
+		if interval <= 0 {
+			continue
+		}
+		go func(name string, db *Debrid) {
+			d.checkPremium(name, db)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					d.checkPremium(name, db)
+				}
+			}
+		}(name, db)
+	}
+}
+
+func (d *Storage) checkPremium(name string, db *Debrid) {
+	_logger := db.client.Logger()
+	profile, err := db.client.GetProfile()
+	if err != nil {
+		_logger.Error().Err(err).Msg("Failed to check premium status")
+		return
+	}
+	if profile == nil {
+		// The provider doesn't report account info
+		return
+	}
+	expired := profile.IsPremiumExpired()
+	if db.premiumExpired.Swap(expired) == expired {
+		return
+	}
+
+	event, status := "premium_expired", "error"
+	msg := fmt.Sprintf("Premium expired for %s, downloads are no longer sent to it.", name)
+	if expired {
+		_logger.Warn().Msg("Premium expired, marking debrid as degraded")
+	} else {
+		event, status = "premium_restored", "success"
+		msg = fmt.Sprintf("Premium restored for %s, downloads are sent to it again.", name)
+		_logger.Info().Msg("Premium restored")
+	}
+	request.Notify(event, status, msg)
+}
+
+// isPremiumExpired reports whether the named debrid is known to have an expired premium
+func (d *Storage) isPremiumExpired(name string) bool {
+	db := d.Debrid(name)
+	return db != nil && db.IsPremiumExpired()
+}
+
+// breaker returns the circuit breaker of the debrid name, nil if it has none
+func (d *Storage) breaker(name string) *types.CircuitBreaker {
+	if db := d.Debrid(name); db != nil {
+		return db.breaker
+	}
+	return nil
+}
+
+// orderByPriority returns the names of the clients, the ones in priority first in that order, then the others
+func orderByPriority(clients map[string]types.Client, priority []string) []string {
+	names := make([]string, 0, len(clients))
+	for _, name := range priority {
+		if _, ok := clients[name]; ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	rest := make([]string, 0, len(clients))
+	for name := range clients {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	return append(names, rest...)
+}
+
 func createDebridClient(dc config.Debrid) (types.Client, error) {
-	switch dc.Name {
+	switch dc.Provider() {
 	case "realdebrid":
 		return realdebrid.New(dc)
 	case "torbox":
@@ -158,16 +348,37 @@ func createDebridClient(dc config.Debrid) (types.Client, error) {
 	}
 }
 
+// ProbeLink checks that a download link can be generated for the largest file of the torrent
+func ProbeLink(db types.Client, torrent *types.Torrent) error {
+	var largest *types.File
+	for _, file := range torrent.GetFiles() {
+		if largest == nil || file.Size > largest.Size {
+			largest = &file
+		}
+	}
+	if largest == nil {
+		return fmt.Errorf("no files to probe")
+	}
+	link, err := db.GetDownloadLink(torrent, largest)
+	if err != nil {
+		return err
+	}
+	if link == nil || link.DownloadLink == "" {
+		return fmt.Errorf("no download link for %s", largest.Name)
+	}
+	return nil
+}
+
 func Process(ctx context.Context, store *Storage, selectedDebrid string, magnet *utils.Magnet, a *arr.Arr, action string, overrideDownloadUncached bool) (*types.Torrent, error) {
 
 	debridTorrent := &types.Torrent{
 		InfoHash: magnet.InfoHash,
 		Magnet:   magnet,
 		Name:     magnet.Name,
-		Arr:      a,
 		Size:     magnet.Size,
 		Files:    make(map[string]types.File),
 	}
+	debridTorrent.SetArr(a)
 
 	clients := store.FilterClients(func(c types.Client) bool {
 		if selectedDebrid != "" && c.Name() != selectedDebrid {
@@ -193,11 +404,28 @@ func Process(ctx context.Context, store *Storage, selectedDebrid string, magnet
 		debridTorrent.DownloadUncached = false
 	}
 
-	for index, db := range clients {
-		_logger := db.Logger()
+	submit := func(index string, db types.Client) (*types.Torrent, error) {
+		if store.isPremiumExpired(index) {
+			return nil, fmt.Errorf("%s: premium expired", index)
+		}
+		if store.isOverBudget(index) {
+			return nil, fmt.Errorf("%s: traffic budget reached", index)
+		}
+		if store.isOverUnlockQuota(index) {
+			return nil, fmt.Errorf("%s: unlock quota reached", index)
+		}
+		if db.AuthState() == request.AuthStateInvalidKey {
+			return nil, fmt.Errorf("%s: %w", index, request.ErrInvalidKey)
+		}
+		breaker := store.breaker(index)
+		if breaker.IsOpen() {
+			return nil, fmt.Errorf("%s: %w", index, types.ErrCircuitOpen)
+		}
+		_logger := logger.Ctx(ctx, db.Logger()).With().
+			Str("debrid", db.Name()).
+			Str("category", a.Name).
+			Logger()
 		_logger.Info().
-			Str("Debrid", db.Name()).
-			Str("Arr", a.Name).
 			Str("Hash", debridTorrent.InfoHash).
 			Str("Name", debridTorrent.Name).
 			Str("Action", action).
@@ -206,13 +434,34 @@ func Process(ctx context.Context, store *Storage, selectedDebrid string, magnet
 		if !overrideDownloadUncached && a.DownloadUncached == nil {
 			debridTorrent.DownloadUncached = db.GetDownloadUncached()
 		}
+		dc := store.Debrid(index).Config()
+		checkCached := dc.CheckCached && !debridTorrent.DownloadUncached
+		if checkCached && dc.CachedCheckStrategy != config.CachedCheckProbeLink {
+			available, err := store.Debrid(index).IsAvailable([]string{debridTorrent.InfoHash})
+			if err != nil {
+				return nil, fmt.Errorf("%s: checking if %s is cached: %w", index, debridTorrent.Name, err)
+			}
+			cached := available[strings.ToLower(debridTorrent.InfoHash)]
+			metrics.CachedCheck(db.Name(), cached)
+			if !cached {
+				return nil, fmt.Errorf("%s: torrent %s not cached", index, debridTorrent.Name)
+			}
+		}
 
+		if err := breaker.Allow(); err != nil {
+			return nil, fmt.Errorf("%s: %w", index, err)
+		}
 		dbt, err := db.SubmitMagnet(debridTorrent)
+		err = utils.WithCorrelationID(ctx, err)
+		breaker.Record(err)
+		if errors.Is(err, utils.TooManyActiveDownloadsError) {
+			_logger.Warn().Msgf("Too many active downloads, trying the other debrids first for %s", config.Get().DebridFullCooldown)
+			store.Debrid(index).markSlotsFull()
+		}
 		if err != nil || dbt == nil || dbt.Id == "" {
-			errs = append(errs, err)
-			continue
+			return nil, err
 		}
-		dbt.Arr = a
+		dbt.SetArr(a)
 		_logger.Info().Str("id", dbt.Id).Msgf("Torrent: %s submitted to %s", dbt.Name, db.Name())
 		store.lastUsed = index
 
@@ -224,15 +473,57 @@ func Process(ctx context.Context, store *Storage, selectedDebrid string, magnet
 			}(torrent.Id)
 		}
 		if err != nil {
-			errs = append(errs, err)
-			continue
+			return nil, err
 		}
 		if torrent == nil {
-			errs = append(errs, fmt.Errorf("torrent %s returned nil after checking status", dbt.Name))
+			return nil, fmt.Errorf("torrent %s returned nil after checking status", dbt.Name)
+		}
+		if checkCached && dc.CachedCheckStrategy != config.CachedCheckAPI && torrent.Status == "downloaded" {
+			if err := ProbeLink(db, torrent); err != nil {
+				go func(id string) {
+					_ = db.DeleteTorrent(id)
+				}(torrent.Id)
+				return nil, fmt.Errorf("%s: torrent %s not cached: %w", index, torrent.Name, err)
+			}
+		}
+		return torrent, nil
+	}
+
+	// A selected debrid is always used, whatever its role
+	primary, standby := clients, map[string]types.Client{}
+	if selectedDebrid == "" {
+		primary, standby = store.splitByRole(clients)
+	}
+	var priority []string
+	if ac, ok := config.Get().GetArr(a.Name); ok {
+		priority = ac.DebridPriority
+	}
+
+	for _, index := range store.submitOrder(primary, priority) {
+		db := primary[index]
+		torrent, err := submit(index, db)
+		if err != nil || torrent == nil {
+			errs = append(errs, err)
 			continue
 		}
+		if selectedDebrid == "" {
+			store.disengageStandby()
+		}
 		return torrent, nil
 	}
+
+	if len(standby) > 0 {
+		store.engageStandby(errors.Join(errs...))
+		for _, index := range store.submitOrder(standby, priority) {
+			db := standby[index]
+			torrent, err := submit(index, db)
+			if err != nil || torrent == nil {
+				errs = append(errs, err)
+				continue
+			}
+			return torrent, nil
+		}
+	}
 	if len(errs) == 0 {
 		return nil, fmt.Errorf("failed to process torrent: no clients available")
 	}
@@ -0,0 +1,358 @@
+package debrid
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient is a debrid client adding torrents in memory, the methods it doesn't override panic
+type fakeClient struct {
+	types.Client
+	name      string // realdebrid if empty
+	mu        sync.Mutex
+	cached    map[string]bool // By infohash
+	noLinks   bool            // GetDownloadLink fails
+	submitErr error           // Returned by SubmitMagnet
+	profile   *types.Profile
+	submitted []string
+	checked   [][]string // Infohashes of each IsAvailable call
+	deleted   chan string
+}
+
+func newFakeClient(cached ...string) *fakeClient {
+	f := &fakeClient{cached: make(map[string]bool), deleted: make(chan string, 10)}
+	for _, hash := range cached {
+		f.cached[hash] = true
+	}
+	return f
+}
+
+func (f *fakeClient) Name() string                  { return cmp.Or(f.name, "realdebrid") }
+func (f *fakeClient) Logger() zerolog.Logger        { return zerolog.Nop() }
+func (f *fakeClient) AuthState() string             { return "" }
+func (f *fakeClient) GetDownloadUncached() bool     { return false }
+func (f *fakeClient) DeleteTorrent(id string) error { f.deleted <- id; return nil }
+
+func (f *fakeClient) IsAvailable(hashes []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checked = append(f.checked, hashes)
+	available := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		available[strings.ToLower(hash)] = f.cached[strings.ToLower(hash)]
+	}
+	return available, nil
+}
+
+func (f *fakeClient) SubmitMagnet(t *types.Torrent) (*types.Torrent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.submitted = append(f.submitted, t.InfoHash)
+	if f.submitErr != nil {
+		return nil, f.submitErr
+	}
+	added := t.Clone()
+	added.Id = "id-" + t.InfoHash
+	added.Debrid = f.Name()
+	return added, nil
+}
+
+func (f *fakeClient) CheckStatus(t *types.Torrent) (*types.Torrent, error) {
+	t.Status = "downloaded"
+	t.Files = map[string]types.File{"movie.mkv": {Id: "1", Name: "movie.mkv", Size: 1 << 30}}
+	return t, nil
+}
+
+func (f *fakeClient) GetProfile() (*types.Profile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.profile, nil
+}
+
+func (f *fakeClient) GetAvailableSlots() (int, error) {
+	return 0, errors.New("slots not reported")
+}
+
+func (f *fakeClient) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	if f.noLinks {
+		return nil, utils.HosterUnavailableError
+	}
+	return &types.DownloadLink{DownloadLink: "https://debrid/" + file.Name}, nil
+}
+
+// setTestConfig loads a config with debrids from a temp directory
+func setTestConfig(t *testing.T, debrids ...config.Debrid) {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(map[string]any{"debrids": debrids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+}
+
+// newTestStorage returns a storage with a single realdebrid debrid served by client, set by opts
+func newTestStorage(t *testing.T, client types.Client, opts ...func(*config.Debrid)) *Storage {
+	t.Helper()
+	debrid := config.Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__"}
+	for _, opt := range opts {
+		opt(&debrid)
+	}
+	setTestConfig(t, debrid)
+	dc, _ := config.Get().GetDebrid(debrid.Name)
+	return &Storage{debrids: map[string]*Debrid{
+		dc.Name: {
+			client:       client,
+			config:       dc,
+			availability: newAvailabilityBatcher(client, 0, 0, 100),
+		},
+	}}
+}
+
+// newMultiStorage returns a storage with a debrid per client, named after it, configured by debrids in that order
+func newMultiStorage(t *testing.T, clients []*fakeClient, debrids ...config.Debrid) *Storage {
+	t.Helper()
+	setTestConfig(t, debrids...)
+	storage := &Storage{debrids: make(map[string]*Debrid)}
+	for _, client := range clients {
+		dc, _ := config.Get().GetDebrid(client.Name())
+		storage.debrids[dc.Name] = &Debrid{
+			client:       client,
+			config:       dc,
+			availability: newAvailabilityBatcher(client, 0, 0, 100),
+		}
+	}
+	return storage
+}
+
+func TestProcessCachedCheckStrategy(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		strategy   config.CachedCheckStrategy
+		cached     bool // Reported by the availability endpoint
+		noLinks    bool
+		wantOK     bool
+		wantCheck  bool // The availability endpoint is called
+		wantSubmit bool
+	}{
+		{config.CachedCheckAPI, true, true, true, true, true},
+		{config.CachedCheckAPI, false, false, false, true, false},
+		{config.CachedCheckProbeLink, false, false, true, false, true},
+		{config.CachedCheckProbeLink, false, true, false, false, true},
+		{config.CachedCheckHybrid, false, false, false, true, false},
+		{config.CachedCheckHybrid, true, true, false, true, true},
+		{config.CachedCheckHybrid, true, false, true, true, true},
+	}
+	for _, tt := range tests {
+		client := newFakeClient()
+		client.cached[hash] = tt.cached
+		client.noLinks = tt.noLinks
+		storage := newTestStorage(t, client, func(d *config.Debrid) {
+			d.CheckCached = true
+			d.CachedCheckStrategy = tt.strategy
+		})
+		magnet := utils.ConstructMagnet(hash, "Movie")
+		_arr := arr.New("radarr", "", "", false, false, nil, "", "")
+
+		torrent, err := Process(context.Background(), storage, "", magnet, _arr, "symlink", false)
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s, cached %v, links %v: error %v, want ok %v", tt.strategy, tt.cached, !tt.noLinks, err, tt.wantOK)
+		}
+		if tt.wantOK && (torrent == nil || torrent.Status != "downloaded") {
+			t.Errorf("%s, cached %v: torrent = %+v, want downloaded", tt.strategy, tt.cached, torrent)
+		}
+		if checked := len(client.checked) > 0; checked != tt.wantCheck {
+			t.Errorf("%s, cached %v: availability checked %v, want %v", tt.strategy, tt.cached, checked, tt.wantCheck)
+		}
+		if submitted := len(client.submitted) > 0; submitted != tt.wantSubmit {
+			t.Errorf("%s, cached %v: submitted %v, want %v", tt.strategy, tt.cached, submitted, tt.wantSubmit)
+		}
+		if tt.wantSubmit && !tt.wantOK {
+			// A torrent failing the probe is removed from the debrid
+			if id := <-client.deleted; id != "id-"+hash {
+				t.Errorf("%s: deleted %s, want id-%s", tt.strategy, id, hash)
+			}
+		}
+	}
+}
+
+func TestProbeLink(t *testing.T) {
+	torrent := &types.Torrent{Files: map[string]types.File{
+		"sample.mkv": {Name: "sample.mkv", Size: 1 << 20},
+		"movie.mkv":  {Name: "movie.mkv", Size: 1 << 30},
+	}}
+	var probed string
+	client := &probeClient{link: func(file *types.File) (*types.DownloadLink, error) {
+		probed = file.Name
+		return &types.DownloadLink{DownloadLink: "https://debrid/" + file.Name}, nil
+	}}
+	if err := ProbeLink(client, torrent); err != nil {
+		t.Fatal(err)
+	}
+	if probed != "movie.mkv" {
+		t.Errorf("probed %s, want the largest file movie.mkv", probed)
+	}
+
+	client.link = func(*types.File) (*types.DownloadLink, error) { return &types.DownloadLink{}, nil }
+	if err := ProbeLink(client, torrent); err == nil {
+		t.Error("probe without a download link succeeded")
+	}
+	client.link = func(*types.File) (*types.DownloadLink, error) { return nil, errors.New("hoster unavailable") }
+	if err := ProbeLink(client, torrent); err == nil {
+		t.Error("probe failing to generate a link succeeded")
+	}
+	if err := ProbeLink(client, &types.Torrent{}); err == nil {
+		t.Error("probe of a torrent without files succeeded")
+	}
+}
+
+type probeClient struct {
+	types.Client
+	link func(file *types.File) (*types.DownloadLink, error)
+}
+
+func (p *probeClient) GetDownloadLink(_ *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	return p.link(file)
+}
+
+// The standby debrid is engaged while every primary debrid fails and disengaged once one succeeds again
+func TestProcessStandby(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	primary := newFakeClient()
+	standby := newFakeClient()
+	standby.name = "torbox"
+	storage := newMultiStorage(t, []*fakeClient{primary, standby},
+		config.Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__"},
+		config.Debrid{Name: "torbox", APIKey: "key", Folder: "/mnt/remote/torbox/__all__", Role: config.DebridRoleStandby},
+	)
+	_arr := arr.New("radarr", "", "", false, false, nil, "", "")
+
+	steps := []struct {
+		name        string
+		primaryErr  error
+		selected    string
+		wantDebrid  string
+		wantEngaged bool
+	}{
+		{"primary up", nil, "", "realdebrid", false},
+		{"primary down", errors.New("service unavailable"), "", "torbox", true},
+		{"primary still down", errors.New("service unavailable"), "", "torbox", true},
+		{"primary recovered", nil, "", "realdebrid", false},
+		{"standby selected", nil, "torbox", "torbox", false},
+	}
+	for _, step := range steps {
+		primary.mu.Lock()
+		primary.submitErr = step.primaryErr
+		primary.mu.Unlock()
+		torrent, err := Process(context.Background(), storage, step.selected, utils.ConstructMagnet(hash, "Movie"), _arr, "symlink", false)
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", step.name, err)
+		}
+		if torrent.Debrid != step.wantDebrid {
+			t.Errorf("%s: submitted to %s, want %s", step.name, torrent.Debrid, step.wantDebrid)
+		}
+		if got := storage.StandbyEngaged(); got != step.wantEngaged {
+			t.Errorf("%s: StandbyEngaged() = %v, want %v", step.name, got, step.wantEngaged)
+		}
+	}
+}
+
+// Downloads aren't sent to a debrid whose premium expired, until it's renewed
+func TestCheckPremium(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	client := newFakeClient()
+	storage := newTestStorage(t, client)
+	db := storage.Debrid("realdebrid")
+	_arr := arr.New("radarr", "", "", false, false, nil, "", "")
+
+	steps := []struct {
+		name        string
+		profile     *types.Profile
+		wantExpired bool
+	}{
+		{"premium", &types.Profile{Type: "premium", Expiration: time.Now().Add(30 * 24 * time.Hour)}, false},
+		{"expired", &types.Profile{Type: "premium", Expiration: time.Now().Add(-time.Hour)}, true},
+		{"free account", &types.Profile{Type: "free"}, true},
+		{"not reported, the state is kept", nil, true},
+		{"renewed", &types.Profile{Type: "premium", Expiration: time.Now().Add(30 * 24 * time.Hour)}, false},
+	}
+	for _, step := range steps {
+		client.mu.Lock()
+		client.profile = step.profile
+		client.mu.Unlock()
+		storage.checkPremium("realdebrid", db)
+		if got := db.IsPremiumExpired(); got != step.wantExpired {
+			t.Errorf("%s: IsPremiumExpired() = %v, want %v", step.name, got, step.wantExpired)
+		}
+		_, err := Process(context.Background(), storage, "", utils.ConstructMagnet(hash, "Movie"), _arr, "symlink", false)
+		if expired := err != nil && strings.Contains(err.Error(), "premium expired"); expired != step.wantExpired {
+			t.Errorf("%s: Process() error = %v, want premium expired %v", step.name, err, step.wantExpired)
+		}
+	}
+}
+
+func TestCategoryDebridPriority(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	clients := []*fakeClient{newFakeClient(), newFakeClient(), newFakeClient()}
+	clients[1].name, clients[2].name = "torbox", "alldebrid"
+	storage := newMultiStorage(t, clients,
+		config.Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__"},
+		config.Debrid{Name: "torbox", APIKey: "key", Folder: "/mnt/remote/torbox/__all__"},
+		config.Debrid{Name: "alldebrid", APIKey: "key", Folder: "/mnt/remote/alldebrid/__all__"},
+	)
+	config.Get().Arrs = []config.Arr{
+		{Name: "radarr4k", DebridPriority: []string{"torbox"}},
+		{Name: "sonarr", DebridPriority: []string{"alldebrid", "realdebrid"}},
+		{Name: "lidarr", DebridPriority: []string{"removed", "alldebrid", "alldebrid"}},
+	}
+
+	tests := []struct {
+		category  string
+		wantOrder []string
+	}{
+		{"radarr", []string{"realdebrid", "torbox", "alldebrid"}},
+		{"radarr4k", []string{"torbox", "realdebrid", "alldebrid"}},
+		{"sonarr", []string{"alldebrid", "realdebrid", "torbox"}},
+		{"lidarr", []string{"alldebrid", "realdebrid", "torbox"}},
+	}
+	all := map[string]types.Client{}
+	for _, c := range clients {
+		all[c.Name()] = c
+	}
+	for _, tt := range tests {
+		var priority []string
+		if ac, ok := config.Get().GetArr(tt.category); ok {
+			priority = ac.DebridPriority
+		}
+		if got := storage.submitOrder(all, priority); !slices.Equal(got, tt.wantOrder) {
+			t.Errorf("%s: submit order = %v, want %v", tt.category, got, tt.wantOrder)
+		}
+
+		_arr := arr.New(tt.category, "", "", false, false, nil, "", "")
+		torrent, err := Process(context.Background(), storage, "", utils.ConstructMagnet(hash, "Movie"), _arr, "symlink", false)
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", tt.category, err)
+		}
+		if torrent.Debrid != tt.wantOrder[0] {
+			t.Errorf("%s: submitted to %s, want %s", tt.category, torrent.Debrid, tt.wantOrder[0])
+		}
+	}
+}
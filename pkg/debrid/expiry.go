@@ -0,0 +1,96 @@
+package debrid
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"time"
+)
+
+// expiryCheckInterval is how often the torrents are checked for an expiry, more often with a short expiry_warning
+const expiryCheckInterval = time.Hour
+
+// StartExpiryChecks periodically looks for the torrents the providers will soon delete and keeps them alive or
+// notifies about them, according to expiry_action, until ctx is done. Only providers reporting an expiry are checked.
+func (d *Storage) StartExpiryChecks(ctx context.Context) {
+	for name, db := range d.Debrids() {
+		lister, ok := db.client.(types.ExpiringTorrentsLister)
+		if !ok || db.config.ExpiryAction == config.ExpiryActionNone {
+			continue
+		}
+		interval := min(expiryCheckInterval, db.config.GetExpiryWarning()/2)
+		go func(name string, db *Debrid) {
+			handled := make(map[string]time.Time) // torrent id -> expiry already acted on
+			d.checkExpiry(ctx, name, db, lister, handled)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					d.checkExpiry(ctx, name, db, lister, handled)
+				}
+			}
+		}(name, db)
+	}
+}
+
+// checkExpiry acts once on each expiry within expiry_warning. A keep-alive that doesn't push the expiry back isn't
+// retried, the torrent is acted on again once the provider reports another expiry.
+func (d *Storage) checkExpiry(ctx context.Context, name string, db *Debrid, lister types.ExpiringTorrentsLister, handled map[string]time.Time) {
+	_logger := db.client.Logger()
+	torrents, err := lister.GetExpiringTorrents()
+	if err != nil {
+		_logger.Error().Err(err).Msg("Failed to check the torrents for an expiry")
+		return
+	}
+
+	warning := db.config.GetExpiryWarning()
+	seen := make(map[string]struct{}, len(torrents))
+	for _, t := range torrents {
+		if ctx.Err() != nil {
+			return
+		}
+		seen[t.Id] = struct{}{}
+		if t.ExpiresAt.IsZero() || time.Until(t.ExpiresAt) > warning || handled[t.Id].Equal(t.ExpiresAt) {
+			continue
+		}
+		handled[t.Id] = t.ExpiresAt
+
+		if db.config.ExpiryAction == config.ExpiryActionKeepAlive {
+			err := keepAlive(db.client, t.Id)
+			if err == nil {
+				_logger.Info().Str("torrent", t.Name).Time("expires_at", t.ExpiresAt).Msg("Kept an expiring torrent alive")
+				continue
+			}
+			_logger.Warn().Err(err).Str("torrent", t.Name).Msg("Failed to keep an expiring torrent alive")
+			request.Notify("torrent_expiring", "warning", fmt.Sprintf("%s will delete %s on %s, keeping it alive failed: %v", name, t.Name, t.ExpiresAt.Format(time.RFC1123), err))
+			continue
+		}
+		_logger.Warn().Str("torrent", t.Name).Time("expires_at", t.ExpiresAt).Msg("Torrent expiring soon")
+		request.Notify("torrent_expiring", "warning", fmt.Sprintf("%s will delete %s on %s.", name, t.Name, t.ExpiresAt.Format(time.RFC1123)))
+	}
+	for id := range handled {
+		if _, ok := seen[id]; !ok {
+			delete(handled, id)
+		}
+	}
+}
+
+// keepAlive accesses a torrent the way a player would, generating a download link for one of its files,
+// so the provider sees it as active
+func keepAlive(client types.Client, torrentId string) error {
+	t, err := client.GetTorrent(torrentId)
+	if err != nil {
+		return err
+	}
+	files := t.GetFiles()
+	if len(files) == 0 {
+		return fmt.Errorf("torrent has no files")
+	}
+	_, err = client.GetDownloadLink(t, &files[0])
+	return err
+}
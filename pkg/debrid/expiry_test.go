@@ -0,0 +1,75 @@
+package debrid
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"testing"
+	"time"
+)
+
+// expiringClient is a fake client reporting expiring torrents and counting the keep-alive links generated
+type expiringClient struct {
+	*fakeClient
+	expiring []*types.Torrent
+	links    map[string]int // By torrent id
+}
+
+func (e *expiringClient) GetExpiringTorrents() ([]*types.Torrent, error) { return e.expiring, nil }
+
+func (e *expiringClient) GetTorrent(id string) (*types.Torrent, error) {
+	return &types.Torrent{Id: id, Files: map[string]types.File{"movie.mkv": {Id: "1", Name: "movie.mkv"}}}, nil
+}
+
+func (e *expiringClient) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	e.links[t.Id]++
+	return e.fakeClient.GetDownloadLink(t, file)
+}
+
+func TestCheckExpiry(t *testing.T) {
+	soon := time.Now().Add(time.Hour)
+	client := &expiringClient{
+		fakeClient: newFakeClient(),
+		expiring: []*types.Torrent{
+			{Id: "soon", Name: "Soon", ExpiresAt: soon},
+			{Id: "later", Name: "Later", ExpiresAt: time.Now().Add(72 * time.Hour)},
+		},
+		links: make(map[string]int),
+	}
+	storage := newTestStorage(t, client)
+	db := storage.debrids["realdebrid"]
+	handled := make(map[string]time.Time)
+
+	storage.checkExpiry(context.Background(), "realdebrid", db, client, handled)
+	if client.links["soon"] != 1 || client.links["later"] != 0 {
+		t.Fatalf("keep-alive links = %v, want one for the torrent expiring within expiry_warning", client.links)
+	}
+
+	// The same expiry is acted on once
+	storage.checkExpiry(context.Background(), "realdebrid", db, client, handled)
+	if client.links["soon"] != 1 {
+		t.Errorf("torrent kept alive %d times for the same expiry, want once", client.links["soon"])
+	}
+
+	// A new expiry is acted on again
+	client.expiring[0] = &types.Torrent{Id: "soon", Name: "Soon", ExpiresAt: soon.Add(time.Minute)}
+	storage.checkExpiry(context.Background(), "realdebrid", db, client, handled)
+	if client.links["soon"] != 2 {
+		t.Errorf("torrent kept alive %d times after a new expiry, want twice", client.links["soon"])
+	}
+
+	// Torrents no longer reported are forgotten
+	client.expiring = client.expiring[1:]
+	storage.checkExpiry(context.Background(), "realdebrid", db, client, handled)
+	if _, ok := handled["soon"]; ok {
+		t.Error("expiry of a torrent no longer reported kept")
+	}
+
+	// notify doesn't access the torrent
+	db.config.ExpiryAction = config.ExpiryActionNotify
+	client.expiring = []*types.Torrent{{Id: "notified", Name: "Notified", ExpiresAt: soon}}
+	storage.checkExpiry(context.Background(), "realdebrid", db, client, handled)
+	if client.links["notified"] != 0 {
+		t.Error("torrent accessed with the notify expiry_action")
+	}
+}
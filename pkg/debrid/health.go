@@ -0,0 +1,144 @@
+package debrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"sync"
+	"time"
+)
+
+// HealthStatus is the state of a debrid API as seen by the health checks
+type HealthStatus string
+
+const (
+	HealthOK       HealthStatus = "ok"       // The last check succeeded
+	HealthDegraded HealthStatus = "degraded" // The last checks failed, fewer than healthDownAfter times in a row
+	HealthDown     HealthStatus = "down"     // The checks keep failing, or the key is rejected
+)
+
+const (
+	healthDownAfter    = 3                // Failed checks in a row before a debrid is down
+	healthCheckTimeout = 30 * time.Second // Timeout of a single check, retries included
+)
+
+// Health is the result of the health checks of a debrid
+type Health struct {
+	Status      HealthStatus `json:"status"`
+	LastCheck   time.Time    `json:"last_check,omitzero"`
+	LastSuccess time.Time    `json:"last_success,omitzero"`
+	LastError   string       `json:"last_error,omitempty"`
+	Code        string       `json:"code,omitempty"` // HTTPError code of the last error, or the auth state
+	failures    int
+}
+
+type healthState struct {
+	mu     sync.Mutex
+	health Health
+}
+
+// HealthCheck pings the debrid API and records the result, returned by Health
+func (de *Debrid) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	err := de.client.HealthCheck(ctx)
+
+	de.health.mu.Lock()
+	defer de.health.mu.Unlock()
+	h := &de.health.health
+	h.LastCheck = time.Now()
+	if err == nil {
+		h.Status = HealthOK
+		h.LastSuccess = h.LastCheck
+		h.LastError, h.Code = "", ""
+		h.failures = 0
+		return nil
+	}
+	h.failures++
+	h.LastError = err.Error()
+	h.Code = healthErrorCode(err)
+	h.Status = HealthDegraded
+	if h.failures >= healthDownAfter || errors.Is(err, request.ErrInvalidKey) || errors.Is(err, request.ErrForbidden) {
+		h.Status = HealthDown
+	}
+	return err
+}
+
+// Health returns the result of the last health checks, ok until the first check
+func (de *Debrid) Health() Health {
+	de.health.mu.Lock()
+	defer de.health.mu.Unlock()
+	h := de.health.health
+	if h.Status == "" {
+		h.Status = HealthOK
+	}
+	return h
+}
+
+// healthErrorCode maps a health check error to the HTTPError code or auth state it carries, "" if none
+func healthErrorCode(err error) string {
+	var httpErr *utils.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Code
+	case errors.Is(err, request.ErrInvalidKey):
+		return request.AuthStateInvalidKey
+	case errors.Is(err, request.ErrForbidden):
+		return request.AuthStateForbidden
+	}
+	return ""
+}
+
+// StartHealthChecks periodically pings every debrid API, until ctx is done
+func (d *Storage) StartHealthChecks(ctx context.Context) {
+	for name, db := range d.Debrids() {
+		interval := db.config.GetHealthCheckInterval()
+		if interval <= 0 {
+			continue
+		}
+		go func(name string, db *Debrid) {
+			d.checkHealth(ctx, name, db)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					d.checkHealth(ctx, name, db)
+				}
+			}
+		}(name, db)
+	}
+}
+
+func (d *Storage) checkHealth(ctx context.Context, name string, db *Debrid) {
+	_logger := db.client.Logger()
+	before := db.Health().Status
+	if err := db.HealthCheck(ctx); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		_logger.Warn().Err(err).Msg("Health check failed")
+	}
+	after := db.Health().Status
+	if before == after || after == HealthDegraded {
+		return
+	}
+
+	event, status := "debrid_down", "error"
+	msg := fmt.Sprintf("%s is down: %s", name, db.Health().LastError)
+	if after == HealthDown {
+		_logger.Error().Msg("Debrid is down")
+	} else {
+		if before != HealthDown {
+			return
+		}
+		event, status = "debrid_recovered", "success"
+		msg = fmt.Sprintf("%s is back up.", name)
+		_logger.Info().Msg("Debrid recovered")
+	}
+	request.Notify(event, status, msg)
+}
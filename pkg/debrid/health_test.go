@@ -0,0 +1,38 @@
+package debrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"testing"
+)
+
+// healthClient is a fake client failing its health checks with err
+type healthClient struct {
+	*fakeClient
+	err error
+}
+
+func (h *healthClient) HealthCheck(context.Context) error { return h.err }
+
+func TestHealthCheckAuthErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus HealthStatus
+		wantCode   string
+	}{
+		{"ok", nil, HealthOK, ""},
+		{"invalid key", fmt.Errorf("%w: bad token", request.ErrInvalidKey), HealthDown, request.AuthStateInvalidKey},
+		{"forbidden", fmt.Errorf("%w: ip blocked", request.ErrForbidden), HealthDown, request.AuthStateForbidden},
+		{"server error", errors.New("HTTP error 500"), HealthDegraded, ""},
+	}
+	for _, tt := range tests {
+		de := &Debrid{client: &healthClient{fakeClient: newFakeClient(), err: tt.err}}
+		_ = de.HealthCheck(context.Background())
+		if h := de.Health(); h.Status != tt.wantStatus || h.Code != tt.wantCode {
+			t.Errorf("%s: health = %s with code %q, want %s with code %q", tt.name, h.Status, h.Code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
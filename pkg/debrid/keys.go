@@ -0,0 +1,112 @@
+package debrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// keyQuotaInterval is how often the quota left of the download keys is fetched from the providers reporting it
+const keyQuotaInterval = 15 * time.Minute
+
+// DownloadKeys returns the status of the download keys of the debrid
+func (de *Debrid) DownloadKeys() []types.AccountStatus {
+	return de.client.Accounts().Status()
+}
+
+// restoreKeyStates sets the rotation state of the download keys of the debrids from download_keys.json
+func (d *Storage) restoreKeyStates() {
+	data, err := os.ReadFile(keysFile())
+	if err != nil {
+		return
+	}
+	var states map[string]types.AccountsState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return
+	}
+	for name, db := range d.debrids {
+		if state, ok := states[name]; ok {
+			db.client.Accounts().Restore(state)
+		}
+	}
+}
+
+// saveKeyStates saves the rotation state of the download keys of the debrids to download_keys.json, if it changed
+// since the last save
+func (d *Storage) saveKeyStates() error {
+	states := make(map[string]types.AccountsState)
+	for name, db := range d.Debrids() {
+		if state := db.client.Accounts().State(); len(state.Keys) > 0 {
+			states[name] = state
+		}
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	d.keysMu.Lock()
+	defer d.keysMu.Unlock()
+	if bytes.Equal(data, d.keysSaved) {
+		return nil
+	}
+	if err := config.Get().WriteFile(keysFile(), data); err != nil {
+		return err
+	}
+	d.keysSaved = data
+	return nil
+}
+
+// refreshKeyQuotas fetches the quota left of the download keys of the debrids reporting it
+func (d *Storage) refreshKeyQuotas() {
+	for _, db := range d.Debrids() {
+		reporter, ok := db.client.(types.KeyQuotaReporter)
+		if !ok {
+			continue
+		}
+		_logger := db.client.Logger()
+		for _, account := range db.client.Accounts().All() {
+			remaining, err := reporter.GetKeyQuota(account)
+			if err != nil {
+				_logger.Debug().Err(err).Int("key", account.Order).Msg("Failed to get the quota of the download key")
+				continue
+			}
+			account.SetRemaining(remaining)
+		}
+	}
+}
+
+// StartKeyTracking periodically saves the rotation state of the download keys, so it survives a restart, and fetches
+// the quota left of the keys, until ctx is done
+func (d *Storage) StartKeyTracking(ctx context.Context) {
+	_logger := logger.Default()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		var quotasFetched time.Time
+		for {
+			if time.Since(quotasFetched) >= keyQuotaInterval {
+				d.refreshKeyQuotas()
+				quotasFetched = time.Now()
+			}
+			if err := d.saveKeyStates(); err != nil {
+				_logger.Error().Err(err).Msg("Failed to save the download keys state")
+			}
+			select {
+			case <-ctx.Done():
+				_ = d.saveKeyStates()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func keysFile() string {
+	return filepath.Join(config.Get().Path, "download_keys.json")
+}
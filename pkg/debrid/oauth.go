@@ -0,0 +1,32 @@
+package debrid
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/providers/realdebrid"
+)
+
+// OAuthLogin logs a debrid with an oauth_client_id in with the OAuth device flow and saves its token.
+// prompt is called with the URL the user opens and the code they enter there, the login then waits for them
+// until the code expires or ctx is done. The debrid's clients pick the token up when they are next created.
+func OAuthLogin(ctx context.Context, dc config.Debrid, prompt func(verificationURL, userCode string)) error {
+	if dc.OAuthClientID == "" {
+		return fmt.Errorf("%s has no oauth_client_id", dc.Name)
+	}
+	switch dc.Provider() {
+	case "realdebrid":
+		code, err := realdebrid.StartDeviceFlow(ctx, dc.OAuthClientID, dc.Proxy)
+		if err != nil {
+			return err
+		}
+		prompt(code.VerificationURL, code.UserCode)
+		token, err := realdebrid.PollDeviceFlow(ctx, dc.OAuthClientID, dc.Proxy, code)
+		if err != nil {
+			return err
+		}
+		return config.Get().SaveOAuthToken(dc.Name, token)
+	default:
+		return fmt.Errorf("%s doesn't support OAuth", dc.Provider())
+	}
+}
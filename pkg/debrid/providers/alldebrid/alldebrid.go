@@ -1,6 +1,7 @@
 package alldebrid
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
@@ -37,18 +38,45 @@ func (ad *AllDebrid) GetProfile() (*types.Profile, error) {
 	return nil, nil
 }
 
+func (ad *AllDebrid) HealthCheck(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/user", ad.Host), nil)
+	resp, err := ad.client.MakeRequest(req)
+	if err != nil {
+		return err
+	}
+	var data struct {
+		Status string         `json:"status"`
+		Error  *errorResponse `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &data); err != nil {
+		return err
+	}
+	if data.Status != "success" {
+		if data.Error != nil {
+			return fmt.Errorf("error checking account: %s: %s", data.Error.Code, data.Error.Message)
+		}
+		return fmt.Errorf("error checking account: status %s", data.Status)
+	}
+	return nil
+}
+
 func New(dc config.Debrid) (*AllDebrid, error) {
-	rl := request.ParseRateLimit(dc.RateLimit)
+	rl := request.RateLimiter(dc.Name+"/api", dc.RateLimit)
 
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Bearer %s", dc.APIKey),
 	}
 	_log := logger.New(dc.Name)
+	softBan := request.NewSoftBanGuard(dc.SoftBanThreshold, dc.GetSoftBanWindow(), dc.GetSoftBanCooldown(), dc.SoftBanRateLimit, _log)
 	client := request.New(
 		request.WithHeaders(headers),
 		request.WithLogger(_log),
 		request.WithRateLimiter(rl),
 		request.WithProxy(dc.Proxy),
+		request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+		request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+		request.WithSoftBanGuard(softBan),
+		request.WithMetrics(dc.Name),
 	)
 
 	autoExpiresLinksAfter, err := time.ParseDuration(dc.AutoExpireLinksAfter)
@@ -56,8 +84,8 @@ func New(dc config.Debrid) (*AllDebrid, error) {
 		autoExpiresLinksAfter = 48 * time.Hour
 	}
 	return &AllDebrid{
-		name:                  "alldebrid",
-		Host:                  "http://api.alldebrid.com/v4.1",
+		name:                  dc.Name,
+		Host:                  dc.GetEndpoint("http://api.alldebrid.com/v4.1"),
 		APIKey:                dc.APIKey,
 		accounts:              types.NewAccounts(dc),
 		DownloadUncached:      dc.DownloadUncached,
@@ -79,13 +107,13 @@ func (ad *AllDebrid) Logger() zerolog.Logger {
 	return ad.logger
 }
 
-func (ad *AllDebrid) IsAvailable(hashes []string) map[string]bool {
+func (ad *AllDebrid) IsAvailable(hashes []string) (map[string]bool, error) {
 	// Check if the infohashes are available in the local cache
 	result := make(map[string]bool)
 
 	// Divide hashes into groups of 100
 	// AllDebrid does not support checking cached infohashes
-	return result
+	return result, nil
 }
 
 func (ad *AllDebrid) SubmitMagnet(torrent *types.Torrent) (*types.Torrent, error) {
@@ -125,7 +153,7 @@ func getAlldebridStatus(statusCode int) string {
 	}
 }
 
-func (ad *AllDebrid) flattenFiles(torrentId string, files []MagnetFile, parentPath string, index *int) map[string]types.File {
+func (ad *AllDebrid) flattenFiles(t *types.Torrent, files []MagnetFile, parentPath string, index *int) map[string]types.File {
 	result := make(map[string]types.File)
 
 	cfg := config.Get()
@@ -138,7 +166,7 @@ func (ad *AllDebrid) flattenFiles(torrentId string, files []MagnetFile, parentPa
 
 		if f.Elements != nil {
 			// This is a folder, recurse into it
-			subFiles := ad.flattenFiles(torrentId, f.Elements, currentPath, index)
+			subFiles := ad.flattenFiles(t, f.Elements, currentPath, index)
 			for k, v := range subFiles {
 				if _, ok := result[k]; ok {
 					// File already exists, use path as key
@@ -159,13 +187,13 @@ func (ad *AllDebrid) flattenFiles(torrentId string, files []MagnetFile, parentPa
 				continue
 			}
 
-			if !cfg.IsSizeAllowed(f.Size) {
+			if !cfg.IsSizeAllowedFor(f.Size, ad.name, t.ArrName()) {
 				continue
 			}
 
 			*index++
 			file := types.File{
-				TorrentId: torrentId,
+				TorrentId: t.Id,
 				Id:        strconv.Itoa(*index),
 				Name:      fileName,
 				Size:      f.Size,
@@ -212,7 +240,7 @@ func (ad *AllDebrid) GetTorrent(torrentId string) (*types.Torrent, error) {
 	if status == "downloaded" {
 		t.Progress = 100
 		index := -1
-		files := ad.flattenFiles(t.Id, data.Files, "", &index)
+		files := ad.flattenFiles(t, data.Files, "", &index)
 		t.Files = files
 	} else {
 		t.Progress = float64(data.Downloaded) / float64(data.Size) * 100
@@ -250,7 +278,7 @@ func (ad *AllDebrid) UpdateTorrent(t *types.Torrent) error {
 	if status == "downloaded" {
 		t.Progress = 100
 		index := -1
-		files := ad.flattenFiles(t.Id, data.Files, "", &index)
+		files := ad.flattenFiles(t, data.Files, "", &index)
 		t.Files = files
 	} else {
 		t.Progress = float64(data.Downloaded) / float64(data.Size) * 100
@@ -374,6 +402,7 @@ func (ad *AllDebrid) GetDownloadLink(t *types.Torrent, file *types.File) (*types
 	if link == "" {
 		return nil, fmt.Errorf("download link is empty")
 	}
+	ad.accounts.Unlocked()
 	now := time.Now()
 	return &types.DownloadLink{
 		Link:         file.Link,
@@ -452,3 +481,15 @@ func (ad *AllDebrid) GetAvailableSlots() (int, error) {
 func (ad *AllDebrid) Accounts() *types.Accounts {
 	return ad.accounts
 }
+
+func (ad *AllDebrid) InConservativeMode() bool {
+	return ad.client.InConservativeMode()
+}
+
+func (ad *AllDebrid) AuthState() string {
+	return ad.client.AuthState()
+}
+
+func (ad *AllDebrid) RateLimitHeadroom() (request.RateLimitHeadroom, bool) {
+	return ad.client.RateLimitHeadroom()
+}
@@ -2,6 +2,7 @@ package debrid_link
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
@@ -26,25 +27,31 @@ type DebridLink struct {
 
 	autoExpiresLinksAfter time.Duration
 
-	MountPath   string
-	logger      zerolog.Logger
-	checkCached bool
-	addSamples  bool
+	MountPath            string
+	logger               zerolog.Logger
+	checkCached          bool
+	checkCachedBatchSize int
+	addSamples           bool
 }
 
 func New(dc config.Debrid) (*DebridLink, error) {
-	rl := request.ParseRateLimit(dc.RateLimit)
+	rl := request.RateLimiter(dc.Name+"/api", dc.RateLimit)
 
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Bearer %s", dc.APIKey),
 		"Content-Type":  "application/json",
 	}
 	_log := logger.New(dc.Name)
+	softBan := request.NewSoftBanGuard(dc.SoftBanThreshold, dc.GetSoftBanWindow(), dc.GetSoftBanCooldown(), dc.SoftBanRateLimit, _log)
 	client := request.New(
 		request.WithHeaders(headers),
 		request.WithLogger(_log),
 		request.WithRateLimiter(rl),
 		request.WithProxy(dc.Proxy),
+		request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+		request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+		request.WithSoftBanGuard(softBan),
+		request.WithMetrics(dc.Name),
 	)
 
 	autoExpiresLinksAfter, err := time.ParseDuration(dc.AutoExpireLinksAfter)
@@ -52,8 +59,8 @@ func New(dc config.Debrid) (*DebridLink, error) {
 		autoExpiresLinksAfter = 48 * time.Hour
 	}
 	return &DebridLink{
-		name:                  "debridlink",
-		Host:                  "https://debrid-link.com/api/v2",
+		name:                  dc.Name,
+		Host:                  dc.GetEndpoint("https://debrid-link.com/api/v2"),
 		APIKey:                dc.APIKey,
 		accounts:              types.NewAccounts(dc),
 		DownloadUncached:      dc.DownloadUncached,
@@ -62,6 +69,7 @@ func New(dc config.Debrid) (*DebridLink, error) {
 		MountPath:             dc.Folder,
 		logger:                logger.New(dc.Name),
 		checkCached:           dc.CheckCached,
+		checkCachedBatchSize:  dc.GetCheckCachedBatchSize(),
 		addSamples:            dc.AddSamples,
 	}, nil
 }
@@ -70,6 +78,22 @@ func (dl *DebridLink) GetProfile() (*types.Profile, error) {
 	return nil, nil
 }
 
+func (dl *DebridLink) HealthCheck(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/account/infos", dl.Host), nil)
+	resp, err := dl.client.MakeRequest(req)
+	if err != nil {
+		return err
+	}
+	var data APIResponse[json.RawMessage]
+	if err := json.Unmarshal(resp, &data); err != nil {
+		return err
+	}
+	if !data.Success {
+		return fmt.Errorf("error checking account")
+	}
+	return nil
+}
+
 func (dl *DebridLink) Name() string {
 	return dl.name
 }
@@ -78,13 +102,13 @@ func (dl *DebridLink) Logger() zerolog.Logger {
 	return dl.logger
 }
 
-func (dl *DebridLink) IsAvailable(hashes []string) map[string]bool {
+func (dl *DebridLink) IsAvailable(hashes []string) (map[string]bool, error) {
 	// Check if the infohashes are available in the local cache
 	result := make(map[string]bool)
 
-	// Divide hashes into groups of 100
-	for i := 0; i < len(hashes); i += 100 {
-		end := i + 100
+	// Divide hashes into batches the provider accepts
+	for i := 0; i < len(hashes); i += dl.checkCachedBatchSize {
+		end := i + dl.checkCachedBatchSize
 		if end > len(hashes) {
 			end = len(hashes)
 		}
@@ -107,17 +131,15 @@ func (dl *DebridLink) IsAvailable(hashes []string) map[string]bool {
 		req, _ := http.NewRequest(http.MethodGet, url, nil)
 		resp, err := dl.client.MakeRequest(req)
 		if err != nil {
-			dl.logger.Error().Err(err).Msgf("Error checking availability")
-			return result
+			return result, fmt.Errorf("error checking availability: %w", err)
 		}
 		var data AvailableResponse
 		err = json.Unmarshal(resp, &data)
 		if err != nil {
-			dl.logger.Error().Err(err).Msgf("Error marshalling availability")
-			return result
+			return result, fmt.Errorf("error unmarshalling availability: %w", err)
 		}
 		if data.Value == nil {
-			return result
+			return result, nil
 		}
 		value := *data.Value
 		for _, h := range hashes[i:end] {
@@ -127,7 +149,7 @@ func (dl *DebridLink) IsAvailable(hashes []string) map[string]bool {
 			}
 		}
 	}
-	return result
+	return result, nil
 }
 
 func (dl *DebridLink) GetTorrent(torrentId string) (*types.Torrent, error) {
@@ -165,7 +187,7 @@ func (dl *DebridLink) GetTorrent(torrentId string) (*types.Torrent, error) {
 	}
 	cfg := config.Get()
 	for _, f := range t.Files {
-		if !cfg.IsSizeAllowed(f.Size) {
+		if !cfg.IsSizeAllowedFor(f.Size, dl.name, "") {
 			continue
 		}
 		file := types.File{
@@ -226,7 +248,7 @@ func (dl *DebridLink) UpdateTorrent(t *types.Torrent) error {
 	links := make(map[string]*types.DownloadLink)
 	now := time.Now()
 	for _, f := range data.Files {
-		if !cfg.IsSizeAllowed(f.Size) {
+		if !cfg.IsSizeAllowedFor(f.Size, dl.name, t.ArrName()) {
 			continue
 		}
 		file := types.File{
@@ -430,7 +452,7 @@ func (dl *DebridLink) getTorrents(page, perPage int) ([]*types.Torrent, error) {
 		cfg := config.Get()
 		now := time.Now()
 		for _, f := range t.Files {
-			if !cfg.IsSizeAllowed(f.Size) {
+			if !cfg.IsSizeAllowedFor(f.Size, dl.name, "") {
 				continue
 			}
 			file := types.File{
@@ -479,3 +501,15 @@ func (dl *DebridLink) GetAvailableSlots() (int, error) {
 func (dl *DebridLink) Accounts() *types.Accounts {
 	return dl.accounts
 }
+
+func (dl *DebridLink) InConservativeMode() bool {
+	return dl.client.InConservativeMode()
+}
+
+func (dl *DebridLink) AuthState() string {
+	return dl.client.AuthState()
+}
+
+func (dl *DebridLink) RateLimitHeadroom() (request.RateLimitHeadroom, bool) {
+	return dl.client.RateLimitHeadroom()
+}
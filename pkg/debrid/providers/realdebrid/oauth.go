@@ -0,0 +1,200 @@
+package realdebrid
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"net/http"
+	gourl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oauthHost       = "https://api.real-debrid.com/oauth/v2"
+	deviceGrantType = "http://oauth.net/grant_type/device/1.0"
+
+	// oauthAccount stands for the OAuth access token in the download accounts, when no download_api_keys are set
+	oauthAccount = "oauth"
+
+	// tokenRefreshMargin is how long before its expiry an access token is refreshed
+	tokenRefreshMargin = time.Minute
+)
+
+// DeviceCode is the code the user enters on VerificationURL to log in
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	Interval        int    `json:"interval"`   // Seconds between two polls
+	ExpiresIn       int    `json:"expires_in"` // Seconds the code is valid for
+	VerificationURL string `json:"verification_url"`
+}
+
+type deviceCredentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newOAuthClient returns the client of the OAuth endpoints, without the Authorization of the API
+func newOAuthClient(proxy string) *request.Client {
+	return request.New(
+		request.WithProxy(proxy),
+		request.WithLogger(zerolog.Nop()), // Polling the device flow is answered 403 until the user logs in
+		request.WithMaxRetries(3),
+		request.WithRetryableStatus(429, 502),
+	)
+}
+
+// StartDeviceFlow asks for a device code, new_credentials gets a client id and secret bound to the user,
+// as the client id of open source apps can't refresh tokens
+func StartDeviceFlow(ctx context.Context, clientID, proxy string) (*DeviceCode, error) {
+	query := gourl.Values{}
+	query.Set("client_id", clientID)
+	query.Set("new_credentials", "yes")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oauthHost+"/device/code?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newOAuthClient(proxy).MakeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("realdebrid device code: %w", err)
+	}
+	var code DeviceCode
+	if err := json.Unmarshal(resp, &code); err != nil {
+		return nil, fmt.Errorf("realdebrid device code: %w", err)
+	}
+	if code.DeviceCode == "" {
+		return nil, fmt.Errorf("realdebrid device code: empty response")
+	}
+	return &code, nil
+}
+
+// PollDeviceFlow waits for the user to enter the code, until it expires or ctx is done, and returns the token
+func PollDeviceFlow(ctx context.Context, clientID, proxy string, code *DeviceCode) (config.OAuthToken, error) {
+	client := newOAuthClient(proxy)
+	interval := time.Duration(max(code.Interval, 5)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+	defer cancel()
+
+	query := gourl.Values{}
+	query.Set("client_id", clientID)
+	query.Set("code", code.DeviceCode)
+	for {
+		select {
+		case <-ctx.Done():
+			return config.OAuthToken{}, fmt.Errorf("realdebrid login not completed: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, oauthHost+"/device/credentials?"+query.Encode(), nil)
+		if err != nil {
+			return config.OAuthToken{}, err
+		}
+		resp, err := client.MakeRequest(req)
+		if errors.Is(err, request.ErrForbidden) {
+			continue // The user hasn't entered the code yet
+		}
+		if err != nil {
+			return config.OAuthToken{}, fmt.Errorf("realdebrid device credentials: %w", err)
+		}
+		var credentials deviceCredentials
+		if err := json.Unmarshal(resp, &credentials); err != nil {
+			return config.OAuthToken{}, fmt.Errorf("realdebrid device credentials: %w", err)
+		}
+		return requestToken(ctx, client, credentials.ClientID, credentials.ClientSecret, code.DeviceCode)
+	}
+}
+
+// requestToken exchanges a device code or a refresh token for an access token
+func requestToken(ctx context.Context, client *request.Client, clientID, clientSecret, code string) (config.OAuthToken, error) {
+	form := gourl.Values{}
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("code", code)
+	form.Set("grant_type", deviceGrantType)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthHost+"/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return config.OAuthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.MakeRequest(req)
+	if err != nil {
+		return config.OAuthToken{}, fmt.Errorf("realdebrid token: %w", err)
+	}
+	var data tokenResponse
+	if err := json.Unmarshal(resp, &data); err != nil {
+		return config.OAuthToken{}, fmt.Errorf("realdebrid token: %w", err)
+	}
+	if data.AccessToken == "" {
+		return config.OAuthToken{}, fmt.Errorf("realdebrid token: empty access token")
+	}
+	return config.OAuthToken{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AccessToken:  data.AccessToken,
+		RefreshToken: data.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(data.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// oauthTokenSource provides the access token of a debrid logged in with the device flow, refreshing it
+// with the refresh token once it expires. Refreshed tokens are saved to the OAuth file.
+type oauthTokenSource struct {
+	debrid string
+	client *request.Client
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	token config.OAuthToken
+}
+
+func newOAuthTokenSource(dc config.Debrid, logger zerolog.Logger) (*oauthTokenSource, error) {
+	token, ok := config.Get().GetOAuthToken(dc.Name)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't logged in, log in from the UI or run decypharr with -oauth-login %s", dc.Name, dc.Name)
+	}
+	return &oauthTokenSource{
+		debrid: dc.Name,
+		client: newOAuthClient(dc.Proxy),
+		logger: logger,
+		token:  token,
+	}, nil
+}
+
+func (s *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.AccessToken != "" && time.Until(s.token.ExpiresAt) > tokenRefreshMargin {
+		return s.token.AccessToken, nil
+	}
+
+	token, err := requestToken(ctx, s.client, s.token.ClientID, s.token.ClientSecret, s.token.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	token.RefreshToken = cmp.Or(token.RefreshToken, s.token.RefreshToken)
+	s.token = token
+	s.logger.Debug().Time("expires_at", token.ExpiresAt).Msg("Refreshed the OAuth access token")
+	if err := config.Get().SaveOAuthToken(s.debrid, token); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save the refreshed OAuth token")
+	}
+	return token.AccessToken, nil
+}
+
+func (s *oauthTokenSource) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token.ExpiresAt = time.Time{}
+}
@@ -3,6 +3,7 @@ package realdebrid
 import (
 	"bytes"
 	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	gourl "net/url"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +26,9 @@ import (
 	"github.com/sirrobot01/decypharr/pkg/rar"
 )
 
+// profileTTL is how long the account profile is cached, so premium expiry is noticed
+const profileTTL = 10 * time.Minute
+
 type RealDebrid struct {
 	name string
 	Host string `json:"host"`
@@ -41,32 +46,49 @@ type RealDebrid struct {
 	logger    zerolog.Logger
 	UnpackRar bool
 
-	rarSemaphore    chan struct{}
-	checkCached     bool
-	addSamples      bool
-	Profile         *types.Profile
-	minimumFreeSlot int // Minimum number of active pots to maintain (used for cached stuffs, etc.)
+	rarSemaphore         chan struct{}
+	checkCached          bool
+	checkCachedBatchSize int
+	addSamples           bool
+	Profile              *types.Profile
+	minimumFreeSlot      int // Minimum number of active pots to maintain (used for cached stuffs, etc.)
 
+	profileMu      sync.Mutex // Guards Profile and profileFetched, concurrent callers wait for a single fetch
+	profileFetched time.Time  // When Profile was last fetched, it's re-fetched after profileTTL
 }
 
 func New(dc config.Debrid) (*RealDebrid, error) {
-	rl := request.ParseRateLimit(dc.RateLimit)
-	repairRl := request.ParseRateLimit(cmp.Or(dc.RepairRateLimit, dc.RateLimit))
-	downloadRl := request.ParseRateLimit(cmp.Or(dc.DownloadRateLimit, dc.RateLimit))
+	rl := request.RateLimiter(dc.Name+"/api", dc.RateLimit)
+	repairRl := request.RateLimiter(dc.Name+"/repair", cmp.Or(dc.RepairRateLimit, dc.RateLimit))
 
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Bearer %s", dc.APIKey),
 	}
 	_log := logger.New(dc.Name)
+	softBan := request.NewSoftBanGuard(dc.SoftBanThreshold, dc.GetSoftBanWindow(), dc.GetSoftBanCooldown(), dc.SoftBanRateLimit, _log)
 
 	autoExpiresLinksAfter, err := time.ParseDuration(dc.AutoExpireLinksAfter)
 	if autoExpiresLinksAfter == 0 || err != nil {
 		autoExpiresLinksAfter = 48 * time.Hour
 	}
 
+	// With OAuth, the requests are authorized with the access token, download accounts included when none are set
+	var tokenSource, downloadTokenSource request.TokenSource
+	if dc.OAuthClientID != "" {
+		oauth, err := newOAuthTokenSource(dc, _log)
+		if err != nil {
+			return nil, err
+		}
+		tokenSource = oauth
+		if !slices.ContainsFunc(dc.DownloadAPIKeys, func(key string) bool { return key != "" }) {
+			dc.DownloadAPIKeys = []string{oauthAccount}
+			downloadTokenSource = oauth
+		}
+	}
+
 	r := &RealDebrid{
-		name:                  "realdebrid",
-		Host:                  "https://api.real-debrid.com/rest/1.0",
+		name:                  dc.Name,
+		Host:                  dc.GetEndpoint("https://api.real-debrid.com/rest/1.0"),
 		APIKey:                dc.APIKey,
 		accounts:              types.NewAccounts(dc),
 		DownloadUncached:      dc.DownloadUncached,
@@ -74,33 +96,49 @@ func New(dc config.Debrid) (*RealDebrid, error) {
 		UnpackRar:             dc.UnpackRar,
 		client: request.New(
 			request.WithHeaders(headers),
+			request.WithTokenSource(tokenSource),
 			request.WithRateLimiter(rl),
 			request.WithLogger(_log),
 			request.WithMaxRetries(10),
 			request.WithRetryableStatus(429, 502),
 			request.WithProxy(dc.Proxy),
+			request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+			request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+			request.WithSoftBanGuard(softBan),
+			request.WithMetrics(dc.Name),
 		),
 		downloadClient: request.New(
-			request.WithRateLimiter(downloadRl),
+			request.WithTokenSource(downloadTokenSource),
+			request.WithRateLimit(dc.Name+"/download", cmp.Or(dc.DownloadRateLimit, dc.RateLimit), dc.RateLimitScope, dc.DownloadAPIKeys...), // One client for all the download_api_keys
 			request.WithLogger(_log),
 			request.WithMaxRetries(10),
 			request.WithRetryableStatus(429, 447, 502),
 			request.WithProxy(dc.Proxy),
+			request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+			request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+			request.WithSoftBanGuard(softBan),
+			request.WithMetrics(dc.Name),
 		),
 		repairClient: request.New(
 			request.WithRateLimiter(repairRl),
 			request.WithHeaders(headers),
+			request.WithTokenSource(tokenSource),
 			request.WithLogger(_log),
 			request.WithMaxRetries(4),
 			request.WithRetryableStatus(429, 502),
 			request.WithProxy(dc.Proxy),
+			request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+			request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+			request.WithSoftBanGuard(softBan),
+			request.WithMetrics(dc.Name),
 		),
-		MountPath:       dc.Folder,
-		logger:          logger.New(dc.Name),
-		rarSemaphore:    make(chan struct{}, 2),
-		checkCached:     dc.CheckCached,
-		addSamples:      dc.AddSamples,
-		minimumFreeSlot: dc.MinimumFreeSlot,
+		MountPath:            dc.Folder,
+		logger:               logger.New(dc.Name),
+		rarSemaphore:         make(chan struct{}, 2),
+		checkCached:          dc.CheckCached,
+		checkCachedBatchSize: dc.GetCheckCachedBatchSize(),
+		addSamples:           dc.AddSamples,
+		minimumFreeSlot:      dc.MinimumFreeSlot,
 	}
 
 	if _, err := r.GetProfile(); err != nil {
@@ -219,9 +257,15 @@ func (r *RealDebrid) handleRarArchive(t *types.Torrent, data torrentInfo, select
 	}
 
 	now := time.Now()
+	cfg := config.Get()
+	filtered := 0
 
 	for _, rarFile := range rarFiles {
 		if file, exists := fileMap[rarFile.Name()]; exists {
+			if cfg.ArchiveFilter != config.ArchiveFilterArchive && !isAllowedArchiveFile(cfg, r.name, t.ArrName(), rarFile) {
+				filtered++
+				continue
+			}
 			file.IsRar = true
 			file.ByteRange = rarFile.ByteRange()
 			file.Link = data.Links[0]
@@ -231,10 +275,18 @@ func (r *RealDebrid) handleRarArchive(t *types.Torrent, data torrentInfo, select
 			r.logger.Warn().Msgf("RAR file %s not found in torrent files", rarFile.Name())
 		}
 	}
+	if filtered > 0 {
+		r.logger.Info().Msgf("Left out %d files of the RAR archive of %s not matching the allowed file types or sizes", filtered, t.Name)
+	}
 
 	return files, nil
 }
 
+// isAllowedArchiveFile checks a file unpacked from an archive against allowed_file_types and the file size limits
+func isAllowedArchiveFile(cfg *config.Config, debrid, arr string, f *rar.File) bool {
+	return cfg.IsAllowedFile(f.Name()) && cfg.IsSizeAllowedFor(f.Size, debrid, arr)
+}
+
 // getTorrentFiles returns a list of torrent files from the torrent info
 // validate is used to determine if the files should be validated
 // if validate is false, selected files will be returned
@@ -253,7 +305,7 @@ func (r *RealDebrid) getTorrentFiles(t *types.Torrent, data torrentInfo) map[str
 		if !cfg.IsAllowedFile(name) {
 			continue
 		}
-		if !cfg.IsSizeAllowed(f.Bytes) {
+		if !cfg.IsSizeAllowedFor(f.Bytes, r.name, t.ArrName()) {
 			continue
 		}
 
@@ -270,13 +322,13 @@ func (r *RealDebrid) getTorrentFiles(t *types.Torrent, data torrentInfo) map[str
 	return files
 }
 
-func (r *RealDebrid) IsAvailable(hashes []string) map[string]bool {
+func (r *RealDebrid) IsAvailable(hashes []string) (map[string]bool, error) {
 	// Check if the infohashes are available in the local cache
 	result := make(map[string]bool)
 
-	// Divide hashes into groups of 100
-	for i := 0; i < len(hashes); i += 200 {
-		end := i + 200
+	// Divide hashes into batches the provider accepts
+	for i := 0; i < len(hashes); i += r.checkCachedBatchSize {
+		end := i + r.checkCachedBatchSize
 		if end > len(hashes) {
 			end = len(hashes)
 		}
@@ -299,14 +351,12 @@ func (r *RealDebrid) IsAvailable(hashes []string) map[string]bool {
 		req, _ := http.NewRequest(http.MethodGet, url, nil)
 		resp, err := r.client.MakeRequest(req)
 		if err != nil {
-			r.logger.Error().Err(err).Msgf("Error checking availability")
-			return result
+			return result, fmt.Errorf("error checking availability: %w", err)
 		}
 		var data AvailabilityResponse
 		err = json.Unmarshal(resp, &data)
 		if err != nil {
-			r.logger.Error().Err(err).Msgf("Error marshalling availability")
-			return result
+			return result, fmt.Errorf("error unmarshalling availability: %w", err)
 		}
 		for _, h := range hashes[i:end] {
 			hosters, exists := data[strings.ToLower(h)]
@@ -315,7 +365,7 @@ func (r *RealDebrid) IsAvailable(hashes []string) map[string]bool {
 			}
 		}
 	}
-	return result
+	return result, nil
 }
 
 func (r *RealDebrid) SubmitMagnet(t *types.Torrent) (*types.Torrent, error) {
@@ -631,7 +681,14 @@ func (r *RealDebrid) CheckLink(link string) error {
 	return nil
 }
 
-func (r *RealDebrid) _getDownloadLink(file *types.File) (*types.DownloadLink, error) {
+// authorize sends req with the key of account, the download client being shared by all the download accounts
+func (r *RealDebrid) authorize(req *http.Request, account *types.Account) {
+	if account != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", account.Token))
+	}
+}
+
+func (r *RealDebrid) _getDownloadLink(file *types.File, account *types.Account) (*types.DownloadLink, error) {
 	url := fmt.Sprintf("%s/unrestrict/link/", r.Host)
 	_link := file.Link
 	if strings.HasPrefix(file.Link, "https://real-debrid.com/d/") && len(file.Link) > 39 {
@@ -641,6 +698,7 @@ func (r *RealDebrid) _getDownloadLink(file *types.File) (*types.DownloadLink, er
 		"link": {_link},
 	}
 	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(payload.Encode()))
+	r.authorize(req, account)
 	resp, err := r.downloadClient.Do(req)
 
 	if err != nil {
@@ -685,6 +743,7 @@ func (r *RealDebrid) _getDownloadLink(file *types.File) (*types.DownloadLink, er
 	if data.Download == "" {
 		return nil, fmt.Errorf("realdebrid API error: download link not found")
 	}
+	r.accounts.Unlocked()
 	now := time.Now()
 	return &types.DownloadLink{
 		Filename:     data.Filename,
@@ -697,42 +756,23 @@ func (r *RealDebrid) _getDownloadLink(file *types.File) (*types.DownloadLink, er
 
 }
 
+// GetDownloadLink generates the download link of file with the download accounts in turn, a key whose traffic is
+// exceeded is skipped until the daily reset and the next one is tried
 func (r *RealDebrid) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
-
-	accounts := r.accounts.All()
-
-	for _, account := range accounts {
-		r.downloadClient.SetHeader("Authorization", fmt.Sprintf("Bearer %s", account.Token))
-		downloadLink, err := r._getDownloadLink(file)
-
+	for _, account := range r.accounts.Rotation() {
+		downloadLink, err := r._getDownloadLink(file, account)
 		if err == nil {
+			account.AddTraffic(downloadLink.Size)
+			downloadLink.Account = account
 			return downloadLink, nil
 		}
-
-		retries := 0
-		if errors.Is(err, utils.TrafficExceededError) {
-			// Retries generating
-			retries = 5
-		} else {
-			// If the error is not traffic exceeded, return the error
+		if !errors.Is(err, utils.TrafficExceededError) {
 			return nil, err
 		}
-		backOff := 1 * time.Second
-		for retries > 0 {
-			downloadLink, err = r._getDownloadLink(file)
-			if err == nil {
-				return downloadLink, nil
-			}
-			if !errors.Is(err, utils.TrafficExceededError) {
-				return nil, err
-			}
-			// Add a delay before retrying
-			time.Sleep(backOff)
-			backOff *= 2 // Exponential backoff
-			retries--
-		}
+		r.logger.Warn().Int("key", account.Order).Msg("Download key traffic exceeded, skipping it until the daily reset")
+		r.accounts.Disable(account)
 	}
-	return nil, fmt.Errorf("realdebrid API error: download link not found")
+	return nil, utils.TrafficExceededError
 }
 
 func (r *RealDebrid) getTorrents(offset int, limit int) (int, []*types.Torrent, error) {
@@ -833,9 +873,8 @@ func (r *RealDebrid) GetDownloadLinks() (map[string]*types.DownloadLink, error)
 		return links, fmt.Errorf("no active download keys")
 	}
 	activeAccount := accounts[0]
-	r.downloadClient.SetHeader("Authorization", fmt.Sprintf("Bearer %s", activeAccount.Token))
 	for {
-		dl, err := r._getDownloads(offset, limit)
+		dl, err := r._getDownloads(offset, limit, activeAccount)
 		if err != nil {
 			break
 		}
@@ -857,12 +896,13 @@ func (r *RealDebrid) GetDownloadLinks() (map[string]*types.DownloadLink, error)
 	return links, nil
 }
 
-func (r *RealDebrid) _getDownloads(offset int, limit int) ([]types.DownloadLink, error) {
+func (r *RealDebrid) _getDownloads(offset int, limit int, account *types.Account) ([]types.DownloadLink, error) {
 	url := fmt.Sprintf("%s/downloads?limit=%d", r.Host, limit)
 	if offset > 0 {
 		url = fmt.Sprintf("%s&offset=%d", url, offset)
 	}
 	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	r.authorize(req, account)
 	resp, err := r.downloadClient.MakeRequest(req)
 	if err != nil {
 		return nil, err
@@ -902,14 +942,36 @@ func (r *RealDebrid) GetMountPath() string {
 func (r *RealDebrid) DeleteDownloadLink(linkId string) error {
 	url := fmt.Sprintf("%s/downloads/delete/%s", r.Host, linkId)
 	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	r.authorize(req, r.accounts.Current())
 	if _, err := r.downloadClient.MakeRequest(req); err != nil {
 		return err
 	}
 	return nil
 }
 
+// GetKeyQuota returns the traffic left per limited hoster of the key of account
+func (r *RealDebrid) GetKeyQuota(account *types.Account) (map[string]int64, error) {
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/traffic", r.Host), nil)
+	r.authorize(req, account)
+	resp, err := r.downloadClient.MakeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]trafficResponse
+	if err := json.Unmarshal(resp, &data); err != nil {
+		return nil, fmt.Errorf("realdebrid API error: Error unmarshalling traffic: %w", err)
+	}
+	remaining := make(map[string]int64, len(data))
+	for host, traffic := range data {
+		remaining[host] = traffic.Left
+	}
+	return remaining, nil
+}
+
 func (r *RealDebrid) GetProfile() (*types.Profile, error) {
-	if r.Profile != nil {
+	r.profileMu.Lock()
+	defer r.profileMu.Unlock()
+	if r.Profile != nil && time.Since(r.profileFetched) < profileTTL {
 		return r.Profile, nil
 	}
 	url := fmt.Sprintf("%s/user", r.Host)
@@ -920,7 +982,7 @@ func (r *RealDebrid) GetProfile() (*types.Profile, error) {
 		return nil, err
 	}
 	var data profileResponse
-	if json.Unmarshal(resp, &data) != nil {
+	if err := json.Unmarshal(resp, &data); err != nil {
 		return nil, err
 	}
 	profile := &types.Profile{
@@ -933,9 +995,16 @@ func (r *RealDebrid) GetProfile() (*types.Profile, error) {
 		Type:       data.Type,
 	}
 	r.Profile = profile
+	r.profileFetched = time.Now()
 	return profile, nil
 }
 
+func (r *RealDebrid) HealthCheck(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/user", r.Host), nil)
+	_, err := r.client.MakeRequest(req)
+	return err
+}
+
 func (r *RealDebrid) GetAvailableSlots() (int, error) {
 	url := fmt.Sprintf("%s/torrents/activeCount", r.Host)
 	req, _ := http.NewRequest(http.MethodGet, url, nil)
@@ -953,3 +1022,15 @@ func (r *RealDebrid) GetAvailableSlots() (int, error) {
 func (r *RealDebrid) Accounts() *types.Accounts {
 	return r.accounts
 }
+
+func (r *RealDebrid) InConservativeMode() bool {
+	return r.client.InConservativeMode()
+}
+
+func (r *RealDebrid) AuthState() string {
+	return r.client.AuthState()
+}
+
+func (r *RealDebrid) RateLimitHeadroom() (request.RateLimitHeadroom, bool) {
+	return r.client.RateLimitHeadroom()
+}
@@ -0,0 +1,97 @@
+package realdebrid
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/rar"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestConfig loads an empty config from a temp directory
+func setTestConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+}
+
+func TestIsAllowedArchiveFile(t *testing.T) {
+	cfg := &config.Config{
+		AllowedExt:  []string{"mkv"},
+		MinFileSize: "10MB",
+		Debrids:     []config.Debrid{{Name: "realdebrid", MaxFileSize: "1GB"}},
+	}
+	tests := []struct {
+		path string
+		size int64
+		want bool
+	}{
+		{"Movie/movie.mkv", 100 << 20, true},
+		{"Movie/sample.mkv", 1 << 20, false},  // Under the global min_file_size
+		{"Movie/movie.nfo", 100 << 20, false}, // Not an allowed file type
+		{"Movie/movie.mkv", 2 << 30, false},   // Over the max_file_size of the debrid
+		{"Movie/movie.mkv", 0, true},          // Size not reported
+	}
+	for _, tt := range tests {
+		f := &rar.File{Path: tt.path, Size: tt.size}
+		if got := isAllowedArchiveFile(cfg, "realdebrid", "", f); got != tt.want {
+			t.Errorf("isAllowedArchiveFile(%s, %d) = %v, want %v", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+// The API requests go to the endpoint set, its trailing slash trimmed
+func TestNewEndpoint(t *testing.T) {
+	setTestConfig(t)
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_, _ = w.Write([]byte(`{"id":1,"username":"user","type":"premium"}`))
+	}))
+	defer srv.Close()
+
+	r, err := New(config.Debrid{Name: "realdebrid", APIKey: "key", Endpoint: srv.URL + "/rest/1.0/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Host != srv.URL+"/rest/1.0" || path != "/rest/1.0/user" {
+		t.Errorf("Host = %q, profile requested at %q, want %s/rest/1.0 and /rest/1.0/user", r.Host, path, srv.URL)
+	}
+}
+
+// The premium expiry is read from the account info
+func TestProfilePremiumExpired(t *testing.T) {
+	setTestConfig(t)
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"premium", `{"id":1,"username":"user","type":"premium","expiration":"2999-01-01T00:00:00.000Z"}`, false},
+		{"expired", `{"id":1,"username":"user","type":"premium","expiration":"2020-01-01T00:00:00.000Z"}`, true},
+		{"free", `{"id":1,"username":"user","type":"free","expiration":"2020-01-01T00:00:00.000Z"}`, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(tt.user))
+		}))
+		r, err := New(config.Debrid{Name: "realdebrid", APIKey: "key", Endpoint: srv.URL})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		profile, err := r.GetProfile()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := profile.IsPremiumExpired(); got != tt.want {
+			t.Errorf("%s: IsPremiumExpired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -156,3 +156,12 @@ type AvailableSlotsResponse struct {
 	ActiveSlots int `json:"nb"`
 	TotalSlots  int `json:"limit"`
 }
+
+// trafficResponse is the traffic of a limited hoster, /traffic returns one per hoster
+type trafficResponse struct {
+	Left  int64  `json:"left"`  // Quota left, in Type units
+	Bytes int64  `json:"bytes"` // Traffic used
+	Links int    `json:"links"` // Links generated
+	Limit int64  `json:"limit"`
+	Type  string `json:"type"` // links, gigabytes or bytes
+}
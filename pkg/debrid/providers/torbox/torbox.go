@@ -2,6 +2,7 @@ package torbox
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
@@ -33,29 +34,51 @@ type Torbox struct {
 	DownloadUncached bool
 	client           *request.Client
 
-	MountPath   string
-	logger      zerolog.Logger
-	checkCached bool
-	addSamples  bool
+	MountPath            string
+	logger               zerolog.Logger
+	checkCached          bool
+	checkCachedBatchSize int
+	addSamples           bool
 }
 
 func (tb *Torbox) GetProfile() (*types.Profile, error) {
 	return nil, nil
 }
 
+func (tb *Torbox) HealthCheck(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/user/me", tb.Host), nil)
+	resp, err := tb.client.MakeRequest(req)
+	if err != nil {
+		return err
+	}
+	var data APIResponse[json.RawMessage]
+	if err := json.Unmarshal(resp, &data); err != nil {
+		return err
+	}
+	if !data.Success {
+		return fmt.Errorf("error checking account: %v %s", data.Error, data.Detail)
+	}
+	return nil
+}
+
 func New(dc config.Debrid) (*Torbox, error) {
-	rl := request.ParseRateLimit(dc.RateLimit)
+	rl := request.RateLimiter(dc.Name+"/api", dc.RateLimit)
 
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Bearer %s", dc.APIKey),
 		"User-Agent":    fmt.Sprintf("Decypharr/%s (%s; %s)", version.GetInfo(), runtime.GOOS, runtime.GOARCH),
 	}
 	_log := logger.New(dc.Name)
+	softBan := request.NewSoftBanGuard(dc.SoftBanThreshold, dc.GetSoftBanWindow(), dc.GetSoftBanCooldown(), dc.SoftBanRateLimit, _log)
 	client := request.New(
 		request.WithHeaders(headers),
 		request.WithRateLimiter(rl),
 		request.WithLogger(_log),
 		request.WithProxy(dc.Proxy),
+		request.WithRetryOn403ViaProxy(dc.ForbiddenProxy),
+		request.WithConnectionPool(dc.MaxIdleConns, dc.MaxConnsPerHost, dc.GetIdleConnTimeout()),
+		request.WithSoftBanGuard(softBan),
+		request.WithMetrics(dc.Name),
 	)
 	autoExpiresLinksAfter, err := time.ParseDuration(dc.AutoExpireLinksAfter)
 	if autoExpiresLinksAfter == 0 || err != nil {
@@ -63,8 +86,8 @@ func New(dc config.Debrid) (*Torbox, error) {
 	}
 
 	return &Torbox{
-		name:                  "torbox",
-		Host:                  "https://api.torbox.app/v1",
+		name:                  dc.Name,
+		Host:                  dc.GetEndpoint("https://api.torbox.app/v1"),
 		APIKey:                dc.APIKey,
 		accounts:              types.NewAccounts(dc),
 		DownloadUncached:      dc.DownloadUncached,
@@ -73,6 +96,7 @@ func New(dc config.Debrid) (*Torbox, error) {
 		MountPath:             dc.Folder,
 		logger:                _log,
 		checkCached:           dc.CheckCached,
+		checkCachedBatchSize:  dc.GetCheckCachedBatchSize(),
 		addSamples:            dc.AddSamples,
 	}, nil
 }
@@ -85,13 +109,13 @@ func (tb *Torbox) Logger() zerolog.Logger {
 	return tb.logger
 }
 
-func (tb *Torbox) IsAvailable(hashes []string) map[string]bool {
+func (tb *Torbox) IsAvailable(hashes []string) (map[string]bool, error) {
 	// Check if the infohashes are available in the local cache
 	result := make(map[string]bool)
 
-	// Divide hashes into groups of 100
-	for i := 0; i < len(hashes); i += 100 {
-		end := i + 100
+	// Divide hashes into batches the provider accepts
+	for i := 0; i < len(hashes); i += tb.checkCachedBatchSize {
+		end := i + tb.checkCachedBatchSize
 		if end > len(hashes) {
 			end = len(hashes)
 		}
@@ -114,17 +138,15 @@ func (tb *Torbox) IsAvailable(hashes []string) map[string]bool {
 		req, _ := http.NewRequest(http.MethodGet, url, nil)
 		resp, err := tb.client.MakeRequest(req)
 		if err != nil {
-			tb.logger.Error().Err(err).Msgf("Error checking availability")
-			return result
+			return result, fmt.Errorf("error checking availability: %w", err)
 		}
 		var res AvailableResponse
 		err = json.Unmarshal(resp, &res)
 		if err != nil {
-			tb.logger.Error().Err(err).Msgf("Error marshalling availability")
-			return result
+			return result, fmt.Errorf("error unmarshalling availability: %w", err)
 		}
 		if res.Data == nil {
-			return result
+			return result, nil
 		}
 
 		for h, c := range *res.Data {
@@ -133,7 +155,7 @@ func (tb *Torbox) IsAvailable(hashes []string) map[string]bool {
 			}
 		}
 	}
-	return result
+	return result, nil
 }
 
 func (tb *Torbox) SubmitMagnet(torrent *types.Torrent) (*types.Torrent, error) {
@@ -184,6 +206,12 @@ func getTorboxStatus(status string, finished bool) string {
 	}
 }
 
+// md5String returns the md5 reported for a file, Torbox reports null when it has none
+func md5String(v interface{}) string {
+	s, _ := v.(string)
+	return strings.ToLower(s)
+}
+
 func (tb *Torbox) GetTorrent(torrentId string) (*types.Torrent, error) {
 	url := fmt.Sprintf("%s/api/torrents/mylist/?id=%s", tb.Host, torrentId)
 	req, _ := http.NewRequest(http.MethodGet, url, nil)
@@ -215,6 +243,7 @@ func (tb *Torbox) GetTorrent(torrentId string) (*types.Torrent, error) {
 		Debrid:           tb.name,
 		Files:            make(map[string]types.File),
 		Added:            data.CreatedAt.Format(time.RFC3339),
+		ExpiresAt:        parseExpiresAt(data.ExpiresAt),
 	}
 	cfg := config.Get()
 	for _, f := range data.Files {
@@ -227,7 +256,7 @@ func (tb *Torbox) GetTorrent(torrentId string) (*types.Torrent, error) {
 			continue
 		}
 
-		if !cfg.IsSizeAllowed(f.Size) {
+		if !cfg.IsSizeAllowedFor(f.Size, tb.name, "") {
 			continue
 		}
 		file := types.File{
@@ -236,6 +265,7 @@ func (tb *Torbox) GetTorrent(torrentId string) (*types.Torrent, error) {
 			Name:      fileName,
 			Size:      f.Size,
 			Path:      f.Name,
+			MD5:       md5String(f.Md5),
 		}
 		t.Files[fileName] = file
 	}
@@ -268,6 +298,7 @@ func (tb *Torbox) UpdateTorrent(t *types.Torrent) error {
 	name := data.Name
 	t.Name = name
 	t.Bytes = data.Size
+	t.ExpiresAt = parseExpiresAt(data.ExpiresAt)
 	t.Folder = name
 	t.Progress = data.Progress * 100
 	t.Status = getTorboxStatus(data.DownloadState, data.DownloadFinished)
@@ -288,7 +319,7 @@ func (tb *Torbox) UpdateTorrent(t *types.Torrent) error {
 			continue
 		}
 
-		if !cfg.IsSizeAllowed(f.Size) {
+		if !cfg.IsSizeAllowedFor(f.Size, tb.name, t.ArrName()) {
 			continue
 		}
 		file := types.File{
@@ -297,6 +328,7 @@ func (tb *Torbox) UpdateTorrent(t *types.Torrent) error {
 			Name:      fileName,
 			Size:      f.Size,
 			Path:      fileName,
+			MD5:       md5String(f.Md5),
 		}
 		t.Files[fileName] = file
 	}
@@ -403,11 +435,23 @@ func (tb *Torbox) GetFileDownloadLinks(t *types.Torrent) error {
 }
 
 func (tb *Torbox) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	return tb.requestDownloadLink(t, file, "")
+}
+
+// GetDownloadLinkForIP generates a link usable from the ip instead of the server
+func (tb *Torbox) GetDownloadLinkForIP(t *types.Torrent, file *types.File, ip string) (*types.DownloadLink, error) {
+	return tb.requestDownloadLink(t, file, ip)
+}
+
+func (tb *Torbox) requestDownloadLink(t *types.Torrent, file *types.File, userIP string) (*types.DownloadLink, error) {
 	url := fmt.Sprintf("%s/api/torrents/requestdl/", tb.Host)
 	query := gourl.Values{}
 	query.Add("torrent_id", t.Id)
 	query.Add("token", tb.APIKey)
 	query.Add("file_id", file.Id)
+	if userIP != "" {
+		query.Add("user_ip", userIP)
+	}
 	url += "?" + query.Encode()
 	req, _ := http.NewRequest(http.MethodGet, url, nil)
 	resp, err := tb.client.MakeRequest(req)
@@ -425,6 +469,7 @@ func (tb *Torbox) GetDownloadLink(t *types.Torrent, file *types.File) (*types.Do
 	if link == "" {
 		return nil, fmt.Errorf("error getting download links")
 	}
+	tb.accounts.Unlocked()
 	now := time.Now()
 	return &types.DownloadLink{
 		Link:         file.Link,
@@ -443,6 +488,51 @@ func (tb *Torbox) GetTorrents() ([]*types.Torrent, error) {
 	return nil, nil
 }
 
+// GetExpiringTorrents lists the torrents Torbox reports an expiry for
+func (tb *Torbox) GetExpiringTorrents() ([]*types.Torrent, error) {
+	url := fmt.Sprintf("%s/api/torrents/mylist/?bypass_cache=true", tb.Host)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := tb.client.MakeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var res ListResponse
+	if err := json.Unmarshal(resp, &res); err != nil {
+		return nil, err
+	}
+	if res.Data == nil {
+		return nil, nil
+	}
+	torrents := make([]*types.Torrent, 0)
+	for _, data := range *res.Data {
+		expiresAt := parseExpiresAt(data.ExpiresAt)
+		if expiresAt.IsZero() {
+			continue
+		}
+		torrents = append(torrents, &types.Torrent{
+			Id:        strconv.Itoa(data.Id),
+			InfoHash:  data.Hash,
+			Name:      data.Name,
+			Debrid:    tb.name,
+			ExpiresAt: expiresAt,
+		})
+	}
+	return torrents, nil
+}
+
+// parseExpiresAt parses the expires_at of a torrent, null when it doesn't expire
+func parseExpiresAt(v interface{}) time.Time {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 func (tb *Torbox) GetDownloadUncached() bool {
 	return tb.DownloadUncached
 }
@@ -471,3 +561,15 @@ func (tb *Torbox) GetAvailableSlots() (int, error) {
 func (tb *Torbox) Accounts() *types.Accounts {
 	return tb.accounts
 }
+
+func (tb *Torbox) InConservativeMode() bool {
+	return tb.client.InConservativeMode()
+}
+
+func (tb *Torbox) AuthState() string {
+	return tb.client.AuthState()
+}
+
+func (tb *Torbox) RateLimitHeadroom() (request.RateLimitHeadroom, bool) {
+	return tb.client.RateLimitHeadroom()
+}
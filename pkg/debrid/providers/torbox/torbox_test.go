@@ -0,0 +1,105 @@
+package torbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestTorbox returns a Torbox client of the debrid dc, loaded from a config in a temp directory
+func newTestTorbox(t *testing.T, dc config.Debrid) *Torbox {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(map[string]any{"debrids": []config.Debrid{dc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	loaded, _ := config.Get().GetDebrid(dc.Name)
+	tb, err := New(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func TestIsAvailableBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hashes := strings.Split(r.URL.Query().Get("hash"), ",")
+		mu.Lock()
+		batches = append(batches, len(hashes))
+		mu.Unlock()
+		cached := make(map[string]any)
+		for _, hash := range hashes {
+			if strings.HasPrefix(hash, "c") {
+				cached[hash] = map[string]any{"name": hash, "size": 1 << 20, "hash": hash}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "data": cached})
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		batchSize int
+		want      []int
+	}{
+		{0, []int{100, 100, 50}}, // The provider maximum
+		{40, []int{40, 40, 40, 40, 40, 40, 10}},
+		{500, []int{100, 100, 50}}, // Capped to the provider maximum
+	} {
+		batches = nil
+		tb := newTestTorbox(t, config.Debrid{
+			Name:                 "torbox",
+			APIKey:               "key",
+			Folder:               "/mnt/remote/torbox",
+			Endpoint:             srv.URL,
+			CheckCachedBatchSize: tt.batchSize,
+		})
+		hashes := make([]string, 250)
+		for i := range hashes {
+			hashes[i] = fmt.Sprintf("%c%039d", "cu"[i%2], i)
+		}
+		available, err := tb.IsAvailable(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(batches) != fmt.Sprint(tt.want) {
+			t.Errorf("batch size %d: requests of %v hashes, want %v", tt.batchSize, batches, tt.want)
+		}
+		if len(available) != 125 {
+			t.Errorf("batch size %d: %d hashes available, want 125", tt.batchSize, len(available))
+		}
+	}
+}
+
+func TestParseExpiresAt(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"2024-05-01T10:00:00Z", "2024-05-01T10:00:00Z"},
+		{nil, ""},
+		{"", ""},
+		{"soon", ""},
+	}
+	for _, tt := range tests {
+		got := parseExpiresAt(tt.value)
+		if (tt.want == "") != got.IsZero() || (tt.want != "" && got.Format(time.RFC3339) != tt.want) {
+			t.Errorf("parseExpiresAt(%v) = %v, want %q", tt.value, got, tt.want)
+		}
+	}
+}
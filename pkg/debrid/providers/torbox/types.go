@@ -72,4 +72,6 @@ type torboxInfo struct {
 
 type InfoResponse APIResponse[torboxInfo]
 
+type ListResponse APIResponse[[]torboxInfo]
+
 type DownloadLinksResponse APIResponse[string]
@@ -0,0 +1,87 @@
+package debrid
+
+import (
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// markSlotsFull demotes the debrid to the end of the submit order for the full-debrid cooldown,
+// after it refused a torrent for too many active downloads
+func (de *Debrid) markSlotsFull() {
+	de.slotsFullUntil.Store(time.Now().Add(config.Get().GetDebridFullCooldown()).UnixNano())
+}
+
+// slotsFull reports whether the debrid refused a torrent for too many active downloads within the cooldown
+func (de *Debrid) slotsFull() bool {
+	return time.Now().UnixNano() < de.slotsFullUntil.Load()
+}
+
+// submitOrder returns the names of the clients in the order torrents are submitted to them: the debrids in priority
+// first, in that order, then the others by free slots above their minimum_free_slot, most first, in declared order on
+// ties. Debrids that don't report their slots count as having none free, and debrids refusing torrents for too many
+// active downloads come last until their cooldown passes.
+func (d *Storage) submitOrder(clients map[string]types.Client, priority []string) []string {
+	names := orderByPriority(clients, priority)
+	first := 0
+	for first < len(names) && slices.Contains(priority, names[first]) {
+		first++
+	}
+	rest := names[first:]
+	if len(rest) < 2 {
+		return names
+	}
+
+	declared := make(map[string]int)
+	for i, dc := range config.Get().Debrids {
+		declared[dc.Name] = i
+	}
+	type candidate struct {
+		name     string
+		slots    int
+		reported bool
+		full     bool
+	}
+	candidates := make([]candidate, 0, len(rest))
+	for _, name := range rest {
+		c := candidate{name: name}
+		if db := d.Debrid(name); db != nil {
+			c.full = db.slotsFull()
+		}
+		if slots, err := clients[name].GetAvailableSlots(); err == nil {
+			c.slots, c.reported = slots, true
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.full != b.full {
+			return !a.full
+		}
+		if a.slots != b.slots {
+			return a.slots > b.slots
+		}
+		return declared[a.name] < declared[b.name]
+	})
+
+	summary := make([]string, 0, len(candidates))
+	for i, c := range candidates {
+		rest[i] = c.name
+		switch {
+		case c.full:
+			summary = append(summary, fmt.Sprintf("%s(full, cooling down)", c.name))
+		case c.reported:
+			summary = append(summary, fmt.Sprintf("%s(%d free)", c.name, c.slots))
+		default:
+			summary = append(summary, fmt.Sprintf("%s(unknown)", c.name))
+		}
+	}
+	_logger := logger.Default()
+	_logger.Info().Msgf("Submitting to %s first, debrids by free slots: %s", names[0], strings.Join(summary, ", "))
+	return names
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GetAvailability returns the last known availability of an infohash.
+// The second return value is false if the hash hasn't been checked yet.
+func (c *Cache) GetAvailability(infohash string) (bool, bool) {
+	v, ok := c.availability.Load(strings.ToLower(infohash))
+	if !ok {
+		return false, false
+	}
+	return v.(bool), true
+}
+
+// preWarmAvailability checks the availability of all cached torrents in batches,
+// so the first access after a restart doesn't need to hit the debrid.
+func (c *Cache) preWarmAvailability(ctx context.Context) {
+	start := time.Now()
+
+	hashes := make([]string, 0, c.torrents.getAllCount())
+	seen := make(map[string]struct{})
+	for _, t := range c.torrents.getAll() {
+		hash := strings.ToLower(t.InfoHash)
+		if hash == "" {
+			continue
+		}
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+		hashes = append(hashes, hash)
+	}
+
+	if len(hashes) == 0 {
+		return
+	}
+
+	c.logger.Info().Msgf("Pre-warming availability for %d torrents", len(hashes))
+
+	workers := max(c.config.PreWarmWorkers, 1)
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var available, unavailable int64
+
+	batchSize := c.config.GetCheckCachedBatchSize()
+	for i := 0; i < len(hashes); i += batchSize {
+		batch := hashes[i:min(i+batchSize, len(hashes))]
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, err := c.client.IsAvailable(batch)
+			if err != nil {
+				c.logger.Error().Err(err).Msg("Failed to pre-warm the availability of a batch")
+				return
+			}
+			for _, hash := range batch {
+				ok := result[hash] || result[strings.ToUpper(hash)] // some providers key by uppercase hashes
+				c.availability.Store(hash, ok)
+				if ok {
+					atomic.AddInt64(&available, 1)
+				} else {
+					atomic.AddInt64(&unavailable, 1)
+				}
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	c.logger.Info().
+		Int64("available", available).
+		Int64("unavailable", unavailable).
+		Dur("duration", time.Since(start)).
+		Msg("Availability pre-warm complete")
+}
@@ -10,7 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +23,7 @@ import (
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
 	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/arr"
 	_ "time/tzdata"
 )
 
@@ -39,17 +40,19 @@ const (
 
 type CachedTorrent struct {
 	*types.Torrent
-	AddedOn    time.Time `json:"added_on"`
-	IsComplete bool      `json:"is_complete"`
-	Bad        bool      `json:"bad"`
+	AddedOn      time.Time `json:"added_on"`
+	IsComplete   bool      `json:"is_complete"`
+	Bad          bool      `json:"bad"`
+	LastRepaired time.Time `json:"last_repaired,omitzero"`
 }
 
 func (c CachedTorrent) copy() CachedTorrent {
 	return CachedTorrent{
-		Torrent:    c.Torrent,
-		AddedOn:    c.AddedOn,
-		IsComplete: c.IsComplete,
-		Bad:        c.Bad,
+		Torrent:      c.Torrent,
+		AddedOn:      c.AddedOn,
+		IsComplete:   c.IsComplete,
+		Bad:          c.Bad,
+		LastRepaired: c.LastRepaired,
 	}
 }
 
@@ -68,14 +71,18 @@ type RepairRequest struct {
 }
 
 type Cache struct {
-	dir    string
-	client types.Client
-	logger zerolog.Logger
+	dir     string
+	client  types.Client
+	breaker *types.CircuitBreaker // Shared with the debrid, nil when disabled
+	logger  zerolog.Logger
 
 	torrents             *torrentCache
 	invalidDownloadLinks sync.Map
+	availability         sync.Map // infohash -> bool, filled by the availability pre-warm
 	folderNaming         WebDavFolderNaming
 
+	dedup atomic.Pointer[dedupIndex] // Set when WebDav.DedupFiles is enabled
+
 	listingDebouncer *utils.Debouncer[bool]
 	// monitors
 	repairRequest        sync.Map
@@ -102,11 +109,21 @@ type Cache struct {
 
 	saveSemaphore chan struct{}
 
-	config        config.Debrid
-	customFolders []string
+	config          config.Debrid
+	customFolders   []string
+	customFoldersMu sync.RWMutex
+
+	activeStreams atomic.Int64 // WebDav streams in progress
+	lastStreamEnd atomic.Int64 // Unix nanoseconds
+
+	reinsertedMu sync.Mutex
+	reinserted   map[string]*reinsertWatch // torrent folder -> closed when the torrent is reinserted
+
+	torrentLocks keyedMutex // Serializes the reinserts and deletes of a torrent, by info hash
+	repairAborts sync.Map   // info hash -> *repairAbort of the reinsert in progress
 }
 
-func NewDebridCache(dc config.Debrid, client types.Client) *Cache {
+func NewDebridCache(dc config.Debrid, client types.Client, breaker *types.CircuitBreaker) *Cache {
 	cfg := config.Get()
 	cet, err := time.LoadLocation("CET")
 	if err != nil {
@@ -126,30 +143,27 @@ func NewDebridCache(dc config.Debrid, client types.Client) *Cache {
 		scheduler = cetSc
 	}
 
+	_log := logger.New(fmt.Sprintf("%s-webdav", client.Name()))
 	var customFolders []string
 	dirFilters := map[string][]directoryFilter{}
 	for name, value := range dc.Directories {
-		for filterType, v := range value.Filters {
-			df := directoryFilter{filterType: filterType, value: v}
-			switch filterType {
-			case filterByRegex, filterByNotRegex:
-				df.regex = regexp.MustCompile(v)
-			case filterBySizeGT, filterBySizeLT:
-				df.sizeThreshold, _ = config.ParseSize(v)
-			case filterBLastAdded:
-				df.ageThreshold, _ = time.ParseDuration(v)
-			}
-			dirFilters[name] = append(dirFilters[name], df)
+		filters, err := parseDirectoryFilters(value.Filters)
+		if err != nil {
+			_log.Error().Err(err).Msgf("Skipping directory %s", name)
+			continue
 		}
+		dirFilters[name] = filters
 		customFolders = append(customFolders, name)
-
 	}
-	_log := logger.New(fmt.Sprintf("%s-webdav", client.Name()))
+	if dc.DedupFiles {
+		customFolders = append(customFolders, DedupFolder)
+	}
 	c := &Cache{
 		dir: filepath.Join(cfg.Path, "cache", dc.Name), // path to save cache files
 
-		torrents:                     newTorrentCache(dirFilters),
+		torrents:                     newTorrentCache(dirFilters, dc.CaseInsensitiveNames),
 		client:                       client,
+		breaker:                      breaker,
 		logger:                       _log,
 		workers:                      dc.Workers,
 		torrentRefreshInterval:       dc.TorrentsRefreshInterval,
@@ -203,6 +217,8 @@ func (c *Cache) Reset() {
 
 	// 3. Clear any sync.Maps
 	c.invalidDownloadLinks = sync.Map{}
+	c.availability = sync.Map{}
+	c.dedup.Store(nil)
 	c.repairRequest = sync.Map{}
 	c.failedToReinsert = sync.Map{}
 	c.downloadLinkRequests = sync.Map{}
@@ -236,6 +252,10 @@ func (c *Cache) Start(ctx context.Context) error {
 	// initial download links
 	go c.refreshDownloadLinks(ctx)
 
+	if c.config.PreWarmAvailability {
+		go c.preWarmAvailability(ctx)
+	}
+
 	if err := c.StartSchedule(ctx); err != nil {
 		c.logger.Error().Err(err).Msg("Failed to start cache worker")
 	}
@@ -250,6 +270,13 @@ func (c *Cache) Start(ctx context.Context) error {
 
 	<-ctx.Done()
 	c.logger.Info().Msgf("Stopping %s WebDav server", name)
+	if active := c.ActiveStreams(); active > 0 {
+		// Resetting the cache would break the streams in progress
+		c.logger.Info().Msgf("Waiting for %d WebDav streams to end", active)
+		if !c.drainStreams(config.Get().GetShutdownTimeout()) {
+			c.logger.Warn().Msgf("%d WebDav streams still running after the shutdown timeout", c.ActiveStreams())
+		}
+	}
 	c.Reset()
 
 	return nil
@@ -313,8 +340,8 @@ func (c *Cache) load(ctx context.Context) (map[string]CachedTorrent, error) {
 					continue
 				}
 
-				isComplete := true
-				if len(ct.GetFiles()) != 0 {
+				isComplete := ct.IsComplete // Partially exposed torrents are fetched again on the next refresh
+				if isComplete && len(ct.GetFiles()) != 0 {
 					// Check if all files are valid, if not, delete the file.json and remove from cache.
 					fs := make(map[string]types.File, len(ct.GetFiles()))
 					for _, f := range ct.GetFiles() {
@@ -484,6 +511,50 @@ func (c *Cache) sync(ctx context.Context, torrents []*types.Torrent) error {
 }
 
 func (c *Cache) GetTorrentFolder(torrent *types.Torrent) string {
+	if folder := c.torrentFolder(torrent); folder != "" && folder != "." {
+		return folder
+	}
+	// Nameless torrent, e.g a magnet without dn the debrid has no metadata for yet
+	return config.Get().NamelessMagnetName(torrent.InfoHash)
+}
+
+// torrentFolder returns the name of the first folder_name_precedence source that knows the torrent under a name
+func (c *Cache) torrentFolder(torrent *types.Torrent) string {
+	for _, source := range c.config.FolderNamePrecedence {
+		var name string
+		switch source {
+		case config.FolderNameArr:
+			name = torrent.RequestedName
+		case config.FolderNameMagnet:
+			if torrent.Magnet != nil && torrent.Magnet.Name != config.Get().NamelessMagnetName(torrent.InfoHash) {
+				name = torrent.Magnet.Name
+			}
+		case config.FolderNameDebrid:
+			return c.debridFolder(torrent)
+		}
+		if folder := c.folderName(name); folder != "" {
+			return folder
+		}
+	}
+	return c.debridFolder(torrent)
+}
+
+// folderName turns a name the torrent was added under into a folder name, following the extension handling of
+// folder_naming. Returns "" if nothing usable is left.
+func (c *Cache) folderName(name string) string {
+	// Names sent by clients can hold path separators, they would nest the folder
+	name = strings.TrimSpace(strings.NewReplacer("/", " ", "\\", " ").Replace(name))
+	if c.folderNaming == WebDavUseFileNameNoExt || c.folderNaming == WebDavUseOriginalNameNoExt {
+		name = utils.RemoveExtension(name)
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// debridFolder returns the folder name of the torrent from the name the debrid reports, per folder_naming
+func (c *Cache) debridFolder(torrent *types.Torrent) string {
 	switch c.folderNaming {
 	case WebDavUseFileName:
 		return path.Clean(torrent.Filename)
@@ -502,8 +573,34 @@ func (c *Cache) GetTorrentFolder(torrent *types.Torrent) string {
 	}
 }
 
-func (c *Cache) setTorrent(t CachedTorrent, callback func(torrent CachedTorrent)) {
+// prepareTorrent reconciles t with its cached version and returns the folder it is exposed under. The names t was added
+// under are kept, the debrid doesn't report them, and the folder of its cached version is dropped if it's named
+// differently now.
+func (c *Cache) prepareTorrent(t *CachedTorrent) string {
+	previous, ok := c.torrents.getByID(t.Id)
+	if ok && previous.Torrent != t.Torrent {
+		c.reconcileFiles(previous, t)
+		if previous.Torrent != nil {
+			t.RequestedName = cmp.Or(t.RequestedName, previous.RequestedName)
+			if t.Magnet == nil {
+				t.Magnet = previous.Magnet
+			}
+		}
+	}
 	torrentName := c.GetTorrentFolder(t.Torrent)
+	if ok && previous.Torrent != nil {
+		previousName := c.GetTorrentFolder(previous.Torrent)
+		if c.torrents.nameKey(previousName) != c.torrents.nameKey(torrentName) {
+			if o, found := c.torrents.getByName(previousName); found && o.Id == t.Id {
+				c.torrents.remove(previousName)
+			}
+		}
+	}
+	return torrentName
+}
+
+func (c *Cache) setTorrent(t CachedTorrent, callback func(torrent CachedTorrent)) {
+	torrentName := c.prepareTorrent(&t)
 	updatedTorrent := t.copy()
 	if o, ok := c.torrents.getByName(torrentName); ok && o.Id != t.Id {
 		// If another torrent with the same name exists, merge the files, if the same file exists,
@@ -522,7 +619,7 @@ func (c *Cache) setTorrent(t CachedTorrent, callback func(torrent CachedTorrent)
 
 func (c *Cache) setTorrents(torrents map[string]CachedTorrent, callback func()) {
 	for _, t := range torrents {
-		torrentName := c.GetTorrentFolder(t.Torrent)
+		torrentName := c.prepareTorrent(&t)
 		updatedTorrent := t.copy()
 		if o, ok := c.torrents.getByName(torrentName); ok && o.Id != t.Id {
 			// Save the most recent torrent
@@ -542,15 +639,65 @@ func (c *Cache) GetListing(folder string) []os.FileInfo {
 	switch folder {
 	case "__all__", "torrents":
 		return c.torrents.getListing()
+	case DedupFolder:
+		return c.getDedupListing()
 	default:
 		return c.torrents.getFolderListing(folder)
 	}
 }
 
+// GetCategoryListings returns the torrents of each category(READ-ONLY), "" for the torrents without one
+func (c *Cache) GetCategoryListings() map[string][]os.FileInfo {
+	return c.torrents.getCategoryListings()
+}
+
 func (c *Cache) GetCustomFolders() []string {
+	c.customFoldersMu.RLock()
+	defer c.customFoldersMu.RUnlock()
 	return c.customFolders
 }
 
+// AddCustomFolder adds a directory to the running cache, e.g. for a newly created category
+func (c *Cache) AddCustomFolder(name string, dir config.WebdavDirectories) {
+	filters, err := parseDirectoryFilters(dir.Filters)
+	if err != nil {
+		c.logger.Error().Err(err).Msgf("Skipping directory %s", name)
+		return
+	}
+	c.customFoldersMu.Lock()
+	if slices.Contains(c.customFolders, name) {
+		c.customFoldersMu.Unlock()
+		return
+	}
+	c.customFolders = append(slices.Clone(c.customFolders), name)
+	c.customFoldersMu.Unlock()
+
+	c.torrents.addDirectory(name, filters)
+	c.listingDebouncer.Call(true)
+}
+
+// SetTorrentArr moves a torrent to another arr(category), so category directories pick it up
+func (c *Cache) SetTorrentArr(torrentId string, a *arr.Arr) {
+	ct, ok := c.torrents.getByID(torrentId)
+	if !ok {
+		return
+	}
+	ct.Torrent.Lock()
+	ct.Torrent.Arr = a
+	ct.Torrent.Unlock()
+	c.setTorrent(ct, func(torrent CachedTorrent) {
+		c.RefreshListings(true)
+	})
+}
+
+// GetFileSortOrder returns the order of files inside the torrent folders of a directory
+func (c *Cache) GetFileSortOrder(folder string) config.FileSortOrder {
+	if dir, ok := c.config.Directories[folder]; ok && dir.FileSortOrder != "" {
+		return dir.FileSortOrder
+	}
+	return c.config.FileSortOrder
+}
+
 func (c *Cache) Close() error {
 	return nil
 }
@@ -662,6 +809,19 @@ func (c *Cache) saveTorrent(id string, data []byte) {
 	}
 }
 
+// restoreArr gives t the arr of its cached version when the debrid returned it without one, it reports whether it did
+func (c *Cache) restoreArr(t *types.Torrent) bool {
+	if t == nil || t.ArrName() != "" {
+		return false
+	}
+	cached, ok := c.torrents.getByID(t.Id)
+	if !ok || cached.Torrent == nil || cached.ArrName() == "" {
+		return false
+	}
+	t.Arr, t.Category = cached.Arr, cached.ArrName()
+	return true
+}
+
 func (c *Cache) ProcessTorrent(t *types.Torrent) error {
 
 	isComplete := func(files map[string]types.File) bool {
@@ -675,6 +835,10 @@ func (c *Cache) ProcessTorrent(t *types.Torrent) error {
 		return _complete
 	}
 
+	if c.restoreArr(t) {
+		// Listed without its arr, the files are filtered again with the size limits of the arr
+		t.Files = make(map[string]types.File)
+	}
 	if !isComplete(t.Files) {
 		if err := c.client.UpdateTorrent(t); err != nil {
 			return fmt.Errorf("failed to update torrent: %w", err)
@@ -682,6 +846,10 @@ func (c *Cache) ProcessTorrent(t *types.Torrent) error {
 	}
 
 	if !isComplete(t.Files) {
+		if c.config.IncompleteDownloads == config.IncompleteDownloadsProgressive {
+			c.addIncompleteTorrent(t)
+			return nil
+		}
 		c.logger.Debug().Msgf("Torrent %s is still not complete. Triggering a reinsert(disabled)", t.Id)
 	} else {
 		addedOn, err := time.Parse(time.RFC3339, t.Added)
@@ -700,6 +868,34 @@ func (c *Cache) ProcessTorrent(t *types.Torrent) error {
 	return nil
 }
 
+// addIncompleteTorrent exposes the files of a torrent still downloading that already have a link, the others are dropped from t.
+// The torrent is processed again on the next refresh, until all its files are available.
+func (c *Cache) addIncompleteTorrent(t *types.Torrent) {
+	files := make(map[string]types.File, len(t.Files))
+	for name, file := range t.Files {
+		if file.Link != "" {
+			files[name] = file
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	total := len(t.Files)
+	t.Files = files
+	addedOn, err := time.Parse(time.RFC3339, t.Added)
+	if err != nil {
+		addedOn = time.Now()
+	}
+	c.logger.Debug().Msgf("Exposing %d/%d files of incomplete torrent %s", len(files), total, t.Id)
+	c.setTorrent(CachedTorrent{
+		Torrent:    t,
+		IsComplete: false,
+		AddedOn:    addedOn,
+	}, func(tor CachedTorrent) {
+		c.listingDebouncer.Call(false)
+	})
+}
+
 func (c *Cache) Add(t *types.Torrent) error {
 	if len(t.Files) == 0 {
 		c.logger.Warn().Msgf("Torrent %s has no files to add. Refreshing", t.Id)
@@ -719,20 +915,44 @@ func (c *Cache) Add(t *types.Torrent) error {
 	c.setTorrent(ct, func(tor CachedTorrent) {
 		c.RefreshListings(true)
 	})
+	if c.config.IsLargeTorrent(len(t.Files)) {
+		// Links are generated as the files are opened instead
+		c.logger.Info().Str("torrent", t.Name).Msgf("Large torrent(%d files), lazy-loading its download links", len(t.Files))
+		return nil
+	}
 	go c.GetFileDownloadLinks(ct)
 	return nil
 
 }
 
+// IsLargeTorrent reports whether the torrent has enough files to be handled as a large torrent
+func (c *Cache) IsLargeTorrent(t *types.Torrent) bool {
+	return t != nil && c.config.IsLargeTorrent(len(t.Files))
+}
+
 func (c *Cache) Client() types.Client {
 	return c.client
 }
 
-func (c *Cache) DeleteTorrent(id string) error {
+// DeleteTorrent deletes the torrent from the cache and debrid service. A reinsert of the torrent in progress is
+// waited for, until ctx is done, before the refreshes are held up for the delete.
+func (c *Cache) DeleteTorrent(ctx context.Context, id string) error {
+	torrent, ok := c.torrents.getByID(id)
+	if !ok {
+		return nil
+	}
+	if config.Get().Repair.DeleteDuringRepair != config.DeleteDuringRepairWait {
+		c.abortRepair(torrent)
+	}
+	if err := c.awaitRepair(ctx, torrent); err != nil {
+		return err
+	}
+
 	c.torrentsRefreshMu.Lock()
 	defer c.torrentsRefreshMu.Unlock()
 
-	if c.deleteTorrent(id, true) {
+	// Looked up before the wait, the reinsert may have replaced its id meanwhile
+	if c.deleteCachedTorrent(ctx, torrent, true) {
 		go c.RefreshListings(true)
 		return nil
 	}
@@ -747,7 +967,7 @@ func (c *Cache) validateAndDeleteTorrents(torrents []string) {
 			defer wg.Done()
 			// Check if torrent is truly deleted
 			if _, err := c.client.GetTorrent(t); err != nil {
-				c.deleteTorrent(t, false) // Since it's removed from debrid already
+				c.deleteTorrent(context.Background(), t, false) // Since it's removed from debrid already
 			}
 		}(torrent)
 	}
@@ -755,9 +975,39 @@ func (c *Cache) validateAndDeleteTorrents(torrents []string) {
 	c.listingDebouncer.Call(true)
 }
 
-// deleteTorrent deletes the torrent from the cache and debrid service
+// deleteTorrent deletes the torrent from the cache and debrid service, once a reinsert of it in progress is over or
+// ctx is done. Repair.DeleteDuringRepair sets whether the reinsert is aborted, if it still completed, its copy is
+// deleted instead.
+func (c *Cache) deleteTorrent(ctx context.Context, id string, removeFromDebrid bool) bool {
+	torrent, ok := c.torrents.getByID(id)
+	if !ok {
+		return false
+	}
+	return c.deleteCachedTorrent(ctx, torrent, removeFromDebrid)
+}
+
+// deleteCachedTorrent deletes the torrent as deleteTorrent does, its copy if a reinsert replaced it
+func (c *Cache) deleteCachedTorrent(ctx context.Context, torrent CachedTorrent, removeFromDebrid bool) bool {
+	if config.Get().Repair.DeleteDuringRepair != config.DeleteDuringRepairWait {
+		c.abortRepair(torrent)
+	}
+	if c.awaitRepair(ctx, torrent) != nil {
+		return false
+	}
+	unlock := c.lockTorrent(torrent)
+	defer unlock()
+	id := torrent.Id
+	if _, ok := c.torrents.getByID(id); !ok {
+		if id = c.reinsertedCopy(torrent); id == "" {
+			return false
+		}
+	}
+	return c.removeTorrent(id, removeFromDebrid)
+}
+
+// removeTorrent removes the torrent from the cache and debrid service, the torrent must be locked.
 // It also handles torrents with the same name but different IDs
-func (c *Cache) deleteTorrent(id string, removeFromDebrid bool) bool {
+func (c *Cache) removeTorrent(id string, removeFromDebrid bool) bool {
 
 	if torrent, ok := c.torrents.getByID(id); ok {
 		c.torrents.removeId(id) // Delete id from cache
@@ -786,6 +1036,8 @@ func (c *Cache) deleteTorrent(id string, removeFromDebrid bool) bool {
 				// Delete the torrent since no files are left
 				c.torrents.remove(torrentName)
 			} else {
+				// A copy, the torrent may be saved meanwhile
+				t.Torrent = t.Torrent.Clone()
 				t.Files = newFiles
 				newId = cmp.Or(newId, t.Id)
 				t.Id = newId
@@ -800,7 +1052,7 @@ func (c *Cache) deleteTorrent(id string, removeFromDebrid bool) bool {
 func (c *Cache) DeleteTorrents(ids []string) {
 	c.logger.Info().Msgf("Deleting %d torrents", len(ids))
 	for _, id := range ids {
-		_ = c.deleteTorrent(id, true)
+		_ = c.deleteTorrent(context.Background(), id, true)
 	}
 	c.listingDebouncer.Call(true)
 }
@@ -834,7 +1086,7 @@ func (c *Cache) removeFile(torrentId string, moveToTrash bool) {
 
 func (c *Cache) OnRemove(torrentId string) {
 	c.logger.Debug().Msgf("OnRemove triggered for %s", torrentId)
-	err := c.DeleteTorrent(torrentId)
+	err := c.DeleteTorrent(context.Background(), torrentId)
 	if err != nil {
 		c.logger.Error().Err(err).Msgf("Failed to delete torrent: %s", torrentId)
 		return
@@ -859,7 +1111,7 @@ func (c *Cache) RemoveFile(torrentId string, filename string) error {
 	// If the torrent has no files left, delete it
 	if len(torrent.GetFiles()) == 0 {
 		c.logger.Debug().Msgf("Torrent %s has no files left, deleting it", torrentId)
-		if err := c.DeleteTorrent(torrentId); err != nil {
+		if err := c.DeleteTorrent(context.Background(), torrentId); err != nil {
 			return fmt.Errorf("failed to delete torrent %s: %w", torrentId, err)
 		}
 		return nil
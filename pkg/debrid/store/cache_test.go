@@ -0,0 +1,384 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient is a debrid client serving its torrents from memory, the methods it doesn't override panic
+type fakeClient struct {
+	types.Client
+	mu       sync.Mutex
+	torrents map[string]*types.Torrent // By ID
+	deleted  []string
+}
+
+func newFakeClient(torrents ...*types.Torrent) *fakeClient {
+	f := &fakeClient{torrents: make(map[string]*types.Torrent)}
+	for _, t := range torrents {
+		f.torrents[t.Id] = t
+	}
+	return f
+}
+
+func (f *fakeClient) Name() string { return "realdebrid" }
+
+func (f *fakeClient) UpdateTorrent(t *types.Torrent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if stored, ok := f.torrents[t.Id]; ok {
+		t.Files = make(map[string]types.File, len(stored.Files))
+		for name, file := range stored.Files {
+			t.Files[name] = file
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) DeleteTorrent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.torrents, id)
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+// newTestCache returns a cache of the realdebrid debrid of a config written to a temp directory, set by opts
+func newTestCache(t *testing.T, client types.Client, opts ...func(*config.Debrid)) *Cache {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "decypharr-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cache saves its torrents in the background, the directory is removed without failing the test
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	debrid := config.Debrid{Name: "realdebrid", APIKey: "key", Folder: filepath.Join(dir, "mnt")}
+	for _, opt := range opts {
+		opt(&debrid)
+	}
+	data, err := json.Marshal(map[string]any{"debrids": []config.Debrid{debrid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	dc, ok := config.Get().GetDebrid(debrid.Name)
+	if !ok {
+		t.Fatalf("debrid %s not loaded", debrid.Name)
+	}
+	return NewDebridCache(dc, client, nil)
+}
+
+func testFile(name, link string) types.File {
+	return types.File{Id: name, Name: name, Path: name, Size: 1 << 20, Link: link}
+}
+
+func TestProcessTorrentIncompleteDownloads(t *testing.T) {
+	downloading := func() *types.Torrent {
+		return &types.Torrent{
+			Id:       "1",
+			InfoHash: "abc",
+			Name:     "Show.S01",
+			Added:    time.Now().Format(time.RFC3339),
+			Files: map[string]types.File{
+				"e01.mkv": testFile("e01.mkv", "https://debrid/e01"),
+				"e02.mkv": testFile("e02.mkv", ""),
+			},
+		}
+	}
+
+	t.Run("hide", func(t *testing.T) {
+		c := newTestCache(t, newFakeClient(downloading()))
+		if err := c.ProcessTorrent(downloading()); err != nil {
+			t.Fatal(err)
+		}
+		if ct := c.GetTorrent("1"); ct != nil {
+			t.Fatalf("incomplete torrent exposed with %d files", len(ct.GetFiles()))
+		}
+	})
+
+	t.Run("progressive", func(t *testing.T) {
+		c := newTestCache(t, newFakeClient(downloading()), func(d *config.Debrid) {
+			d.IncompleteDownloads = config.IncompleteDownloadsProgressive
+		})
+		if err := c.ProcessTorrent(downloading()); err != nil {
+			t.Fatal(err)
+		}
+		ct := c.GetTorrent("1")
+		if ct == nil {
+			t.Fatal("incomplete torrent not exposed")
+		}
+		if ct.IsComplete {
+			t.Error("incomplete torrent marked complete")
+		}
+		if files := ct.GetFiles(); len(files) != 1 || files[0].Name != "e01.mkv" {
+			t.Errorf("exposed files = %v, want only e01.mkv", files)
+		}
+		if broken, _ := c.GetBrokenFiles(ct, nil); len(broken) != 0 {
+			t.Errorf("files of an incomplete torrent reported broken: %v", broken)
+		}
+	})
+}
+
+func TestGetTorrentFolderNameless(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	c := newTestCache(t, newFakeClient())
+	cfg := config.Get()
+	cfg.NamelessMagnetPolicy = config.NamelessMagnetTemplate
+	cfg.NamelessMagnetTemplate = "Unnamed {hash}"
+
+	// The debrid has no metadata yet, the placeholder name of the magnet is kept
+	nameless := &types.Torrent{InfoHash: hash, Magnet: &utils.Magnet{InfoHash: hash, Name: cfg.NamelessMagnetName(hash)}}
+	if got, want := c.GetTorrentFolder(nameless), "Unnamed "+hash; got != want {
+		t.Errorf("folder of a nameless torrent = %q, want %q", got, want)
+	}
+
+	// Once the debrid reports a name, it's used
+	named := &types.Torrent{InfoHash: hash, Name: "Movie 2024", OriginalFilename: "Movie 2024", Filename: "Movie 2024", Magnet: nameless.Magnet}
+	if got := c.GetTorrentFolder(named); got != "Movie 2024" {
+		t.Errorf("folder of a named torrent = %q, want Movie 2024", got)
+	}
+}
+
+// sizeFilterClient filters the files it returns with the size limits of the torrent's arr, as the providers do
+type sizeFilterClient struct {
+	*fakeClient
+}
+
+func (s *sizeFilterClient) UpdateTorrent(t *types.Torrent) error {
+	if err := s.fakeClient.UpdateTorrent(t); err != nil {
+		return err
+	}
+	for name, file := range t.Files {
+		if !config.Get().IsSizeAllowedFor(file.Size, s.Name(), t.ArrName()) {
+			delete(t.Files, name)
+		}
+	}
+	return nil
+}
+
+// A torrent listed by the debrid without its arr keeps the one it was cached with, and its size limits
+func TestProcessTorrentKeepsArr(t *testing.T) {
+	listed := func() *types.Torrent {
+		sample := testFile("sample.mkv", "https://debrid/sample")
+		sample.Size = 1 << 10
+		sample.TorrentId = "1"
+		episode := testFile("e01.mkv", "https://debrid/e01")
+		episode.TorrentId = "1"
+		return &types.Torrent{
+			Id:       "1",
+			InfoHash: "abc",
+			Name:     "Show.S01",
+			Added:    time.Now().Format(time.RFC3339),
+			Files:    map[string]types.File{"sample.mkv": sample, "e01.mkv": episode},
+		}
+	}
+	client := &sizeFilterClient{newFakeClient(listed())}
+	c := newTestCache(t, client)
+	config.Get().Arrs = []config.Arr{{Name: "sonarr", MinFileSize: "1MB"}}
+
+	added := listed()
+	added.SetArr(arr.New("sonarr", "", "", false, false, nil, "", ""))
+	delete(added.Files, "sample.mkv")
+	if err := c.ProcessTorrent(added); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ProcessTorrent(listed()); err != nil {
+		t.Fatal(err)
+	}
+	ct := c.GetTorrent("1")
+	if ct == nil {
+		t.Fatal("torrent not cached")
+	}
+	if ct.ArrName() != "sonarr" {
+		t.Errorf("arr = %q after the debrid listed the torrent without it, want sonarr", ct.ArrName())
+	}
+	if files := ct.GetFiles(); len(files) != 1 || files[0].Name != "e01.mkv" {
+		t.Errorf("files = %v, want the sample under the min_file_size of sonarr left out", files)
+	}
+}
+
+func TestFolderNamePrecedence(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	type names struct{ arr, magnet string }
+	torrent := func(n names) *types.Torrent {
+		return &types.Torrent{
+			Id:               "1",
+			InfoHash:         hash,
+			Name:             "Debrid Name",
+			Filename:         "Debrid Name",
+			OriginalFilename: "Debrid Name",
+			RequestedName:    n.arr,
+			Magnet:           &utils.Magnet{InfoHash: hash, Name: n.magnet},
+		}
+	}
+	arr, magnet, debrid := config.FolderNameArr, config.FolderNameMagnet, config.FolderNameDebrid
+	tests := []struct {
+		precedence []config.FolderNameSource
+		names      names
+		want       string
+	}{
+		{nil, names{"Arr Name", "Magnet Name"}, "Debrid Name"},
+		{[]config.FolderNameSource{arr, magnet, debrid}, names{"Arr Name", "Magnet Name"}, "Arr Name"},
+		{[]config.FolderNameSource{arr, magnet, debrid}, names{"", "Magnet Name"}, "Magnet Name"},
+		{[]config.FolderNameSource{arr, magnet, debrid}, names{"", ""}, "Debrid Name"},
+		{[]config.FolderNameSource{magnet, arr}, names{"Arr Name", "Magnet Name"}, "Magnet Name"},
+		{[]config.FolderNameSource{magnet, arr}, names{"Arr Name", ""}, "Arr Name"},
+		{[]config.FolderNameSource{debrid, arr}, names{"Arr Name", "Magnet Name"}, "Debrid Name"},
+		{[]config.FolderNameSource{arr}, names{"", "Magnet Name"}, "Debrid Name"}, // The debrid name is the last resort
+		{[]config.FolderNameSource{magnet}, names{"", hash}, "Debrid Name"},       // The placeholder name of a nameless magnet
+		{[]config.FolderNameSource{arr}, names{"Shows/Arr Name", ""}, "Shows Arr Name"},
+		{[]config.FolderNameSource{arr}, names{"..", ""}, "Debrid Name"},
+	}
+	for _, tt := range tests {
+		c := newTestCache(t, newFakeClient(), func(d *config.Debrid) { d.FolderNamePrecedence = tt.precedence })
+		if got := c.GetTorrentFolder(torrent(tt.names)); got != tt.want {
+			t.Errorf("folder with precedence %v, arr %q and magnet %q = %q, want %q", tt.precedence, tt.names.arr, tt.names.magnet, got, tt.want)
+		}
+	}
+}
+
+// A torrent refreshed from the debrid keeps the folder of the name it was added under
+func TestFolderNameKeptOnRefresh(t *testing.T) {
+	listed := func() *types.Torrent {
+		file := testFile("movie.mkv", "https://debrid/movie")
+		file.TorrentId = "1"
+		return &types.Torrent{
+			Id:               "1",
+			InfoHash:         "abc",
+			Name:             "Debrid Name",
+			Filename:         "Debrid Name",
+			OriginalFilename: "Debrid Name",
+			Added:            time.Now().Format(time.RFC3339),
+			Files:            map[string]types.File{"movie.mkv": file},
+		}
+	}
+	c := newTestCache(t, newFakeClient(listed()), func(d *config.Debrid) {
+		d.FolderNamePrecedence = []config.FolderNameSource{config.FolderNameArr, config.FolderNameDebrid}
+	})
+	added := listed()
+	added.RequestedName = "Arr Name"
+	if err := c.ProcessTorrent(added); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ProcessTorrent(listed()); err != nil {
+		t.Fatal(err)
+	}
+	if ct := c.GetTorrentByName("Arr Name"); ct == nil || ct.Id != "1" {
+		t.Error("torrent not served under the name it was added under after a refresh")
+	}
+	if ct := c.GetTorrentByName("Debrid Name"); ct != nil {
+		t.Error("torrent also served under the debrid name")
+	}
+}
+
+func TestCaseInsensitiveNames(t *testing.T) {
+	torrent := func(id, name string) *types.Torrent {
+		file := testFile(id+".mkv", "https://debrid/"+id)
+		file.TorrentId = id
+		return &types.Torrent{
+			Id:       id,
+			InfoHash: "hash" + id,
+			Name:     name,
+			Filename: name,
+			Added:    time.Now().Format(time.RFC3339),
+			Files:    map[string]types.File{file.Name: file},
+		}
+	}
+	tests := []struct {
+		foldCase bool
+		listed   []string
+		lookup   string // ID of the torrent found by "movie 2021", "" if none
+	}{
+		{false, []string{"MOVIE 2021", "Movie 2021"}, ""},
+		{true, []string{"Movie 2021"}, "2"},
+	}
+	for _, tt := range tests {
+		c := newTestCache(t, newFakeClient(), func(d *config.Debrid) { d.CaseInsensitiveNames = tt.foldCase })
+		for _, added := range []*types.Torrent{torrent("1", "Movie 2021"), torrent("2", "MOVIE 2021")} {
+			if err := c.ProcessTorrent(added); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var listed []string
+		for _, fi := range c.GetListing("__all__") {
+			listed = append(listed, fi.Name())
+		}
+		if strings.Join(listed, ",") != strings.Join(tt.listed, ",") {
+			t.Errorf("foldCase %v: listing %v, want %v", tt.foldCase, listed, tt.listed)
+		}
+		got := ""
+		if ct := c.GetTorrentByName("movie 2021"); ct != nil {
+			got = ct.Id
+		}
+		if got != tt.lookup {
+			t.Errorf("foldCase %v: GetTorrentByName(\"movie 2021\") = %q, want %q", tt.foldCase, got, tt.lookup)
+		}
+	}
+}
+
+// A directory added for a new category lists the torrents of that category
+func TestAddCustomFolder(t *testing.T) {
+	c := newTestCache(t, newFakeClient())
+	for id, category := range map[string]string{"1": "sonarr", "2": "radarr"} {
+		file := testFile("e01.mkv", "https://debrid/"+id)
+		file.TorrentId = id
+		torrent := &types.Torrent{
+			Id:       id,
+			InfoHash: "hash" + id,
+			Name:     "Torrent " + id,
+			Filename: "Torrent " + id,
+			Added:    time.Now().Format(time.RFC3339),
+			Files:    map[string]types.File{file.Name: file},
+		}
+		torrent.SetArr(arr.New(category, "", "", false, false, nil, "", ""))
+		if err := c.ProcessTorrent(torrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.AddCustomFolder("sonarr", config.WebdavDirectories{Filters: map[string]string{"category": "sonarr"}})
+	c.AddCustomFolder("sonarr", config.WebdavDirectories{Filters: map[string]string{"category": "radarr"}})
+	if folders := c.GetCustomFolders(); len(folders) != 1 || folders[0] != "sonarr" {
+		t.Errorf("custom folders = %v, want sonarr once", folders)
+	}
+	c.torrents.refreshListing()
+	listing := c.GetListing("sonarr")
+	if len(listing) != 1 || listing[0].Name() != "Torrent 1" {
+		t.Errorf("sonarr listing = %v, want Torrent 1", listing)
+	}
+}
+
+// The file order of a directory overrides the one of the debrid
+func TestGetFileSortOrder(t *testing.T) {
+	c := newTestCache(t, newFakeClient(), func(d *config.Debrid) {
+		d.FileSortOrder = config.FileSortByNatural
+		d.Directories = map[string]config.WebdavDirectories{
+			"shows":  {Filters: map[string]string{"category": "sonarr"}, FileSortOrder: config.FileSortByIndex},
+			"movies": {Filters: map[string]string{"category": "radarr"}},
+		}
+	})
+	for folder, want := range map[string]config.FileSortOrder{
+		"shows":   config.FileSortByIndex,
+		"movies":  config.FileSortByNatural,
+		"__all__": config.FileSortByNatural,
+	} {
+		if got := c.GetFileSortOrder(folder); got != want {
+			t.Errorf("GetFileSortOrder(%s) = %q, want %q", folder, got, want)
+		}
+	}
+}
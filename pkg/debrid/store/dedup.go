@@ -0,0 +1,135 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+)
+
+// DedupFolder presents every file once across all torrents, when WebDav.DedupFiles is set
+const DedupFolder = "__dedup__"
+
+// DuplicateFile is a file found in more than one torrent, identified by its checksum, or its name and size
+type DuplicateFile struct {
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	Canonical string   `json:"canonical"` // The torrent exposing the file in the dedup view, the oldest one
+	Torrents  []string `json:"torrents"`  // All the torrents referencing the file
+}
+
+type dedupIndex struct {
+	duplicates  []DuplicateFile
+	hidden      map[string]map[string]struct{} // torrent id -> file names hidden in the dedup view
+	fullyHidden map[string]struct{}            // torrent ids with every file hidden
+}
+
+// dedupFileKey identifies a file across torrents by the checksum the debrid reports, or by its base name and size.
+// File IDs are indexes within a torrent, unrelated files of different torrents share them
+func dedupFileKey(f types.File) string {
+	if f.MD5 != "" {
+		return "md5:" + strings.ToLower(f.MD5)
+	}
+	return fmt.Sprintf("name:%s:%d", path.Base(f.Name), f.Size)
+}
+
+// buildDedupIndex finds the files shared by several torrents, torrents is keyed by name.
+// The oldest torrent referencing a file keeps it in the dedup view.
+func buildDedupIndex(torrents map[string]CachedTorrent) *dedupIndex {
+	names := make([]string, 0, len(torrents))
+	for name := range torrents {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := torrents[names[i]], torrents[names[j]]
+		if !a.AddedOn.Equal(b.AddedOn) {
+			return a.AddedOn.Before(b.AddedOn)
+		}
+		return names[i] < names[j]
+	})
+
+	idx := &dedupIndex{
+		hidden:      make(map[string]map[string]struct{}),
+		fullyHidden: make(map[string]struct{}),
+	}
+	owners := make(map[string]*DuplicateFile)
+	keys := make([]string, 0)
+	for _, name := range names {
+		t := torrents[name]
+		files := t.GetFiles()
+		for _, f := range files {
+			key := dedupFileKey(f)
+			dup, ok := owners[key]
+			if !ok {
+				owners[key] = &DuplicateFile{Name: f.Name, Size: f.Size, Canonical: name, Torrents: []string{name}}
+				keys = append(keys, key)
+				continue
+			}
+			dup.Torrents = append(dup.Torrents, name)
+			if idx.hidden[t.Id] == nil {
+				idx.hidden[t.Id] = make(map[string]struct{})
+			}
+			idx.hidden[t.Id][f.Name] = struct{}{}
+		}
+		if len(files) > 0 && len(idx.hidden[t.Id]) == len(files) {
+			idx.fullyHidden[t.Id] = struct{}{}
+		}
+	}
+
+	for _, key := range keys {
+		if dup := owners[key]; len(dup.Torrents) > 1 {
+			idx.duplicates = append(idx.duplicates, *dup)
+		}
+	}
+	sort.Slice(idx.duplicates, func(i, j int) bool {
+		return strings.ToLower(idx.duplicates[i].Name) < strings.ToLower(idx.duplicates[j].Name)
+	})
+	return idx
+}
+
+func (c *Cache) refreshDedupIndex() {
+	if !c.config.DedupFiles {
+		return
+	}
+	c.dedup.Store(buildDedupIndex(c.torrents.getAllByName()))
+}
+
+// GetDuplicateFiles returns the files referenced by more than one torrent
+func (c *Cache) GetDuplicateFiles() []DuplicateFile {
+	idx := c.dedup.Load()
+	if idx == nil {
+		return []DuplicateFile{}
+	}
+	return idx.duplicates
+}
+
+// IsDedupHidden reports whether the file of the torrent is hidden from the dedup view
+func (c *Cache) IsDedupHidden(torrentId, filename string) bool {
+	idx := c.dedup.Load()
+	if idx == nil {
+		return false
+	}
+	_, hidden := idx.hidden[torrentId][filename]
+	return hidden
+}
+
+func (c *Cache) getDedupListing() []os.FileInfo {
+	listing := c.torrents.getListing()
+	idx := c.dedup.Load()
+	if idx == nil {
+		return listing
+	}
+	result := make([]os.FileInfo, 0, len(listing))
+	for _, fi := range listing {
+		if f, ok := fi.(*fileInfo); ok {
+			if _, hidden := idx.fullyHidden[f.id]; hidden {
+				continue
+			}
+		}
+		result = append(result, fi)
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDedupListing(t *testing.T) {
+	c := newTestCache(t, newFakeClient(), func(d *config.Debrid) { d.DedupFiles = true })
+	file := func(torrentId, id, name string, size int64, md5 string) types.File {
+		return types.File{TorrentId: torrentId, Id: id, Name: name, Path: name, Size: size, MD5: md5, Link: "https://debrid/" + torrentId + "/" + name}
+	}
+	torrents := []*types.Torrent{
+		{Id: "1", Name: "Movie", Files: map[string]types.File{
+			"movie.mkv": file("1", "1", "movie.mkv", 4<<30, "ABC"),
+			"movie.nfo": file("1", "2", "movie.nfo", 1<<10, ""),
+		}},
+		// Same checksum under another name, its only file: hidden as a whole
+		{Id: "2", Name: "Movie Copy", Files: map[string]types.File{
+			"Movie.2021.mkv": file("2", "1", "Movie.2021.mkv", 4<<30, "abc"),
+		}},
+		// Same name and size without a checksum, and a file of its own
+		{Id: "3", Name: "Movie Extras", Files: map[string]types.File{
+			"movie.nfo":  file("3", "1", "movie.nfo", 1<<10, ""),
+			"extras.mkv": file("3", "2", "extras.mkv", 1<<30, ""),
+		}},
+		// Same file IDs and names as the first, other sizes: not duplicates
+		{Id: "4", Name: "Other", Files: map[string]types.File{
+			"movie.mkv": file("4", "1", "movie.mkv", 2<<30, ""),
+			"movie.nfo": file("4", "2", "movie.nfo", 2<<10, ""),
+		}},
+	}
+	added := time.Now().Add(-time.Hour)
+	for i, torrent := range torrents {
+		torrent.InfoHash = "hash" + torrent.Id
+		torrent.Filename = torrent.Name
+		torrent.Added = added.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+		if err := c.ProcessTorrent(torrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.refreshDedupIndex()
+
+	duplicates := c.GetDuplicateFiles()
+	if len(duplicates) != 2 {
+		t.Fatalf("duplicates = %+v, want movie.mkv and movie.nfo", duplicates)
+	}
+	for i, want := range []DuplicateFile{
+		{Name: "movie.mkv", Size: 4 << 30, Canonical: "Movie", Torrents: []string{"Movie", "Movie Copy"}},
+		{Name: "movie.nfo", Size: 1 << 10, Canonical: "Movie", Torrents: []string{"Movie", "Movie Extras"}},
+	} {
+		got := duplicates[i]
+		if got.Name != want.Name || got.Size != want.Size || got.Canonical != want.Canonical || !slices.Equal(got.Torrents, want.Torrents) {
+			t.Errorf("duplicate %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	hidden := []struct {
+		torrentId, file string
+		want            bool
+	}{
+		{"1", "movie.mkv", false},
+		{"2", "Movie.2021.mkv", true},
+		{"3", "movie.nfo", true},
+		{"3", "extras.mkv", false},
+		{"4", "movie.mkv", false},
+	}
+	for _, h := range hidden {
+		if got := c.IsDedupHidden(h.torrentId, h.file); got != h.want {
+			t.Errorf("IsDedupHidden(%s, %s) = %v, want %v", h.torrentId, h.file, got, h.want)
+		}
+	}
+
+	var listed []string
+	for _, fi := range c.GetListing(DedupFolder) {
+		listed = append(listed, fi.Name())
+	}
+	slices.Sort(listed)
+	if want := []string{"Movie", "Movie Extras", "Other"}; !slices.Equal(listed, want) {
+		t.Errorf("dedup listing = %v, want %v", listed, want)
+	}
+	if got := len(c.GetListing("__all__")); got != 4 {
+		t.Errorf("raw listing has %d torrents, want all 4", got)
+	}
+}
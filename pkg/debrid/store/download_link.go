@@ -1,8 +1,11 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/metrics"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/debrid/types"
 )
@@ -102,8 +105,21 @@ func (c *Cache) fetchDownloadLink(torrentName, filename, fileLink string) (*type
 	}
 
 	c.logger.Trace().Msgf("Getting download link for %s(%s)", filename, file.Link)
-	downloadLink, err := c.client.GetDownloadLink(ct.Torrent, &file)
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	var downloadLink *types.DownloadLink
+	err := utils.RetryHTTP(context.Background(), func(ctx context.Context) error {
+		var err error
+		downloadLink, err = c.client.GetDownloadLink(ct.Torrent, &file)
+		return err
+	}, c.retryOptions())
+	c.breaker.Record(err)
 	if err != nil {
+		var httpErr *utils.HTTPError
+		if errors.As(err, &httpErr) {
+			metrics.DebridError(c.client.Name(), httpErr.Code)
+		}
 		if errors.Is(err, utils.HosterUnavailableError) {
 			newCt, err := c.reInsertTorrent(ct)
 			if err != nil {
@@ -134,16 +150,33 @@ func (c *Cache) fetchDownloadLink(torrentName, filename, fileLink string) (*type
 		return nil, fmt.Errorf("download link is empty")
 	}
 
+	if name := unlockedName(file, downloadLink); name != "" && c.config.UnlockedFilenames != config.UnlockedFilenamesProvider {
+		c.logger.Debug().
+			Str("torrent", ct.Name).
+			Str("file", file.Name).
+			Str("unlocked_name", name).
+			Msg("Debrid unlocked the file under another name, keeping the torrent's file name")
+	}
+
 	// Set link to cache
 	go c.client.Accounts().SetDownloadLink(fileLink, downloadLink)
 	return downloadLink, nil
 }
 
+// retryOptions returns the retries of the transient errors getting a download link
+func (c *Cache) retryOptions() utils.RetryOptions {
+	return utils.RetryOptions{
+		MaxAttempts: c.config.GetRetryAttempts(),
+		BaseDelay:   c.config.GetRetryBaseDelay(),
+	}
+}
+
 func (c *Cache) GetFileDownloadLinks(t CachedTorrent) {
 	if err := c.client.GetFileDownloadLinks(t.Torrent); err != nil {
 		c.logger.Error().Err(err).Str("torrent", t.Name).Msg("Failed to generate download links")
 		return
 	}
+	c.applyUnlockedNames(t.Id)
 }
 
 func (c *Cache) checkDownloadLink(link string) (string, error) {
@@ -191,3 +224,44 @@ func (c *Cache) GetDownloadByteRange(torrentName, filename string) (*[2]int64, e
 func (c *Cache) GetTotalActiveDownloadLinks() int {
 	return c.client.Accounts().GetLinksCount()
 }
+
+// IPLockedBehavior returns how links are served when clients are redirected to the debrid, "" if its links aren't IP-locked
+func (c *Cache) IPLockedBehavior() config.IPLockedLinks {
+	if !c.config.IPLocked {
+		return ""
+	}
+	return c.config.IPLockedBehavior
+}
+
+// CanLinkForIP reports whether the debrid can generate links usable from another IP
+func (c *Cache) CanLinkForIP() bool {
+	_, ok := c.client.(types.ClientIPLinker)
+	return ok
+}
+
+// GetDownloadLinkForIP generates a download link usable from ip. It isn't cached, as it's only valid for that client
+func (c *Cache) GetDownloadLinkForIP(torrentName, filename, ip string) (string, error) {
+	linker, ok := c.client.(types.ClientIPLinker)
+	if !ok {
+		return "", fmt.Errorf("%s can't generate links for another IP", c.client.Name())
+	}
+	ct := c.GetTorrentByName(torrentName)
+	if ct == nil {
+		return "", fmt.Errorf("torrent not found")
+	}
+	file, ok := ct.GetFile(filename)
+	if !ok {
+		return "", fmt.Errorf("file %s not found in torrent %s", filename, torrentName)
+	}
+	if file.Link == "" {
+		return "", fmt.Errorf("file %s has no link yet", filename)
+	}
+	dl, err := linker.GetDownloadLinkForIP(ct.Torrent, &file, ip)
+	if err != nil {
+		return "", err
+	}
+	if dl == nil || dl.DownloadLink == "" {
+		return "", fmt.Errorf("download link is empty for %s in torrent %s", filename, torrentName)
+	}
+	return dl.DownloadLink, nil
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileListChanges is what changed between two file lists of a torrent
+type fileListChanges struct {
+	renamed map[string]string // Pinned name -> name reported by the debrid
+	added   []string
+	removed []string
+}
+
+func (ch fileListChanges) empty() bool {
+	return len(ch.renamed) == 0 && len(ch.added) == 0 && len(ch.removed) == 0
+}
+
+// pinFiles reconciles the files reported by the debrid with the ones previously listed, matching them by file id.
+// Files keep the name they were first listed with, new files are added under their own name and missing ones dropped.
+// It returns nil if the files can't be matched, i.e some have no id.
+func pinFiles(previous, current map[string]types.File) (map[string]types.File, fileListChanges) {
+	var changes fileListChanges
+	pinned := make(map[string]string, len(previous)) // file id -> pinned name
+	for name, f := range previous {
+		if f.Id == "" {
+			return nil, changes
+		}
+		pinned[f.Id] = name
+	}
+	for _, f := range current {
+		if f.Id == "" {
+			return nil, changes
+		}
+	}
+
+	files := make(map[string]types.File, len(current))
+	var unpinned []types.File
+	for name, f := range current {
+		pinnedName, ok := pinned[f.Id]
+		if !ok {
+			unpinned = append(unpinned, f)
+			continue
+		}
+		delete(pinned, f.Id)
+		if pinnedName != name {
+			if changes.renamed == nil {
+				changes.renamed = make(map[string]string)
+			}
+			changes.renamed[pinnedName] = name
+			f.Name = pinnedName
+		}
+		files[pinnedName] = f
+	}
+	for _, f := range unpinned {
+		if _, taken := files[f.Name]; taken {
+			// The name is pinned to another file, tell them apart with the file id
+			ext := filepath.Ext(f.Name)
+			f.Name = fmt.Sprintf("%s.%s%s", strings.TrimSuffix(f.Name, ext), f.Id, ext)
+		}
+		files[f.Name] = f
+		changes.added = append(changes.added, f.Name)
+	}
+	for _, name := range pinned {
+		changes.removed = append(changes.removed, name)
+	}
+	return files, changes
+}
+
+// reconcileFiles applies FileListChanges to a torrent refreshed from the debrid, previous is its cached version
+func (c *Cache) reconcileFiles(previous CachedTorrent, t *CachedTorrent) {
+	if c.config.FileListChanges != config.FileListPin || previous.Torrent == nil || t.Torrent == nil || len(t.Files) == 0 {
+		return
+	}
+	// Files of other torrents may have been merged in under the same name
+	own := make(map[string]types.File, len(previous.Files))
+	for name, f := range previous.Files {
+		if f.TorrentId == "" || f.TorrentId == t.Id {
+			own[name] = f
+		}
+	}
+	if len(own) == 0 {
+		return
+	}
+
+	files, changes := pinFiles(own, t.Files)
+	if files == nil || changes.empty() {
+		return
+	}
+	c.logger.Info().
+		Str("torrent", t.Name).
+		Interface("renamed", changes.renamed).
+		Strs("added", changes.added).
+		Strs("removed", changes.removed).
+		Msg("Debrid reported a different file list, keeping the pinned file names")
+	t.Files = files
+}
+
+// unlockedName returns the name the debrid unlocked a file under, "" if it's the file's own name or has none
+func unlockedName(file types.File, dl *types.DownloadLink) string {
+	if dl == nil || dl.Filename == "" {
+		return ""
+	}
+	name := path.Base(strings.ReplaceAll(dl.Filename, "\\", "/"))
+	if name == "." || name == "/" || name == file.Name {
+		return ""
+	}
+	return name
+}
+
+// applyUnlockedNames applies UnlockedFilenames to the files of a torrent the debrid unlocked under another name,
+// from their cached download links. Called once the links are generated or refreshed rather than when a file is
+// opened, the renamed files are a copy of the torrent's, stored through setTorrent. The download links are cached
+// by the file link, so they still serve the files once renamed.
+func (c *Cache) applyUnlockedNames(torrentId string) {
+	if c.config.UnlockedFilenames != config.UnlockedFilenamesProvider {
+		return
+	}
+	torrent, ok := c.torrents.getByID(torrentId)
+	if !ok || torrent.Torrent == nil {
+		return
+	}
+	// Not to bring back a torrent a reinsert or delete replaced meanwhile
+	unlock := c.lockTorrent(torrent)
+	defer unlock()
+	if torrent, ok = c.torrents.getByID(torrentId); !ok || torrent.Torrent == nil {
+		return
+	}
+	t := torrent.Torrent.Clone()
+	files := t.Files
+	renamed := false
+	for _, file := range torrent.Files {
+		if file.TorrentId != "" && file.TorrentId != torrent.Id {
+			// Merged in from another torrent under the same name
+			continue
+		}
+		dl, err := c.client.Accounts().GetDownloadLink(file.Link)
+		if err != nil {
+			continue
+		}
+		name := unlockedName(file, dl)
+		if name == "" {
+			continue
+		}
+		// Names of the files before renaming count too, so the result doesn't depend on the order of the files
+		_, renamedTo := files[name]
+		_, original := torrent.Files[name]
+		if renamedTo || original {
+			c.logger.Warn().
+				Str("torrent", torrent.Name).
+				Str("file", file.Name).
+				Str("unlocked_name", name).
+				Msg("Debrid unlocked the file under the name of another file, keeping its name")
+			continue
+		}
+		c.logger.Info().
+			Str("torrent", torrent.Name).
+			Str("file", file.Name).
+			Str("unlocked_name", name).
+			Msg("Debrid unlocked the file under another name, renaming it")
+		delete(files, file.Name)
+		file.Name = name
+		files[name] = file
+		renamed = true
+	}
+	if !renamed {
+		return
+	}
+	torrent.Torrent = t
+	c.setTorrent(torrent, func(torrent CachedTorrent) {
+		c.listingDebouncer.Call(true)
+	})
+}
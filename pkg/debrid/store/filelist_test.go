@@ -0,0 +1,117 @@
+package store
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPinFiles(t *testing.T) {
+	file := func(id, name string) types.File { return types.File{Id: id, Name: name} }
+	previous := map[string]types.File{
+		"e01.mkv": file("1", "e01.mkv"),
+		"e02.mkv": file("2", "e02.mkv"),
+		"e03.mkv": file("3", "e03.mkv"),
+	}
+	current := map[string]types.File{
+		"Show.E01.mkv": file("1", "Show.E01.mkv"), // Renamed
+		"e02.mkv":      file("2", "e02.mkv"),      // Unchanged
+		"e01.mkv":      file("4", "e01.mkv"),      // Another file under a pinned name
+		"e05.mkv":      file("5", "e05.mkv"),      // Added, e03.mkv removed
+	}
+
+	files, changes := pinFiles(previous, current)
+	want := []string{"e01.4.mkv", "e01.mkv", "e02.mkv", "e05.mkv"}
+	if got := slices.Sorted(maps.Keys(files)); !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if f := files["e01.mkv"]; f.Id != "1" || f.Name != "e01.mkv" {
+		t.Errorf("e01.mkv = %+v, want file 1 under its pinned name", f)
+	}
+	if changes.renamed["e01.mkv"] != "Show.E01.mkv" || len(changes.renamed) != 1 {
+		t.Errorf("renamed = %v, want e01.mkv -> Show.E01.mkv", changes.renamed)
+	}
+	if slices.Sort(changes.added); !slices.Equal(changes.added, []string{"e01.4.mkv", "e05.mkv"}) {
+		t.Errorf("added = %v, want e01.4.mkv and e05.mkv", changes.added)
+	}
+	if !slices.Equal(changes.removed, []string{"e03.mkv"}) {
+		t.Errorf("removed = %v, want e03.mkv", changes.removed)
+	}
+
+	if _, changes := pinFiles(previous, previous); !changes.empty() {
+		t.Errorf("changes = %+v for the same file list, want none", changes)
+	}
+
+	current["e06.mkv"] = file("", "e06.mkv")
+	if files, _ := pinFiles(previous, current); files != nil {
+		t.Error("files without an id were pinned")
+	}
+}
+
+func TestUnlockedName(t *testing.T) {
+	file := types.File{Name: "movie.mkv"}
+	tests := []struct {
+		dl   *types.DownloadLink
+		want string
+	}{
+		{nil, ""},
+		{&types.DownloadLink{}, ""},
+		{&types.DownloadLink{Filename: "movie.mkv"}, ""},
+		{&types.DownloadLink{Filename: "movie [account].mkv"}, "movie [account].mkv"},
+		{&types.DownloadLink{Filename: "folder/movie.mp4"}, "movie.mp4"},
+		{&types.DownloadLink{Filename: `folder\movie.mp4`}, "movie.mp4"},
+		{&types.DownloadLink{Filename: "/"}, ""},
+	}
+	for _, tt := range tests {
+		if got := unlockedName(file, tt.dl); got != tt.want {
+			t.Errorf("unlockedName(%+v) = %q, want %q", tt.dl, got, tt.want)
+		}
+	}
+}
+
+// accountsClient is a fake client with download accounts, to cache download links
+type accountsClient struct {
+	*fakeClient
+	accounts *types.Accounts
+}
+
+func (f *accountsClient) Accounts() *types.Accounts { return f.accounts }
+
+func TestApplyUnlockedNames(t *testing.T) {
+	for _, policy := range []config.UnlockedFilenames{config.UnlockedFilenamesPreserve, config.UnlockedFilenamesProvider} {
+		t.Run(string(policy), func(t *testing.T) {
+			torrent := &types.Torrent{
+				Id:       "1",
+				InfoHash: "abc",
+				Name:     "Show.S01",
+				Added:    time.Now().Format(time.RFC3339),
+				Files: map[string]types.File{
+					"e01.mkv": testFile("e01.mkv", "https://debrid/e01"),
+					"e02.mkv": testFile("e02.mkv", "https://debrid/e02"),
+				},
+			}
+			client := &accountsClient{fakeClient: newFakeClient(torrent)}
+			c := newTestCache(t, client, func(d *config.Debrid) { d.UnlockedFilenames = policy })
+			client.accounts = types.NewAccounts(c.config)
+			if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+				t.Fatal(err)
+			}
+			expires := time.Now().Add(time.Hour)
+			client.accounts.SetDownloadLink("https://debrid/e01", &types.DownloadLink{Filename: "e01 [account].mkv", DownloadLink: "https://cdn/e01", ExpiresAt: expires})
+			client.accounts.SetDownloadLink("https://debrid/e02", &types.DownloadLink{Filename: "e01.mkv", DownloadLink: "https://cdn/e02", ExpiresAt: expires}) // Name of another file
+
+			c.applyUnlockedNames("1")
+			want := []string{"e01.mkv", "e02.mkv"}
+			if policy == config.UnlockedFilenamesProvider {
+				want = []string{"e01 [account].mkv", "e02.mkv"}
+			}
+			got := slices.Sorted(maps.Keys(c.GetTorrent("1").Files))
+			if !slices.Equal(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"sync"
+)
+
+// keyedMutex serializes the operations on a key, its zero value is ready to use
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int // Holders and waiters, the lock is dropped once it's 0
+}
+
+// lock locks key and returns its unlock
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// torrentKey is the key of a torrent's operations, its info hash so its reinserted copies share it
+func torrentKey(ct CachedTorrent) string {
+	return cmp.Or(ct.InfoHash, ct.Id)
+}
+
+// lockTorrent serializes the reinsert swaps, deletes and renames of a torrent, it returns its unlock
+func (c *Cache) lockTorrent(ct CachedTorrent) func() {
+	return c.torrentLocks.lock(torrentKey(ct))
+}
+
+// repairAbort cancels a reinsert in progress, a pointer so it can be compared in repairAborts.
+// done is closed once the reinsert is over.
+type repairAbort struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startRepair registers a reinsert of the torrent in progress, a delete aborts it by canceling the returned context.
+// done must be called once the reinsert is over.
+func (c *Cache) startRepair(ct CachedTorrent) (ctx context.Context, done func()) {
+	key := torrentKey(ct)
+	ctx, cancel := context.WithCancel(context.Background())
+	abort := &repairAbort{cancel: cancel, done: make(chan struct{})}
+	c.repairAborts.Store(key, abort)
+	return ctx, func() {
+		c.repairAborts.CompareAndDelete(key, abort)
+		cancel()
+		close(abort.done)
+	}
+}
+
+// awaitRepair waits for the reinsert of the torrent in progress, if any, to be over. It returns ctx's error if ctx
+// is done first.
+func (c *Cache) awaitRepair(ctx context.Context, ct CachedTorrent) error {
+	abort, ok := c.repairAborts.Load(torrentKey(ct))
+	if !ok {
+		return nil
+	}
+	select {
+	case <-abort.(*repairAbort).done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// repairing reports whether a reinsert of the torrent, or of the torrent it's a copy of, is in progress
+func (c *Cache) repairing(t *types.Torrent) bool {
+	_, ok := c.repairAborts.Load(torrentKey(CachedTorrent{Torrent: t}))
+	return ok
+}
+
+// abortRepair signals the reinsert of the torrent in progress, if any, to abort
+func (c *Cache) abortRepair(ct CachedTorrent) {
+	if abort, ok := c.repairAborts.Load(torrentKey(ct)); ok {
+		c.logger.Info().Str("torrent", ct.Name).Msg("Torrent deleted during its repair, aborting the reinsert")
+		abort.(*repairAbort).cancel()
+	}
+}
+
+// reinsertedCopy returns the id of the copy that replaced the torrent while a delete waited for its reinsert, "" if none
+func (c *Cache) reinsertedCopy(ct CachedTorrent) string {
+	t, ok := c.torrents.getByName(c.GetTorrentFolder(ct.Torrent))
+	if !ok || t.Id == ct.Id || t.InfoHash == "" || t.InfoHash != ct.InfoHash {
+		return ""
+	}
+	return t.Id
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var k keyedMutex
+	unlock := k.lock("a")
+	locked := make(chan struct{})
+	go func() {
+		defer k.lock("a")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("key locked twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	k.lock("b")() // Other keys aren't held up
+	unlock()
+	<-locked
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.locks) != 0 {
+		t.Errorf("%d locks left once released, want 0", len(k.locks))
+	}
+}
+
+// blockingClient is a reinsertClient whose reinserts wait for release once submitted
+type blockingClient struct {
+	*reinsertClient
+	submitted chan struct{}
+	release   chan struct{}
+}
+
+func (f *blockingClient) SubmitMagnet(t *types.Torrent) (*types.Torrent, error) {
+	close(f.submitted)
+	<-f.release
+	return f.reinsertClient.SubmitMagnet(t)
+}
+
+// A torrent deleted while the repair reinserts it stays deleted, the reinserted copy included
+func TestDeleteDuringRepair(t *testing.T) {
+	for _, policy := range []config.DeleteDuringRepair{config.DeleteDuringRepairAbort, config.DeleteDuringRepairWait} {
+		t.Run(string(policy), func(t *testing.T) {
+			file := testFile("movie.mkv", "https://debrid/old")
+			file.TorrentId = "old"
+			torrent := &types.Torrent{
+				Id:       "old",
+				InfoHash: "abc",
+				Name:     "Movie 2024",
+				Added:    time.Now().Add(-time.Hour).Format(time.RFC3339),
+				Files:    map[string]types.File{"movie.mkv": file},
+			}
+			client := &blockingClient{
+				reinsertClient: &reinsertClient{fakeClient: newFakeClient(torrent)},
+				submitted:      make(chan struct{}),
+				release:        make(chan struct{}),
+			}
+			c := newTestCache(t, client)
+			config.Get().Repair.DeleteDuringRepair = policy
+			if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+				t.Fatal(err)
+			}
+
+			repaired := make(chan error, 1)
+			go func() {
+				_, err := c.reInsertTorrent(c.GetTorrent("old"))
+				repaired <- err
+			}()
+			<-client.submitted
+
+			deleted := make(chan error, 1)
+			go func() { deleted <- c.DeleteTorrent(context.Background(), "old") }()
+			select {
+			case err := <-deleted:
+				t.Fatalf("delete returned %v during the reinsert, want it to wait", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(client.release)
+
+			err := <-repaired
+			if policy == config.DeleteDuringRepairAbort && !errors.Is(err, errRepairAborted) {
+				t.Errorf("reinsert error = %v, want it aborted", err)
+			}
+			if policy == config.DeleteDuringRepairWait && err != nil {
+				t.Errorf("reinsert error = %v, want it completed", err)
+			}
+			if err := <-deleted; err != nil {
+				t.Fatal(err)
+			}
+
+			if c.GetTorrent("old") != nil || c.GetTorrent("new") != nil {
+				t.Error("torrent back in the cache after its delete")
+			}
+			waitFor(t, func() bool {
+				client.mu.Lock()
+				defer client.mu.Unlock()
+				return slices.Contains(client.deleted, "old") && slices.Contains(client.deleted, "new")
+			})
+		})
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// refreshClient is a reinsertClient listing its torrents. The reinserted copy is listed with its links, but can't
+// generate a download link, and its status check waits for a refresh to run.
+type refreshClient struct {
+	*reinsertClient
+	checking  chan struct{}
+	refreshed chan struct{}
+}
+
+func (f *refreshClient) SubmitMagnet(t *types.Torrent) (*types.Torrent, error) {
+	submitted, _ := f.reinsertClient.SubmitMagnet(t)
+	listed, _ := f.reinsertClient.CheckStatus(submitted.Clone())
+	f.mu.Lock()
+	f.torrents[listed.Id] = listed
+	f.mu.Unlock()
+	return submitted, nil
+}
+
+func (f *refreshClient) CheckStatus(t *types.Torrent) (*types.Torrent, error) {
+	close(f.checking)
+	<-f.refreshed
+	return f.reinsertClient.CheckStatus(t)
+}
+
+func (f *refreshClient) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	return nil, errors.New("no link")
+}
+
+func (f *refreshClient) GetTorrents() ([]*types.Torrent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var torrents []*types.Torrent
+	for _, t := range f.torrents {
+		torrents = append(torrents, t.Clone())
+	}
+	return torrents, nil
+}
+
+// A refresh during a reinsert doesn't expose the copy being checked, it's rolled back if it doesn't work
+func TestRefreshDuringRepair(t *testing.T) {
+	file := testFile("movie.mkv", "https://debrid/old")
+	file.TorrentId = "old"
+	torrent := &types.Torrent{
+		Id:       "old",
+		InfoHash: "abc",
+		Name:     "Movie 2024",
+		Added:    time.Now().Add(-time.Hour).Format(time.RFC3339),
+		Files:    map[string]types.File{"movie.mkv": file},
+	}
+	client := &refreshClient{
+		reinsertClient: &reinsertClient{fakeClient: newFakeClient(torrent)},
+		checking:       make(chan struct{}),
+		refreshed:      make(chan struct{}),
+	}
+	c := newTestCache(t, client, func(d *config.Debrid) { d.Workers = 2 })
+	if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+		t.Fatal(err)
+	}
+
+	repaired := make(chan error, 1)
+	go func() {
+		_, err := c.reInsertTorrent(c.GetTorrent("old"))
+		repaired <- err
+	}()
+	<-client.checking
+	c.refreshTorrents(context.Background())
+	close(client.refreshed)
+	if err := <-repaired; !errors.Is(err, ErrReInsertFailed) {
+		t.Fatalf("reinsert error = %v, want it failed", err)
+	}
+
+	if c.GetTorrent("new") != nil {
+		t.Error("copy rolled back by the reinsert exposed by the refresh")
+	}
+	ct, ok := c.torrents.getByName(c.GetTorrentFolder(torrent))
+	if !ok || ct.Id != "old" {
+		t.Error("folder of the torrent not served by the original")
+	}
+}
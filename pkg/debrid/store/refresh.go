@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/pkg/debrid/types"
 	"io"
 	"net/http"
@@ -32,6 +33,7 @@ func (fi *fileInfo) Sys() interface{}   { return nil }
 func (c *Cache) RefreshListings(refreshRclone bool) {
 	// Copy the torrents to a string|time map
 	c.torrents.refreshListing() // refresh torrent listings
+	c.refreshDedupIndex()
 
 	if refreshRclone {
 		if err := c.refreshRclone(); err != nil {
@@ -85,6 +87,13 @@ func (c *Cache) refreshTorrents(ctx context.Context) {
 	newTorrents := make([]*types.Torrent, 0)
 	for _, t := range debTorrents {
 		if _, exists := cachedTorrents[t.Id]; !exists {
+			if c.repairing(t) {
+				// The copy a reinsert is checking, the reinsert swaps it in once it works
+				continue
+			}
+			newTorrents = append(newTorrents, t)
+		} else if ct, ok := c.torrents.getByID(t.Id); ok && !ct.IsComplete {
+			// Partially exposed torrent, check for newly available files
 			newTorrents = append(newTorrents, t)
 		}
 	}
@@ -212,6 +221,14 @@ func (c *Cache) refreshTorrent(torrentId string) *CachedTorrent {
 		c.logger.Error().Err(err).Msgf("Failed to get torrent %s", torrentId)
 		return nil
 	}
+	if c.restoreArr(torrent) {
+		// Fetched without its arr, the files are filtered again with the size limits of the arr
+		torrent.Files = make(map[string]types.File)
+		if err := c.client.UpdateTorrent(torrent); err != nil {
+			c.logger.Error().Err(err).Msgf("Failed to get torrent %s", torrentId)
+			return nil
+		}
+	}
 	addedOn, err := time.Parse(time.RFC3339, torrent.Added)
 	if err != nil {
 		addedOn = time.Now()
@@ -249,6 +266,11 @@ func (c *Cache) refreshDownloadLinks(ctx context.Context) {
 	}
 
 	c.client.Accounts().SetDownloadLinks(links)
+	if c.config.UnlockedFilenames == config.UnlockedFilenamesProvider {
+		for id := range c.torrents.getIdMaps() {
+			c.applyUnlockedNames(id)
+		}
+	}
 
 	c.logger.Debug().Msgf("Refreshed download %d links", c.client.Accounts().GetLinksCount())
 }
@@ -7,10 +7,21 @@ import (
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 )
 
+// errRepairCooldown is returned when a torrent was repaired less than Repair.MinRepairInterval ago
+var errRepairCooldown = errors.New("torrent was repaired recently")
+
+// errRepairAborted is returned when a torrent is deleted during its reinsert
+var errRepairAborted = errors.New("torrent was deleted during its repair")
+
+// ErrReInsertFailed is returned when a torrent couldn't be reinserted, the original is kept
+var ErrReInsertFailed = errors.New("reinsert failed")
+
 type reInsertRequest struct {
 	result *CachedTorrent
 	err    error
@@ -36,6 +47,9 @@ func (r *reInsertRequest) Wait() (*CachedTorrent, error) {
 
 func (c *Cache) markAsFailedToReinsert(torrentId string) {
 	c.failedToReinsert.Store(torrentId, struct{}{})
+	if config.Get().Repair.ReInsertFailure == config.ReInsertFailureKeep {
+		return
+	}
 
 	// Remove the torrent from the directory if it has failed to reinsert, max retries are hardcoded to 5
 	if torrent, ok := c.torrents.getByID(torrentId); ok {
@@ -59,51 +73,122 @@ func (c *Cache) markAsSuccessfullyReinserted(torrentId string) {
 	}
 }
 
-func (c *Cache) GetBrokenFiles(t *CachedTorrent, filenames []string) []string {
+// inRepairCooldown reports whether the torrent was repaired within Repair.MinRepairInterval
+func (c *Cache) inRepairCooldown(t *CachedTorrent) bool {
+	interval := config.Get().Repair.GetMinRepairInterval()
+	if interval <= 0 || t.LastRepaired.IsZero() {
+		return false
+	}
+	return time.Since(t.LastRepaired) < interval
+}
+
+// GetBrokenFiles returns the broken files of the torrent, after trying to reinsert it. The error reports a failed
+// reinsertion, the torrent is then left as it was.
+func (c *Cache) GetBrokenFiles(t *CachedTorrent, filenames []string) ([]string, error) {
+	t, brokenFiles, checked := c.checkFiles(t, filenames, true)
+	if !checked {
+		return brokenFiles, nil
+	}
+
+	// Try to reinsert the torrent if it's broken
+	if len(brokenFiles) > 0 && t.Torrent != nil {
+		// Check if the torrent is already in progress
+		if _, err := c.reInsertTorrent(t); err != nil {
+			if errors.Is(err, errRepairCooldown) {
+				return nil, nil
+			}
+			c.logger.Error().Err(err).Str("torrentId", t.Torrent.Id).Msg("Failed to reinsert torrent")
+			return brokenFiles, err // Return broken files if reinsert fails
+		}
+		return nil, nil // Return nil if the torrent was successfully reinserted
+	}
+
+	return brokenFiles, nil
+}
+
+// FindBrokenFiles returns the broken files of the torrent without refreshing or reinserting it, for a dry run.
+// It reports whether GetBrokenFiles would try to reinsert the torrent.
+func (c *Cache) FindBrokenFiles(t *CachedTorrent, filenames []string) ([]string, bool) {
+	t, brokenFiles, checked := c.checkFiles(t, filenames, false)
+	if !checked || len(brokenFiles) == 0 || t.Torrent == nil {
+		return brokenFiles, false
+	}
+	_, failed := c.failedToReinsert.Load(t.Id)
+	return brokenFiles, !failed
+}
+
+// checkFiles checks the links of the torrent files according to the repair strategy, refreshing the torrent first if
+// refresh is set and some links are missing. It returns the torrent checked and its broken files, checked is false if
+// the torrent was skipped or couldn't be refreshed, the broken files are then final.
+func (c *Cache) checkFiles(t *CachedTorrent, filenames []string, refresh bool) (*CachedTorrent, []string, bool) {
 	files := make(map[string]types.File)
-	repairStrategy := config.Get().Repair.Strategy
+	if c.inRepairCooldown(t) {
+		c.logger.Debug().Str("torrentId", t.Id).Msgf("Skipping torrent repaired at %s", t.LastRepaired.Format(time.RFC3339))
+		return t, nil, false
+	}
+	if !t.IsComplete {
+		// Still downloading, only its available files are exposed
+		return t, nil, false
+	}
 	brokenFiles := make([]string, 0)
-	if len(filenames) > 0 {
+	large := c.IsLargeTorrent(t.Torrent)
+	limit := c.config.LargeTorrentCheckLimit
+	switch {
+	case large:
+		// Only check a sample of the files, a few at a time
+		files = sampleFiles(t.Files, filenames, limit)
+	case len(filenames) > 0:
 		for name, f := range t.Files {
 			if utils.Contains(filenames, name) {
 				files[name] = f
 			}
 		}
-	} else {
+	default:
 		files = t.Files
 	}
 	for _, f := range files {
 		// Check if file is missing
-		if f.Link == "" {
+		if f.Link == "" && refresh {
 			// refresh torrent and then break
 			if newT := c.refreshTorrent(f.TorrentId); newT != nil {
 				t = newT
 			} else {
 				c.logger.Error().Str("torrentId", t.Torrent.Id).Msg("Failed to refresh torrent")
-				return filenames // Return original filenames if refresh fails(torrent is somehow botched)
+				return t, filenames, false // Return original filenames if refresh fails(torrent is somehow botched)
 			}
+			break
 		}
 	}
 
 	if t.Torrent == nil {
 		c.logger.Error().Str("torrentId", t.Torrent.Id).Msg("Failed to refresh torrent")
-		return filenames // Return original filenames if refresh fails(torrent is somehow botched)
+		return t, filenames, false // Return original filenames if refresh fails(torrent is somehow botched)
 	}
 
 	files = t.Files
+	repairStrategy := config.Get().RepairStrategyFor(t.Torrent.ArrName())
+	checked := files
+	concurrency := len(files)
+	if large {
+		concurrency = limit
+		checked = sampleFiles(files, filenames, limit)
+	}
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	sem := make(chan struct{}, max(concurrency, 1))
 
 	// Use a mutex to protect brokenFiles slice and torrent-wide failure flag
 	var mu sync.Mutex
 	torrentWideFailed := false
 
-	wg.Add(len(files))
+	wg.Add(len(checked))
 
-	for _, f := range files {
+	for _, f := range checked {
 		go func(f types.File) {
 			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
 			select {
 			case <-ctx.Done():
@@ -154,18 +239,31 @@ func (c *Cache) GetBrokenFiles(t *CachedTorrent, filenames []string) []string {
 		}
 	}
 	// For per_file strategy, brokenFiles already contains only the broken ones
+	return t, brokenFiles, true
+}
 
-	// Try to reinsert the torrent if it's broken
-	if len(brokenFiles) > 0 && t.Torrent != nil {
-		// Check if the torrent is already in progress
-		if _, err := c.reInsertTorrent(t); err != nil {
-			c.logger.Error().Err(err).Str("torrentId", t.Torrent.Id).Msg("Failed to reinsert torrent")
-			return brokenFiles // Return broken files if reinsert fails
+// sampleFiles returns up to limit files to check for a large torrent, the requested ones or the first files by name
+func sampleFiles(files map[string]types.File, filenames []string, limit int) map[string]types.File {
+	if len(filenames) > 0 {
+		requested := make(map[string]types.File, min(limit, len(filenames)))
+		for _, name := range filenames {
+			if len(requested) == limit {
+				break
+			}
+			if f, ok := files[name]; ok {
+				requested[name] = f
+			}
+		}
+		if len(requested) > 0 {
+			return requested
 		}
-		return nil // Return nil if the torrent was successfully reinserted
 	}
-
-	return brokenFiles
+	names := slices.Sorted(maps.Keys(files))
+	sample := make(map[string]types.File, min(limit, len(names)))
+	for _, name := range names[:min(limit, len(names))] {
+		sample[name] = files[name]
+	}
+	return sample
 }
 
 func (c *Cache) repairWorker(ctx context.Context) {
@@ -196,12 +294,16 @@ func (c *Cache) repairWorker(ctx context.Context) {
 			case RepairTypeReinsert:
 				c.logger.Debug().Str("torrentId", torrentId).Msg("Reinserting torrent")
 				if _, err := c.reInsertTorrent(cachedTorrent); err != nil {
+					if errors.Is(err, errRepairCooldown) {
+						c.logger.Debug().Str("torrentId", torrentId).Msg("Torrent was repaired recently, skipping")
+						continue
+					}
 					c.logger.Error().Err(err).Str("torrentId", cachedTorrent.Id).Msg("Failed to reinsert torrent")
 					continue
 				}
 			case RepairTypeDelete:
 				c.logger.Debug().Str("torrentId", torrentId).Msg("Deleting torrent")
-				if err := c.DeleteTorrent(torrentId); err != nil {
+				if err := c.DeleteTorrent(ctx, torrentId); err != nil {
 					c.logger.Error().Err(err).Str("torrentId", torrentId).Msg("Failed to delete torrent")
 					continue
 				}
@@ -210,12 +312,17 @@ func (c *Cache) repairWorker(ctx context.Context) {
 	}
 }
 
+// reInsertTorrent re-adds the torrent to the debrid. The original stays in the cache until the new copy is
+// confirmed to serve links, a copy failing along the way is removed from the debrid and the original kept.
 func (c *Cache) reInsertTorrent(ct *CachedTorrent) (*CachedTorrent, error) {
 	// Check if Magnet is not empty, if empty, reconstruct the magnet
 	torrent := ct.Torrent
 	oldID := torrent.Id // Store the old ID
 	if _, ok := c.failedToReinsert.Load(oldID); ok {
-		return ct, fmt.Errorf("can't retry re-insert for %s", torrent.Id)
+		return ct, fmt.Errorf("%w: can't retry re-insert for %s", ErrReInsertFailed, torrent.Id)
+	}
+	if c.inRepairCooldown(ct) {
+		return ct, errRepairCooldown
 	}
 	if reqI, inFlight := c.repairRequest.Load(oldID); inFlight {
 		req := reqI.(*reInsertRequest)
@@ -225,10 +332,30 @@ func (c *Cache) reInsertTorrent(ct *CachedTorrent) (*CachedTorrent, error) {
 	req := newReInsertRequest()
 	c.repairRequest.Store(oldID, req)
 
-	// Make sure we clean up even if there's a panic
-	defer func() {
-		c.repairRequest.Delete(oldID)
-	}()
+	// A delete of the torrent meanwhile waits for the reinsert, and aborts it unless Repair.DeleteDuringRepair is wait
+	ctx, done := c.startRepair(*ct)
+	result, err := c.reInsert(ctx, ct)
+	done()
+	if err != nil {
+		if !errors.Is(err, errRepairAborted) {
+			c.markAsFailedToReinsert(oldID)
+		}
+		err = fmt.Errorf("%w: %w", ErrReInsertFailed, err)
+	}
+	req.Complete(result, err)
+	c.repairRequest.Delete(oldID)
+	return result, err
+}
+
+// reInsert adds a new copy of the torrent and swaps it in once it works, it returns the original on failure.
+// It's aborted, the new copy removed, once ctx is canceled or the torrent deleted. The torrent is only locked for
+// the swap, the debrid calls checking the new copy don't hold up the other operations on it.
+func (c *Cache) reInsert(ctx context.Context, ct *CachedTorrent) (*CachedTorrent, error) {
+	torrent := ct.Torrent
+	oldID := torrent.Id
+	if _, ok := c.torrents.getByID(oldID); !ok {
+		return ct, errRepairAborted
+	}
 
 	// Submit the magnet to the debrid service
 	newTorrent := &types.Torrent{
@@ -238,67 +365,106 @@ func (c *Cache) reInsertTorrent(ct *CachedTorrent) (*CachedTorrent, error) {
 		Size:     torrent.Size,
 		Files:    make(map[string]types.File),
 		Arr:      torrent.Arr,
+		Category: torrent.ArrName(),
 	}
-	var err error
-	newTorrent, err = c.client.SubmitMagnet(newTorrent)
+	submitted, err := c.client.SubmitMagnet(newTorrent)
 	if err != nil {
-		c.markAsFailedToReinsert(oldID)
-		// Remove the old torrent from the cache and debrid service
 		return ct, fmt.Errorf("failed to submit magnet: %w", err)
 	}
-
 	// Check if the torrent was submitted
-	if newTorrent == nil || newTorrent.Id == "" {
-		c.markAsFailedToReinsert(oldID)
+	if submitted == nil || submitted.Id == "" {
 		return ct, fmt.Errorf("failed to submit magnet: empty torrent")
 	}
-	newTorrent.DownloadUncached = false // Set to false, avoid re-downloading
-	newTorrent, err = c.client.CheckStatus(newTorrent)
-	if err != nil {
-		if newTorrent != nil && newTorrent.Id != "" {
-			// Delete the torrent if it was not downloaded
-			_ = c.client.DeleteTorrent(newTorrent.Id)
+	newID := submitted.Id
+
+	// Roll back the new copy, unless the debrid deduplicated it into the original
+	rollback := func(err error) (*CachedTorrent, error) {
+		if newID != oldID {
+			if delErr := c.client.DeleteTorrent(newID); delErr != nil {
+				c.logger.Warn().Err(delErr).Str("torrentId", newID).Msg("Failed to remove the reinserted copy")
+			}
 		}
-		c.markAsFailedToReinsert(oldID)
 		return ct, err
 	}
 
-	// Update the torrent in the cache
-	addedOn, err := time.Parse(time.RFC3339, newTorrent.Added)
+	if ctx.Err() != nil {
+		return rollback(errRepairAborted)
+	}
+
+	submitted.DownloadUncached = false // Set to false, avoid re-downloading
+	submitted.Arr, submitted.Category = torrent.Arr, torrent.ArrName()
+	newTorrent, err = c.client.CheckStatus(submitted)
 	if err != nil {
-		addedOn = time.Now()
+		return rollback(err)
+	}
+	if newTorrent == nil {
+		return rollback(fmt.Errorf("failed to reinsert torrent: no status"))
 	}
 	for _, f := range newTorrent.GetFiles() {
 		if f.Link == "" {
-			c.markAsFailedToReinsert(oldID)
-			return ct, fmt.Errorf("failed to reinsert torrent: empty link")
+			return rollback(fmt.Errorf("failed to reinsert torrent: empty link"))
 		}
 	}
+	if err := c.probeReinserted(newTorrent); err != nil {
+		return rollback(fmt.Errorf("failed to reinsert torrent: %w", err))
+	}
+	unlock := c.lockTorrent(*ct)
+	defer unlock()
+	if _, ok := c.torrents.getByID(oldID); !ok || ctx.Err() != nil {
+		return rollback(errRepairAborted)
+	}
+
+	// Update the torrent in the cache
+	addedOn, err := time.Parse(time.RFC3339, newTorrent.Added)
+	if err != nil {
+		addedOn = time.Now()
+	}
+	// Keep the names the torrent was added under, so it keeps its folder
+	newTorrent.RequestedName = torrent.RequestedName
+	if torrent.Magnet != nil {
+		newTorrent.Magnet = torrent.Magnet
+	}
 	// Set torrent to newTorrent
 	newCt := CachedTorrent{
-		Torrent:    newTorrent,
-		AddedOn:    addedOn,
-		IsComplete: len(newTorrent.Files) > 0,
+		Torrent:      newTorrent,
+		AddedOn:      addedOn,
+		IsComplete:   len(newTorrent.Files) > 0,
+		LastRepaired: time.Now(),
 	}
 	c.setTorrent(newCt, func(torrent CachedTorrent) {
 		c.RefreshListings(true)
 	})
+	c.signalReinserted(c.GetTorrentFolder(newTorrent))
 
-	ct = &newCt // Update ct to point to the new torrent
-
-	// We can safely delete the old torrent here
-	if oldID != "" {
-		if err := c.DeleteTorrent(oldID); err != nil {
-			return ct, fmt.Errorf("failed to delete old torrent: %w", err)
-		}
+	// The new copy is in place, the old one can go
+	if oldID != "" && oldID != newTorrent.Id && c.removeTorrent(oldID, true) {
+		go c.RefreshListings(true)
 	}
-
-	req.Complete(ct, err)
 	c.markAsSuccessfullyReinserted(oldID)
 
-	c.logger.Debug().Str("torrentId", torrent.Id).Msg("Torrent successfully reinserted")
+	c.logger.Debug().Str("torrentId", oldID).Str("newTorrentId", newTorrent.Id).Msg("Torrent successfully reinserted")
+	return &newCt, nil
+}
 
-	return ct, nil
+// probeReinserted generates a download link for the largest file of a reinserted torrent, to confirm it can be served
+func (c *Cache) probeReinserted(t *types.Torrent) error {
+	var largest *types.File
+	for _, file := range t.GetFiles() {
+		if largest == nil || file.Size > largest.Size {
+			largest = &file
+		}
+	}
+	if largest == nil {
+		return fmt.Errorf("no files")
+	}
+	link, err := c.client.GetDownloadLink(t, largest)
+	if err != nil {
+		return err
+	}
+	if link == nil || link.DownloadLink == "" {
+		return fmt.Errorf("no download link for %s", largest.Name)
+	}
+	return nil
 }
 
 func (c *Cache) resetInvalidLinks(ctx context.Context) {
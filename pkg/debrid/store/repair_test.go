@@ -0,0 +1,252 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reinsertClient is a fake client that re-adds torrents as "new", with working links unless broken
+type reinsertClient struct {
+	*fakeClient
+	broken bool // The new copy has no links
+}
+
+func (f *reinsertClient) SubmitMagnet(t *types.Torrent) (*types.Torrent, error) {
+	submitted := t.Clone()
+	submitted.Id = "new"
+	return submitted, nil
+}
+
+func (f *reinsertClient) CheckStatus(t *types.Torrent) (*types.Torrent, error) {
+	link := "https://debrid/new"
+	if f.broken {
+		link = ""
+	}
+	t.Status = "downloaded"
+	t.Added = time.Now().Format(time.RFC3339)
+	file := testFile("movie.mkv", link)
+	file.TorrentId = t.Id
+	t.Files = map[string]types.File{"movie.mkv": file}
+	return t, nil
+}
+
+func (f *reinsertClient) GetDownloadLink(t *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	if f.broken {
+		return nil, fmt.Errorf("no link")
+	}
+	return &types.DownloadLink{Filename: file.Name, Link: file.Link, DownloadLink: file.Link}, nil
+}
+
+func TestReInsertTorrent(t *testing.T) {
+	original := func() *types.Torrent {
+		file := testFile("movie.mkv", "https://debrid/old")
+		file.TorrentId = "old"
+		return &types.Torrent{
+			Id:       "old",
+			InfoHash: "abc",
+			Name:     "Movie 2024",
+			Added:    time.Now().Add(-time.Hour).Format(time.RFC3339),
+			Files:    map[string]types.File{"movie.mkv": file},
+		}
+	}
+
+	t.Run("broken copy", func(t *testing.T) {
+		client := &reinsertClient{fakeClient: newFakeClient(original()), broken: true}
+		c := newTestCache(t, client)
+		if err := c.ProcessTorrent(original()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.reInsertTorrent(c.GetTorrent("old")); !errors.Is(err, ErrReInsertFailed) {
+			t.Fatalf("reInsertTorrent() error = %v, want ErrReInsertFailed", err)
+		}
+		if c.GetTorrent("old") == nil {
+			t.Error("original removed after a failed reinsert")
+		}
+		if c.GetTorrent("new") != nil {
+			t.Error("broken copy added to the cache")
+		}
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		if !slices.Equal(client.deleted, []string{"new"}) {
+			t.Errorf("deleted %v, want only the broken copy", client.deleted)
+		}
+	})
+
+	t.Run("working copy", func(t *testing.T) {
+		client := &reinsertClient{fakeClient: newFakeClient(original())}
+		c := newTestCache(t, client)
+		if err := c.ProcessTorrent(original()); err != nil {
+			t.Fatal(err)
+		}
+		reinserted, err := c.reInsertTorrent(c.GetTorrent("old"))
+		if err != nil {
+			t.Fatalf("reInsertTorrent() error = %v", err)
+		}
+		if reinserted.Id != "new" || c.GetTorrent("new") == nil {
+			t.Errorf("reinserted %s, want the new copy in the cache", reinserted.Id)
+		}
+		if c.GetTorrent("old") != nil {
+			t.Error("original kept after the copy was swapped in")
+		}
+		if ct, ok := c.torrents.getByName(c.GetTorrentFolder(reinserted.Torrent)); !ok || ct.Id != "new" {
+			t.Error("folder of the torrent not served by the new copy")
+		}
+	})
+}
+
+// checkLinkClient is a fake client counting the links it checks, the unavailable ones reported on a hoster down
+type checkLinkClient struct {
+	*fakeClient
+	checked     atomic.Int32
+	unavailable map[string]bool
+}
+
+func (f *checkLinkClient) CheckLink(link string) error {
+	f.checked.Add(1)
+	if f.unavailable[link] {
+		return utils.HosterUnavailableError
+	}
+	return nil
+}
+
+func TestLargeTorrentCheckLimit(t *testing.T) {
+	files := make(map[string]types.File)
+	for i := range 20 {
+		name := fmt.Sprintf("e%02d.mkv", i)
+		files[name] = testFile(name, "https://debrid/"+name)
+	}
+	torrent := &types.Torrent{Id: "1", InfoHash: "abc", Name: "Show.S01", Added: time.Now().Format(time.RFC3339), Files: files}
+	client := &checkLinkClient{fakeClient: newFakeClient(torrent)}
+	c := newTestCache(t, client, func(d *config.Debrid) {
+		d.LargeTorrentFiles = 10
+		d.LargeTorrentCheckLimit = 5
+	})
+	if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+		t.Fatal(err)
+	}
+	ct := c.GetTorrent("1")
+	if !c.IsLargeTorrent(ct.Torrent) {
+		t.Fatal("torrent of 20 files not large with large_torrent_files 10")
+	}
+	if broken, _ := c.FindBrokenFiles(ct, nil); len(broken) != 0 {
+		t.Errorf("broken files = %v, want none", broken)
+	}
+	if got := client.checked.Load(); got != 5 {
+		t.Errorf("%d links checked, want the limit of 5", got)
+	}
+
+	sample := sampleFiles(files, []string{"e15.mkv", "missing.mkv", "e03.mkv"}, 5)
+	if got := slices.Sorted(maps.Keys(sample)); !slices.Equal(got, []string{"e03.mkv", "e15.mkv"}) {
+		t.Errorf("sample = %v, want the requested files", got)
+	}
+	sample = sampleFiles(files, []string{"missing.mkv"}, 3)
+	if got := slices.Sorted(maps.Keys(sample)); !slices.Equal(got, []string{"e00.mkv", "e01.mkv", "e02.mkv"}) {
+		t.Errorf("sample = %v, want the first files by name", got)
+	}
+}
+
+// The repair strategy of the torrent's arr overrides repair.strategy
+func TestRepairStrategyPerArr(t *testing.T) {
+	tests := []struct {
+		arr  string
+		want []string
+	}{
+		{"", []string{"e02.mkv"}},
+		{"sonarr", []string{"e01.mkv", "e02.mkv"}},
+		{"radarr", []string{"e02.mkv"}},
+	}
+	for _, tt := range tests {
+		torrent := &types.Torrent{
+			Id:       "1",
+			InfoHash: "abc",
+			Name:     "Show.S01",
+			Added:    time.Now().Format(time.RFC3339),
+			Category: tt.arr,
+			Files: map[string]types.File{
+				"e01.mkv": testFile("e01.mkv", "https://debrid/e01"),
+				"e02.mkv": testFile("e02.mkv", "https://debrid/e02"),
+			},
+		}
+		client := &checkLinkClient{fakeClient: newFakeClient(torrent), unavailable: map[string]bool{"https://debrid/e02": true}}
+		c := newTestCache(t, client)
+		cfg := config.Get()
+		cfg.Repair.Strategy = config.RepairStrategyPerFile
+		cfg.Arrs = []config.Arr{
+			{Name: "sonarr", RepairStrategy: config.RepairStrategyPerTorrent},
+			{Name: "radarr"},
+		}
+		if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+			t.Fatal(err)
+		}
+
+		broken, _ := c.FindBrokenFiles(c.GetTorrent("1"), nil)
+		slices.Sort(broken)
+		if !slices.Equal(broken, tt.want) {
+			t.Errorf("broken files of a torrent of arr %q = %v, want %v", tt.arr, broken, tt.want)
+		}
+	}
+}
+
+// A torrent repaired within repair.min_repair_interval is skipped, its links aren't checked
+func TestRepairCooldown(t *testing.T) {
+	tests := []struct {
+		interval     string
+		lastRepaired time.Duration // Ago, 0 if never repaired
+		skipped      bool
+	}{
+		{"", time.Minute, false},
+		{"1h", time.Minute, true},
+		{"1h", 2 * time.Hour, false},
+		{"1h", 0, false},
+	}
+	for _, tt := range tests {
+		torrent := &types.Torrent{
+			Id:       "1",
+			InfoHash: "abc",
+			Name:     "Movie",
+			Added:    time.Now().Format(time.RFC3339),
+			Files:    map[string]types.File{"movie.mkv": testFile("movie.mkv", "https://debrid/movie")},
+		}
+		client := &checkLinkClient{fakeClient: newFakeClient(torrent), unavailable: map[string]bool{"https://debrid/movie": true}}
+		c := newTestCache(t, client)
+		config.Get().Repair.MinRepairInterval = tt.interval
+		if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+			t.Fatal(err)
+		}
+		ct := c.GetTorrent("1")
+		if tt.lastRepaired > 0 {
+			ct.LastRepaired = time.Now().Add(-tt.lastRepaired)
+		}
+
+		broken, _ := c.FindBrokenFiles(ct, nil)
+		if skipped := client.checked.Load() == 0; skipped != tt.skipped {
+			t.Errorf("interval %q, repaired %v ago: skipped = %v, want %v", tt.interval, tt.lastRepaired, skipped, tt.skipped)
+		}
+		if !tt.skipped && !slices.Equal(broken, []string{"movie.mkv"}) {
+			t.Errorf("interval %q, repaired %v ago: broken files = %v, want movie.mkv", tt.interval, tt.lastRepaired, broken)
+		}
+	}
+
+	// The time of the last repair is saved with the torrent
+	repaired := CachedTorrent{Torrent: &types.Torrent{Id: "1"}, LastRepaired: time.Now().Add(-time.Minute).Truncate(time.Second)}
+	data, err := json.Marshal(repaired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded CachedTorrent
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.LastRepaired.Equal(repaired.LastRepaired) {
+		t.Errorf("LastRepaired loaded as %v, want %v", loaded.LastRepaired, repaired.LastRepaired)
+	}
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamStarted counts a WebDav stream of the cache as active, until the returned function is called
+func (c *Cache) StreamStarted() func() {
+	c.activeStreams.Add(1)
+	return func() {
+		c.lastStreamEnd.Store(time.Now().UnixNano())
+		c.activeStreams.Add(-1)
+	}
+}
+
+// ActiveStreams returns the number of WebDav streams in progress
+func (c *Cache) ActiveStreams() int {
+	return int(c.activeStreams.Load())
+}
+
+// drainStreamsInterval is how often the streams in progress are counted while draining them
+const drainStreamsInterval = 500 * time.Millisecond
+
+// drainStreams waits up to timeout for the WebDav streams in progress to end, it reports whether they all did
+func (c *Cache) drainStreams(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.ActiveStreams() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainStreamsInterval)
+	}
+	return true
+}
+
+// LastStreamEnd returns when the last WebDav stream ended, zero if none did yet
+func (c *Cache) LastStreamEnd() time.Time {
+	if ns := c.lastStreamEnd.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// reinsertWatch is closed when its torrent is reinserted, streams counts the streams watching it
+type reinsertWatch struct {
+	ch      chan struct{}
+	streams int
+}
+
+// Reinserted returns a channel closed when the torrent is next reinserted by the repair, for the streams opened
+// on its old links. The returned function must be called when the stream ends, the channel is dropped once no
+// stream watches it anymore.
+func (c *Cache) Reinserted(torrentName string) (<-chan struct{}, func()) {
+	c.reinsertedMu.Lock()
+	defer c.reinsertedMu.Unlock()
+	if c.reinserted == nil {
+		c.reinserted = make(map[string]*reinsertWatch)
+	}
+	watch, ok := c.reinserted[torrentName]
+	if !ok {
+		watch = &reinsertWatch{ch: make(chan struct{})}
+		c.reinserted[torrentName] = watch
+	}
+	watch.streams++
+	var once sync.Once
+	return watch.ch, func() {
+		once.Do(func() {
+			c.reinsertedMu.Lock()
+			defer c.reinsertedMu.Unlock()
+			watch.streams--
+			if watch.streams == 0 && c.reinserted[torrentName] == watch {
+				delete(c.reinserted, torrentName)
+			}
+		})
+	}
+}
+
+// signalReinserted tells the streams of the torrent that it was reinserted
+func (c *Cache) signalReinserted(torrentName string) {
+	c.reinsertedMu.Lock()
+	defer c.reinsertedMu.Unlock()
+	if watch, ok := c.reinserted[torrentName]; ok {
+		close(watch.ch)
+		delete(c.reinserted, torrentName)
+	}
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"testing"
+	"time"
+)
+
+func TestActiveStreams(t *testing.T) {
+	c := newTestCache(t, newFakeClient())
+
+	first, second := c.StreamStarted(), c.StreamStarted()
+	if got := c.ActiveStreams(); got != 2 {
+		t.Fatalf("ActiveStreams() = %d, want 2", got)
+	}
+	if !c.LastStreamEnd().IsZero() {
+		t.Errorf("LastStreamEnd() = %s before any stream ended, want zero", c.LastStreamEnd())
+	}
+
+	first()
+	if got := c.ActiveStreams(); got != 1 {
+		t.Errorf("ActiveStreams() = %d, want 1", got)
+	}
+	if time.Since(c.LastStreamEnd()) > time.Second {
+		t.Errorf("LastStreamEnd() = %s, want now", c.LastStreamEnd())
+	}
+	if c.drainStreams(0) {
+		t.Error("drainStreams() reported drained with a stream in progress")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		second()
+	}()
+	if !c.drainStreams(5 * time.Second) {
+		t.Error("drainStreams() timed out after the last stream ended")
+	}
+}
+
+func TestReinsertedWatch(t *testing.T) {
+	c := newTestCache(t, newFakeClient())
+	closed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	first, releaseFirst := c.Reinserted("Movie")
+	second, releaseSecond := c.Reinserted("Movie")
+	other, releaseOther := c.Reinserted("Show")
+	defer releaseOther()
+	if first != second {
+		t.Error("streams of the same torrent watch different channels")
+	}
+	c.signalReinserted("Movie")
+	if !closed(first) {
+		t.Error("channel not closed once the torrent was reinserted")
+	}
+	if closed(other) {
+		t.Error("channel of another torrent closed")
+	}
+	releaseFirst()
+	releaseSecond()
+	releaseSecond() // Releasing twice doesn't drop a newer watch
+
+	// A stream opened after the reinsert watches the next one
+	next, releaseNext := c.Reinserted("Movie")
+	if closed(next) {
+		t.Error("stream opened after the reinsert sees it")
+	}
+	releaseNext()
+	c.reinsertedMu.Lock()
+	defer c.reinsertedMu.Unlock()
+	if _, ok := c.reinserted["Movie"]; ok {
+		t.Error("watch kept after its last stream ended")
+	}
+}
+
+func TestReinsertSignalsStreams(t *testing.T) {
+	torrent := &types.Torrent{
+		Id:       "old",
+		InfoHash: "abc",
+		Name:     "Movie 2024",
+		Added:    time.Now().Format(time.RFC3339),
+		Files:    map[string]types.File{"movie.mkv": testFile("movie.mkv", "https://debrid/old")},
+	}
+	c := newTestCache(t, &reinsertClient{fakeClient: newFakeClient(torrent)})
+	if err := c.ProcessTorrent(torrent.Clone()); err != nil {
+		t.Fatal(err)
+	}
+	reinserted, release := c.Reinserted(c.GetTorrentFolder(torrent))
+	defer release()
+	if _, err := c.reInsertTorrent(c.GetTorrent("old")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reinserted:
+	default:
+		t.Error("streams of the torrent not told it was reinserted")
+	}
+}
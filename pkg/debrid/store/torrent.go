@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"os"
 	"regexp"
 	"sort"
@@ -30,6 +31,8 @@ const (
 	filterBySizeLT string = "size_lt"
 
 	filterBLastAdded string = "last_added"
+
+	filterByCategory string = "category" // matches the category(arr) the torrent was added with
 )
 
 type directoryFilter struct {
@@ -40,10 +43,33 @@ type directoryFilter struct {
 	ageThreshold  time.Duration  // only for last_added
 }
 
+// parseDirectoryFilters parses the filters of a directory, failing on an invalid regex
+func parseDirectoryFilters(filters map[string]string) ([]directoryFilter, error) {
+	dirFilters := make([]directoryFilter, 0, len(filters))
+	for filterType, v := range filters {
+		df := directoryFilter{filterType: filterType, value: v}
+		switch filterType {
+		case filterByRegex, filterByNotRegex:
+			regex, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s filter %q: %w", filterType, v, err)
+			}
+			df.regex = regex
+		case filterBySizeGT, filterBySizeLT:
+			df.sizeThreshold, _ = config.ParseSize(v)
+		case filterBLastAdded:
+			df.ageThreshold, _ = time.ParseDuration(v)
+		}
+		dirFilters = append(dirFilters, df)
+	}
+	return dirFilters, nil
+}
+
 type torrents struct {
 	sync.RWMutex
 	byID   map[string]CachedTorrent
 	byName map[string]CachedTorrent
+	names  map[string]string // name key -> exposed name
 }
 
 type folders struct {
@@ -55,34 +81,42 @@ type torrentCache struct {
 	torrents torrents
 
 	listing            atomic.Value
+	categories         atomic.Value // category -> listing of its torrents, "" for the torrents without one
 	folders            folders
 	directoriesFilters map[string][]directoryFilter
 	sortNeeded         atomic.Bool
+
+	// foldCase makes names differing only by case collide, for case-insensitive clients
+	foldCase bool
 }
 
 type sortableFile struct {
-	id      string
-	name    string
-	modTime time.Time
-	size    int64
-	bad     bool
+	id       string
+	name     string
+	modTime  time.Time
+	size     int64
+	bad      bool
+	category string
 }
 
-func newTorrentCache(dirFilters map[string][]directoryFilter) *torrentCache {
+func newTorrentCache(dirFilters map[string][]directoryFilter, foldCase bool) *torrentCache {
 
 	tc := &torrentCache{
 		torrents: torrents{
 			byID:   make(map[string]CachedTorrent),
 			byName: make(map[string]CachedTorrent),
+			names:  make(map[string]string),
 		},
 		folders: folders{
 			listing: make(map[string][]os.FileInfo),
 		},
 		directoriesFilters: dirFilters,
+		foldCase:           foldCase,
 	}
 
 	tc.sortNeeded.Store(false)
 	tc.listing.Store(make([]os.FileInfo, 0))
+	tc.categories.Store(make(map[string][]os.FileInfo))
 	return tc
 }
 
@@ -90,11 +124,13 @@ func (tc *torrentCache) reset() {
 	tc.torrents.Lock()
 	tc.torrents.byID = make(map[string]CachedTorrent)
 	tc.torrents.byName = make(map[string]CachedTorrent)
+	tc.torrents.names = make(map[string]string)
 	tc.torrents.Unlock()
 
 	// reset the sorted listing
 	tc.sortNeeded.Store(false)
 	tc.listing.Store(make([]os.FileInfo, 0))
+	tc.categories.Store(make(map[string][]os.FileInfo))
 
 	// reset any per-folder views
 	tc.folders.Lock()
@@ -102,6 +138,19 @@ func (tc *torrentCache) reset() {
 	tc.folders.Unlock()
 }
 
+// addDirectory adds a custom directory, the listing must be refreshed for it to show up
+func (tc *torrentCache) addDirectory(name string, filters []directoryFilter) {
+	tc.folders.Lock()
+	defer tc.folders.Unlock()
+	dirFilters := make(map[string][]directoryFilter, len(tc.directoriesFilters)+1)
+	for dir, f := range tc.directoriesFilters {
+		dirFilters[dir] = f
+	}
+	dirFilters[name] = filters
+	tc.directoriesFilters = dirFilters
+	tc.sortNeeded.Store(true)
+}
+
 func (tc *torrentCache) getByID(id string) (CachedTorrent, bool) {
 	tc.torrents.RLock()
 	defer tc.torrents.RUnlock()
@@ -109,10 +158,18 @@ func (tc *torrentCache) getByID(id string) (CachedTorrent, bool) {
 	return torrent, exists
 }
 
+// nameKey returns the key used to store a torrent name, folded to lower case if foldCase is set
+func (tc *torrentCache) nameKey(name string) string {
+	if tc.foldCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
 func (tc *torrentCache) getByName(name string) (CachedTorrent, bool) {
 	tc.torrents.RLock()
 	defer tc.torrents.RUnlock()
-	torrent, exists := tc.torrents.byName[name]
+	torrent, exists := tc.torrents.byName[tc.nameKey(name)]
 	return torrent, exists
 }
 
@@ -120,7 +177,12 @@ func (tc *torrentCache) set(name string, torrent, newTorrent CachedTorrent) {
 	tc.torrents.Lock()
 	// Set the id first
 
-	tc.torrents.byName[name] = torrent
+	key := tc.nameKey(name)
+	tc.torrents.byName[key] = torrent
+	if _, ok := tc.torrents.names[key]; !ok {
+		// The first name seen is the one exposed, so colliding names resolve consistently
+		tc.torrents.names[key] = name
+	}
 	tc.torrents.byID[torrent.Id] = torrent // This is the unadulterated torrent
 	tc.torrents.Unlock()
 	tc.sortNeeded.Store(true)
@@ -137,6 +199,14 @@ func (tc *torrentCache) getListing() []os.FileInfo {
 	return tc.listing.Load().([]os.FileInfo)
 }
 
+// getCategoryListings returns the torrents of each category, "" for the torrents without one
+func (tc *torrentCache) getCategoryListings() map[string][]os.FileInfo {
+	if tc.sortNeeded.Load() {
+		tc.refreshListing()
+	}
+	return tc.categories.Load().(map[string][]os.FileInfo)
+}
+
 func (tc *torrentCache) getFolderListing(folderName string) []os.FileInfo {
 	tc.folders.RLock()
 	defer tc.folders.RUnlock()
@@ -154,8 +224,12 @@ func (tc *torrentCache) refreshListing() {
 
 	tc.torrents.RLock()
 	all := make([]sortableFile, 0, len(tc.torrents.byName))
-	for name, t := range tc.torrents.byName {
-		all = append(all, sortableFile{t.Id, name, t.AddedOn, t.Bytes, t.Bad})
+	for key, t := range tc.torrents.byName {
+		category := ""
+		if t.Arr != nil {
+			category = t.Arr.Name
+		}
+		all = append(all, sortableFile{t.Id, tc.torrents.names[key], t.AddedOn, t.Bytes, t.Bad, category})
 	}
 	tc.sortNeeded.Store(false)
 	tc.torrents.RUnlock()
@@ -173,10 +247,13 @@ func (tc *torrentCache) refreshListing() {
 	go func() {
 		defer wg.Done()
 		listing := make([]os.FileInfo, len(all))
+		categories := make(map[string][]os.FileInfo)
 		for i, sf := range all {
 			listing[i] = &fileInfo{sf.id, sf.name, sf.size, 0755 | os.ModeDir, sf.modTime, true}
+			categories[sf.category] = append(categories[sf.category], listing[i])
 		}
 		tc.listing.Store(listing)
+		tc.categories.Store(categories)
 	}()
 
 	wg.Add(1)
@@ -205,9 +282,13 @@ func (tc *torrentCache) refreshListing() {
 		tc.folders.Unlock()
 	}()
 
+	tc.folders.RLock()
+	directoriesFilters := tc.directoriesFilters
+	tc.folders.RUnlock()
+
 	now := time.Now()
-	wg.Add(len(tc.directoriesFilters)) // for each directory filter
-	for dir, filters := range tc.directoriesFilters {
+	wg.Add(len(directoriesFilters)) // for each directory filter
+	for dir, filters := range directoriesFilters {
 		go func(dir string, filters []directoryFilter) {
 			defer wg.Done()
 			var matched []os.FileInfo
@@ -267,6 +348,8 @@ func (tc *torrentCache) torrentMatchDirectory(filters []directoryFilter, file so
 			matched = file.size < filter.sizeThreshold
 		case filterBLastAdded:
 			matched = file.modTime.After(now.Add(-filter.ageThreshold))
+		case filterByCategory:
+			matched = strings.EqualFold(file.category, filter.value)
 		}
 		if !matched {
 			return false // All filters must match
@@ -297,8 +380,8 @@ func (tc *torrentCache) getAllByName() map[string]CachedTorrent {
 	tc.torrents.RLock()
 	defer tc.torrents.RUnlock()
 	results := make(map[string]CachedTorrent, len(tc.torrents.byName))
-	for name, torrent := range tc.torrents.byName {
-		results[name] = torrent
+	for key, torrent := range tc.torrents.byName {
+		results[tc.torrents.names[key]] = torrent
 	}
 	return results
 }
@@ -323,6 +406,8 @@ func (tc *torrentCache) removeId(id string) {
 func (tc *torrentCache) remove(name string) {
 	tc.torrents.Lock()
 	defer tc.torrents.Unlock()
-	delete(tc.torrents.byName, name)
+	key := tc.nameKey(name)
+	delete(tc.torrents.byName, key)
+	delete(tc.torrents.names, key)
 	tc.sortNeeded.Store(true)
 }
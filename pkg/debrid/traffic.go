@@ -0,0 +1,199 @@
+package debrid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// trafficBucketSize is the granularity of the rolling traffic window
+const trafficBucketSize = time.Hour
+
+type trafficBucket struct {
+	Start time.Time `json:"start"`
+	Bytes int64     `json:"bytes"`
+}
+
+// trafficTracker counts the bytes served and downloaded per debrid in hourly buckets, persisted to traffic.json
+type trafficTracker struct {
+	mu       sync.Mutex
+	buckets  map[string][]trafficBucket
+	filename string
+	dirty    bool
+}
+
+func newTrafficTracker(filename string) *trafficTracker {
+	t := &trafficTracker{
+		buckets:  make(map[string][]trafficBucket),
+		filename: filename,
+	}
+	if data, err := os.ReadFile(filename); err == nil {
+		_ = json.Unmarshal(data, &t.buckets)
+	}
+	return t
+}
+
+func (t *trafficTracker) add(name string, n int64) {
+	if n <= 0 {
+		return
+	}
+	start := time.Now().Truncate(trafficBucketSize)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buckets := t.buckets[name]
+	if len(buckets) > 0 && buckets[len(buckets)-1].Start.Equal(start) {
+		buckets[len(buckets)-1].Bytes += n
+	} else {
+		t.buckets[name] = append(buckets, trafficBucket{Start: start, Bytes: n})
+	}
+	t.dirty = true
+}
+
+// usage returns the bytes counted for the debrid within window
+func (t *trafficTracker) usage(name string, window time.Duration) int64 {
+	since := time.Now().Add(-window)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total int64
+	for _, b := range t.buckets[name] {
+		if b.Start.Add(trafficBucketSize).After(since) {
+			total += b.Bytes
+		}
+	}
+	return total
+}
+
+// prune drops the buckets older than the window of their debrid
+func (t *trafficTracker) prune(windows map[string]time.Duration) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, buckets := range t.buckets {
+		window, ok := windows[name]
+		if !ok {
+			delete(t.buckets, name)
+			t.dirty = true
+			continue
+		}
+		kept := buckets[:0]
+		for _, b := range buckets {
+			if b.Start.Add(trafficBucketSize).After(now.Add(-window)) {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) != len(buckets) {
+			t.dirty = true
+		}
+		t.buckets[name] = kept
+	}
+}
+
+func (t *trafficTracker) save() error {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(t.buckets)
+	t.dirty = false
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return config.Get().WriteFile(t.filename, data)
+}
+
+// RecordTraffic counts bytes served or downloaded from the debrid against its traffic budget
+func (de *Debrid) RecordTraffic(n int64) {
+	if de.traffic != nil {
+		de.traffic.add(de.config.Name, n)
+	}
+}
+
+// TrafficUsage returns the bytes used within the traffic budget window and the budget, 0 if there is none
+func (de *Debrid) TrafficUsage() (int64, int64) {
+	if de.traffic == nil {
+		return 0, 0
+	}
+	return de.traffic.usage(de.config.Name, de.config.GetTrafficBudgetWindow()), de.config.GetTrafficBudget()
+}
+
+// IsOverBudget reports whether the last traffic check found the debrid past its budget threshold
+func (de *Debrid) IsOverBudget() bool {
+	return de.overBudget.Load()
+}
+
+// StartTrafficTracking periodically saves the traffic usage and checks the debrids against their budget, until ctx is done
+func (d *Storage) StartTrafficTracking(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			d.checkTraffic()
+			select {
+			case <-ctx.Done():
+				_ = d.traffic.save()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (d *Storage) checkTraffic() {
+	_logger := logger.Default()
+	windows := make(map[string]time.Duration)
+	for name, db := range d.Debrids() {
+		windows[name] = db.config.GetTrafficBudgetWindow()
+		used, budget := db.TrafficUsage()
+		over := budget > 0 && used*100 >= budget*int64(db.config.TrafficBudgetThreshold)
+		if db.overBudget.Swap(over) == over {
+			continue
+		}
+
+		event, status := "traffic_budget_reached", "warning"
+		msg := fmt.Sprintf("%s used %s of its %s traffic budget, new downloads avoid it.", name, formatBytes(used), db.config.TrafficBudget)
+		if over {
+			_logger.Warn().Str("debrid", name).Msgf("Traffic budget threshold reached, %s used", formatBytes(used))
+		} else {
+			event, status = "traffic_budget_restored", "success"
+			msg = fmt.Sprintf("%s is back under its %s traffic budget threshold, downloads are sent to it again.", name, db.config.TrafficBudget)
+			_logger.Info().Str("debrid", name).Msg("Back under the traffic budget threshold")
+		}
+		request.Notify(event, status, msg)
+	}
+	d.traffic.prune(windows)
+	if err := d.traffic.save(); err != nil {
+		_logger.Error().Err(err).Msg("Failed to save traffic usage")
+	}
+}
+
+// isOverBudget reports whether the named debrid is past its traffic budget threshold
+func (d *Storage) isOverBudget(name string) bool {
+	db := d.Debrid(name)
+	return db != nil && db.IsOverBudget()
+}
+
+func trafficFile() string {
+	return filepath.Join(config.Get().Path, "traffic.json")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,73 @@
+package debrid
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// New downloads avoid a debrid near its traffic budget, until its usage falls back under the threshold
+func TestTrafficBudget(t *testing.T) {
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	budgeted, other := newFakeClient(), newFakeClient()
+	other.name = "torbox"
+	storage := newMultiStorage(t, []*fakeClient{budgeted, other},
+		config.Debrid{Name: "realdebrid", APIKey: "key", Folder: "/mnt/remote/realdebrid/__all__", TrafficBudget: "1GB"},
+		config.Debrid{Name: "torbox", APIKey: "key", Folder: "/mnt/remote/torbox/__all__"},
+	)
+	filename := filepath.Join(t.TempDir(), "traffic.json")
+	storage.traffic = newTrafficTracker(filename)
+	for _, db := range storage.debrids {
+		db.traffic = storage.traffic
+	}
+	rd := storage.Debrid("realdebrid")
+	_, budget := rd.TrafficUsage()
+	_arr := arr.New("radarr", "", "", false, false, nil, "", "")
+
+	steps := []struct {
+		name       string
+		traffic    int64 // Recorded before the check
+		wantOver   bool
+		wantDebrid string
+	}{
+		{"under the threshold", budget * 80 / 100, false, "realdebrid"},
+		{"threshold reached", budget * 15 / 100, true, "torbox"},
+		{"other debrid unaffected", 0, true, "torbox"},
+	}
+	for _, step := range steps {
+		rd.RecordTraffic(step.traffic)
+		storage.checkTraffic()
+		if got := rd.IsOverBudget(); got != step.wantOver {
+			t.Errorf("%s: IsOverBudget() = %v, want %v", step.name, got, step.wantOver)
+		}
+		torrent, err := Process(context.Background(), storage, "", utils.ConstructMagnet(hash, "Movie"), _arr, "symlink", false)
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", step.name, err)
+		}
+		if torrent.Debrid != step.wantDebrid {
+			t.Errorf("%s: submitted to %s, want %s", step.name, torrent.Debrid, step.wantDebrid)
+		}
+	}
+
+	// The usage survives a restart
+	if got, want := newTrafficTracker(filename).usage("realdebrid", 24*time.Hour), budget*95/100; got != want {
+		t.Errorf("usage after a restart = %d, want %d", got, want)
+	}
+
+	// Once the traffic is older than the window, the debrid is used again
+	storage.traffic.mu.Lock()
+	storage.traffic.buckets["realdebrid"][0].Start = time.Now().Add(-25 * time.Hour).Truncate(trafficBucketSize)
+	storage.traffic.mu.Unlock()
+	storage.checkTraffic()
+	if rd.IsOverBudget() {
+		t.Error("still over budget once the traffic left the window")
+	}
+	torrent, err := Process(context.Background(), storage, "", utils.ConstructMagnet(hash, "Movie"), _arr, "symlink", false)
+	if err != nil || torrent.Debrid != "realdebrid" {
+		t.Errorf("Process() once back under the budget = %v, %v, want realdebrid", torrent, err)
+	}
+}
@@ -1,15 +1,39 @@
 package types
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/sirrobot01/decypharr/internal/config"
+	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// quotaLocation is where the provider quotas reset at midnight
+var quotaLocation = func() *time.Location {
+	for _, name := range []string{"CET", "Europe/Berlin"} {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}()
+
+// quotaDay returns the day the provider quotas are counted for
+func quotaDay() string {
+	return time.Now().In(quotaLocation).Format(time.DateOnly)
+}
+
 type Accounts struct {
 	current  *Account
 	accounts []*Account
 	mu       sync.RWMutex
+
+	onUnlock func() // Called for each download link generated by the provider
+
+	next int    // Position of the next account in the round-robin
+	day  string // Day the traffic and the exhausted accounts are counted for, see quotaDay
 }
 
 func NewAccounts(debridConf config.Debrid) *Accounts {
@@ -18,7 +42,7 @@ func NewAccounts(debridConf config.Debrid) *Accounts {
 		if token == "" {
 			continue
 		}
-		account := newAccount(debridConf.Name, token, idx)
+		account := newAccount(debridConf.Provider(), token, idx)
 		accounts = append(accounts, account)
 	}
 
@@ -29,6 +53,7 @@ func NewAccounts(debridConf config.Debrid) *Accounts {
 	return &Accounts{
 		accounts: accounts,
 		current:  current,
+		day:      quotaDay(),
 	}
 }
 
@@ -39,6 +64,29 @@ type Account struct {
 	Token    string
 	links    map[string]*DownloadLink
 	mu       sync.RWMutex
+
+	traffic   atomic.Int64     // Bytes of the links generated today
+	remaining map[string]int64 // Quota left per hoster, as reported by the provider, guarded by mu
+}
+
+// AccountStatus describes a download account for the health endpoint, without its key
+type AccountStatus struct {
+	Key       string           `json:"key"` // Last characters of the key
+	Exhausted bool             `json:"exhausted"`
+	Traffic   int64            `json:"traffic"`             // Bytes of the links generated today
+	Remaining map[string]int64 `json:"remaining,omitempty"` // Quota left per hoster, when the provider reports it
+}
+
+// AccountsState is the rotation state of the accounts, saved so it survives a restart
+type AccountsState struct {
+	Day  string                  `json:"day"`
+	Next int                     `json:"next"`
+	Keys map[string]AccountState `json:"keys"` // By key fingerprint
+}
+
+type AccountState struct {
+	Traffic   int64 `json:"traffic"`
+	Exhausted bool  `json:"exhausted"`
 }
 
 func (a *Accounts) All() []*Account {
@@ -106,7 +154,9 @@ func (a *Accounts) Reset() {
 	for _, acc := range a.accounts {
 		acc.resetDownloadLinks()
 		acc.Disabled = false
+		acc.traffic.Store(0)
 	}
+	a.day = quotaDay()
 	if len(a.accounts) > 0 {
 		a.current = a.accounts[0]
 	} else {
@@ -131,6 +181,8 @@ func (a *Accounts) GetDownloadLink(fileLink string) (*DownloadLink, error) {
 	return dl, nil
 }
 
+// GetDownloadLinkWithAccount returns the cached download link of fileLink with the account that generated it, the
+// current account if it isn't known
 func (a *Accounts) GetDownloadLinkWithAccount(fileLink string) (*DownloadLink, *Account, error) {
 	currentAccount := a.Current()
 	if currentAccount == nil {
@@ -140,6 +192,9 @@ func (a *Accounts) GetDownloadLinkWithAccount(fileLink string) (*DownloadLink, *
 	if !ok {
 		return nil, nil, NoDownloadLinkError
 	}
+	if dl.Account != nil {
+		currentAccount = dl.Account
+	}
 	if dl.ExpiresAt.IsZero() || dl.ExpiresAt.Before(time.Now()) {
 		return nil, currentAccount, DownloadLinkExpiredError
 	}
@@ -149,6 +204,111 @@ func (a *Accounts) GetDownloadLinkWithAccount(fileLink string) (*DownloadLink, *
 	return dl, currentAccount, nil
 }
 
+// Rotation returns the enabled accounts in the order to try them for a download link, starting with the next one of
+// the round-robin. The accounts disabled, e.g. exhausted, are enabled again once the day of the quotas changes.
+func (a *Accounts) Rotation() []*Account {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if day := quotaDay(); a.day != day {
+		a.day = day
+		for _, acc := range a.accounts {
+			acc.Disabled = false
+			acc.traffic.Store(0)
+		}
+		if a.current == nil && len(a.accounts) > 0 {
+			a.current = a.accounts[0]
+		}
+	}
+
+	active := make([]*Account, 0, len(a.accounts))
+	for _, acc := range a.accounts {
+		if !acc.Disabled {
+			active = append(active, acc)
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	start := a.next % len(active)
+	a.next = start + 1
+	return append(active[start:], active[:start]...)
+}
+
+// Status returns the status of all the accounts, disabled ones included
+func (a *Accounts) Status() []AccountStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	status := make([]AccountStatus, 0, len(a.accounts))
+	for _, acc := range a.accounts {
+		key := acc.Token
+		if len(key) > 4 {
+			key = "..." + key[len(key)-4:]
+		}
+		status = append(status, AccountStatus{
+			Key:       key,
+			Exhausted: acc.Disabled,
+			Traffic:   acc.Traffic(),
+			Remaining: acc.Remaining(),
+		})
+	}
+	return status
+}
+
+// State returns the rotation state of the accounts
+func (a *Accounts) State() AccountsState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	state := AccountsState{Day: a.day, Next: a.next, Keys: make(map[string]AccountState, len(a.accounts))}
+	for _, acc := range a.accounts {
+		state.Keys[acc.Fingerprint()] = AccountState{Traffic: acc.Traffic(), Exhausted: acc.Disabled}
+	}
+	return state
+}
+
+// Restore sets the rotation state of the accounts from a saved state, the traffic and the exhausted accounts only if
+// they are counted for the current day. Accounts are matched by key, the keys not in state are left as they are.
+func (a *Accounts) Restore(state AccountsState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next = state.Next
+	if state.Day != quotaDay() {
+		return
+	}
+	a.day = state.Day
+	for _, acc := range a.accounts {
+		if s, ok := state.Keys[acc.Fingerprint()]; ok {
+			acc.traffic.Store(s.Traffic)
+			acc.Disabled = s.Exhausted
+		}
+	}
+	if a.current != nil && a.current.Disabled {
+		a.current = nil
+		for _, acc := range a.accounts {
+			if !acc.Disabled {
+				a.current = acc
+				break
+			}
+		}
+	}
+}
+
+// OnUnlock sets the function called for each download link the provider generates
+func (a *Accounts) OnUnlock(fn func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onUnlock = fn
+}
+
+// Unlocked records a download link generated by the provider, providers call it after each successful unlock
+func (a *Accounts) Unlocked() {
+	a.mu.RLock()
+	fn := a.onUnlock
+	a.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (a *Accounts) SetDownloadLink(fileLink string, dl *DownloadLink) {
 	if a.Current() == nil {
 		return
@@ -214,6 +374,38 @@ func (a *Account) LinksCount() int {
 	return len(a.links)
 }
 
+// Fingerprint identifies the account in saved state without its key
+func (a *Account) Fingerprint() string {
+	sum := sha256.Sum256([]byte(a.Token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// AddTraffic counts n bytes of download links generated with the account
+func (a *Account) AddTraffic(n int64) {
+	if n > 0 {
+		a.traffic.Add(n)
+	}
+}
+
+// Traffic returns the bytes of download links generated with the account today
+func (a *Account) Traffic() int64 {
+	return a.traffic.Load()
+}
+
+// SetRemaining sets the quota left per hoster, as reported by the provider
+func (a *Account) SetRemaining(remaining map[string]int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.remaining = remaining
+}
+
+// Remaining returns the quota left per hoster as last reported by the provider, nil if it reports none
+func (a *Account) Remaining() map[string]int64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return maps.Clone(a.remaining)
+}
+
 func (a *Account) disable() {
 	a.Disabled = true
 }
@@ -0,0 +1,168 @@
+package types
+
+import (
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a debrid
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // Requests are sent
+	BreakerOpen     BreakerState = "open"      // Requests fail right away until the cooldown passes
+	BreakerHalfOpen BreakerState = "half_open" // A single trial request is sent, its result closes or reopens the breaker
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open, the debrid keeps failing")
+
+// BreakerStatus is the state of a circuit breaker, for the health endpoint
+type BreakerStatus struct {
+	State     BreakerState `json:"state"`
+	Failures  int          `json:"failures"`            // Failures in a row within the window
+	OpenUntil time.Time    `json:"open_until,omitzero"` // When a trial request is allowed, while open
+}
+
+// CircuitBreaker stops the requests to a debrid failing repeatedly. It opens after threshold failures in a row within
+// window, fails the requests for cooldown, then lets a single trial request through. A nil breaker is always closed.
+type CircuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	logger    zerolog.Logger
+
+	mu           sync.Mutex
+	state        BreakerState
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+	trial        bool // A half-open trial request is in flight
+}
+
+// NewCircuitBreaker returns the breaker of cfg, nil if its threshold is not positive
+func NewCircuitBreaker(cfg config.CircuitBreaker, logger zerolog.Logger) *CircuitBreaker {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{
+		threshold: cfg.Threshold,
+		window:    cfg.GetWindow(),
+		cooldown:  cfg.GetCooldown(),
+		logger:    logger,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow returns ErrCircuitOpen if a request must not be sent. Once the cooldown passed, a single request is allowed
+// as a trial, its result must be given to Record.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		b.logger.Info().Msg("Circuit breaker half-open, sending a trial request")
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Record registers the result of an allowed request. Only the errors of a failing provider count as failures,
+// any other result closes the breaker.
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	now := time.Now()
+	if !IsProviderFailure(err) {
+		if b.state != BreakerClosed {
+			b.logger.Info().Msg("Circuit breaker closed, the debrid recovered")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	if b.state == BreakerHalfOpen {
+		b.open(now)
+		b.logger.Warn().Err(err).Msgf("Trial request failed, circuit breaker open until %s", b.openUntil.Format(time.RFC3339))
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.state == BreakerClosed && b.failures >= b.threshold {
+		b.open(now)
+		b.logger.Warn().Err(err).Msgf("%d failures in a row, circuit breaker open until %s", b.failures, b.openUntil.Format(time.RFC3339))
+	}
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openUntil = now.Add(b.cooldown)
+}
+
+// IsOpen reports whether the breaker fails the requests, false once the cooldown passed
+func (b *CircuitBreaker) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen && time.Now().Before(b.openUntil)
+}
+
+// Status returns the state of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state == BreakerOpen {
+		status.OpenUntil = b.openUntil
+	}
+	return status
+}
+
+// IsProviderFailure reports whether err shows the provider failing, a hoster unavailable or server error, rather
+// than a problem with the request or the account
+func IsProviderFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, utils.HosterUnavailableError) {
+		return true
+	}
+	var httpErr *utils.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.Code {
+	case utils.TrafficExceededError.Code, utils.TooManyActiveDownloadsError.Code:
+		return false
+	}
+	return httpErr.StatusCode >= 500
+}
@@ -1,7 +1,9 @@
 package types
 
 import (
+	"context"
 	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/request"
 )
 
 type Client interface {
@@ -10,7 +12,7 @@ type Client interface {
 	GetFileDownloadLinks(tr *Torrent) error
 	GetDownloadLink(tr *Torrent, file *File) (*DownloadLink, error)
 	DeleteTorrent(torrentId string) error
-	IsAvailable(infohashes []string) map[string]bool
+	IsAvailable(infohashes []string) (map[string]bool, error)
 	GetDownloadUncached() bool
 	UpdateTorrent(torrent *Torrent) error
 	GetTorrent(torrentId string) (*Torrent, error)
@@ -24,5 +26,25 @@ type Client interface {
 	Accounts() *Accounts // Returns the active download account/token
 	DeleteDownloadLink(linkId string) error
 	GetProfile() (*Profile, error)
+	HealthCheck(ctx context.Context) error // Calls a cheap authenticated endpoint, an error means the provider or the key is failing
 	GetAvailableSlots() (int, error)
+	InConservativeMode() bool                             // Slowed down after repeated rate limits
+	AuthState() string                                    // request.AuthStateInvalidKey or request.AuthStateForbidden after a 401 or 403
+	RateLimitHeadroom() (request.RateLimitHeadroom, bool) // Quota of the provider's rate-limit headers, false if it sends none
+}
+
+// ExpiringTorrentsLister is implemented by the providers reporting when they will delete a torrent, e.g. for inactivity
+type ExpiringTorrentsLister interface {
+	GetExpiringTorrents() ([]*Torrent, error) // The torrents with an expiry, their ExpiresAt set
+}
+
+// KeyQuotaReporter is implemented by the providers reporting the quota left of a download key
+type KeyQuotaReporter interface {
+	GetKeyQuota(account *Account) (map[string]int64, error) // Quota left per hoster
+}
+
+// ClientIPLinker is implemented by the providers able to generate a download link for another IP than the server,
+// used when links are IP-locked and clients are redirected to them
+type ClientIPLinker interface {
+	GetDownloadLinkForIP(tr *Torrent, file *File, ip string) (*DownloadLink, error)
 }
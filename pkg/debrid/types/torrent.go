@@ -2,8 +2,10 @@ package types
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -34,7 +36,12 @@ type Torrent struct {
 
 	Debrid string `json:"debrid"`
 
-	Arr *arr.Arr `json:"arr"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // When the provider will delete the torrent, zero if it doesn't report it
+
+	Arr      *arr.Arr `json:"arr"`
+	Category string   `json:"category,omitempty"` // Name of the arr, kept when the torrent is refreshed from the debrid without it
+
+	RequestedName string `json:"requested_name,omitempty"` // Name the arr added the torrent under, "" if it sent none
 
 	SizeDownloaded   int64 `json:"-"` // This is used for local download
 	DownloadUncached bool  `json:"-"`
@@ -42,6 +49,53 @@ type Torrent struct {
 	sync.Mutex
 }
 
+// Clone returns a copy of the torrent with its own files, links and lock, for updating a torrent other goroutines read
+func (t *Torrent) Clone() *Torrent {
+	return &Torrent{
+		Id:               t.Id,
+		InfoHash:         t.InfoHash,
+		Name:             t.Name,
+		Folder:           t.Folder,
+		Filename:         t.Filename,
+		OriginalFilename: t.OriginalFilename,
+		Size:             t.Size,
+		Bytes:            t.Bytes,
+		Magnet:           t.Magnet,
+		Files:            maps.Clone(t.Files),
+		Status:           t.Status,
+		Added:            t.Added,
+		Progress:         t.Progress,
+		Speed:            t.Speed,
+		Seeders:          t.Seeders,
+		Links:            slices.Clone(t.Links),
+		MountPath:        t.MountPath,
+		DeletedFiles:     slices.Clone(t.DeletedFiles),
+		Debrid:           t.Debrid,
+		ExpiresAt:        t.ExpiresAt,
+		Arr:              t.Arr,
+		Category:         t.Category,
+		RequestedName:    t.RequestedName,
+		SizeDownloaded:   t.SizeDownloaded,
+		DownloadUncached: t.DownloadUncached,
+	}
+}
+
+// ArrName returns the name of the arr that added the torrent, "" if unknown
+func (t *Torrent) ArrName() string {
+	if t.Arr == nil {
+		return t.Category
+	}
+	return t.Arr.Name
+}
+
+// SetArr sets the arr that added the torrent, and the category keeping its name
+func (t *Torrent) SetArr(a *arr.Arr) {
+	t.Arr = a
+	if a != nil {
+		t.Category = a.Name
+	}
+}
+
 func (t *Torrent) GetSymlinkFolder(parent string) string {
 	return filepath.Join(parent, t.Arr.Name, t.Folder)
 }
@@ -83,6 +137,47 @@ func (t *Torrent) GetFiles() []File {
 	return files
 }
 
+// GetMainFiles returns the main file of the torrent, the largest video file, and its subtitles if keepSubtitles is set.
+// Of videos of the same size, the first by name is the main file. All files are returned if the torrent has no video file.
+func (t *Torrent) GetMainFiles(keepSubtitles bool) []File {
+	files := t.GetFiles()
+	mainIdx := -1
+	for i, f := range files {
+		if !utils.IsVideoFile(f.Name) {
+			continue
+		}
+		if mainIdx == -1 || f.Size > files[mainIdx].Size || (f.Size == files[mainIdx].Size && f.Name < files[mainIdx].Name) {
+			mainIdx = i
+		}
+	}
+	if mainIdx == -1 {
+		return files
+	}
+	mainFiles := []File{files[mainIdx]}
+	if keepSubtitles {
+		for _, f := range files {
+			if utils.IsSubtitleFile(f.Name) {
+				mainFiles = append(mainFiles, f)
+			}
+		}
+	}
+	return mainFiles
+}
+
+// HasPlayableContent reports whether the torrent has a video file that isn't a sample.
+// RAR archives count as playable when unpackRar is set, their content is exposed once unpacked.
+func (t *Torrent) HasPlayableContent(unpackRar bool) bool {
+	for _, f := range t.GetFiles() {
+		if utils.IsVideoFile(f.Name) && !utils.IsSampleFile(f.Name) {
+			return true
+		}
+		if unpackRar && (f.IsRar || utils.IsRarFile(f.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
 type File struct {
 	TorrentId    string        `json:"torrent_id"`
 	Id           string        `json:"id"`
@@ -95,6 +190,7 @@ type File struct {
 	AccountId    string        `json:"account_id"`
 	Generated    time.Time     `json:"generated"`
 	Deleted      bool          `json:"deleted"`
+	MD5          string        `json:"md5,omitempty"` // Checksum reported by the debrid, if any
 	DownloadLink *DownloadLink `json:"-"`
 }
 
@@ -129,6 +225,14 @@ type Profile struct {
 	ActiveLinks int `json:"active_links"`
 }
 
+// IsPremiumExpired reports whether the account lost its premium status
+func (p *Profile) IsPremiumExpired() bool {
+	if p.Type == "free" {
+		return true
+	}
+	return !p.Expiration.IsZero() && p.Expiration.Before(time.Now())
+}
+
 type DownloadLink struct {
 	Filename     string    `json:"filename"`
 	Link         string    `json:"link"`
@@ -137,6 +241,8 @@ type DownloadLink struct {
 	Size         int64     `json:"size"`
 	Id           string    `json:"id"`
 	ExpiresAt    time.Time
+
+	Account *Account `json:"-"` // Account that generated the link, nil if unknown
 }
 
 func (d *DownloadLink) String() string {
@@ -0,0 +1,99 @@
+package types
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetMainFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         []File
+		keepSubtitles bool
+		want          []string
+	}{
+		{
+			"largest video",
+			[]File{{Name: "movie.mkv", Size: 4 << 30}, {Name: "sample.mkv", Size: 50 << 20}, {Name: "movie.nfo", Size: 1 << 10}},
+			false,
+			[]string{"movie.mkv"},
+		},
+		{
+			"subtitles kept",
+			[]File{{Name: "movie.mkv", Size: 4 << 30}, {Name: "movie.en.srt", Size: 1 << 10}, {Name: "movie.nfo", Size: 1 << 10}},
+			true,
+			[]string{"movie.en.srt", "movie.mkv"},
+		},
+		{
+			"subtitles dropped",
+			[]File{{Name: "movie.mkv", Size: 4 << 30}, {Name: "movie.en.srt", Size: 1 << 10}},
+			false,
+			[]string{"movie.mkv"},
+		},
+		{
+			"larger non-video file",
+			[]File{{Name: "movie.mp4", Size: 1 << 30}, {Name: "extras.iso.rar", Size: 8 << 30}},
+			false,
+			[]string{"movie.mp4"},
+		},
+		{
+			"deleted largest video",
+			[]File{{Name: "movie.mkv", Size: 4 << 30, Deleted: true}, {Name: "other.mkv", Size: 1 << 30}},
+			false,
+			[]string{"other.mkv"},
+		},
+		{
+			"same size, first by name",
+			[]File{{Name: "b.mkv", Size: 1 << 30}, {Name: "a.mkv", Size: 1 << 30}, {Name: "c.mkv", Size: 1 << 30}},
+			false,
+			[]string{"a.mkv"},
+		},
+		{
+			"no video",
+			[]File{{Name: "album.flac", Size: 1 << 20}, {Name: "cover.jpg", Size: 1 << 10}},
+			false,
+			[]string{"album.flac", "cover.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		torrent := &Torrent{Files: make(map[string]File, len(tt.files))}
+		for _, f := range tt.files {
+			torrent.Files[f.Name] = f
+		}
+		var got []string
+		for _, f := range torrent.GetMainFiles(tt.keepSubtitles) {
+			got = append(got, f.Name)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: GetMainFiles(%v) = %v, want %v", tt.name, tt.keepSubtitles, got, tt.want)
+		}
+	}
+}
+
+func TestHasPlayableContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []File
+		unpackRar bool
+		want      bool
+	}{
+		{"video", []File{{Name: "movie.mkv"}, {Name: "movie.nfo"}}, false, true},
+		{"sample only", []File{{Name: "sample.mkv"}, {Name: "movie.nfo"}}, false, false},
+		{"deleted video", []File{{Name: "movie.mkv", Deleted: true}, {Name: "movie.nfo"}}, false, false},
+		{"RAR not unpacked", []File{{Name: "movie.rar"}, {Name: "movie.r00"}}, false, false},
+		{"RAR unpacked", []File{{Name: "movie.rar"}, {Name: "movie.r00"}}, true, true},
+		{"RAR volume unpacked", []File{{Name: "movie.r01"}}, true, true},
+		{"archive flagged by the debrid", []File{{Name: "movie.bin.part", IsRar: true}}, true, true},
+		{"no archive to unpack", []File{{Name: "movie.nfo"}, {Name: "cover.jpg"}}, true, false},
+	}
+	for _, tt := range tests {
+		torrent := &Torrent{Files: make(map[string]File, len(tt.files))}
+		for _, f := range tt.files {
+			torrent.Files[f.Name] = f
+		}
+		if got := torrent.HasPlayableContent(tt.unpackRar); got != tt.want {
+			t.Errorf("%s: HasPlayableContent(%v) = %v, want %v", tt.name, tt.unpackRar, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package debrid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type unlockCount struct {
+	Day   string `json:"day"` // UTC date the count is for, 2006-01-02
+	Count int    `json:"count"`
+}
+
+// unlockTracker counts the download links generated per debrid each UTC day, persisted to unlocks.json
+type unlockTracker struct {
+	mu       sync.Mutex
+	counts   map[string]unlockCount
+	filename string
+	dirty    bool
+	version  uint64 // Bumped on each change, so a save only marks the changes it wrote as saved
+}
+
+func newUnlockTracker(filename string) *unlockTracker {
+	t := &unlockTracker{
+		counts:   make(map[string]unlockCount),
+		filename: filename,
+	}
+	if data, err := os.ReadFile(filename); err == nil {
+		_ = json.Unmarshal(data, &t.counts)
+	}
+	return t
+}
+
+func unlockDay() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+func (t *unlockTracker) add(name string) {
+	day := unlockDay()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.counts[name]
+	if c.Day != day {
+		c = unlockCount{Day: day}
+	}
+	c.Count++
+	t.counts[name] = c
+	t.dirty = true
+	t.version++
+}
+
+// usage returns the download links generated by the debrid today
+func (t *unlockTracker) usage(name string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.counts[name]; c.Day == unlockDay() {
+		return c.Count
+	}
+	return 0
+}
+
+func (t *unlockTracker) save() error {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(t.counts)
+	version := t.version
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := config.Get().WriteFile(t.filename, data); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.version == version {
+		t.dirty = false
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// UnlockUsage returns the download links generated today and the daily unlock quota, 0 if there is none
+func (de *Debrid) UnlockUsage() (int, int) {
+	if de.unlocks == nil {
+		return 0, 0
+	}
+	return de.unlocks.usage(de.config.Name), de.config.UnlockQuota
+}
+
+// IsOverUnlockQuota reports whether the debrid is past its unlock quota threshold
+func (de *Debrid) IsOverUnlockQuota() bool {
+	return de.overUnlockQuota.Load()
+}
+
+// recordUnlock counts a download link generated by the debrid, checking it against its quota right away
+func (de *Debrid) recordUnlock() {
+	de.unlocks.add(de.config.Name)
+	de.checkUnlockQuota()
+}
+
+// checkUnlockQuota updates whether the debrid is past its unlock quota threshold, notifying when it changes
+func (de *Debrid) checkUnlockQuota() {
+	used, quota := de.UnlockUsage()
+	over := quota > 0 && used*100 >= quota*de.config.UnlockQuotaThreshold
+	if de.overUnlockQuota.Swap(over) == over {
+		return
+	}
+
+	_logger := logger.Default()
+	name := de.config.Name
+	event, status := "unlock_quota_reached", "warning"
+	msg := fmt.Sprintf("%s generated %d of its %d daily download links, new downloads avoid it until the quota resets at midnight UTC.", name, used, quota)
+	if over {
+		_logger.Warn().Str("debrid", name).Msgf("Unlock quota threshold reached, %d of %d download links generated today", used, quota)
+	} else {
+		event, status = "unlock_quota_restored", "success"
+		msg = fmt.Sprintf("%s is back under its unlock quota threshold, downloads are sent to it again.", name)
+		_logger.Info().Str("debrid", name).Msg("Back under the unlock quota threshold")
+	}
+	request.Notify(event, status, msg)
+}
+
+// StartUnlockTracking periodically saves the unlock counts and checks the debrids against their quota, so the debrids
+// are used again once the quota resets, until ctx is done
+func (d *Storage) StartUnlockTracking(ctx context.Context) {
+	_logger := logger.Default()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			for _, db := range d.Debrids() {
+				db.checkUnlockQuota()
+			}
+			if err := d.unlocks.save(); err != nil {
+				_logger.Error().Err(err).Msg("Failed to save unlock counts")
+			}
+			select {
+			case <-ctx.Done():
+				_ = d.unlocks.save()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// isOverUnlockQuota reports whether the named debrid is past its unlock quota threshold
+func (d *Storage) isOverUnlockQuota(name string) bool {
+	db := d.Debrid(name)
+	return db != nil && db.IsOverUnlockQuota()
+}
+
+func unlocksFile() string {
+	return filepath.Join(config.Get().Path, "unlocks.json")
+}
@@ -0,0 +1,73 @@
+package debrid
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnlockQuota(t *testing.T) {
+	setTestConfig(t)
+	filename := filepath.Join(t.TempDir(), "unlocks.json")
+	de := &Debrid{
+		config:  config.Debrid{Name: "realdebrid", UnlockQuota: 10, UnlockQuotaThreshold: 90},
+		unlocks: newUnlockTracker(filename),
+	}
+
+	for range 8 {
+		de.recordUnlock()
+	}
+	if de.IsOverUnlockQuota() {
+		t.Error("over the unlock quota with 8 of 10 links, want the threshold at 9")
+	}
+	de.recordUnlock()
+	if !de.IsOverUnlockQuota() {
+		t.Error("not over the unlock quota with 9 of 10 links")
+	}
+	if used, quota := de.UnlockUsage(); used != 9 || quota != 10 {
+		t.Errorf("UnlockUsage() = %d, %d, want 9, 10", used, quota)
+	}
+
+	// The counts are saved and read back
+	if err := de.unlocks.save(); err != nil {
+		t.Fatal(err)
+	}
+	if used := newUnlockTracker(filename).usage("realdebrid"); used != 9 {
+		t.Errorf("saved usage = %d, want 9", used)
+	}
+
+	// The count of a previous day doesn't count against today's quota
+	de.unlocks.counts["realdebrid"] = unlockCount{Day: "2024-01-01", Count: 9}
+	de.checkUnlockQuota()
+	if de.IsOverUnlockQuota() {
+		t.Error("still over the unlock quota after the day changed")
+	}
+	de.recordUnlock()
+	if used, _ := de.UnlockUsage(); used != 1 {
+		t.Errorf("usage = %d after the first link of the day, want 1", used)
+	}
+}
+
+// Counts failing to save are saved on the next try
+func TestUnlockTrackerSaveFails(t *testing.T) {
+	setTestConfig(t)
+	dir := filepath.Join(t.TempDir(), "missing")
+	tracker := newUnlockTracker(filepath.Join(dir, "unlocks.json"))
+	tracker.add("realdebrid")
+	if err := tracker.save(); err == nil {
+		t.Fatal("save() into a missing directory succeeded")
+	}
+	if !tracker.dirty {
+		t.Fatal("counts marked saved after a failed save")
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.save(); err != nil {
+		t.Fatal(err)
+	}
+	if used := newUnlockTracker(tracker.filename).usage("realdebrid"); used != 1 {
+		t.Errorf("saved usage = %d, want 1", used)
+	}
+}
@@ -126,26 +126,12 @@ func (q *QBit) categoryContext(next http.Handler) http.Handler {
 func (q *QBit) authContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		host, token, err := decodeAuthHeader(r.Header.Get("Authorization"))
+		if err != nil {
+			host, token = "", ""
+		}
 		category := getCategory(r.Context())
 		arrs := store.Get().Arr()
-		// Check if arr exists
-		a := arrs.Get(category)
-		if a == nil {
-			// Arr is not configured, create a new one
-			downloadUncached := false
-			a = arr.New(category, "", "", false, false, &downloadUncached, "", "auto")
-		}
-		if err == nil {
-			host = strings.TrimSpace(host)
-			if host != "" {
-				a.Host = host
-			}
-			token = strings.TrimSpace(token)
-			if token != "" {
-				a.Token = token
-			}
-		}
-		a.Source = "auto"
+		a := arrs.Detect(category, strings.TrimSpace(host), strings.TrimSpace(token))
 		if err := validateServiceURL(a.Host); err != nil {
 			// Return silently, no need to raise a problem. Just do not add the Arr to the context/config.json
 			next.ServeHTTP(w, r)
@@ -1,8 +1,13 @@
 package qbit
 
 import (
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
 	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/store"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -95,51 +100,68 @@ func (q *QBit) handleTorrentsAdd(w http.ResponseWriter, r *http.Request) {
 	}
 	debridName := r.FormValue("debrid")
 	category := r.FormValue("category")
+	if category != "" {
+		if err := store.ValidateCategory(category); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	rename := strings.TrimSpace(r.FormValue("rename")) // Name the arr expects the torrent under
 	_arr := getArrFromContext(ctx)
 	if _arr == nil {
 		// Arr is not in context
 		_arr = arr.New(category, "", "", false, false, nil, "", "")
 	}
-	atleastOne := false
+	_logger := logger.Ctx(ctx, q.logger).With().Str("category", _arr.Name).Logger()
 
-	// Handle magnet URLs
-	if urls := r.FormValue("urls"); urls != "" {
-		var urlList []string
-		for _, u := range strings.Split(urls, "\n") {
-			urlList = append(urlList, strings.TrimSpace(u))
-		}
-		for _, url := range urlList {
-			if err := q.addMagnet(ctx, url, _arr, debridName, action); err != nil {
-				q.logger.Debug().Msgf("Error adding magnet: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
+	inputs := torrentInputs(r)
+	if len(inputs) == 0 {
+		http.Error(w, "No valid URLs or torrents provided", http.StatusBadRequest)
+		return
+	}
+	magnets := make([]*utils.Magnet, 0, len(inputs))
+	for _, input := range inputs {
+		infoHash, name, err := parseTorrentInput(input)
+		if err != nil {
+			var httpErr *utils.HTTPError
+			if errors.As(err, &httpErr) {
+				_logger.Debug().Err(err).Msgf("Invalid torrent input")
+				http.Error(w, httpErr.Message, httpErr.StatusCode)
 				return
 			}
-			atleastOne = true
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	}
-
-	// Handle torrent files
-	if r.MultipartForm != nil && r.MultipartForm.File != nil {
-		if files := r.MultipartForm.File["torrents"]; len(files) > 0 {
-			for _, fileHeader := range files {
-				if err := q.addTorrent(ctx, fileHeader, _arr, debridName, action); err != nil {
-					q.logger.Debug().Err(err).Msgf("Error adding torrent")
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-				atleastOne = true
-			}
+		magnet := parsedMagnet(input)
+		if magnet == nil {
+			magnet = utils.ConstructMagnet(infoHash, name)
 		}
+		magnets = append(magnets, magnet)
 	}
-
-	if !atleastOne {
-		http.Error(w, "No valid URLs or torrents provided", http.StatusBadRequest)
-		return
+	if rename != "" && len(magnets) != 1 {
+		// The name can't be told apart between the torrents
+		_logger.Debug().Msgf("Ignoring rename %q, %d torrents were added", rename, len(magnets))
+		rename = ""
+	}
+	for _, magnet := range magnets {
+		if err := q.addTorrent(ctx, magnet, _arr, debridName, action, rename); err != nil {
+			_logger.Debug().Err(err).Msgf("Error adding torrent")
+			http.Error(w, err.Error(), addErrorStatus(err))
+			return
+		}
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// addErrorStatus is the status answered for a failed add, 503 in maintenance mode so the arr retries later
+func addErrorStatus(err error) int {
+	if errors.Is(err, store.ErrMaintenance) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
 func (q *QBit) handleTorrentsDelete(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	hashes := getHashes(ctx)
@@ -148,8 +170,18 @@ func (q *QBit) handleTorrentsDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	category := getCategory(ctx)
+	deleteFiles := strings.ToLower(r.FormValue("deleteFiles")) == "true"
+	cfg := config.Get()
 	for _, hash := range hashes {
-		q.storage.Delete(hash, category, false)
+		removeFromDebrid := false
+		if deleteFiles {
+			// Only clean up the debrid side if the arr asked for the files to be deleted too
+			if torrent := q.storage.Get(hash, category); torrent != nil {
+				dc, ok := cfg.GetDebrid(torrent.Debrid)
+				removeFromDebrid = ok && dc.FullDeleteOnRemove
+			}
+		}
+		q.storage.Delete(hash, category, removeFromDebrid)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -203,7 +235,7 @@ func (q *QBit) handleTorrentRecheck(w http.ResponseWriter, r *http.Request) {
 func (q *QBit) handleCategories(w http.ResponseWriter, r *http.Request) {
 	var categories = map[string]TorrentCategory{}
 	for _, cat := range q.Categories {
-		path := filepath.Join(q.DownloadFolder, cat)
+		path := filepath.Join(q.downloadFolder(cat), cat)
 		categories[cat] = TorrentCategory{
 			Name:     cat,
 			SavePath: path,
@@ -224,8 +256,13 @@ func (q *QBit) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No name provided", http.StatusBadRequest)
 		return
 	}
+	if err := store.ValidateCategory(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	q.Categories = append(q.Categories, name)
+	store.Get().AddCategoryDirectory(name)
 
 	request.JSONResponse(w, nil, http.StatusOK)
 }
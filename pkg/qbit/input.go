@@ -0,0 +1,216 @@
+package qbit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxTorrentFileSize caps the .torrent files read from uploads and URLs
+const maxTorrentFileSize = 10 << 20
+
+// torrentInputs splits an add request into one request per torrent, for parseTorrentInput: one per line of its urls
+// field and one per file of its torrents field. Each carries the context of r.
+func torrentInputs(r *http.Request) []*http.Request {
+	var inputs []*http.Request
+	input := func() *http.Request {
+		in := r.WithContext(context.WithValue(r.Context(), parsedMagnetKey{}, new(*utils.Magnet)))
+		in.Form, in.PostForm, in.MultipartForm = url.Values{}, url.Values{}, nil
+		return in
+	}
+	for _, line := range strings.Split(r.FormValue("urls"), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		in := input()
+		in.Form.Set("urls", line)
+		inputs = append(inputs, in)
+	}
+	if r.MultipartForm != nil {
+		for _, fileHeader := range r.MultipartForm.File["torrents"] {
+			in := input()
+			in.MultipartForm = &multipart.Form{File: map[string][]*multipart.FileHeader{"torrents": {fileHeader}}}
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs
+}
+
+type parsedMagnetKey struct{}
+
+// parseTorrentInput returns the infohash and name of the torrent of an add request holding a single one, see
+// torrentInputs: a magnet link, a bare infohash or the URL of a .torrent file in its urls field, or a .torrent or
+// .magnet file uploaded in its torrents field. Each input is recognized by its content, so both fields read the
+// infohash and name the same way: the infohash is normalized to lowercase hex, and the name is the one in the torrent
+// or magnet, falling back to the file name. The torrent itself is then returned by parsedMagnet.
+// Malformed input fails with a 400 *utils.HTTPError.
+func parseTorrentInput(r *http.Request) (infoHash string, name string, err error) {
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["torrents"]
+	}
+	link := strings.TrimSpace(r.FormValue("urls"))
+	var magnet *utils.Magnet
+	switch {
+	case link == "" && len(files) == 0:
+		return "", "", invalidTorrentInput(errors.New("No valid URLs or torrents provided"))
+	case strings.Contains(link, "\n") || len(files) > 1 || (link != "" && len(files) > 0):
+		return "", "", invalidTorrentInput(errors.New("expected a single URL or torrent"))
+	case link != "":
+		if magnet, err = magnetFromURL(r.Context(), link); err != nil {
+			return "", "", invalidTorrentInput(err)
+		}
+	default:
+		if magnet, err = magnetFromUpload(files[0]); err != nil {
+			return "", "", invalidTorrentInput(fmt.Errorf("%s: %w", files[0].Filename, err))
+		}
+	}
+	if slot, ok := r.Context().Value(parsedMagnetKey{}).(**utils.Magnet); ok {
+		*slot = magnet
+	}
+	return magnet.InfoHash, magnet.Name, nil
+}
+
+// parsedMagnet returns the torrent parseTorrentInput read from an input of torrentInputs, with its link, size and
+// .torrent file, nil if it wasn't parsed
+func parsedMagnet(input *http.Request) *utils.Magnet {
+	if slot, ok := input.Context().Value(parsedMagnetKey{}).(**utils.Magnet); ok {
+		return *slot
+	}
+	return nil
+}
+
+func invalidTorrentInput(err error) *utils.HTTPError {
+	return &utils.HTTPError{
+		StatusCode: http.StatusBadRequest,
+		Message:    err.Error(),
+		Code:       "invalid_torrent",
+	}
+}
+
+// magnetFromURL parses a magnet link, a bare infohash or the URL of a .torrent file, which may redirect to a magnet link
+func magnetFromURL(ctx context.Context, link string) (*utils.Magnet, error) {
+	switch {
+	case strings.HasPrefix(link, "magnet:"):
+		return magnetFromLink(link)
+	case strings.HasPrefix(link, "http://"), strings.HasPrefix(link, "https://"):
+		return magnetFromTorrentURL(ctx, link)
+	}
+	if hash, err := utils.NormalizeInfoHash(link); err == nil {
+		return utils.ConstructMagnet(hash, ""), nil
+	}
+	return nil, fmt.Errorf("unsupported URL %q, expected a magnet link, an infohash or an http(s) URL", link)
+}
+
+// magnetFromLink parses a magnet link, checking its infohash
+func magnetFromLink(link string) (*utils.Magnet, error) {
+	magnet, err := utils.GetMagnetInfo(link)
+	if err != nil {
+		return nil, err
+	}
+	if magnet.InfoHash, err = utils.NormalizeInfoHash(magnet.InfoHash); err != nil {
+		return nil, fmt.Errorf("magnet link: %w", err)
+	}
+	return magnet, nil
+}
+
+// magnetFromTorrent parses the content of a .torrent file
+func magnetFromTorrent(data []byte) (*utils.Magnet, error) {
+	magnet, err := utils.GetMagnetFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	if magnet.InfoHash, err = utils.NormalizeInfoHash(magnet.InfoHash); err != nil {
+		return nil, fmt.Errorf("torrent file: %w", err)
+	}
+	return magnet, nil
+}
+
+// magnetFromTorrentURL downloads the .torrent file at link, or follows its redirect to a magnet link
+func magnetFromTorrentURL(ctx context.Context, link string) (*utils.Magnet, error) {
+	var magnetLink string
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme == "magnet" {
+				magnetLink = req.URL.String()
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", link, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", link, err)
+	}
+	defer resp.Body.Close()
+	if magnetLink != "" {
+		return magnetFromLink(magnetLink)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", link, resp.StatusCode)
+	}
+	data, err := readTorrentFile(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", link, err)
+	}
+	return magnetFromTorrent(data)
+}
+
+// magnetFromUpload parses an uploaded .torrent file, or a .magnet file holding a magnet link
+func magnetFromUpload(fileHeader *multipart.FileHeader) (*utils.Magnet, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := readTorrentFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var magnet *utils.Magnet
+	if content := bytes.TrimSpace(data); bytes.HasPrefix(content, []byte("magnet:")) {
+		magnet, err = magnetFromLink(utils.ReadMagnetFile(bytes.NewReader(content)))
+	} else {
+		magnet, err = magnetFromTorrent(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if magnet.Name == "" {
+		magnet.Name = strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename))
+	}
+	return magnet, nil
+}
+
+// readTorrentFile reads a .torrent or .magnet file, up to maxTorrentFileSize
+func readTorrentFile(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTorrentFileSize {
+		return nil, fmt.Errorf("larger than %d MB", maxTorrentFileSize>>20)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty file")
+	}
+	return data, nil
+}
@@ -0,0 +1,214 @@
+package qbit
+
+import (
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/pkg/store"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// maindataSnapshot is what a sync/maindata response sent to a client, for the next response to be diffed against it
+type maindataSnapshot struct {
+	rid         int64
+	torrents    map[string]map[string]any
+	categories  map[string]TorrentCategory
+	tags        []string
+	serverState map[string]any
+}
+
+// maindataState keeps the last snapshots sent by sync/maindata, per category, bounded to history snapshots in total.
+// A client polling with the rid of a kept snapshot gets what changed since, any other rid gets a full update.
+type maindataState struct {
+	mu        sync.Mutex
+	rid       int64
+	history   int
+	snapshots map[string][]*maindataSnapshot // category -> snapshots, oldest first
+	count     int
+}
+
+func newMaindataState(history int) *maindataState {
+	return &maindataState{
+		history:   history,
+		snapshots: make(map[string][]*maindataSnapshot),
+	}
+}
+
+// diff returns the response for a client that last received rid, and keeps the current snapshot
+func (s *maindataState) diff(category string, rid int64, current *maindataSnapshot) *MainData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rid++
+	current.rid = s.rid
+	var previous *maindataSnapshot
+	for _, snapshot := range s.snapshots[category] {
+		if snapshot.rid == rid {
+			previous = snapshot
+			break
+		}
+	}
+	if s.history > 0 {
+		s.snapshots[category] = append(s.snapshots[category], current)
+		s.count++
+		s.evict()
+	}
+
+	if previous == nil {
+		return &MainData{
+			Rid:         current.rid,
+			FullUpdate:  true,
+			Torrents:    current.torrents,
+			Categories:  current.categories,
+			Tags:        current.tags,
+			ServerState: current.serverState,
+		}
+	}
+
+	data := &MainData{
+		Rid:         current.rid,
+		Torrents:    make(map[string]map[string]any),
+		Categories:  make(map[string]TorrentCategory),
+		ServerState: changedFields(previous.serverState, current.serverState),
+	}
+	for hash, fields := range current.torrents {
+		if changed := changedFields(previous.torrents[hash], fields); len(changed) > 0 {
+			data.Torrents[hash] = changed
+		}
+	}
+	for hash := range previous.torrents {
+		if _, ok := current.torrents[hash]; !ok {
+			data.TorrentsRemoved = append(data.TorrentsRemoved, hash)
+		}
+	}
+	for name, cat := range current.categories {
+		if previousCat, ok := previous.categories[name]; !ok || previousCat != cat {
+			data.Categories[name] = cat
+		}
+	}
+	for name := range previous.categories {
+		if _, ok := current.categories[name]; !ok {
+			data.CategoriesRemoved = append(data.CategoriesRemoved, name)
+		}
+	}
+	data.Tags = missing(current.tags, previous.tags)
+	data.TagsRemoved = missing(previous.tags, current.tags)
+	return data
+}
+
+// evict drops the oldest snapshots past the history, s.mu must be held
+func (s *maindataState) evict() {
+	for s.count > s.history {
+		oldestCategory := ""
+		var oldest *maindataSnapshot
+		for category, snapshots := range s.snapshots {
+			if len(snapshots) > 0 && (oldest == nil || snapshots[0].rid < oldest.rid) {
+				oldestCategory, oldest = category, snapshots[0]
+			}
+		}
+		s.snapshots[oldestCategory] = s.snapshots[oldestCategory][1:]
+		if len(s.snapshots[oldestCategory]) == 0 {
+			delete(s.snapshots, oldestCategory)
+		}
+		s.count--
+	}
+}
+
+func (s *maindataState) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = make(map[string][]*maindataSnapshot)
+	s.count = 0
+}
+
+// changedFields returns the fields of current that are new or differ from previous, all of them if previous is nil.
+// Fields dropped since, empty fields are omitted from the torrents, are sent with their zero value.
+func changedFields(previous, current map[string]any) map[string]any {
+	if previous == nil {
+		return current
+	}
+	changed := make(map[string]any)
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed[key] = value
+		}
+	}
+	for key, old := range previous {
+		if _, ok := current[key]; !ok && old != nil {
+			changed[key] = reflect.Zero(reflect.TypeOf(old)).Interface()
+		}
+	}
+	return changed
+}
+
+// missing returns the items of a that aren't in b
+func missing(a, b []string) []string {
+	var result []string
+	for _, item := range a {
+		found := false
+		for _, other := range b {
+			if item == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// maindataSnapshot returns the current state of the torrents of the category, as sent by sync/maindata
+func (q *QBit) maindataSnapshot(category string) *maindataSnapshot {
+	snapshot := &maindataSnapshot{
+		torrents:   make(map[string]map[string]any),
+		categories: make(map[string]TorrentCategory),
+		tags:       append([]string(nil), q.Tags...),
+	}
+	var dlSpeed int64
+	for _, t := range q.storage.GetAll(category, "", nil) {
+		fields, err := torrentFields(t)
+		if err != nil {
+			q.logger.Debug().Err(err).Msgf("Failed to encode %s for sync/maindata", t.Name)
+			continue
+		}
+		snapshot.torrents[t.Hash] = fields
+		dlSpeed += t.Dlspeed
+	}
+	for _, cat := range q.Categories {
+		snapshot.categories[cat] = TorrentCategory{
+			Name:     cat,
+			SavePath: filepath.Join(q.downloadFolder(cat), cat),
+		}
+	}
+	snapshot.serverState = map[string]any{
+		"connection_status": "connected",
+		"dl_info_speed":     dlSpeed,
+		"up_info_speed":     0,
+	}
+	return snapshot
+}
+
+// torrentFields returns the fields of the torrent as sent in torrents/info, without its files
+func torrentFields(t *store.Torrent) (map[string]any, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "files")
+	return fields, nil
+}
+
+func (q *QBit) handleSyncMaindata(w http.ResponseWriter, r *http.Request) {
+	rid, _ := strconv.ParseInt(r.URL.Query().Get("rid"), 10, 64)
+	category := getCategory(r.Context())
+	request.JSONResponse(w, q.maindata.diff(category, rid, q.maindataSnapshot(category)), http.StatusOK)
+}
@@ -0,0 +1,122 @@
+package qbit
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// snapshot returns a maindata snapshot of torrents, with a category and tag per name given
+func snapshot(torrents map[string]map[string]any, categories, tags []string) *maindataSnapshot {
+	s := &maindataSnapshot{
+		torrents:    torrents,
+		categories:  make(map[string]TorrentCategory),
+		tags:        tags,
+		serverState: map[string]any{"connection_status": "connected"},
+	}
+	for _, name := range categories {
+		s.categories[name] = TorrentCategory{Name: name, SavePath: "/downloads/" + name}
+	}
+	return s
+}
+
+func TestMaindataDiff(t *testing.T) {
+	state := newMaindataState(10)
+	steps := []struct {
+		name     string
+		rid      int64 // Sent by the client, -1 for the rid of the previous response
+		current  *maindataSnapshot
+		wantFull bool
+		want     string // Response without its rid
+	}{
+		{
+			"first poll", 0,
+			snapshot(map[string]map[string]any{"a": {"name": "A", "progress": 0.5}}, []string{"radarr"}, nil),
+			true,
+			`{"full_update":true,"torrents":{"a":{"name":"A","progress":0.5}},"categories":{"radarr":{"name":"radarr","savePath":"/downloads/radarr"}},"server_state":{"connection_status":"connected"}}`,
+		},
+		{
+			"nothing changed", -1,
+			snapshot(map[string]map[string]any{"a": {"name": "A", "progress": 0.5}}, []string{"radarr"}, nil),
+			false,
+			`{"full_update":false}`,
+		},
+		{
+			"torrent changed and added", -1,
+			snapshot(map[string]map[string]any{"a": {"name": "A", "progress": 1.0}, "b": {"name": "B", "progress": 0.0}}, []string{"radarr"}, []string{"4k"}),
+			false,
+			`{"full_update":false,"torrents":{"a":{"progress":1},"b":{"name":"B","progress":0}},"tags":["4k"]}`,
+		},
+		{
+			"torrent, category and tag removed", -1,
+			snapshot(map[string]map[string]any{"b": {"name": "B", "progress": 0.0}}, []string{"sonarr"}, nil),
+			false,
+			`{"full_update":false,"torrents_removed":["a"],"categories":{"sonarr":{"name":"sonarr","savePath":"/downloads/sonarr"}},"categories_removed":["radarr"],"tags_removed":["4k"]}`,
+		},
+		{
+			"unknown rid", 99,
+			snapshot(map[string]map[string]any{"b": {"name": "B", "progress": 0.0}}, []string{"sonarr"}, nil),
+			true,
+			`{"full_update":true,"torrents":{"b":{"name":"B","progress":0}},"categories":{"sonarr":{"name":"sonarr","savePath":"/downloads/sonarr"}},"server_state":{"connection_status":"connected"}}`,
+		},
+	}
+	var last int64 // rid of the previous response
+	for _, step := range steps {
+		rid := step.rid
+		if rid < 0 {
+			rid = last
+		}
+		data := state.diff("", rid, step.current)
+		if data.Rid <= last {
+			t.Errorf("%s: rid = %d, want it past %d", step.name, data.Rid, last)
+		}
+		last = data.Rid
+		data.Rid = 0
+		if data.FullUpdate != step.wantFull {
+			t.Errorf("%s: full update = %v, want %v", step.name, data.FullUpdate, step.wantFull)
+		}
+		got, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != `{"rid":0,`+step.want[1:] {
+			t.Errorf("%s: response = %s, want %s", step.name, got, step.want)
+		}
+	}
+}
+
+// Snapshots are kept per category, within the history
+func TestMaindataHistory(t *testing.T) {
+	state := newMaindataState(2)
+	current := func() *maindataSnapshot {
+		return snapshot(map[string]map[string]any{"a": {"name": "A"}}, nil, nil)
+	}
+	first := state.diff("radarr", 0, current()).Rid
+	if data := state.diff("sonarr", first, current()); !data.FullUpdate {
+		t.Error("rid of another category answered with a diff")
+	}
+	if data := state.diff("radarr", first, current()); data.FullUpdate {
+		t.Error("rid within the history answered with a full update")
+	}
+	// Two more snapshots evict the first one
+	state.diff("radarr", 0, current())
+	if data := state.diff("radarr", first, current()); !data.FullUpdate {
+		t.Error("evicted rid answered with a diff")
+	}
+	if state.count != 2 || len(state.snapshots["sonarr"]) != 0 {
+		t.Errorf("%d snapshots kept, %d for sonarr, want 2 and 0", state.count, len(state.snapshots["sonarr"]))
+	}
+
+	var rids []int64
+	none := newMaindataState(0)
+	for range 2 {
+		data := none.diff("", 1, current())
+		rids = append(rids, data.Rid)
+		if !data.FullUpdate {
+			t.Error("diff without a history")
+		}
+	}
+	if !slices.IsSorted(rids) {
+		t.Errorf("rids %v not increasing", rids)
+	}
+}
@@ -15,6 +15,7 @@ type QBit struct {
 	storage        *store.TorrentStorage
 	logger         zerolog.Logger
 	Tags           []string
+	maindata       *maindataState
 }
 
 func New() *QBit {
@@ -27,12 +28,19 @@ func New() *QBit {
 		Categories:     cfg.Categories,
 		storage:        store.Get().Torrents(),
 		logger:         logger.New("qbit"),
+		maindata:       newMaindataState(max(cfg.MaindataHistory, 0)),
 	}
 }
 
+// downloadFolder returns the download folder of the torrents of category
+func (q *QBit) downloadFolder(category string) string {
+	return config.Get().QBitTorrent.CategoryDownloadFolder(category)
+}
+
 func (q *QBit) Reset() {
 	if q.storage != nil {
 		q.storage.Reset()
 	}
 	q.Tags = nil
+	q.maindata.reset()
 }
@@ -30,6 +30,8 @@ func (q *QBit) Routes() http.Handler {
 			r.Get("/files", q.handleTorrentFiles)
 		})
 
+		r.Get("/sync/maindata", q.handleSyncMaindata)
+
 		r.Route("/app", func(r chi.Router) {
 			r.Get("/version", q.handleVersion)
 			r.Get("/webapiVersion", q.handleWebAPIVersion)
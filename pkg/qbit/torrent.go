@@ -6,40 +6,16 @@ import (
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/arr"
 	"github.com/sirrobot01/decypharr/pkg/store"
-	"io"
-	"mime/multipart"
 	"strings"
 	"time"
 )
 
 // All torrent-related helpers goes here
-func (q *QBit) addMagnet(ctx context.Context, url string, arr *arr.Arr, debrid string, action string) error {
-	magnet, err := utils.GetMagnetFromUrl(url)
-	if err != nil {
-		return fmt.Errorf("error parsing magnet link: %w", err)
-	}
-	_store := store.Get()
-
-	importReq := store.NewImportRequest(debrid, q.DownloadFolder, magnet, arr, action, false, "", store.ImportTypeQBitTorrent)
-
-	err = _store.AddTorrent(ctx, importReq)
-	if err != nil {
-		return fmt.Errorf("failed to process torrent: %w", err)
-	}
-	return nil
-}
-
-func (q *QBit) addTorrent(ctx context.Context, fileHeader *multipart.FileHeader, arr *arr.Arr, debrid string, action string) error {
-	file, _ := fileHeader.Open()
-	defer file.Close()
-	var reader io.Reader = file
-	magnet, err := utils.GetMagnetFromFile(reader, fileHeader.Filename)
-	if err != nil {
-		return fmt.Errorf("error reading file: %s \n %w", fileHeader.Filename, err)
-	}
+func (q *QBit) addTorrent(ctx context.Context, magnet *utils.Magnet, arr *arr.Arr, debrid string, action string, rename string) error {
 	_store := store.Get()
-	importReq := store.NewImportRequest(debrid, q.DownloadFolder, magnet, arr, action, false, "", store.ImportTypeQBitTorrent)
-	err = _store.AddTorrent(ctx, importReq)
+	importReq := store.NewImportRequest(debrid, q.downloadFolder(arr.Name), magnet, arr, action, false, "", store.ImportTypeQBitTorrent)
+	importReq.RequestedName = rename
+	err := _store.AddTorrent(ctx, importReq)
 	if err != nil {
 		return fmt.Errorf("failed to process torrent: %w", err)
 	}
@@ -47,6 +23,8 @@ func (q *QBit) addTorrent(ctx context.Context, fileHeader *multipart.FileHeader,
 }
 
 func (q *QBit) ResumeTorrent(t *store.Torrent) bool {
+	// Only torrents held during maintenance are actually paused
+	store.Get().ResumeHeld(t)
 	return true
 }
 
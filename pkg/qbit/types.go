@@ -166,6 +166,19 @@ type TorrentCategory struct {
 	SavePath string `json:"savePath"`
 }
 
+// MainData is the response of sync/maindata, holding only what changed since the rid sent by the client unless FullUpdate is set
+type MainData struct {
+	Rid               int64                      `json:"rid"`
+	FullUpdate        bool                       `json:"full_update"`
+	Torrents          map[string]map[string]any  `json:"torrents,omitempty"` // hash -> changed fields
+	TorrentsRemoved   []string                   `json:"torrents_removed,omitempty"`
+	Categories        map[string]TorrentCategory `json:"categories,omitempty"`
+	CategoriesRemoved []string                   `json:"categories_removed,omitempty"`
+	Tags              []string                   `json:"tags,omitempty"`
+	TagsRemoved       []string                   `json:"tags_removed,omitempty"`
+	ServerState       map[string]any             `json:"server_state,omitempty"`
+}
+
 type TorrentProperties struct {
 	AdditionDate           int64  `json:"addition_date,omitempty"`
 	Comment                string `json:"comment,omitempty"`
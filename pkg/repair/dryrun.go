@@ -0,0 +1,76 @@
+package repair
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+)
+
+// DryRunReport is what a dry run of the repair would have done, nothing was changed
+type DryRunReport struct {
+	Strategy   config.RepairStrategy `json:"strategy"`
+	Reinserts  []DryRunReinsert      `json:"reinserts"`   // Torrents that would be reinserted
+	ArrActions []DryRunArrAction     `json:"arr_actions"` // Files that would be deleted from the arrs and searched again
+}
+
+// DryRunReinsert is a broken torrent that would be reinserted. Its files are only sent to the arrs if the reinsert fails.
+type DryRunReinsert struct {
+	Debrid      string   `json:"debrid"`
+	Torrent     string   `json:"torrent"`
+	BrokenFiles []string `json:"broken_files"` // All of its files with the per_torrent strategy
+}
+
+// DryRunArrAction is a media whose broken files would be deleted and searched again by the arr
+type DryRunArrAction struct {
+	Arr   string   `json:"arr"`
+	Media string   `json:"media"`
+	Files []string `json:"files"`
+}
+
+func (j *Job) addDryRunReinsert(debridName, torrentName string, brokenFiles []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.DryRunReport.Reinserts = append(j.DryRunReport.Reinserts, DryRunReinsert{
+		Debrid:      debridName,
+		Torrent:     torrentName,
+		BrokenFiles: brokenFiles,
+	})
+}
+
+func (j *Job) addDryRunArrAction(arrName string, media arr.Content, items []arr.ContentFile) {
+	files := make([]string, 0, len(items))
+	for _, item := range items {
+		files = append(files, item.Path)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.DryRunReport.ArrActions = append(j.DryRunReport.ArrActions, DryRunArrAction{
+		Arr:   arrName,
+		Media: media.Title,
+		Files: files,
+	})
+}
+
+// logDryRunReport logs what the dry run found, one line per action and a summary
+func (r *Repair) logDryRunReport(job *Job) {
+	report := job.DryRunReport
+	for _, re := range report.Reinserts {
+		r.logger.Info().
+			Str("debrid", re.Debrid).
+			Str("torrent", re.Torrent).
+			Strs("broken_files", re.BrokenFiles).
+			Msg("[dry run] Would reinsert torrent")
+	}
+	for _, action := range report.ArrActions {
+		r.logger.Info().
+			Str("arr", action.Arr).
+			Str("media", action.Media).
+			Strs("files", action.Files).
+			Msg("[dry run] Would delete files and search for them")
+	}
+	r.logger.Info().
+		Str("job", job.ID).
+		Str("strategy", string(report.Strategy)).
+		Int("reinserts", len(report.Reinserts)).
+		Int("arr_actions", len(report.ArrActions)).
+		Msg("[dry run] Repair finished, nothing was changed")
+}
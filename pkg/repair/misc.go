@@ -85,7 +85,7 @@ func collectFiles(media arr.Content) map[string][]arr.ContentFile {
 	return uniqueParents
 }
 
-func (r *Repair) checkTorrentFiles(torrentPath string, files []arr.ContentFile, clients map[string]types.Client, caches map[string]*store.Cache) []arr.ContentFile {
+func (r *Repair) checkTorrentFiles(job *Job, torrentPath string, files []arr.ContentFile, clients map[string]types.Client, caches map[string]*store.Cache) []arr.ContentFile {
 	brokenFiles := make([]arr.ContentFile, 0)
 
 	emptyFiles := make([]arr.ContentFile, 0)
@@ -128,7 +128,21 @@ func (r *Repair) checkTorrentFiles(torrentPath string, files []arr.ContentFile,
 		filePaths[i] = file.TargetPath
 	}
 
-	brokenFilePaths := cache.GetBrokenFiles(&torrent, filePaths)
+	var brokenFilePaths []string
+	if job.DryRun {
+		broken, wouldReinsert := cache.FindBrokenFiles(&torrent, filePaths)
+		if wouldReinsert {
+			job.addDryRunReinsert(debridName, torrentName, broken)
+			return emptyFiles
+		}
+		brokenFilePaths = broken
+	} else {
+		var err error
+		brokenFilePaths, err = cache.GetBrokenFiles(&torrent, filePaths)
+		if err != nil {
+			job.addReInsertFailure(torrentName, err)
+		}
+	}
 	if len(brokenFilePaths) > 0 {
 		r.logger.Debug().Msgf("%d broken files found in %s", len(brokenFilePaths), torrentName)
 
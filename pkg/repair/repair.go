@@ -10,6 +10,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/metrics"
 	"github.com/sirrobot01/decypharr/internal/request"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/arr"
@@ -21,9 +22,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,6 +44,12 @@ type Repair struct {
 	workers     int
 	scheduler   gocron.Scheduler
 
+	running     sync.WaitGroup // Jobs being run, waited for on shutdown
+	interrupted atomic.Bool    // Set once the running jobs are cancelled by a shutdown
+
+	throttleMu sync.Mutex  // Serializes the workers while throttled by active streams
+	yielding   atomic.Bool // Set while workers are held back by active streams
+
 	debridPathCache sync.Map // debridPath:debridName cache.Emptied after each run
 	torrentsMap     sync.Map //debridName: map[string]*store.CacheTorrent. Emptied after each run
 	ctx             context.Context
@@ -55,6 +64,8 @@ const (
 	JobCompleted  JobStatus = "completed"
 	JobProcessing JobStatus = "processing"
 	JobCancelled  JobStatus = "cancelled"
+
+	JobInterrupted JobStatus = "interrupted" // Stopped by a shutdown, resumed from its checkpoint on the next start
 )
 
 type Job struct {
@@ -71,6 +82,14 @@ type Job struct {
 
 	Error string `json:"error"`
 
+	ReInsertFailures map[string]string `json:"reinsert_failures,omitempty"` // Torrent name -> error, the torrents were left as they were
+
+	DryRun       bool          `json:"dry_run"`
+	DryRunReport *DryRunReport `json:"dry_run_report,omitempty"`
+
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+
+	mu         sync.Mutex
 	cancelFunc context.CancelFunc
 	ctx        context.Context
 }
@@ -139,9 +158,12 @@ func (r *Repair) Start(ctx context.Context) error {
 		}
 	}
 
+	r.resumeInterrupted()
+
 	<-ctx.Done()
 
 	r.logger.Info().Msg("Stopping repair scheduler")
+	r.drain(config.Get().GetShutdownTimeout())
 	r.Reset()
 
 	return nil
@@ -159,7 +181,21 @@ func (j *Job) discordContext() string {
 
 	dateFmt := "2006-01-02 15:04:05"
 
-	return fmt.Sprintf(format, j.ID, strings.Join(j.Arrs, ","), strings.Join(j.MediaIDs, ", "), j.Status, j.StartedAt.Format(dateFmt), j.CompletedAt.Format(dateFmt))
+	msg := fmt.Sprintf(format, j.ID, strings.Join(j.Arrs, ","), strings.Join(j.MediaIDs, ", "), j.Status, j.StartedAt.Format(dateFmt), j.CompletedAt.Format(dateFmt))
+	if len(j.ReInsertFailures) > 0 {
+		msg += fmt.Sprintf("\t\t**Reinsert Failures**: %d\n", len(j.ReInsertFailures))
+	}
+	return msg
+}
+
+// addReInsertFailure records a torrent that couldn't be reinserted during the run
+func (j *Job) addReInsertFailure(torrentName string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.ReInsertFailures == nil {
+		j.ReInsertFailures = make(map[string]string)
+	}
+	j.ReInsertFailures[torrentName] = err.Error()
 }
 
 func (r *Repair) getArrs(arrNames []string) []string {
@@ -197,7 +233,18 @@ func (r *Repair) reset(j *Job) {
 	j.CompletedAt = time.Time{}
 	j.FailedAt = time.Time{}
 	j.BrokenItems = nil
+	j.ReInsertFailures = nil
+	j.Checkpoint = nil
 	j.Error = ""
+	j.DryRun = config.Get().Repair.DryRun
+	j.DryRunReport = nil
+	if j.DryRun {
+		j.DryRunReport = &DryRunReport{
+			Strategy:   config.Get().Repair.Strategy,
+			Reinserts:  make([]DryRunReinsert, 0),
+			ArrActions: make([]DryRunArrAction, 0),
+		}
+	}
 	if j.Recurrent || j.Arrs == nil {
 		j.Arrs = r.getArrs([]string{}) // Get new arrs
 	}
@@ -277,7 +324,15 @@ func (r *Repair) AddJob(arrsNames []string, mediaIDs []string, autoProcess, recu
 	job.ctx, job.cancelFunc = context.WithCancel(r.ctx)
 	r.Jobs[key] = job
 	go r.saveToFile()
+	r.run(job)
+	return nil
+}
+
+// run runs a job in the background
+func (r *Repair) run(job *Job) {
+	r.running.Add(1)
 	go func() {
+		defer r.running.Done()
 		if err := r.repair(job); err != nil {
 			r.logger.Error().Err(err).Msg("Error running repair")
 			if !errors.Is(job.ctx.Err(), context.Canceled) {
@@ -292,9 +347,9 @@ func (r *Repair) AddJob(arrsNames []string, mediaIDs []string, autoProcess, recu
 				job.CompletedAt = time.Now()
 			}
 		}
+		metrics.RepairRun(string(job.Status), time.Since(job.StartedAt))
 		r.onComplete() // Clear caches and maps after job completion
 	}()
-	return nil
 }
 
 func (r *Repair) StopJob(id string) error {
@@ -336,7 +391,8 @@ func (r *Repair) repair(job *Job) error {
 	}
 
 	// Initialize the run
-	r.initRun(job.ctx)
+	jobCtx := job.ctx
+	r.initRun(jobCtx)
 
 	// Use a mutex to protect concurrent access to brokenItems
 	var mu sync.Mutex
@@ -383,7 +439,13 @@ func (r *Repair) repair(job *Job) error {
 	}
 
 	// Wait for all goroutines to complete and check for errors
-	if err := g.Wait(); err != nil {
+	err := g.Wait()
+	if jobCtx.Err() != nil && r.interrupted.Load() {
+		job.Status = JobInterrupted
+		job.Error = "Interrupted by a shutdown, resumed on the next start"
+		return nil
+	}
+	if err != nil {
 		// Check if j0b was canceled
 		if errors.Is(ctx.Err(), context.Canceled) {
 			job.Status = JobCancelled
@@ -396,23 +458,24 @@ func (r *Repair) repair(job *Job) error {
 		job.Error = err.Error()
 		job.Status = JobFailed
 		job.CompletedAt = time.Now()
-		go func() {
-			if err := request.SendDiscordMessage("repair_failed", "error", job.discordContext()); err != nil {
-				r.logger.Error().Msgf("Error sending discord message: %v", err)
-			}
-		}()
+		request.Notify("repair_failed", "error", job.discordContext())
 		return err
 	}
 
+	if job.DryRun {
+		// Nothing to process, the report is all there is
+		job.BrokenItems = brokenItems
+		job.CompletedAt = time.Now()
+		job.Status = JobCompleted
+		r.logDryRunReport(job)
+		return nil
+	}
+
 	if len(brokenItems) == 0 {
 		job.CompletedAt = time.Now()
 		job.Status = JobCompleted
 
-		go func() {
-			if err := request.SendDiscordMessage("repair_complete", "success", job.discordContext()); err != nil {
-				r.logger.Error().Msgf("Error sending discord message: %v", err)
-			}
-		}()
+		request.Notify("repair_complete", "success", job.discordContext())
 
 		return nil
 	}
@@ -422,18 +485,10 @@ func (r *Repair) repair(job *Job) error {
 		// Job is already processed
 		job.CompletedAt = time.Now() // Mark as completed
 		job.Status = JobCompleted
-		go func() {
-			if err := request.SendDiscordMessage("repair_complete", "success", job.discordContext()); err != nil {
-				r.logger.Error().Msgf("Error sending discord message: %v", err)
-			}
-		}()
+		request.Notify("repair_complete", "success", job.discordContext())
 	} else {
 		job.Status = JobPending
-		go func() {
-			if err := request.SendDiscordMessage("repair_pending", "pending", job.discordContext()); err != nil {
-				r.logger.Error().Msgf("Error sending discord message: %v", err)
-			}
-		}()
+		request.Notify("repair_pending", "pending", job.discordContext())
 	}
 	return nil
 }
@@ -460,6 +515,16 @@ func (r *Repair) repairArr(job *Job, _arr string, tmdbId string) ([]arr.ContentF
 		return brokenItems, fmt.Errorf("mount check failed: %w", err)
 	}
 
+	// Skip the media checked before an interruption
+	checked, previous := job.checkpointed(a.Name)
+	if len(checked) > 0 {
+		media = slices.DeleteFunc(media, func(m arr.Content) bool {
+			_, ok := checked[m.Id]
+			return ok
+		})
+		r.logger.Info().Msgf("Resuming %s repair, %d media left", a.Name, len(media))
+	}
+
 	// Mutex for brokenItems
 	var mu sync.Mutex
 	var wg sync.WaitGroup
@@ -475,10 +540,13 @@ func (r *Repair) repairArr(job *Job, _arr string, tmdbId string) ([]arr.ContentF
 					return
 				default:
 				}
+				r.yieldToStreams(job.ctx)
 				items := r.getBrokenFiles(job, m)
 				if items != nil {
 					r.logger.Debug().Msgf("Found %d broken files for %s", len(items), m.Title)
-					if job.AutoProcess {
+					if job.DryRun {
+						job.addDryRunArrAction(a.Name, m, items)
+					} else if job.AutoProcess {
 						r.logger.Info().Msgf("Auto processing %d broken items for %s", len(items), m.Title)
 
 						// Delete broken items
@@ -496,6 +564,10 @@ func (r *Repair) repairArr(job *Job, _arr string, tmdbId string) ([]arr.ContentF
 					brokenItems = append(brokenItems, items...)
 					mu.Unlock()
 				}
+				if job.ctx.Err() == nil {
+					// A media checked while cancelled may be incomplete
+					job.checkpoint(a.Name, m.Id, items)
+				}
 			}
 		}()
 	}
@@ -512,6 +584,7 @@ func (r *Repair) repairArr(job *Job, _arr string, tmdbId string) ([]arr.ContentF
 	}()
 
 	wg.Wait()
+	brokenItems = append(previous, brokenItems...)
 	if len(brokenItems) == 0 {
 		r.logger.Info().Msgf("No broken items found for %s", a.Name)
 		return brokenItems, nil
@@ -676,7 +749,7 @@ func (r *Repair) getWebdavBrokenFiles(job *Job, media arr.Content) []arr.Content
 			return brokenFiles
 		default:
 		}
-		brokenFilesForTorrent := r.checkTorrentFiles(torrentPath, files, clients, caches)
+		brokenFilesForTorrent := r.checkTorrentFiles(job, torrentPath, files, clients, caches)
 		if len(brokenFilesForTorrent) > 0 {
 			brokenFiles = append(brokenFiles, brokenFilesForTorrent...)
 		}
@@ -802,7 +875,7 @@ func (r *Repair) saveToFile() {
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to marshal jobs")
 	}
-	_ = os.WriteFile(r.filename, data, 0644)
+	_ = config.Get().WriteFile(r.filename, data)
 }
 
 func (r *Repair) loadFromFile() {
@@ -820,8 +893,8 @@ func (r *Repair) loadFromFile() {
 	}
 	jobs := make(map[string]*Job)
 	for k, v := range _jobs {
-		if v.Status != JobPending {
-			// Skip jobs that are not pending processing due to reboot
+		if v.Status != JobPending && v.Status != JobInterrupted {
+			// Skip jobs that are not pending processing or resumed due to reboot
 			continue
 		}
 		jobs[k] = v
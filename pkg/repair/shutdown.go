@@ -0,0 +1,92 @@
+package repair
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"slices"
+	"time"
+)
+
+// interruptGrace bounds waiting for the jobs cancelled by a shutdown to save their progress
+const interruptGrace = 5 * time.Second
+
+// Checkpoint is the progress of a repair run, an interrupted run resumes from it on the next start
+type Checkpoint struct {
+	Checked map[string][]int             `json:"checked"` // Arr -> ids of the media already checked
+	Broken  map[string][]arr.ContentFile `json:"broken"`  // Arr -> broken files found so far
+}
+
+// checkpoint records a checked media and its broken files
+func (j *Job) checkpoint(arrName string, mediaID int, items []arr.ContentFile) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Checkpoint == nil {
+		j.Checkpoint = &Checkpoint{
+			Checked: make(map[string][]int),
+			Broken:  make(map[string][]arr.ContentFile),
+		}
+	}
+	j.Checkpoint.Checked[arrName] = append(j.Checkpoint.Checked[arrName], mediaID)
+	if len(items) > 0 {
+		j.Checkpoint.Broken[arrName] = append(j.Checkpoint.Broken[arrName], items...)
+	}
+}
+
+// checkpointed returns the ids of the media of arrName checked before the run was interrupted, and the broken files
+// found in them
+func (j *Job) checkpointed(arrName string) (map[int]struct{}, []arr.ContentFile) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	checked := make(map[int]struct{})
+	if j.Checkpoint == nil {
+		return checked, nil
+	}
+	for _, id := range j.Checkpoint.Checked[arrName] {
+		checked[id] = struct{}{}
+	}
+	return checked, slices.Clone(j.Checkpoint.Broken[arrName])
+}
+
+// drain waits up to timeout for the running jobs to finish. The jobs still running are then cancelled and saved
+// with their checkpoint, as interrupted, to be resumed on the next start.
+func (r *Repair) drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	r.logger.Info().Msg("Repair jobs still running after the shutdown timeout, saving their progress")
+	r.interrupted.Store(true)
+	for _, job := range r.Jobs {
+		if job.Status == JobStarted && job.cancelFunc != nil {
+			job.cancelFunc()
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(interruptGrace):
+		r.logger.Warn().Msg("Repair jobs didn't stop in time, their progress may be lost")
+	}
+	r.saveToFile()
+}
+
+// resumeInterrupted resumes the jobs interrupted by the last shutdown, skipping the media they already checked
+func (r *Repair) resumeInterrupted() {
+	for _, job := range r.Jobs {
+		if job.Status != JobInterrupted {
+			continue
+		}
+		r.logger.Info().Msgf("Resuming repair job %s", job.ID)
+		job.Status = JobStarted
+		job.Error = ""
+		job.ctx, job.cancelFunc = context.WithCancel(r.ctx)
+		r.run(job)
+	}
+}
@@ -0,0 +1,76 @@
+package repair
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"time"
+)
+
+const (
+	streamingPollInterval = 2 * time.Second
+	streamingThrottle     = 2 * time.Second // Delay between items while throttled
+)
+
+// activeStreams returns the number of WebDav streams in progress across debrids
+func (r *Repair) activeStreams() int {
+	total := 0
+	for _, db := range r.deb.Debrids() {
+		if cache := db.Cache(); cache != nil {
+			total += cache.ActiveStreams()
+		}
+	}
+	return total
+}
+
+// yieldToStreams holds a repair worker back while the active WebDav streams reach Repair.StreamingThreshold.
+// Paused workers resume once the streams stayed under the threshold for StreamingResumeAfter.
+// Throttled workers keep going one at a time, with a delay between items.
+func (r *Repair) yieldToStreams(ctx context.Context) {
+	cfg := config.Get().Repair
+	threshold := cfg.StreamingThreshold
+	if threshold <= 0 || r.activeStreams() < threshold {
+		return
+	}
+
+	if cfg.StreamingBehavior == config.StreamingThrottle {
+		if !r.yielding.Swap(true) {
+			r.logger.Info().Msgf("Throttling repair, %d active streams", r.activeStreams())
+		}
+		r.throttleMu.Lock()
+		defer r.throttleMu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(streamingThrottle):
+		}
+		if r.activeStreams() < threshold && r.yielding.Swap(false) {
+			r.logger.Info().Msg("Streaming quieted down, repair back to full speed")
+		}
+		return
+	}
+
+	if !r.yielding.Swap(true) {
+		r.logger.Info().Msgf("Pausing repair, %d active streams", r.activeStreams())
+	}
+	resumeAfter := cfg.GetStreamingResumeAfter()
+	var quietSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamingPollInterval):
+		}
+		if r.activeStreams() >= threshold {
+			quietSince = time.Time{}
+			continue
+		}
+		if quietSince.IsZero() {
+			quietSince = time.Now()
+		}
+		if time.Since(quietSince) >= resumeAfter {
+			if r.yielding.Swap(false) {
+				r.logger.Info().Msg("Streaming quieted down, resuming repair")
+			}
+			return
+		}
+	}
+}
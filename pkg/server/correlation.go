@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/sirrobot01/decypharr/internal/logger"
+	"net/http"
+)
+
+// CorrelationIDHeader carries the correlation ID of a request, set by the client or generated, and sent back in the
+// response
+const CorrelationIDHeader = "X-Request-ID"
+
+// correlationID gives every request a correlation ID, carried by its context so the logs of the work it triggers,
+// debrid calls included, can be tied back to it
+func correlationID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationIDHeader)
+		if !validCorrelationID(id) {
+			id = logger.NewCorrelationID()
+		}
+		w.Header().Set(CorrelationIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logger.WithCorrelationID(r.Context(), id)))
+	})
+}
+
+// validCorrelationID reports whether a client supplied correlation ID is safe to log: short and printable ASCII
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/logger"
+	"github.com/sirrobot01/decypharr/internal/metrics"
 	"io"
 	"net/http"
 	"net/url"
@@ -24,6 +25,7 @@ func New(handlers map[string]http.Handler) *Server {
 	l := logger.New("http")
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
+	r.Use(correlationID)
 
 	cfg := config.Get()
 
@@ -32,7 +34,7 @@ func New(handlers map[string]http.Handler) *Server {
 	}
 	staticPath, _ := url.JoinPath(cfg.URLBase, "static")
 	r.Handle(staticPath+"/*",
-		http.StripPrefix(staticPath, http.FileServer(http.Dir("static"))),
+		http.StripPrefix(staticPath, newStaticHandler("static")),
 	)
 
 	r.Route(cfg.URLBase, func(r chi.Router) {
@@ -50,6 +52,11 @@ func New(handlers map[string]http.Handler) *Server {
 			r.Get("/ingests/{debrid}", s.handleIngestsByDebrid)
 		})
 
+		//metrics
+		if cfg.EnableMetrics {
+			r.Handle("/metrics", metrics.Handler())
+		}
+
 		//webhooks
 		r.Post("/webhooks/tautulli", s.handleTautulli)
 
@@ -75,8 +82,16 @@ func (s *Server) Start(ctx context.Context) error {
 	}()
 
 	<-ctx.Done()
-	s.logger.Info().Msg("Shutting down gracefully...")
-	return srv.Shutdown(context.Background())
+	// New requests are refused right away, the in-flight ones, e.g. WebDav streams, are waited for until the timeout
+	timeout := config.Get().GetShutdownTimeout()
+	s.logger.Info().Msgf("Shutting down gracefully, waiting up to %s for in-flight requests...", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		s.logger.Warn().Err(err).Msg("In-flight requests still running after the shutdown timeout, closing them")
+		return srv.Close()
+	}
+	return nil
 }
 
 func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type assetHash struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// staticHandler serves the UI assets in dir with an ETag computed from their content.
+// Browsers keep the assets cached but revalidate them, so an update is picked up without a hard refresh.
+type staticHandler struct {
+	dir    string
+	mu     sync.Mutex
+	hashes map[string]assetHash
+}
+
+func newStaticHandler(dir string) *staticHandler {
+	return &staticHandler{
+		dir:    dir,
+		hashes: make(map[string]assetHash),
+	}
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	file, err := os.Open(filepath.Join(h.dir, filepath.FromSlash(name)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag, err := h.etag(name, file, info)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	// ServeContent answers 304 when If-None-Match matches the ETag
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// etag returns the content hash of the file, recomputed only when its size or modification time changes
+func (h *staticHandler) etag(name string, file *os.File, info os.FileInfo) (string, error) {
+	h.mu.Lock()
+	cached, ok := h.hashes[name]
+	h.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+
+	h.mu.Lock()
+	h.hashes[name] = assetHash{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	h.mu.Unlock()
+	return etag, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticETag(t *testing.T) {
+	for _, urlBase := range []string{"/", "/decypharr/"} {
+		dir := t.TempDir()
+		asset := filepath.Join(dir, "app.js")
+		if err := os.WriteFile(asset, []byte("console.log(1)"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		staticPath, _ := url.JoinPath(urlBase, "static")
+		handler := http.StripPrefix(staticPath, newStaticHandler(dir))
+		get := func(etag string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, staticPath+"/app.js", nil)
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		first := get("")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" || first.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("%s: GET = %d, ETag %q, Cache-Control %q", urlBase, first.Code, etag, first.Header().Get("Cache-Control"))
+		}
+		if rec := get(etag); rec.Code != http.StatusNotModified {
+			t.Errorf("%s: GET with the same ETag = %d, want 304", urlBase, rec.Code)
+		}
+
+		// Same size, the modification time tells the content changed
+		if err := os.WriteFile(asset, []byte("console.log(2)"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(asset, later, later); err != nil {
+			t.Fatal(err)
+		}
+		updated := get(etag)
+		if updated.Code != http.StatusOK || updated.Body.String() != "console.log(2)" {
+			t.Errorf("%s: GET after an update = %d %q, want the new content", urlBase, updated.Code, updated.Body.String())
+		}
+		if got := updated.Header().Get("ETag"); got == etag || got == "" {
+			t.Errorf("%s: ETag after an update = %q, want a new one", urlBase, got)
+		}
+
+		if rec := get(""); rec.Header().Get("ETag") != updated.Header().Get("ETag") {
+			t.Errorf("%s: ETag changed without the content changing", urlBase)
+		}
+	}
+}
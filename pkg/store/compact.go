@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"os"
+	"time"
+)
+
+// CompactResult is what a compaction of torrents.json pruned
+type CompactResult struct {
+	Removed        int   `json:"removed"`         // Entries pruned
+	Rekeyed        int   `json:"rekeyed"`         // Entries moved to the key of their hash and category
+	BytesBefore    int64 `json:"bytes_before"`    // Size of torrents.json before the compaction
+	BytesAfter     int64 `json:"bytes_after"`     // Size of torrents.json after the compaction
+	BytesReclaimed int64 `json:"bytes_reclaimed"` // BytesBefore - BytesAfter
+}
+
+// Compact prunes the stale entries of the torrents and rewrites torrents.json: empty entries, entries duplicated
+// under a key that no longer matches their hash and category, and failed torrents older than failed_torrents_retention.
+// Torrents are only removed from the file, their content and debrid torrent are left as is.
+func (ts *TorrentStorage) Compact() (CompactResult, error) {
+	result := CompactResult{BytesBefore: fileSize(ts.filename)}
+	retention := config.Get().GetFailedTorrentsRetention()
+	now := time.Now()
+
+	ts.mu.Lock()
+	for key, torrent := range ts.torrents {
+		if torrent == nil {
+			delete(ts.torrents, key)
+			result.Removed++
+			continue
+		}
+		if retention > 0 && torrent.State == "error" && now.Sub(time.Unix(torrent.AddedOn, 0)) > retention {
+			delete(ts.torrents, key)
+			result.Removed++
+			continue
+		}
+		if current := keyPair(torrent.Hash, torrent.Category); key != current {
+			delete(ts.torrents, key)
+			if _, exists := ts.torrents[current]; exists {
+				result.Removed++
+				continue
+			}
+			ts.torrents[current] = torrent
+			result.Rekeyed++
+		}
+	}
+	ts.mu.Unlock()
+
+	if err := ts.saveToFile(); err != nil {
+		return result, err
+	}
+	result.BytesAfter = fileSize(ts.filename)
+	result.BytesReclaimed = result.BytesBefore - result.BytesAfter
+	return result, nil
+}
+
+// fileSize returns the size of the file, 0 if it doesn't exist
+func fileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// CompactTorrents compacts torrents.json and logs what was reclaimed
+func (s *Store) CompactTorrents() (CompactResult, error) {
+	result, err := s.torrents.Compact()
+	if err != nil {
+		return result, err
+	}
+	s.logger.Info().
+		Int("removed", result.Removed).
+		Int("rekeyed", result.Rekeyed).
+		Int64("bytes_reclaimed", result.BytesReclaimed).
+		Msg("Compacted torrents.json")
+	return result, nil
+}
+
+// processCompactTorrents compacts torrents.json every compact_torrents_interval, until ctx is done
+func (s *Store) processCompactTorrents(ctx context.Context) {
+	interval := config.Get().GetCompactTorrentsInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.CompactTorrents(); err != nil {
+				s.logger.Error().Err(err).Msg("Error compacting torrents.json")
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	s := newTestStore(t)
+	config.Get().FailedTorrentsRetention = "24h"
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	ts := s.torrents
+	ts.torrents = Torrents{
+		keyPair("a", "movies"): {Hash: "a", Category: "movies", State: "pausedUP", AddedOn: old},
+		keyPair("b", "movies"): {Hash: "b", Category: "movies", State: "error", AddedOn: old},               // Failed past the retention
+		keyPair("c", "movies"): {Hash: "c", Category: "movies", State: "error", AddedOn: time.Now().Unix()}, // Failed recently
+		keyPair("d", "tv"):     {Hash: "d", Category: "movies", State: "pausedUP", AddedOn: old},            // Category changed
+		keyPair("a", "tv"):     {Hash: "a", Category: "movies", State: "pausedUP", AddedOn: old},            // Duplicate of a
+		keyPair("e", "movies"): nil,
+	}
+	if err := ts.saveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ts.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed != 3 || result.Rekeyed != 1 {
+		t.Errorf("removed %d and rekeyed %d, want 3 and 1", result.Removed, result.Rekeyed)
+	}
+	if result.BytesAfter >= result.BytesBefore || result.BytesReclaimed != result.BytesBefore-result.BytesAfter {
+		t.Errorf("%d bytes before and %d after, reclaimed %d, want the file smaller", result.BytesBefore, result.BytesAfter, result.BytesReclaimed)
+	}
+	for _, key := range []string{keyPair("a", "movies"), keyPair("c", "movies"), keyPair("d", "movies")} {
+		if _, ok := ts.torrents[key]; !ok {
+			t.Errorf("%s pruned, want it kept", key)
+		}
+	}
+	if len(ts.torrents) != 3 {
+		t.Errorf("%d torrents left, want 3", len(ts.torrents))
+	}
+}
@@ -1,15 +1,20 @@
 package store
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cavaliergopher/grab/v3"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/internal/utils"
 )
 
@@ -65,11 +70,15 @@ func (s *Store) processDownload(torrent *Torrent, debridTorrent *types.Torrent)
 		// add the previous error to the error and return
 		return "", fmt.Errorf("failed to create directory: %s: %v", torrentPath, err)
 	}
-	s.downloadFiles(torrent, debridTorrent, torrentPath)
+	if err := s.downloadFiles(torrent, debridTorrent, torrentPath); err != nil {
+		return "", err
+	}
 	return torrentPath, nil
 }
 
-func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, parent string) {
+// downloadFiles downloads the files of the torrent to parent.
+// Failed downloads are logged, only files failing their checksum verification fail the download.
+func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, parent string) error {
 	var wg sync.WaitGroup
 
 	totalSize := int64(0)
@@ -80,7 +89,9 @@ func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, pa
 	debridTorrent.SizeDownloaded = 0 // Reset downloaded bytes
 	debridTorrent.Progress = 0       // Reset progress
 	debridTorrent.Unlock()
-	progressCallback := func(downloaded int64, speed int64) {
+	deb := s.debrid.Debrid(debridTorrent.Debrid)
+	// updateProgress adds downloaded bytes, negative to take back a discarded copy, to the progress
+	updateProgress := func(downloaded int64, speed int64) {
 		debridTorrent.Lock()
 		defer debridTorrent.Unlock()
 		torrent.Lock()
@@ -96,6 +107,12 @@ func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, pa
 		}
 		s.partialTorrentUpdate(torrent, debridTorrent)
 	}
+	progressCallback := func(downloaded int64, speed int64) {
+		if deb != nil {
+			deb.RecordTraffic(downloaded)
+		}
+		updateProgress(downloaded, speed)
+	}
 	client := &grab.Client{
 		UserAgent: "Decypharr[QBitTorrent]",
 		HTTPClient: &http.Client{
@@ -104,38 +121,62 @@ func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, pa
 			},
 		},
 	}
+	dc, _ := config.Get().GetDebrid(debridTorrent.Debrid)
 	errChan := make(chan error, len(debridTorrent.Files))
+	checksumErrChan := make(chan error, len(debridTorrent.Files))
 	for _, file := range debridTorrent.GetFiles() {
 		if file.DownloadLink == nil {
 			s.logger.Info().Msgf("No download link found for %s", file.Name)
 			continue
 		}
 		wg.Add(1)
-		s.downloadSemaphore <- struct{}{}
+		semaphore := s.downloadSemaphoreFor(torrent.Category)
+		semaphore <- struct{}{}
 		go func(file types.File) {
 			defer wg.Done()
-			defer func() { <-s.downloadSemaphore }()
+			defer func() { <-semaphore }()
 			filename := file.Name
+			download := func() error {
+				return grabber(
+					client,
+					file.DownloadLink.DownloadLink,
+					filepath.Join(parent, filename),
+					file.ByteRange,
+					progressCallback,
+				)
+			}
 
-			err := grabber(
-				client,
-				file.DownloadLink.DownloadLink,
-				filepath.Join(parent, filename),
-				file.ByteRange,
-				progressCallback,
-			)
-
+			err := download()
 			if err != nil {
 				s.logger.Error().Msgf("Failed to download %s: %v", filename, err)
 				errChan <- err
-			} else {
-				s.logger.Info().Msgf("Downloaded %s", filename)
+				return
+			}
+			s.logger.Info().Msgf("Downloaded %s", filename)
+
+			if !dc.VerifyChecksums || file.MD5 == "" || file.ByteRange != nil {
+				return
+			}
+			err = verifyChecksum(filepath.Join(parent, filename), file.MD5)
+			if err != nil && dc.ChecksumMismatch == config.ChecksumMismatchRedownload {
+				s.logger.Warn().Msgf("%v, downloading it again", err)
+				// Take the bad copy out of the progress, its traffic was still used
+				updateProgress(-file.Size, 0)
+				_ = os.Remove(filepath.Join(parent, filename))
+				if err = download(); err == nil {
+					err = verifyChecksum(filepath.Join(parent, filename), file.MD5)
+				}
+			}
+			if err != nil {
+				s.logger.Error().Msgf("%v", err)
+				checksumErrChan <- err
 			}
 		}(file)
 	}
 	wg.Wait()
 
 	close(errChan)
+	close(checksumErrChan)
 	var errors []error
 	for err := range errChan {
 		if err != nil {
@@ -144,9 +185,35 @@ func (s *Store) downloadFiles(torrent *Torrent, debridTorrent *types.Torrent, pa
 	}
 	if len(errors) > 0 {
 		s.logger.Error().Msgf("Errors occurred during download: %v", errors)
-		return
 	}
-	s.logger.Info().Msgf("Downloaded all files for %s", debridTorrent.Name)
+	var checksumErrors []error
+	for err := range checksumErrChan {
+		checksumErrors = append(checksumErrors, err)
+	}
+	if len(checksumErrors) > 0 {
+		return fmt.Errorf("checksum verification failed: %v", checksumErrors)
+	}
+	if len(errors) == 0 {
+		s.logger.Info().Msgf("Downloaded all files for %s", debridTorrent.Name)
+	}
+	return nil
+}
+
+// verifyChecksum checks the md5 of the downloaded file against the one reported by the debrid
+func verifyChecksum(filename, expected string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(filename), err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(filename), expected, actual)
+	}
+	return nil
 }
 
 func (s *Store) processSymlink(torrent *Torrent, debridTorrent *types.Torrent) (string, error) {
@@ -164,7 +231,8 @@ func (s *Store) processSymlink(torrent *Torrent, debridTorrent *types.Torrent) (
 	}
 	// Check if the torrent path is a file
 	torrentRclonePath := filepath.Join(rCloneBase, torrentPath) // leave it as is
-	if debridTorrent.Debrid == "alldebrid" && utils.IsMediaFile(torrentPath) {
+	dc, _ := config.Get().GetDebrid(debridTorrent.Debrid)
+	if dc.Provider() == "alldebrid" && utils.IsMediaFile(torrentPath) {
 		// Alldebrid hotfix for single file torrents
 		torrentFolder = utils.RemoveExtension(torrentFolder)
 		torrentRclonePath = rCloneBase // /mnt/rclone/magnets/  // Remove the filename since it's in the root folder
@@ -225,7 +293,7 @@ func (s *Store) processSymlink(torrent *Torrent, debridTorrent *types.Torrent) (
 			return torrentSymlinkPath, fmt.Errorf("timeout waiting for files: %d files still pending", len(pending))
 		}
 	}
-	if s.skipPreCache {
+	if config.Get().QBitTorrent.CategorySkipPreCache(torrent.Category) {
 		return torrentSymlinkPath, nil
 	}
 
@@ -289,7 +357,7 @@ func (s *Store) createSymlinksWebdav(torrent *Torrent, debridTorrent *types.Torr
 		}
 	}
 
-	if s.skipPreCache {
+	if config.Get().QBitTorrent.CategorySkipPreCache(torrent.Category) {
 		return symlinkPath, nil
 	}
 
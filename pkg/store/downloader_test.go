@@ -0,0 +1,87 @@
+package store
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloadChecksums(t *testing.T) {
+	const good, bad = "the movie", "the m0vie"
+	sum := md5.Sum([]byte(good))
+	checksum := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name     string
+		verify   bool
+		policy   config.ChecksumMismatch
+		md5      string
+		served   []string // Content of each download, the last one repeated
+		wantErr  bool
+		wantGets int32
+	}{
+		{"matching", true, config.ChecksumMismatchError, checksum, []string{good}, false, 1},
+		{"matching, uppercase", true, config.ChecksumMismatchError, strings.ToUpper(checksum), []string{good}, false, 1},
+		{"mismatching", true, config.ChecksumMismatchError, checksum, []string{bad}, true, 1},
+		{"mismatching, downloaded again", true, config.ChecksumMismatchRedownload, checksum, []string{bad, good}, false, 2},
+		{"mismatching twice", true, config.ChecksumMismatchRedownload, checksum, []string{bad}, true, 2},
+		{"not reported", true, config.ChecksumMismatchError, "", []string{bad}, false, 1},
+		{"not verified", false, config.ChecksumMismatchError, checksum, []string{bad}, false, 1},
+	}
+	for _, tt := range tests {
+		var gets atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(gets.Add(1))
+			_, _ = w.Write([]byte(tt.served[min(n, len(tt.served))-1]))
+		}))
+
+		dir := t.TempDir()
+		data, err := json.Marshal(map[string]any{"debrids": []config.Debrid{{
+			Name:             "torbox",
+			APIKey:           "key",
+			Folder:           "/mnt/remote/torbox",
+			VerifyChecksums:  tt.verify,
+			ChecksumMismatch: tt.policy,
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		config.SetConfigPath(dir)
+		config.Reload()
+		s := &Store{debrid: &debrid.Storage{}, logger: zerolog.Nop(), downloadSemaphore: make(chan struct{}, 5)}
+
+		file := types.File{Name: "movie.mkv", Size: int64(len(good)), MD5: tt.md5, DownloadLink: &types.DownloadLink{DownloadLink: srv.URL + "/movie.mkv"}}
+		debridTorrent := &types.Torrent{Name: "Movie", Debrid: "torbox", Files: map[string]types.File{file.Name: file}}
+		parent := filepath.Join(dir, "downloads")
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err = s.downloadFiles(&Torrent{Hash: "abc", Category: "radarr"}, debridTorrent, parent)
+		srv.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: downloadFiles() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := gets.Load(); got != tt.wantGets {
+			t.Errorf("%s: %d downloads, want %d", tt.name, got, tt.wantGets)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got, _ := os.ReadFile(filepath.Join(parent, file.Name)); tt.verify && tt.md5 != "" && string(got) != good {
+			t.Errorf("%s: downloaded %q, want %q", tt.name, got, good)
+		}
+	}
+}
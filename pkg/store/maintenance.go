@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"sync"
+)
+
+// ErrMaintenance is returned for a torrent added in maintenance mode with the reject policy
+var ErrMaintenance = errors.New("decypharr is in maintenance mode, try again later")
+
+// pendingAdd is a torrent accepted in maintenance mode, not sent to the debrid yet
+type pendingAdd struct {
+	importReq *ImportRequest
+	torrent   *Torrent
+	held      bool // Waits to be resumed instead of being sent when maintenance ends
+}
+
+// maintenance holds whether Decypharr is in maintenance mode and the torrents added meanwhile, in the order they were added
+type maintenance struct {
+	mu      sync.Mutex
+	enabled bool
+	pending []*pendingAdd
+}
+
+// InMaintenance reports whether Decypharr is in maintenance mode
+func (s *Store) InMaintenance() bool {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	return s.maintenance.enabled
+}
+
+// SetMaintenance turns maintenance mode on or off. Turning it off sends the queued torrents to the debrid,
+// in the order they were added. Held torrents wait to be resumed.
+func (s *Store) SetMaintenance(enabled bool) {
+	s.maintenance.mu.Lock()
+	if s.maintenance.enabled == enabled {
+		s.maintenance.mu.Unlock()
+		return
+	}
+	s.maintenance.enabled = enabled
+	var queued []*pendingAdd
+	if !enabled {
+		held := s.maintenance.pending[:0]
+		for _, p := range s.maintenance.pending {
+			if p.held {
+				held = append(held, p)
+			} else {
+				queued = append(queued, p)
+			}
+		}
+		s.maintenance.pending = held
+	}
+	s.maintenance.mu.Unlock()
+
+	if enabled {
+		s.logger.Info().Msg("Maintenance mode enabled")
+		return
+	}
+	s.logger.Info().Msgf("Maintenance mode disabled, adding %d queued torrents", len(queued))
+	go func() {
+		for _, p := range queued {
+			s.addPending(p)
+		}
+	}()
+}
+
+// ResumeHeld sends a torrent held during maintenance to the debrid, it reports false if the torrent isn't held
+// or maintenance mode is still on
+func (s *Store) ResumeHeld(t *Torrent) bool {
+	s.maintenance.mu.Lock()
+	if s.maintenance.enabled {
+		s.maintenance.mu.Unlock()
+		return false
+	}
+	var resumed *pendingAdd
+	for i, p := range s.maintenance.pending {
+		if p.torrent == t {
+			resumed = p
+			s.maintenance.pending = append(s.maintenance.pending[:i], s.maintenance.pending[i+1:]...)
+			break
+		}
+	}
+	s.maintenance.mu.Unlock()
+	if resumed == nil {
+		return false
+	}
+	go s.addPending(resumed)
+	return true
+}
+
+// holdForMaintenance handles a torrent added in maintenance mode according to the maintenance_adds policy.
+// It reports false if Decypharr isn't in maintenance mode, the torrent is then added as usual.
+func (s *Store) holdForMaintenance(importReq *ImportRequest) (bool, error) {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	if !s.maintenance.enabled {
+		return false, nil
+	}
+	policy := config.Get().MaintenanceAdds
+	if policy == config.MaintenanceAddsReject {
+		return true, ErrMaintenance
+	}
+
+	torrent := createTorrentFromMagnet(importReq)
+	torrent.State = "queuedDL"
+	if policy == config.MaintenanceAddsHold {
+		torrent.State = "pausedDL"
+	}
+	s.maintenance.pending = append(s.maintenance.pending, &pendingAdd{
+		importReq: importReq,
+		torrent:   torrent,
+		held:      policy == config.MaintenanceAddsHold,
+	})
+	s.torrents.AddOrUpdate(torrent)
+	s.logger.Info().Msgf("In maintenance mode, %s is %s", importReq.Magnet.Name, torrent.State)
+	return true, nil
+}
+
+// addPending sends a torrent accepted during maintenance to the debrid, marking it as failed if that fails
+func (s *Store) addPending(p *pendingAdd) {
+	if s.torrents.Get(p.torrent.Hash, p.torrent.Category) != p.torrent {
+		// Deleted or replaced meanwhile
+		return
+	}
+	if err := s.addTorrent(context.Background(), p.importReq); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to add %s after maintenance", p.importReq.Magnet.Name)
+		s.markTorrentAsFailed(p.torrent)
+		p.importReq.markAsFailed(err, p.torrent, nil)
+	}
+}
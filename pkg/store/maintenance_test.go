@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestMaintenanceAdds adds two torrents in maintenance mode, ends it, then resumes the second one
+func TestMaintenanceAdds(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       config.MaintenanceAdds
+		wantErr      error
+		wantState    string   // State of the torrents while in maintenance, empty if they aren't stored
+		wantReleased []string // Torrents sent to the debrid when maintenance ends, in order
+		wantResumed  bool
+	}{
+		{"queue", config.MaintenanceAddsQueue, nil, "queuedDL", []string{"First", "Second"}, false},
+		{"reject", config.MaintenanceAddsReject, ErrMaintenance, "", nil, false},
+		{"hold", config.MaintenanceAddsHold, nil, "pausedDL", nil, true},
+	}
+	for _, tt := range tests {
+		// Without debrids every add fails, the callback tells which torrent was sent and when
+		sent := make(chan string, 4)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var resp struct {
+				Torrent *Torrent `json:"torrent"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&resp); err == nil && resp.Torrent != nil {
+				sent <- resp.Torrent.Name
+			}
+		}))
+
+		s := newTestStore(t)
+		s.debrid = &debrid.Storage{}
+		config.Get().MaintenanceAdds = tt.policy
+		s.SetMaintenance(true)
+
+		var reqs []*ImportRequest
+		for i, name := range []string{"First", "Second"} {
+			magnet := &utils.Magnet{Name: name, InfoHash: string(rune('a'+i)) + "bc", Link: "magnet:?xt=urn:btih:" + name}
+			req := NewImportRequest("", t.TempDir(), magnet, arr.New("sonarr", "", "", false, false, nil, "", ""), "symlink", false, srv.URL, ImportTypeQBitTorrent)
+			if err := s.AddTorrent(context.Background(), req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: add %s: got error %v, want %v", tt.name, name, err, tt.wantErr)
+			}
+			reqs = append(reqs, req)
+		}
+		var stored []*Torrent
+		for _, req := range reqs {
+			torrent := s.torrents.Get(req.Magnet.InfoHash, "sonarr")
+			state := ""
+			if torrent != nil {
+				state = torrent.State
+				stored = append(stored, torrent)
+			}
+			if state != tt.wantState {
+				t.Errorf("%s: %s state = %q, want %q", tt.name, req.Magnet.Name, state, tt.wantState)
+			}
+		}
+		if len(stored) > 0 && s.ResumeHeld(stored[1]) {
+			t.Errorf("%s: resumed a torrent while in maintenance", tt.name)
+		}
+
+		s.SetMaintenance(false)
+		if s.InMaintenance() {
+			t.Errorf("%s: still in maintenance", tt.name)
+		}
+		var released []string
+		for range tt.wantReleased {
+			select {
+			case name := <-sent:
+				released = append(released, name)
+			case <-time.After(5 * time.Second):
+			}
+		}
+		if !slices.Equal(released, tt.wantReleased) {
+			t.Errorf("%s: released %v, want %v", tt.name, released, tt.wantReleased)
+		}
+
+		resumed := len(stored) > 0 && s.ResumeHeld(stored[1])
+		if resumed != tt.wantResumed {
+			t.Errorf("%s: resumed = %v, want %v", tt.name, resumed, tt.wantResumed)
+		}
+		if resumed {
+			select {
+			case name := <-sent:
+				if name != "Second" {
+					t.Errorf("%s: resumed %s, want Second", tt.name, name)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s: resumed torrent not sent", tt.name)
+			}
+			if stored[0].State != "pausedDL" {
+				t.Errorf("%s: other held torrent state = %q, want pausedDL", tt.name, stored[0].State)
+			}
+		}
+		select {
+		case name := <-sent:
+			t.Errorf("%s: %s sent unexpectedly", tt.name, name)
+		case <-time.After(50 * time.Millisecond):
+		}
+		srv.Close()
+	}
+}
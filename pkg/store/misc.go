@@ -1,6 +1,11 @@
 package store
 
 import (
+	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/utils"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,3 +34,135 @@ func createTorrentFromMagnet(req *ImportRequest) *Torrent {
 	}
 	return torrent
 }
+
+// setUncachedSavePath moves the save path of an uncached torrent to the uncached folder of its debrid, if one is set.
+// The download folder is kept if the uncached folder doesn't have enough free space for the torrent.
+func (s *Store) setUncachedSavePath(torrent *Torrent, debridTorrent *types.Torrent) {
+	dc, ok := config.Get().GetDebrid(debridTorrent.Debrid)
+	if !ok || dc.UncachedFolder == "" {
+		return
+	}
+	if free, err := utils.FreeSpace(dc.UncachedFolder); err == nil && free < uint64(max(debridTorrent.Size, 0)) {
+		s.logger.Warn().
+			Str("debrid", dc.Name).
+			Msgf("Not enough free space in %s for %s, using the download folder", dc.UncachedFolder, debridTorrent.Name)
+		return
+	}
+	torrent.SavePath = filepath.Join(dc.UncachedFolder, torrent.Category) + string(os.PathSeparator)
+}
+
+// removeFromDebrid deletes a torrent from its debrid.
+// If the debrid has FullDeleteOnRemove set, the WebDAV cache entry and its cached files are cleaned up as well.
+// A torrent not in the cache is only deleted from the debrid.
+func (s *Store) removeFromDebrid(debridName, torrentId string) {
+	db := s.debrid.Debrid(debridName)
+	if db == nil {
+		return
+	}
+	dc, _ := config.Get().GetDebrid(debridName)
+	if cache := db.Cache(); cache != nil && dc.FullDeleteOnRemove {
+		if t := cache.GetTorrent(torrentId); t != nil {
+			files := len(t.GetFiles())
+			if err := cache.DeleteTorrent(context.Background(), torrentId); err != nil {
+				s.logger.Error().Err(err).Str("debrid", debridName).Msgf("Failed to clean up torrent %s", torrentId)
+				return
+			}
+			s.logger.Info().
+				Str("debrid", debridName).
+				Int("files", files).
+				Msgf("Cleaned up torrent %s: removed from debrid and WebDAV cache", torrentId)
+			return
+		}
+	}
+	if err := db.Client().DeleteTorrent(torrentId); err != nil {
+		s.logger.Error().Err(err).Str("debrid", debridName).Msgf("Failed to delete torrent %s", torrentId)
+		return
+	}
+	s.logger.Info().Str("debrid", debridName).Msgf("Removed torrent %s from debrid", torrentId)
+}
+
+// AddCategoryDirectory exposes a new category as a WebDav directory, if enabled. It returns false if nothing was added
+func (s *Store) AddCategoryDirectory(category string) bool {
+	dir, added, err := config.Get().AddCategoryDirectory(category)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to save WebDav directory for category %s", category)
+	}
+	if !added {
+		return false
+	}
+	for _, cache := range s.debrid.Caches() {
+		cache.AddCustomFolder(category, dir)
+	}
+	s.logger.Info().Msgf("Created WebDav directory for category %s", category)
+	return true
+}
+
+// ValidateCategory checks that a category can name a folder inside the download folder
+func ValidateCategory(category string) error {
+	switch {
+	case category == "":
+		return fmt.Errorf("category is empty")
+	case category == "." || strings.ContainsAny(category, `/\`) || strings.Contains(category, ".."):
+		return fmt.Errorf("invalid category %q, it can't contain path separators or ..", category)
+	}
+	return nil
+}
+
+// RecategorizeTorrent moves a torrent to another category: its symlinks are moved to the category folder,
+// and its WebDav entry follows the category's arr. If rescan is set, the new arr is asked to rescan its downloads.
+func (s *Store) RecategorizeTorrent(hash, category string, rescan bool) (*Torrent, error) {
+	if err := ValidateCategory(category); err != nil {
+		return nil, err
+	}
+	torrent := s.torrents.Get(hash, "")
+	if torrent == nil {
+		return nil, fmt.Errorf("torrent %s not found", hash)
+	}
+	torrent.Lock()
+	defer torrent.Unlock()
+	if torrent.Category == category {
+		return torrent, nil
+	}
+
+	cfg := config.Get()
+	_arr := s.arr.Get(category)
+	if _arr == nil && !utils.Contains(cfg.QBitTorrent.Categories, category) && !s.AddCategoryDirectory(category) {
+		return nil, fmt.Errorf("category %s does not exist", category)
+	}
+
+	oldCategory := torrent.Category
+	savePath := filepath.Join(filepath.Dir(filepath.Clean(torrent.SavePath)), category) + string(os.PathSeparator)
+	torrentPath, contentPath := "", filepath.Join(savePath, torrent.Name)+string(os.PathSeparator)
+	if torrent.TorrentPath != "" {
+		torrentPath = filepath.Join(savePath, filepath.Base(torrent.TorrentPath))
+		if _, err := os.Stat(torrent.TorrentPath); err == nil {
+			if err := os.MkdirAll(savePath, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create category folder: %w", err)
+			}
+			if err := os.Rename(torrent.TorrentPath, torrentPath); err != nil {
+				return nil, fmt.Errorf("failed to move torrent: %w", err)
+			}
+		}
+		contentPath = torrentPath + string(os.PathSeparator)
+	}
+	s.torrents.Move(torrent, func(t *Torrent) {
+		if torrentPath != "" {
+			t.TorrentPath = torrentPath
+		}
+		t.ContentPath = contentPath
+		t.Category = category
+		t.SavePath = savePath
+	})
+
+	if _arr != nil && torrent.Debrid != "" && torrent.DebridID != "" {
+		if db := s.debrid.Debrid(torrent.Debrid); db != nil && db.Cache() != nil {
+			db.Cache().SetTorrentArr(torrent.DebridID, _arr)
+		}
+	}
+	s.logger.Info().Msgf("Moved torrent %s from %s to %s", torrent.Name, oldCategory, category)
+
+	if rescan && _arr != nil {
+		go s.arr.Rescan(_arr)
+	}
+	return torrent, nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoveFromDebrid(t *testing.T) {
+	tests := []struct {
+		name       string
+		fullDelete bool
+		cached     bool
+		wantCached bool // Torrent still in the WebDAV cache after the delete
+	}{
+		{"cleanup", true, true, false},
+		{"cleanup of a torrent not cached", true, false, false},
+		{"no cleanup", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if id, ok := strings.CutPrefix(r.URL.Path, "/torrents/delete/"); ok && r.Method == http.MethodDelete {
+					mu.Lock()
+					deleted = append(deleted, id)
+					mu.Unlock()
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				_, _ = w.Write([]byte(`{"id":1,"username":"user","type":"premium"}`))
+			}))
+			defer srv.Close()
+
+			dir, err := os.MkdirTemp("", "decypharr-store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The cache saves its torrents in the background, the directory is removed without failing the test
+			t.Cleanup(func() { _ = os.RemoveAll(dir) })
+			data, err := json.Marshal(map[string]any{
+				"qbittorrent": map[string]any{"download_folder": t.TempDir()},
+				"debrids": []config.Debrid{{
+					Name:               "realdebrid",
+					APIKey:             "key",
+					Folder:             filepath.Join(dir, "mnt"),
+					Endpoint:           srv.URL,
+					UseWebDav:          true,
+					FullDeleteOnRemove: tt.fullDelete,
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			config.SetConfigPath(dir)
+			config.Reload()
+			s := &Store{debrid: debrid.NewStorage(), logger: zerolog.Nop()}
+			cache := s.debrid.Debrid("realdebrid").Cache()
+			if tt.cached {
+				file := types.File{Id: "1", Name: "movie.mkv", Path: "movie.mkv", Size: 1 << 20, Link: "https://debrid/movie", TorrentId: "1"}
+				err := cache.ProcessTorrent(&types.Torrent{
+					Id:       "1",
+					InfoHash: "abc",
+					Name:     "Movie",
+					Filename: "Movie",
+					Added:    time.Now().Format(time.RFC3339),
+					Files:    map[string]types.File{file.Name: file},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s.removeFromDebrid("realdebrid", "1")
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(deleted, []string{"1"}) {
+				t.Errorf("deleted %v from the debrid, want [1]", deleted)
+			}
+			if got := cache.GetTorrent("1") != nil; got != tt.wantCached {
+				t.Errorf("torrent cached after the delete = %v, want %v", got, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestRecategorizeTorrent(t *testing.T) {
+	tests := []struct {
+		name        string
+		category    string
+		torrentFile bool // The torrent has symlinks on disk
+		wantErr     bool
+	}{
+		{"move with symlinks", "movies", true, false},
+		{"move without symlinks", "movies", false, false},
+		{"same category", "tv", true, false},
+		{"unknown category", "music", true, true},
+		{"invalid category", "../movies", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "decypharr-store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The torrents are saved in the background, the directory is removed without failing the test
+			t.Cleanup(func() { _ = os.RemoveAll(dir) })
+			downloads := filepath.Join(dir, "downloads")
+			data, err := json.Marshal(map[string]any{
+				"qbittorrent": map[string]any{"download_folder": downloads, "categories": []string{"tv", "movies"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			config.SetConfigPath(dir)
+			config.Reload()
+			s := &Store{
+				arr:      arr.NewStorage(),
+				debrid:   debrid.NewStorage(),
+				torrents: newTorrentStorage(filepath.Join(dir, "torrents.json"), zerolog.Nop()),
+				logger:   zerolog.Nop(),
+			}
+
+			savePath := filepath.Join(downloads, "tv") + string(os.PathSeparator)
+			torrent := &Torrent{
+				Hash:        "abc",
+				Name:        "Show",
+				Category:    "tv",
+				SavePath:    savePath,
+				ContentPath: filepath.Join(savePath, "Show") + string(os.PathSeparator),
+			}
+			if tt.torrentFile {
+				torrent.TorrentPath = filepath.Join(savePath, "Show")
+				if err := os.MkdirAll(filepath.Join(torrent.TorrentPath, "Season 1"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.torrents.Add(torrent)
+
+			got, err := s.RecategorizeTorrent("abc", tt.category, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if torrent.Category != "tv" || s.torrents.Get("abc", "tv") != torrent {
+					t.Errorf("torrent moved to %q after a failed recategorize", torrent.Category)
+				}
+				return
+			}
+			wantSavePath := filepath.Join(downloads, tt.category) + string(os.PathSeparator)
+			wantContentPath := filepath.Join(wantSavePath, "Show") + string(os.PathSeparator)
+			if got.Category != tt.category || got.SavePath != wantSavePath || got.ContentPath != wantContentPath {
+				t.Errorf("torrent at %q/%q/%q, want %q/%q/%q", got.Category, got.SavePath, got.ContentPath, tt.category, wantSavePath, wantContentPath)
+			}
+			if s.torrents.Get("abc", tt.category) != torrent {
+				t.Errorf("torrent not stored under category %s", tt.category)
+			}
+			if tt.category != "tv" && s.torrents.Get("abc", "tv") != nil {
+				t.Errorf("torrent still stored under its old category")
+			}
+			if tt.torrentFile {
+				if _, err := os.Stat(filepath.Join(wantSavePath, "Show", "Season 1")); err != nil {
+					t.Errorf("symlinks not moved to the category folder: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetUncachedSavePath(t *testing.T) {
+	dir := t.TempDir()
+	uncached := filepath.Join(dir, "uncached")
+	if err := os.Mkdir(uncached, 0755); err != nil {
+		t.Fatal(err)
+	}
+	downloads := filepath.Join(dir, "downloads", "tv") + string(os.PathSeparator)
+	data, err := json.Marshal(map[string]any{
+		"debrids": []config.Debrid{
+			{Name: "realdebrid", APIKey: "key", Folder: "/mnt/realdebrid", UncachedFolder: uncached},
+			{Name: "torbox", APIKey: "key", Folder: "/mnt/torbox"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	s := &Store{logger: zerolog.Nop()}
+
+	tests := []struct {
+		name   string
+		debrid string
+		size   int64
+		want   string
+	}{
+		{"uncached folder", "realdebrid", 1 << 20, filepath.Join(uncached, "tv") + string(os.PathSeparator)},
+		{"unknown size", "realdebrid", 0, filepath.Join(uncached, "tv") + string(os.PathSeparator)},
+		{"not enough free space", "realdebrid", 1 << 62, downloads},
+		{"no uncached folder", "torbox", 1 << 20, downloads},
+		{"unknown debrid", "alldebrid", 1 << 20, downloads},
+	}
+	for _, tt := range tests {
+		torrent := &Torrent{Hash: "abc", Category: "tv", SavePath: downloads}
+		s.setUncachedSavePath(torrent, &types.Torrent{Name: "Show", Debrid: tt.debrid, Size: tt.size})
+		if torrent.SavePath != tt.want {
+			t.Errorf("%s: save path = %q, want %q", tt.name, torrent.SavePath, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"github.com/sirrobot01/decypharr/internal/metrics"
 	"time"
 )
 
@@ -40,6 +41,16 @@ func (s *Store) StartQueueSchedule(ctx context.Context) error {
 		}
 	}()
 
+	go s.processCompactTorrents(ctx)
+
+	// Periodically check debrid accounts for an expired premium
+	s.debrid.StartPremiumCheck(ctx)
+	s.debrid.StartTrafficTracking(ctx)
+	s.debrid.StartUnlockTracking(ctx)
+	s.debrid.StartKeyTracking(ctx)
+	s.debrid.StartHealthChecks(ctx)
+	s.debrid.StartExpiryChecks(ctx)
+
 	return nil
 }
 
@@ -84,15 +95,19 @@ func (s *Store) trackAvailableSlots(ctx context.Context) {
 	availableSlots := make(map[string]int)
 
 	for name, deb := range s.debrid.Debrids() {
+		if deb.IsPremiumExpired() {
+			continue
+		}
 		slots, err := deb.Client().GetAvailableSlots()
 		if err != nil {
 			continue
 		}
 		availableSlots[name] = slots
+		metrics.SetFreeSlots(name, slots)
 	}
 
-	if s.importsQueue.Size() <= 0 {
-		// Queue is empty, no need to process
+	if s.importsQueue.Size() <= 0 || s.InMaintenance() {
+		// Queue is empty or paused by maintenance, no need to process
 		return
 	}
 
@@ -124,7 +139,7 @@ func (s *Store) processFromQueue(ctx context.Context) error {
 	if importReq == nil {
 		return nil
 	}
-	return s.AddTorrent(ctx, importReq)
+	return s.addTorrent(ctx, importReq)
 }
 
 func (s *Store) removeStalledTorrents(ctx context.Context) error {
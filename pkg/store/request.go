@@ -33,6 +33,8 @@ type ImportRequest struct {
 	Action           string        `json:"action"`
 	DownloadUncached bool          `json:"downloadUncached"`
 	CallBackUrl      string        `json:"callBackUrl"`
+	CorrelationID    string        `json:"correlationId,omitempty"` // Of the request that added the torrent
+	RequestedName    string        `json:"requestedName,omitempty"` // Name the arr expects the torrent under
 
 	Status      string    `json:"status"`
 	CompletedAt time.Time `json:"completedAt,omitempty"`
@@ -40,6 +42,8 @@ type ImportRequest struct {
 
 	Type  ImportType `json:"type"`
 	Async bool       `json:"async"`
+
+	contentChecked bool // The NoVideoPolicy was applied to the file list reported when the torrent was added
 }
 
 func NewImportRequest(debrid string, downloadFolder string, magnet *utils.Magnet, arr *arr.Arr, action string, downloadUncached bool, callBackUrl string, importType ImportType) *ImportRequest {
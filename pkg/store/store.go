@@ -21,9 +21,11 @@ type Store struct {
 	torrents           *TorrentStorage
 	logger             zerolog.Logger
 	refreshInterval    time.Duration
-	skipPreCache       bool
 	downloadSemaphore  chan struct{}
 	removeStalledAfter time.Duration // Duration after which stalled torrents are removed
+	maintenance        maintenance
+
+	categorySemaphores sync.Map // category -> chan struct{}, for the categories with their own max_downloads
 }
 
 var (
@@ -43,10 +45,9 @@ func Get() *Store {
 			repair:            repair.New(arrs, deb),
 			arr:               arrs,
 			debrid:            deb,
-			torrents:          newTorrentStorage(cfg.TorrentsFile()),
+			torrents:          newTorrentStorage(cfg.TorrentsFile(), logger.Default()),
 			logger:            logger.Default(), // Use default logger [decypharr]
 			refreshInterval:   time.Duration(cmp.Or(qbitCfg.RefreshInterval, 10)) * time.Minute,
-			skipPreCache:      qbitCfg.SkipPreCache,
 			downloadSemaphore: make(chan struct{}, cmp.Or(qbitCfg.MaxDownloads, 5)),
 			importsQueue:      NewImportQueue(context.Background(), 1000),
 		}
@@ -60,6 +61,23 @@ func Get() *Store {
 	return instance
 }
 
+// downloadSemaphoreFor returns the semaphore bounding the files of category downloaded at once,
+// its own if the category overrides max_downloads. It's rebuilt when the limit of the category changes.
+func (s *Store) downloadSemaphoreFor(category string) chan struct{} {
+	limit := config.Get().QBitTorrent.CategoryMaxDownloads(category)
+	if limit <= 0 {
+		return s.downloadSemaphore
+	}
+	for {
+		current, _ := s.categorySemaphores.LoadOrStore(category, make(chan struct{}, limit))
+		if sem := current.(chan struct{}); cap(sem) == limit {
+			return sem
+		}
+		// The downloads in progress release the semaphore they acquired, the old limit applies to them
+		s.categorySemaphores.CompareAndSwap(category, current, make(chan struct{}, limit))
+	}
+}
+
 func Reset() {
 	if instance != nil {
 		if instance.debrid != nil {
@@ -5,6 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
 	"github.com/sirrobot01/decypharr/internal/request"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	debridTypes "github.com/sirrobot01/decypharr/pkg/debrid"
@@ -12,10 +15,33 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+const (
+	namelessMetadataAttempts = 6
+	namelessMetadataInterval = 10 * time.Second
+)
+
 func (s *Store) AddTorrent(ctx context.Context, importReq *ImportRequest) error {
+	if importReq.Magnet.Name == "" {
+		// No display name, use a placeholder until the debrid reports the torrent name
+		importReq.Magnet.Name = config.Get().NamelessMagnetName(importReq.Magnet.InfoHash)
+	}
+	if importReq.CorrelationID == "" {
+		importReq.CorrelationID = logger.CorrelationID(ctx)
+	}
+	if held, err := s.holdForMaintenance(importReq); held {
+		return err
+	}
+	return s.addTorrent(ctx, importReq)
+}
+
+// addTorrent sends the torrent to the debrid and processes it
+func (s *Store) addTorrent(ctx context.Context, importReq *ImportRequest) error {
+	ctx = logger.WithCorrelationID(ctx, importReq.CorrelationID) // Kept by the queued imports retried later
+	_logger := s.importLogger(importReq, importReq.SelectedDebrid)
 	torrent := createTorrentFromMagnet(importReq)
 	debridTorrent, err := debridTypes.Process(ctx, s.debrid, importReq.SelectedDebrid, importReq.Magnet, importReq.Arr, importReq.Action, importReq.DownloadUncached)
 
@@ -25,10 +51,10 @@ func (s *Store) AddTorrent(ctx context.Context, importReq *ImportRequest) error
 			switch httpErr.Code {
 			case "too_many_active_downloads":
 				// Handle too much active downloads error
-				s.logger.Warn().Msgf("Too many active downloads for %s, adding to queue", importReq.Magnet.Name)
+				_logger.Warn().Msgf("Too many active downloads for %s, adding to queue", importReq.Magnet.Name)
 
 				if err := s.addToQueue(importReq); err != nil {
-					s.logger.Error().Err(err).Msgf("Failed to add %s to queue", importReq.Magnet.Name)
+					_logger.Error().Err(err).Msgf("Failed to add %s to queue", importReq.Magnet.Name)
 					return err
 				}
 				torrent.State = "queued"
@@ -41,6 +67,15 @@ func (s *Store) AddTorrent(ctx context.Context, importReq *ImportRequest) error
 			return err
 		}
 	}
+	if debridTorrent != nil {
+		debridTorrent.RequestedName = importReq.RequestedName
+		if err := s.checkAddedContent(torrent, debridTorrent, importReq); err != nil {
+			return err
+		}
+	}
+	if debridTorrent != nil && debridTorrent.Status != "downloaded" {
+		s.setUncachedSavePath(torrent, debridTorrent)
+	}
 	torrent = s.partialTorrentUpdate(torrent, debridTorrent)
 	s.torrents.AddOrUpdate(torrent)
 	go s.processFiles(torrent, debridTorrent, importReq) // We can send async for file processing not to delay the response
@@ -54,14 +89,17 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 		return
 	}
 
+	_logger := s.importLogger(importReq, debridTorrent.Debrid)
 	deb := s.debrid.Debrid(debridTorrent.Debrid)
 	client := deb.Client()
 	downloadingStatuses := client.GetDownloadingStatus()
 	_arr := importReq.Arr
 	backoff := time.NewTimer(s.refreshInterval)
 	defer backoff.Stop()
+	promotionInterval := deb.Config().GetCachedPromotionInterval()
+	lastPromotionCheck := time.Now()
 	for debridTorrent.Status != "downloaded" {
-		s.logger.Debug().Msgf("%s <- (%s) Download Progress: %.2f%%", debridTorrent.Debrid, debridTorrent.Name, debridTorrent.Progress)
+		_logger.Debug().Msgf("%s <- (%s) Download Progress: %.2f%%", debridTorrent.Debrid, debridTorrent.Name, debridTorrent.Progress)
 		dbT, err := client.CheckStatus(debridTorrent)
 		if err != nil {
 			if dbT != nil && dbT.Id != "" {
@@ -70,11 +108,9 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 					_ = client.DeleteTorrent(dbT.Id)
 				}()
 			}
-			s.logger.Error().Msgf("Error checking status: %v", err)
+			_logger.Error().Msgf("Error checking status: %v", err)
 			s.markTorrentAsFailed(torrent)
-			go func() {
-				_arr.Refresh()
-			}()
+			go s.arr.Rescan(_arr)
 			importReq.markAsFailed(err, torrent, debridTorrent)
 			return
 		}
@@ -86,6 +122,15 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 		if debridTorrent.Status == "downloaded" || !utils.Contains(downloadingStatuses, debridTorrent.Status) {
 			break
 		}
+		if promotionInterval > 0 && time.Since(lastPromotionCheck) >= promotionInterval {
+			lastPromotionCheck = time.Now()
+			if promoted := s.promoteIfCached(deb, debridTorrent); promoted != nil {
+				debridTorrent = promoted
+				torrent = s.partialTorrentUpdate(torrent, debridTorrent)
+				request.Notify("torrent_promoted", "success", torrent.discordContext())
+				break
+			}
+		}
 		select {
 		case <-backoff.C:
 			// Increase interval gradually, cap at max
@@ -95,7 +140,12 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 	}
 	var torrentSymlinkPath string
 	var err error
-	debridTorrent.Arr = _arr
+	debridTorrent.SetArr(_arr)
+	// The debrid doesn't report the names the torrent was added under, they can name its folder
+	debridTorrent.RequestedName = importReq.RequestedName
+	if debridTorrent.Magnet == nil {
+		debridTorrent.Magnet = importReq.Magnet
+	}
 
 	// Check if debrid supports webdav by checking cache
 	timer := time.Now()
@@ -104,10 +154,10 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 		s.markTorrentAsFailed(torrent)
 		go func() {
 			if deleteErr := client.DeleteTorrent(debridTorrent.Id); deleteErr != nil {
-				s.logger.Warn().Err(deleteErr).Msgf("Failed to delete torrent %s", debridTorrent.Id)
+				_logger.Warn().Err(deleteErr).Msgf("Failed to delete torrent %s", debridTorrent.Id)
 			}
 		}()
-		s.logger.Error().Err(err).Msgf("Error occured while processing torrent %s", debridTorrent.Name)
+		_logger.Error().Err(err).Msgf("Error occured while processing torrent %s", debridTorrent.Name)
 		importReq.markAsFailed(err, torrent, debridTorrent)
 		return
 	}
@@ -115,26 +165,38 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 	onSuccess := func(torrentSymlinkPath string) {
 		torrent.TorrentPath = torrentSymlinkPath
 		s.updateTorrent(torrent, debridTorrent)
-		s.logger.Info().Msgf("Adding %s took %s", debridTorrent.Name, time.Since(timer))
+		_logger.Info().Msgf("Adding %s took %s", debridTorrent.Name, time.Since(timer))
 
 		go importReq.markAsCompleted(torrent, debridTorrent) // Mark the import request as completed, send callback if needed
-		go func() {
-			if err := request.SendDiscordMessage("download_complete", "success", torrent.discordContext()); err != nil {
-				s.logger.Error().Msgf("Error sending discord message: %v", err)
-			}
-		}()
-		go func() {
-			_arr.Refresh()
-		}()
+		request.Notify("download_complete", "success", torrent.discordContext())
+		go s.arr.Rescan(_arr)
+	}
+
+	s.ensureTorrentName(client, debridTorrent)
+	torrent.Name = debridTorrent.Name
+
+	if err := s.checkFileSizes(client, debridTorrent); err != nil {
+		onFailed(err)
+		return
+	}
+
+	if !importReq.contentChecked && !s.checkPlayableContent(torrent, debridTorrent, deb.Config().UnpackRar) {
+		onFailed(fmt.Errorf("no playable video file in %s", debridTorrent.Name))
+		return
+	}
+
+	if err := s.waitForReadiness(torrent, debridTorrent, client, deb.Config()); err != nil {
+		onFailed(err)
+		return
 	}
 
 	switch importReq.Action {
 	case "symlink":
 		// Symlink action, we will create a symlink to the torrent
-		s.logger.Debug().Msgf("Post-Download Action: Symlink")
+		_logger.Debug().Msgf("Post-Download Action: Symlink")
 		cache := deb.Cache()
 		if cache != nil {
-			s.logger.Info().Msgf("Using internal webdav for %s", debridTorrent.Debrid)
+			_logger.Info().Msgf("Using internal webdav for %s", debridTorrent.Debrid)
 			// Use webdav to download the file
 			if err := cache.Add(debridTorrent); err != nil {
 				onFailed(err)
@@ -161,7 +223,7 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 	case "download":
 		// Download action, we will download the torrent to the specified folder
 		// Generate download links
-		s.logger.Debug().Msgf("Post-Download Action: Download")
+		_logger.Debug().Msgf("Post-Download Action: Download")
 		if err := client.GetFileDownloadLinks(debridTorrent); err != nil {
 			onFailed(err)
 			return
@@ -178,7 +240,7 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 		}
 		onSuccess(torrentSymlinkPath)
 	case "none":
-		s.logger.Debug().Msgf("Post-Download Action: None")
+		_logger.Debug().Msgf("Post-Download Action: None")
 		// No action, just update the torrent and mark it as completed
 		onSuccess(torrent.TorrentPath)
 	default:
@@ -186,14 +248,135 @@ func (s *Store) processFiles(torrent *Torrent, debridTorrent *types.Torrent, imp
 	}
 }
 
+// importLogger returns the store logger with the correlation ID, category and debrid of the import as attributes
+func (s *Store) importLogger(importReq *ImportRequest, debridName string) zerolog.Logger {
+	with := s.logger.With()
+	if importReq.CorrelationID != "" {
+		with = with.Str("correlation_id", importReq.CorrelationID)
+	}
+	if importReq.Arr != nil {
+		with = with.Str("category", importReq.Arr.Name)
+	}
+	if debridName != "" {
+		with = with.Str("debrid", debridName)
+	}
+	return with.Logger()
+}
+
+// ensureTorrentName names a torrent the debrid reported no name for, so its symlink and WebDav folders aren't empty-named.
+// With the metadata policy, the debrid is asked again for a while before falling back to the infohash.
+func (s *Store) ensureTorrentName(client types.Client, debridTorrent *types.Torrent) {
+	cfg := config.Get()
+	placeholder := cfg.NamelessMagnetName(debridTorrent.InfoHash)
+	named := func() bool {
+		return debridTorrent.Name != "" && debridTorrent.Name != placeholder
+	}
+	if cfg.NamelessMagnetPolicy == config.NamelessMagnetMetadata {
+		for i := 0; i < namelessMetadataAttempts && !named(); i++ {
+			time.Sleep(namelessMetadataInterval)
+			if err := client.UpdateTorrent(debridTorrent); err != nil {
+				s.logger.Debug().Err(err).Msgf("Failed to fetch the metadata of %s", debridTorrent.InfoHash)
+			}
+		}
+	}
+	if debridTorrent.Name == "" {
+		s.logger.Warn().Msgf("No name reported for %s, using %s", debridTorrent.InfoHash, placeholder)
+		debridTorrent.Name = placeholder
+	}
+	debridTorrent.Filename = cmp.Or(debridTorrent.Filename, debridTorrent.Name)
+	debridTorrent.OriginalFilename = cmp.Or(debridTorrent.OriginalFilename, debridTorrent.Name)
+}
+
+// readinessPollInterval is how often a slow-start debrid is asked for the status of the torrent while waiting for readiness
+var readinessPollInterval = 5 * time.Second
+
+// waitForReadiness waits for the readiness delay of the debrid then, if a readiness timeout is set, polls the status
+// of the torrent until the debrid reports it downloaded and probes a link once. Only the probe generates a link, polling
+// doesn't use up the unlock quota. The torrent is shown as checkingDL meanwhile.
+func (s *Store) waitForReadiness(torrent *Torrent, debridTorrent *types.Torrent, client types.Client, dc config.Debrid) error {
+	delay, timeout := dc.GetReadinessDelay(), dc.GetReadinessTimeout()
+	if delay == 0 && timeout == 0 {
+		return nil
+	}
+	s.logger.Info().Msgf("Waiting for provider readiness of %s", debridTorrent.Name)
+	// Set under the storage lock, a save of the torrents may be serializing it
+	s.torrents.Move(torrent, func(t *Torrent) { t.State = "checkingDL" })
+	defer s.torrents.Move(torrent, func(t *Torrent) { t.State = "downloading" })
+
+	time.Sleep(delay)
+	if timeout == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for debridTorrent.Status != "downloaded" {
+		if time.Now().Add(readinessPollInterval).After(deadline) {
+			return fmt.Errorf("%s not ready after %s: status is %s", debridTorrent.Name, timeout, debridTorrent.Status)
+		}
+		time.Sleep(readinessPollInterval)
+		if _, err := client.CheckStatus(debridTorrent); err != nil {
+			s.logger.Debug().Err(err).Msgf("%s not ready yet", debridTorrent.Name)
+		}
+	}
+	if err := debridTypes.ProbeLink(client, debridTorrent); err != nil {
+		return fmt.Errorf("%s not ready: %w", debridTorrent.Name, err)
+	}
+	return nil
+}
+
+// promoteIfCached re-adds an uncached download that the debrid now reports as cached.
+// It returns the cached torrent, replacing the uncached one, or nil if the torrent is still uncached.
+func (s *Store) promoteIfCached(deb *debridTypes.Debrid, debridTorrent *types.Torrent) *types.Torrent {
+	if debridTorrent.Magnet == nil {
+		return nil
+	}
+	available, err := deb.IsAvailable([]string{debridTorrent.InfoHash})
+	if err != nil {
+		s.logger.Debug().Err(err).Msgf("Failed to check if %s became cached", debridTorrent.Name)
+		return nil
+	}
+	if !available[strings.ToLower(debridTorrent.InfoHash)] {
+		return nil
+	}
+	client := deb.Client()
+	cached, err := client.SubmitMagnet(&types.Torrent{
+		InfoHash: debridTorrent.InfoHash,
+		Magnet:   debridTorrent.Magnet,
+		Name:     debridTorrent.Name,
+		Arr:      debridTorrent.Arr,
+		Size:     debridTorrent.Size,
+		Files:    make(map[string]types.File),
+	})
+	if err != nil || cached == nil || cached.Id == "" {
+		s.logger.Warn().Err(err).Msgf("Failed to re-add %s now that it is cached", debridTorrent.Name)
+		return nil
+	}
+	if cached.Id == debridTorrent.Id {
+		// The debrid kept the existing download, it completes on its own
+		return nil
+	}
+	cached.Arr = debridTorrent.Arr
+	cached, err = client.CheckStatus(cached)
+	if err != nil || cached == nil || cached.Status != "downloaded" {
+		if cached != nil && cached.Id != "" {
+			go func(id string) {
+				_ = client.DeleteTorrent(id)
+			}(cached.Id)
+		}
+		return nil
+	}
+	go func(id string) {
+		if err := client.DeleteTorrent(id); err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to delete uncached download %s", id)
+		}
+	}(debridTorrent.Id)
+	s.logger.Info().Str("old_id", debridTorrent.Id).Str("id", cached.Id).Msgf("%s became cached on %s, cancelled the uncached download", debridTorrent.Name, debridTorrent.Debrid)
+	return cached
+}
+
 func (s *Store) markTorrentAsFailed(t *Torrent) *Torrent {
 	t.State = "error"
 	s.torrents.AddOrUpdate(t)
-	go func() {
-		if err := request.SendDiscordMessage("download_failed", "error", t.discordContext()); err != nil {
-			s.logger.Error().Msgf("Error sending discord message: %v", err)
-		}
-	}()
+	request.Notify("download_failed", "error", t.discordContext())
 	return t
 }
 
@@ -230,10 +413,13 @@ func (s *Store) partialTorrentUpdate(t *Torrent, debridTorrent *types.Torrent) *
 		})
 	}
 	t.DebridID = debridTorrent.Id
-	t.Name = debridTorrent.Name
+	t.Name = cmp.Or(debridTorrent.Name, t.Name) // The debrid may not know the name yet
 	t.AddedOn = addedOn.Unix()
 	t.Files = files
 	t.Debrid = debridTorrent.Debrid
+	if dc, ok := config.Get().GetDebrid(debridTorrent.Debrid); ok {
+		t.LazyLoaded = dc.IsLargeTorrent(len(debridTorrent.Files))
+	}
 	t.Size = totalSize
 	t.Completed = sizeCompleted
 	t.NumSeeds = debridTorrent.Seeders
@@ -288,3 +474,76 @@ func (s *Store) updateTorrent(t *Torrent, debridTorrent *types.Torrent) *Torrent
 		}
 	}
 }
+
+// checkAddedContent applies the NoVideoPolicy when the torrent is added, if the debrid already reports its files. A
+// rejected torrent is removed from the debrid and the add fails, so the arr knows at once. Without a file list yet,
+// the policy is applied once the download completes, before the torrent is processed.
+func (s *Store) checkAddedContent(torrent *Torrent, debridTorrent *types.Torrent, importReq *ImportRequest) error {
+	deb := s.debrid.Debrid(debridTorrent.Debrid)
+	if deb == nil || len(debridTorrent.GetFiles()) == 0 {
+		return nil
+	}
+	importReq.contentChecked = true
+	if s.checkPlayableContent(torrent, debridTorrent, deb.Config().UnpackRar) {
+		return nil
+	}
+	go func() {
+		if err := deb.Client().DeleteTorrent(debridTorrent.Id); err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to delete torrent %s", debridTorrent.Id)
+		}
+	}()
+	return fmt.Errorf("no playable video file in %s", debridTorrent.Name)
+}
+
+// checkPlayableContent applies the NoVideoPolicy to a torrent without a playable video file.
+// It returns false if the torrent should be rejected.
+func (s *Store) checkPlayableContent(torrent *Torrent, debridTorrent *types.Torrent, unpackRar bool) bool {
+	policy := config.Get().NoVideoPolicy
+	if policy != config.NoVideoFlag && policy != config.NoVideoReject {
+		return true
+	}
+	if debridTorrent.HasPlayableContent(unpackRar) {
+		return true
+	}
+	if policy == config.NoVideoReject {
+		return false
+	}
+	s.logger.Warn().Msgf("No playable video file in %s", debridTorrent.Name)
+	request.Notify("no_video", "warning", torrent.discordContext())
+	return true
+}
+
+// checkFileSizes applies the pessimistic UnknownSizePolicy to a downloaded torrent. The debrid is asked again for the
+// sizes it hadn't reported, then the files still of an unknown size or outside the size limits are dropped.
+// It fails if no file is left.
+func (s *Store) checkFileSizes(client types.Client, debridTorrent *types.Torrent) error {
+	cfg := config.Get()
+	if cfg.UnknownSizePolicy != config.UnknownSizePessimistic {
+		return nil
+	}
+	minSize, maxSize := cfg.FileSizeLimits(debridTorrent.Debrid, debridTorrent.ArrName())
+	if minSize == 0 && maxSize == 0 {
+		return nil
+	}
+	for _, f := range debridTorrent.GetFiles() {
+		if f.Size == 0 {
+			if err := client.UpdateTorrent(debridTorrent); err != nil {
+				return fmt.Errorf("failed to get the file sizes of %s: %w", debridTorrent.Name, err)
+			}
+			break
+		}
+	}
+	for name, f := range debridTorrent.Files {
+		if f.Deleted {
+			continue
+		}
+		if f.Size == 0 || !cfg.IsSizeAllowedFor(f.Size, debridTorrent.Debrid, debridTorrent.ArrName()) {
+			s.logger.Info().Int64("size", f.Size).Msgf("Dropping %s from %s, its size is unknown or outside the size limits", name, debridTorrent.Name)
+			delete(debridTorrent.Files, name)
+		}
+	}
+	if len(debridTorrent.GetFiles()) == 0 {
+		return fmt.Errorf("no file of %s within the size limits", debridTorrent.Name)
+	}
+	return nil
+}
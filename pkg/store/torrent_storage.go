@@ -3,6 +3,8 @@ package store
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"os"
 	"sort"
 	"sync"
@@ -19,6 +21,7 @@ type TorrentStorage struct {
 	torrents Torrents
 	mu       sync.RWMutex
 	filename string // Added to store the filename for persistence
+	logger   zerolog.Logger
 }
 
 func loadTorrentsFromJSON(filename string) (Torrents, error) {
@@ -33,7 +36,7 @@ func loadTorrentsFromJSON(filename string) (Torrents, error) {
 	return torrents, nil
 }
 
-func newTorrentStorage(filename string) *TorrentStorage {
+func newTorrentStorage(filename string, logger zerolog.Logger) *TorrentStorage {
 	// Open the JSON file and read the data
 	torrents, err := loadTorrentsFromJSON(filename)
 	if err != nil {
@@ -43,6 +46,7 @@ func newTorrentStorage(filename string) *TorrentStorage {
 	return &TorrentStorage{
 		torrents: torrents,
 		filename: filename,
+		logger:   logger,
 	}
 }
 
@@ -164,6 +168,22 @@ func (ts *TorrentStorage) Update(torrent *Torrent) {
 	}()
 }
 
+// Move changes the category of a torrent with update, under the storage lock so it's never saved half-updated,
+// and re-keys it
+func (ts *TorrentStorage) Move(torrent *Torrent, update func(t *Torrent)) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	oldCategory := torrent.Category
+	update(torrent)
+	delete(ts.torrents, keyPair(torrent.Hash, oldCategory))
+	ts.torrents[keyPair(torrent.Hash, torrent.Category)] = torrent
+	go func() {
+		if err := ts.saveToFile(); err != nil {
+			ts.logger.Error().Err(err).Str("torrent", torrent.Name).Msg("Failed to save torrents after a move")
+		}
+	}()
+}
+
 func (ts *TorrentStorage) Delete(hash, category string, removeFromDebrid bool) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
@@ -192,10 +212,7 @@ func (ts *TorrentStorage) Delete(hash, category string, removeFromDebrid bool) {
 	}
 
 	if removeFromDebrid && torrent.DebridID != "" && torrent.Debrid != "" {
-		dbClient := st.debrid.Client(torrent.Debrid)
-		if dbClient != nil {
-			_ = dbClient.DeleteTorrent(torrent.DebridID)
-		}
+		go st.removeFromDebrid(torrent.Debrid, torrent.DebridID)
 	}
 
 	delete(ts.torrents, key)
@@ -253,18 +270,9 @@ func (ts *TorrentStorage) DeleteMultiple(hashes []string, removeFromDebrid bool)
 		}
 	}()
 
-	clients := st.debrid.Clients()
-
 	go func() {
 		for id, debrid := range toDelete {
-			dbClient, ok := clients[debrid]
-			if !ok {
-				continue
-			}
-			err := dbClient.DeleteTorrent(id)
-			if err != nil {
-				fmt.Println(err)
-			}
+			st.removeFromDebrid(debrid, id)
 		}
 	}()
 }
@@ -281,7 +289,7 @@ func (ts *TorrentStorage) saveToFile() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(ts.filename, data, 0644)
+	return config.Get().WriteFile(ts.filename, data)
 }
 
 func (ts *TorrentStorage) Reset() {
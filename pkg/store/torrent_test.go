@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient is a debrid client that reports a torrent downloaded after readyAfter status checks
+type fakeClient struct {
+	types.Client
+	mu         sync.Mutex
+	readyAfter int
+	checks     int
+	links      int
+	noLinks    bool
+	sizes      map[string]int64 // File sizes reported by UpdateTorrent
+}
+
+func (c *fakeClient) CheckStatus(tr *types.Torrent) (*types.Torrent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks++
+	if c.checks >= c.readyAfter {
+		tr.Status = "downloaded"
+	}
+	return tr, nil
+}
+
+func (c *fakeClient) GetDownloadLink(tr *types.Torrent, file *types.File) (*types.DownloadLink, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.links++
+	if c.noLinks {
+		return nil, fmt.Errorf("link generation unavailable")
+	}
+	return &types.DownloadLink{Filename: file.Name, DownloadLink: "https://example.com/" + file.Name}, nil
+}
+
+func (c *fakeClient) UpdateTorrent(tr *types.Torrent) error {
+	for name, size := range c.sizes {
+		if f, ok := tr.Files[name]; ok {
+			f.Size = size
+			tr.Files[name] = f
+		}
+	}
+	return nil
+}
+
+// setTestConfig loads a default config from a temp directory
+func setTestConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+}
+
+// newTestStore returns a store with its torrents saved in a temp directory
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	setTestConfig(t)
+	return &Store{
+		torrents: newTorrentStorage(filepath.Join(t.TempDir(), "torrents.json"), zerolog.Nop()),
+		logger:   zerolog.Nop(),
+	}
+}
+
+func TestWaitForReadiness(t *testing.T) {
+	interval := readinessPollInterval
+	readinessPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { readinessPollInterval = interval })
+
+	tests := []struct {
+		name       string
+		delay      string
+		timeout    string
+		readyAfter int
+		noLinks    bool
+		wantErr    bool
+		wantChecks int
+		wantLinks  int
+	}{
+		{name: "disabled"},
+		{name: "delay only", delay: "10ms"},
+		{name: "ready", timeout: "1s", readyAfter: 3, wantChecks: 3, wantLinks: 1},
+		{name: "never ready", timeout: "50ms", readyAfter: 1000, wantErr: true},
+		{name: "no link", timeout: "1s", readyAfter: 1, noLinks: true, wantErr: true, wantChecks: 1, wantLinks: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			client := &fakeClient{readyAfter: tt.readyAfter, noLinks: tt.noLinks}
+			torrent := &Torrent{Hash: "hash", Category: "movies", State: "downloading"}
+			debridTorrent := &types.Torrent{
+				Name:   "Movie",
+				Status: "downloading",
+				Files:  map[string]types.File{"movie.mkv": {Name: "movie.mkv", Size: 1 << 30}},
+			}
+			dc := config.Debrid{Name: "realdebrid", ReadinessDelay: tt.delay, ReadinessTimeout: tt.timeout}
+
+			err := s.waitForReadiness(torrent, debridTorrent, client, dc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("waitForReadiness() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && tt.wantChecks == 0 {
+				// Polled until the timeout, the link is never probed
+				if client.links != 0 {
+					t.Errorf("%d links generated before the torrent was downloaded, want 0", client.links)
+				}
+				return
+			}
+			if client.checks != tt.wantChecks || client.links != tt.wantLinks {
+				t.Errorf("%d status checks and %d links, want %d and %d", client.checks, client.links, tt.wantChecks, tt.wantLinks)
+			}
+			if torrent.State != "downloading" {
+				t.Errorf("state = %s after waiting, want downloading", torrent.State)
+			}
+		})
+	}
+}
+
+func TestCheckFileSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  config.UnknownSizePolicy
+		sizes   map[string]int64 // Reported when asked again
+		want    []string
+		wantErr bool
+	}{
+		{name: "optimistic", policy: config.UnknownSizeOptimistic, want: []string{"movie.mkv", "sample.mkv", "unknown.mkv"}},
+		{name: "sizes reported", policy: config.UnknownSizePessimistic, sizes: map[string]int64{"unknown.mkv": 1 << 30}, want: []string{"movie.mkv", "unknown.mkv"}},
+		{name: "sizes still unknown", policy: config.UnknownSizePessimistic, want: []string{"movie.mkv"}},
+		{name: "none left", policy: config.UnknownSizePessimistic, sizes: map[string]int64{"movie.mkv": 1 << 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			cfg := config.Get()
+			cfg.UnknownSizePolicy = tt.policy
+			cfg.MinFileSize = "10MB"
+			debridTorrent := &types.Torrent{
+				Name:   "Movie",
+				Debrid: "realdebrid",
+				Files: map[string]types.File{
+					"movie.mkv":   {Name: "movie.mkv", Size: 1 << 30},
+					"sample.mkv":  {Name: "sample.mkv", Size: 1 << 10},
+					"unknown.mkv": {Name: "unknown.mkv"},
+				},
+			}
+			err := s.checkFileSizes(&fakeClient{sizes: tt.sizes}, debridTorrent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkFileSizes() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var names []string
+			for _, f := range debridTorrent.GetFiles() {
+				names = append(names, f.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("files = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPlayableContent(t *testing.T) {
+	noVideo := []string{"movie.nfo", "sample.mkv", "cover.jpg"}
+	withRar := []string{"movie.nfo", "movie.rar", "movie.r00"}
+	withVideo := []string{"movie.nfo", "movie.mkv"}
+	tests := []struct {
+		name      string
+		policy    config.NoVideoPolicy
+		files     []string
+		unpackRar bool
+		want      bool
+	}{
+		{"allow without video", config.NoVideoAllow, noVideo, false, true},
+		{"flag without video", config.NoVideoFlag, noVideo, false, true},
+		{"reject without video", config.NoVideoReject, noVideo, false, false},
+		{"reject with a RAR not unpacked", config.NoVideoReject, withRar, false, false},
+		{"reject with a RAR unpacked", config.NoVideoReject, withRar, true, true},
+		{"reject with video", config.NoVideoReject, withVideo, false, true},
+	}
+	for _, tt := range tests {
+		s := newTestStore(t)
+		config.Get().NoVideoPolicy = tt.policy
+		debridTorrent := &types.Torrent{Name: "Movie", Files: make(map[string]types.File, len(tt.files))}
+		for _, name := range tt.files {
+			debridTorrent.Files[name] = types.File{Name: name, Size: 1 << 20}
+		}
+		torrent := &Torrent{Hash: "hash", Name: "Movie", Category: "movies"}
+		if got := s.checkPlayableContent(torrent, debridTorrent, tt.unpackRar); got != tt.want {
+			t.Errorf("%s: checkPlayableContent() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -22,6 +22,7 @@ type Torrent struct {
 	Debrid      string  `json:"debrid"`
 	TorrentPath string  `json:"-"`
 	Files       []*File `json:"files,omitempty"`
+	LazyLoaded  bool    `json:"lazy_loaded,omitempty"` // Large torrent, its download links are generated as its files are opened
 
 	AddedOn           int64   `json:"added_on,omitempty"`
 	AmountLeft        int64   `json:"amount_left"`
@@ -1,8 +1,10 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sirrobot01/decypharr/pkg/store"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -13,6 +15,9 @@ import (
 	"github.com/sirrobot01/decypharr/internal/request"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/arr"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
+	debridStore "github.com/sirrobot01/decypharr/pkg/debrid/store"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
 	"github.com/sirrobot01/decypharr/pkg/version"
 )
 
@@ -33,12 +38,18 @@ func (wb *Web) handleAddContent(w http.ResponseWriter, r *http.Request) {
 	errs := make([]string, 0)
 
 	arrName := r.FormValue("arr")
+	if arrName != "" {
+		if err := store.ValidateCategory(arrName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	action := r.FormValue("action")
 	debridName := r.FormValue("debrid")
 	callbackUrl := r.FormValue("callbackUrl")
 	downloadFolder := r.FormValue("downloadFolder")
 	if downloadFolder == "" {
-		downloadFolder = config.Get().QBitTorrent.DownloadFolder
+		downloadFolder = config.Get().QBitTorrent.CategoryDownloadFolder(arrName)
 	}
 
 	downloadUncached := r.FormValue("downloadUncached") == "true"
@@ -153,10 +164,155 @@ func (wb *Web) handleGetVersion(w http.ResponseWriter, r *http.Request) {
 	request.JSONResponse(w, v, http.StatusOK)
 }
 
+func (wb *Web) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	store.Get().SetMaintenance(req.Enabled)
+	request.JSONResponse(w, map[string]bool{"maintenance": req.Enabled}, http.StatusOK)
+}
+
+func (wb *Web) handleCompactTorrents(w http.ResponseWriter, r *http.Request) {
+	result, err := store.Get().CompactTorrents()
+	if err != nil {
+		http.Error(w, "Error compacting torrents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	request.JSONResponse(w, result, http.StatusOK)
+}
+
+// debridHealth is the state of a debrid in the health details
+type debridHealth struct {
+	Name           string            `json:"name"`
+	Role           config.DebridRole `json:"role"`
+	PremiumExpired bool              `json:"premium_expired"`
+	Conservative   bool              `json:"conservative"`
+	AuthState      string            `json:"auth_state,omitempty"` // invalid_key or forbidden
+	TrafficUsed    int64             `json:"traffic_used"`
+	TrafficBudget  int64             `json:"traffic_budget,omitempty"`
+	OverBudget     bool              `json:"over_budget"`
+	Health         debrid.Health     `json:"health"`
+
+	UnlocksUsed     int  `json:"unlocks_used"` // Download links generated today, UTC
+	UnlockQuota     int  `json:"unlock_quota,omitempty"`
+	OverUnlockQuota bool `json:"over_unlock_quota"`
+
+	CircuitBreaker types.BreakerStatus `json:"circuit_breaker"`
+
+	DownloadKeys []types.AccountStatus `json:"download_keys,omitempty"` // Download API keys, in round-robin
+
+	RateLimit *request.RateLimitHeadroom `json:"rate_limit,omitempty"` // Quota reported by the provider's rate-limit headers
+}
+
+// arrHealth is the state of an arr in the health details
+type arrHealth struct {
+	Name    string `json:"name"`
+	Failing bool   `json:"failing"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthDetails is the state of Decypharr, its debrids and its arrs, only served to authenticated users
+type healthDetails struct {
+	Status         string         `json:"status"`
+	Maintenance    bool           `json:"maintenance"`
+	StandbyEngaged bool           `json:"standby_engaged"`
+	Debrids        []debridHealth `json:"debrids"`
+	Arrs           []arrHealth    `json:"arrs"`
+}
+
+// handleGetHealth is the public liveness check, it only reports whether Decypharr is degraded
+func (wb *Web) handleGetHealth(w http.ResponseWriter, r *http.Request) {
+	health := getHealthDetails()
+	request.JSONResponse(w, map[string]any{
+		"status":      health.Status,
+		"maintenance": health.Maintenance,
+	}, http.StatusOK)
+}
+
+func (wb *Web) handleGetHealthDetails(w http.ResponseWriter, r *http.Request) {
+	request.JSONResponse(w, getHealthDetails(), http.StatusOK)
+}
+
+func getHealthDetails() healthDetails {
+	health := healthDetails{
+		Status:      "ok",
+		Maintenance: store.Get().InMaintenance(),
+		Debrids:     make([]debridHealth, 0),
+		Arrs:        make([]arrHealth, 0),
+	}
+
+	if debrids := store.Get().Debrid(); debrids != nil {
+		health.StandbyEngaged = debrids.StandbyEngaged()
+		for name, db := range debrids.Debrids() {
+			used, budget := db.TrafficUsage()
+			unlocks, quota := db.UnlockUsage()
+			var rateLimit *request.RateLimitHeadroom
+			if headroom, ok := db.Client().RateLimitHeadroom(); ok {
+				rateLimit = &headroom
+			}
+			health.Debrids = append(health.Debrids, debridHealth{
+				Name:           name,
+				Role:           db.Config().Role,
+				PremiumExpired: db.IsPremiumExpired(),
+				Conservative:   db.Client().InConservativeMode(),
+				AuthState:      db.Client().AuthState(),
+				TrafficUsed:    used,
+				TrafficBudget:  budget,
+				OverBudget:     db.IsOverBudget(),
+				Health:         db.Health(),
+				CircuitBreaker: db.CircuitBreaker(),
+				RateLimit:      rateLimit,
+
+				UnlocksUsed:     unlocks,
+				UnlockQuota:     quota,
+				OverUnlockQuota: db.IsOverUnlockQuota(),
+
+				DownloadKeys: db.DownloadKeys(),
+			})
+			if db.IsPremiumExpired() || db.Client().InConservativeMode() || db.Client().AuthState() != "" || db.IsOverBudget() || db.IsOverUnlockQuota() || db.Health().Status != debrid.HealthOK || db.CircuitBreaker().State != types.BreakerClosed {
+				health.Status = "degraded"
+			}
+		}
+		if health.StandbyEngaged {
+			health.Status = "degraded"
+		}
+	}
+
+	if arrs := store.Get().Arr(); arrs != nil {
+		for _, a := range arrs.GetAll() {
+			failing, reason := a.Failing()
+			health.Arrs = append(health.Arrs, arrHealth{
+				Name:    a.Name,
+				Failing: failing,
+				Error:   reason,
+			})
+			if failing {
+				health.Status = "degraded"
+			}
+		}
+	}
+	return health
+}
+
 func (wb *Web) handleGetTorrents(w http.ResponseWriter, r *http.Request) {
 	request.JSONResponse(w, wb.torrents.GetAllSorted("", "", nil, "added_on", false), http.StatusOK)
 }
 
+// handleGetTorrent returns the torrents of a hash, one per category, with the debrid holding each and its ID on the debrid
+func (wb *Web) handleGetTorrent(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(chi.URLParam(r, "hash"))
+	torrents := wb.torrents.GetAll(r.URL.Query().Get("category"), "", []string{hash})
+	if len(torrents) == 0 {
+		http.Error(w, "Torrent not found", http.StatusNotFound)
+		return
+	}
+	request.JSONResponse(w, torrents, http.StatusOK)
+}
+
 func (wb *Web) handleDeleteTorrent(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 	category := chi.URLParam(r, "category")
@@ -169,6 +325,29 @@ func (wb *Web) handleDeleteTorrent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (wb *Web) handleRecategorizeTorrent(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	var req struct {
+		Category string `json:"category"`
+		Rescan   bool   `json:"rescan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Category == "" {
+		http.Error(w, "No category provided", http.StatusBadRequest)
+		return
+	}
+	torrent, err := store.Get().RecategorizeTorrent(hash, req.Category, req.Rescan)
+	if err != nil {
+		wb.logger.Error().Err(err).Msgf("Failed to recategorize torrent %s", hash)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.JSONResponse(w, torrent, http.StatusOK)
+}
+
 func (wb *Web) handleDeleteTorrents(w http.ResponseWriter, r *http.Request) {
 	hashesStr := r.URL.Query().Get("hashes")
 	removeFromDebrid := r.URL.Query().Get("removeFromDebrid") == "true"
@@ -181,10 +360,25 @@ func (wb *Web) handleDeleteTorrents(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (wb *Web) handleGetDuplicateFiles(w http.ResponseWriter, r *http.Request) {
+	duplicates := make(map[string][]debridStore.DuplicateFile)
+	if debrids := store.Get().Debrid(); debrids != nil {
+		for name, cache := range debrids.Caches() {
+			duplicates[name] = cache.GetDuplicateFiles()
+		}
+	}
+	request.JSONResponse(w, duplicates, http.StatusOK)
+}
+
+// handleGetConfig returns the config with its secrets redacted, see config.Config.Redacted
 func (wb *Web) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	// Merge config arrs, with arr Storage
 	unique := map[string]config.Arr{}
-	cfg := config.Get()
+	cfg, err := config.Get().Redacted()
+	if err != nil {
+		http.Error(w, "Error reading config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	arrStorage := store.Get().Arr()
 
 	// Add existing Arrs from storage
@@ -194,7 +388,7 @@ func (wb *Web) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 			unique[a.Name] = config.Arr{
 				Name:             a.Name,
 				Host:             a.Host,
-				Token:            a.Token,
+				Token:            config.RedactedValue,
 				Cleanup:          a.Cleanup,
 				SkipRepair:       a.SkipRepair,
 				DownloadUncached: a.DownloadUncached,
@@ -217,7 +411,62 @@ func (wb *Web) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	request.JSONResponse(w, cfg, http.StatusOK)
 }
 
+// handlePatchConfig applies a partial config, see config.Patch. The services are restarted if the config changed.
+func (wb *Web) handlePatchConfig(w http.ResponseWriter, r *http.Request) {
+	if config.Get().IsReadOnly() {
+		http.Error(w, "Config file is read-only, changes are disabled", http.StatusForbidden)
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	next, changed, err := config.Patch(patch, func(current, next *config.Config) error {
+		return debrid.ChangeAPIKeys(r.Context(), current.Debrids, next.Debrids)
+	})
+	var patchErr *config.PatchError
+	if errors.As(err, &patchErr) {
+		messages := make([]string, 0, len(patchErr.Errors))
+		for _, e := range patchErr.Errors {
+			messages = append(messages, e.Error())
+		}
+		request.JSONResponse(w, map[string][]string{"errors": messages}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redacted, err := next.Redacted()
+	if err != nil {
+		http.Error(w, "Error reading config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	if len(changed) > 0 {
+		wb.logger.Info().Strs("changed", changed).Msg("Config patched")
+		if restartFunc != nil {
+			go func() {
+				// Small delay to ensure the response is sent
+				time.Sleep(500 * time.Millisecond)
+				restartFunc()
+			}()
+		}
+	}
+	request.JSONResponse(w, map[string]any{"changed": changed, "config": redacted}, http.StatusOK)
+}
+
 func (wb *Web) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	if config.Get().IsReadOnly() {
+		http.Error(w, "Config file is read-only, changes are disabled", http.StatusForbidden)
+		return
+	}
+
 	// Decode the JSON body
 	var updatedConfig config.Config
 	if err := json.NewDecoder(r.Body).Decode(&updatedConfig); err != nil {
@@ -226,38 +475,15 @@ func (wb *Web) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the current configuration
-	currentConfig := config.Get()
-
-	// Update fields that can be changed
-	currentConfig.LogLevel = updatedConfig.LogLevel
-	currentConfig.MinFileSize = updatedConfig.MinFileSize
-	currentConfig.MaxFileSize = updatedConfig.MaxFileSize
-	currentConfig.RemoveStalledAfter = updatedConfig.RemoveStalledAfter
-	currentConfig.AllowedExt = updatedConfig.AllowedExt
-	currentConfig.DiscordWebhook = updatedConfig.DiscordWebhook
-
-	// Should this be added?
-	currentConfig.URLBase = updatedConfig.URLBase
-	currentConfig.BindAddress = updatedConfig.BindAddress
-	currentConfig.Port = updatedConfig.Port
-
-	// Update QBitTorrent config
-	currentConfig.QBitTorrent = updatedConfig.QBitTorrent
-
-	// Update Repair config
-	currentConfig.Repair = updatedConfig.Repair
-
-	// Update Debrids
-	if len(updatedConfig.Debrids) > 0 {
-		currentConfig.Debrids = updatedConfig.Debrids
-		// Clear legacy single debrid if using array
+	// Secrets left redacted by the UI keep their current value
+	config.Get().RestoreRedacted(&updatedConfig)
+	arrStorage := store.Get().Arr()
+	for i, a := range updatedConfig.Arrs {
+		if existing := arrStorage.Get(a.Name); existing != nil && a.Token == config.RedactedValue {
+			updatedConfig.Arrs[i].Token = existing.Token
+		}
 	}
 
-	// Update Arrs through the service
-	storage := store.Get()
-	arrStorage := storage.Arr()
-
 	newConfigArrs := make([]config.Arr, 0)
 	for _, a := range updatedConfig.Arrs {
 		if a.Name == "" || a.Host == "" || a.Token == "" {
@@ -266,13 +492,50 @@ func (wb *Web) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 		}
 		newConfigArrs = append(newConfigArrs, a)
 	}
-	currentConfig.Arrs = newConfigArrs
 
-	// Add config arr into the config
-	for _, a := range currentConfig.Arrs {
-		if a.Host == "" || a.Token == "" {
-			continue // Skip empty arrs
+	// Get the current configuration
+	currentConfig := config.Get()
+
+	if err := debrid.ChangeAPIKeys(r.Context(), currentConfig.Debrids, updatedConfig.Debrids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update fields that can be changed, a save running meanwhile waits for the update to be applied
+	err := currentConfig.Update(func(currentConfig *config.Config) {
+		currentConfig.LogLevel = updatedConfig.LogLevel
+		currentConfig.MinFileSize = updatedConfig.MinFileSize
+		currentConfig.MaxFileSize = updatedConfig.MaxFileSize
+		currentConfig.RemoveStalledAfter = updatedConfig.RemoveStalledAfter
+		currentConfig.AllowedExt = updatedConfig.AllowedExt
+		currentConfig.DiscordWebhook = updatedConfig.DiscordWebhook
+
+		// Should this be added?
+		currentConfig.URLBase = updatedConfig.URLBase
+		currentConfig.BindAddress = updatedConfig.BindAddress
+		currentConfig.Port = updatedConfig.Port
+
+		// Update QBitTorrent config, the UI doesn't edit the category settings
+		if updatedConfig.QBitTorrent.CategorySettings == nil {
+			updatedConfig.QBitTorrent.CategorySettings = currentConfig.QBitTorrent.CategorySettings
+		}
+		currentConfig.QBitTorrent = updatedConfig.QBitTorrent
+
+		// Update Repair config
+		currentConfig.Repair = updatedConfig.Repair
+
+		// Update Debrids
+		if len(updatedConfig.Debrids) > 0 {
+			currentConfig.Debrids = updatedConfig.Debrids
+			// Clear legacy single debrid if using array
 		}
+
+		currentConfig.Arrs = newConfigArrs
+	})
+
+	// Update Arrs through the service
+	// Add config arr into the config
+	for _, a := range newConfigArrs {
 		existingArr := arrStorage.Get(a.Name)
 		if existingArr != nil {
 			// Update existing Arr
@@ -291,7 +554,7 @@ func (wb *Web) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := currentConfig.Save(); err != nil {
+	if err != nil {
 		http.Error(w, "Error saving config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -313,6 +576,19 @@ func (wb *Web) handleGetRepairJobs(w http.ResponseWriter, r *http.Request) {
 	request.JSONResponse(w, _store.Repair().GetJobs(), http.StatusOK)
 }
 
+func (wb *Web) handleGetRepairJobReport(w http.ResponseWriter, r *http.Request) {
+	job := store.Get().Repair().GetJob(chi.URLParam(r, "id"))
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.DryRunReport == nil {
+		http.Error(w, "Job is not a dry run", http.StatusNotFound)
+		return
+	}
+	request.JSONResponse(w, job.DryRunReport, http.StatusOK)
+}
+
 func (wb *Web) handleProcessRepairJob(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/store"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The torrent API exposes the debrid holding each torrent and its ID there, behind the auth
+func TestGetTorrentDebridID(t *testing.T) {
+	dir, err := os.MkdirTemp("", "decypharr-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The torrents are saved in the background, the directory is removed without failing the test
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	store.Reset()
+	t.Cleanup(store.Reset)
+	torrents := store.Get().Torrents()
+	torrents.Add(&store.Torrent{Hash: "abcdef", Name: "Movie", Category: "radarr", Debrid: "realdebrid", DebridID: "RD123"})
+	torrents.Add(&store.Torrent{Hash: "abcdef", Name: "Movie", Category: "radarr4k", Debrid: "torbox", DebridID: "42"})
+
+	wb := &Web{torrents: torrents}
+	r := chi.NewRouter()
+	r.With(wb.authMiddleware).Get("/api/torrents/{hash}", wb.handleGetTorrent)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       map[string]string // Debrid ID by debrid
+	}{
+		{"all categories", "/api/torrents/ABCDEF", http.StatusOK, map[string]string{"realdebrid": "RD123", "torbox": "42"}},
+		{"one category", "/api/torrents/abcdef?category=radarr4k", http.StatusOK, map[string]string{"torbox": "42"}},
+		{"unknown hash", "/api/torrents/012345", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+		var got []map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ids := make(map[string]string, len(got))
+		for _, torrent := range got {
+			debrid, _ := torrent["debrid"].(string)
+			ids[debrid], _ = torrent["debrid_id"].(string)
+		}
+		if !maps.Equal(ids, tt.want) {
+			t.Errorf("%s: debrid IDs = %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+
+	// Without a session, the IDs aren't exposed
+	config.Get().UseAuth = true
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/torrents/abcdef", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("status without a session = %d, want a redirect", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"errors"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
@@ -22,10 +23,17 @@ func (wb *Web) verifyAuth(username, password string) bool {
 	return err == nil
 }
 
+// skipAuthHandler disables auth from the first-run setup, it is forbidden once credentials are set
 func (wb *Web) skipAuthHandler(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	cfg.UseAuth = false
-	if err := cfg.Save(); err != nil {
+	if !cfg.NeedsAuth() {
+		http.Error(w, "Credentials are already set", http.StatusForbidden)
+		return
+	}
+	err := cfg.Update(func(c *config.Config) {
+		c.UseAuth = false
+	})
+	if err != nil && !errors.Is(err, config.ErrReadOnly) {
 		wb.logger.Error().Err(err).Msg("failed to save config")
 		http.Error(w, "failed to save config", http.StatusInternalServerError)
 		return
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/sirrobot01/decypharr/internal/config"
 	"net/http"
+	"net/url"
 )
 
 func (wb *Web) setupMiddleware(next http.Handler) http.Handler {
@@ -11,7 +12,7 @@ func (wb *Web) setupMiddleware(next http.Handler) http.Handler {
 		cfg := config.Get()
 		needsAuth := cfg.NeedsSetup()
 		if needsAuth != nil && r.URL.Path != "/config" && r.URL.Path != "/api/config" {
-			http.Redirect(w, r, fmt.Sprintf("/config?inco=%s", needsAuth.Error()), http.StatusSeeOther)
+			http.Redirect(w, r, fmt.Sprintf("/config?inco=%s", url.QueryEscape(needsAuth.Error())), http.StatusSeeOther)
 			return
 		}
 
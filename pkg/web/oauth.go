@@ -0,0 +1,74 @@
+package web
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/request"
+	"github.com/sirrobot01/decypharr/pkg/debrid"
+	"net/http"
+	"time"
+)
+
+// oauthLoginTimeout bounds a device flow login started from the UI, the provider expires its codes before that
+const oauthLoginTimeout = 30 * time.Minute
+
+// handleOAuthLogin starts the OAuth device flow of a debrid and responds with the code the user enters.
+// The login completes in the background, the services are then restarted for the debrid to use the token.
+func (wb *Web) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	dc, ok := config.Get().GetDebrid(name)
+	if !ok {
+		http.Error(w, "Debrid not found", http.StatusNotFound)
+		return
+	}
+
+	type prompt struct {
+		VerificationURL string `json:"verification_url"`
+		UserCode        string `json:"user_code"`
+	}
+	prompts := make(chan prompt, 1)
+	errs := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), oauthLoginTimeout)
+		defer cancel()
+		err := debrid.OAuthLogin(ctx, dc, func(verificationURL, userCode string) {
+			prompts <- prompt{VerificationURL: verificationURL, UserCode: userCode}
+		})
+		if err != nil {
+			wb.logger.Error().Err(err).Str("debrid", name).Msg("OAuth login failed")
+			errs <- err
+			return
+		}
+		wb.logger.Info().Str("debrid", name).Msg("OAuth login completed, restarting the services")
+		if restartFunc != nil {
+			restartFunc()
+		}
+	}()
+
+	select {
+	case p := <-prompts:
+		request.JSONResponse(w, p, http.StatusOK)
+	case err := <-errs:
+		http.Error(w, "OAuth login failed: "+err.Error(), http.StatusBadRequest)
+	case <-r.Context().Done():
+	}
+}
+
+// handleGetOAuthStatus reports whether a debrid is logged in with OAuth
+func (wb *Web) handleGetOAuthStatus(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, ok := config.Get().GetDebrid(name); !ok {
+		http.Error(w, "Debrid not found", http.StatusNotFound)
+		return
+	}
+	token, loggedIn := config.Get().GetOAuthToken(name)
+	status := struct {
+		LoggedIn  bool      `json:"logged_in"`
+		ExpiresAt time.Time `json:"expires_at,omitzero"` // Expiry of the access token, refreshed when used past it
+	}{LoggedIn: loggedIn}
+	if loggedIn {
+		status.ExpiresAt = token.ExpiresAt
+	}
+	request.JSONResponse(w, status, http.StatusOK)
+}
@@ -14,6 +14,7 @@ func (wb *Web) Routes() http.Handler {
 	r.Post("/register", wb.RegisterHandler)
 	r.Get("/skip-auth", wb.skipAuthHandler)
 	r.Get("/version", wb.handleGetVersion)
+	r.Get("/api/health", wb.handleGetHealth)
 
 	r.Group(func(r chi.Router) {
 		r.Use(wb.authMiddleware)
@@ -24,17 +25,27 @@ func (wb *Web) Routes() http.Handler {
 		r.Get("/config", wb.ConfigHandler)
 		r.Route("/api", func(r chi.Router) {
 			r.Get("/arrs", wb.handleGetArrs)
+			r.Get("/health/details", wb.handleGetHealthDetails)
 			r.Post("/add", wb.handleAddContent)
 			r.Post("/repair", wb.handleRepairMedia)
 			r.Get("/repair/jobs", wb.handleGetRepairJobs)
 			r.Post("/repair/jobs/{id}/process", wb.handleProcessRepairJob)
+			r.Get("/repair/jobs/{id}/report", wb.handleGetRepairJobReport)
 			r.Post("/repair/jobs/{id}/stop", wb.handleStopRepairJob)
 			r.Delete("/repair/jobs", wb.handleDeleteRepairJob)
 			r.Get("/torrents", wb.handleGetTorrents)
+			r.Get("/torrents/{hash}", wb.handleGetTorrent)
 			r.Delete("/torrents/{category}/{hash}", wb.handleDeleteTorrent)
+			r.Post("/torrents/{hash}/recategorize", wb.handleRecategorizeTorrent)
 			r.Delete("/torrents/", wb.handleDeleteTorrents)
+			r.Post("/torrents/compact", wb.handleCompactTorrents)
+			r.Get("/webdav/duplicates", wb.handleGetDuplicateFiles)
 			r.Get("/config", wb.handleGetConfig)
 			r.Post("/config", wb.handleUpdateConfig)
+			r.Patch("/config", wb.handlePatchConfig)
+			r.Post("/maintenance", wb.handleSetMaintenance)
+			r.Get("/debrids/{name}/oauth", wb.handleGetOAuthStatus)
+			r.Post("/debrids/{name}/oauth", wb.handleOAuthLogin)
 		})
 	})
 
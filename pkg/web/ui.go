@@ -2,9 +2,10 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/sirrobot01/decypharr/internal/config"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
+	"strings"
 )
 
 func (wb *Web) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -59,15 +60,21 @@ func (wb *Web) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// RegisterHandler serves the first-run auth setup. The credentials are accepted once, the setup is then forbidden.
 func (wb *Web) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	authCfg := cfg.GetAuth()
 
 	if r.Method == "GET" {
+		if !cfg.NeedsAuth() {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
 		data := map[string]interface{}{
-			"URLBase": cfg.URLBase,
-			"Page":    "register",
-			"Title":   "Register",
+			"URLBase":            cfg.URLBase,
+			"Page":               "register",
+			"Title":              "Register",
+			"PasswordMinLength":  cfg.GetPasswordMinLength(),
+			"PasswordMinClasses": cfg.PasswordMinClasses,
 		}
 		_ = wb.templates.ExecuteTemplate(w, "layout", data)
 		return
@@ -77,31 +84,29 @@ func (wb *Web) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	confirmPassword := r.FormValue("confirmPassword")
 
-	if password != confirmPassword {
-		http.Error(w, "Passwords do not match", http.StatusBadRequest)
+	if !cfg.NeedsAuth() {
+		http.Error(w, "Credentials are already set", http.StatusForbidden)
 		return
 	}
-
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Error processing password", http.StatusInternalServerError)
+	if password != confirmPassword {
+		http.Error(w, "Passwords do not match", http.StatusBadRequest)
 		return
 	}
 
-	// Set the credentials
-	authCfg.Username = username
-	authCfg.Password = string(hashedPassword)
-
-	if err := cfg.SaveAuth(authCfg); err != nil {
-		http.Error(w, "Error saving credentials", http.StatusInternalServerError)
+	if err := cfg.SetupAuth(username, password); err != nil {
+		if errors.Is(err, config.ErrAuthConfigured) {
+			http.Error(w, "Credentials are already set", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	wb.logger.Info().Str("username", strings.TrimSpace(username)).Msg("Auth setup completed")
 
 	// Create a session
 	session, _ := wb.cookie.Get(r, "auth-session")
 	session.Values["authenticated"] = true
-	session.Values["username"] = username
+	session.Values["username"] = strings.TrimSpace(username)
 	if err := session.Save(r, w); err != nil {
 		http.Error(w, "Error saving session", http.StatusInternalServerError)
 		return
@@ -0,0 +1,136 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// alternateLinks returns the link on each of the CDN failover hosts, skipping the host of the link itself
+func alternateLinks(link *url.URL, hosts []string) []*url.URL {
+	links := make([]*url.URL, 0, len(hosts))
+	for _, host := range hosts {
+		if strings.EqualFold(host, link.Host) {
+			continue
+		}
+		alternate := *link
+		alternate.Host = host
+		links = append(links, &alternate)
+	}
+	return links
+}
+
+// isCDNFailure reports whether an upstream request failed because of the host serving it, so another CDN host may serve it.
+// Missing links(404) and traffic errors(503) aren't host failures, they are handled by regenerating the link.
+func isCDNFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// doUpstream sends the upstream request, retrying it on the CDN failover hosts of the debrid while the host fails
+func (f *File) doUpstream(req *http.Request) (*http.Response, error) {
+	resp, err := sharedClient.Do(req)
+	if len(f.cdnHosts) == 0 {
+		return resp, err
+	}
+	for _, link := range alternateLinks(req.URL, f.cdnHosts) {
+		if !isCDNFailure(resp, err) {
+			break
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		_log := f.cache.Logger()
+		_log.Debug().Str("file", f.name).Str("host", link.Host).Msg("CDN host failed, retrying on an alternate host")
+		alternate := req.Clone(req.Context())
+		alternate.URL = link
+		alternate.Host = ""
+		resp, err = sharedClient.Do(alternate)
+	}
+	return resp, err
+}
+
+// resumeStream continues a stream that failed after sent bytes of the upstream request, from the CDN failover hosts.
+// It returns streamErr if the stream can't be resumed.
+func (f *File) resumeStream(w http.ResponseWriter, upstreamReq *http.Request, sent int64, streamErr error) error {
+	start, end, ok := upstreamRange(upstreamReq)
+	if !ok {
+		return streamErr
+	}
+	for _, link := range alternateLinks(upstreamReq.URL, f.cdnHosts) {
+		var se *streamError
+		if errors.As(streamErr, &se) && se.IsClientDisconnection || upstreamReq.Context().Err() != nil {
+			return streamErr
+		}
+		_log := f.cache.Logger()
+		_log.Debug().Str("file", f.name).Str("host", link.Host).Int64("offset", start+sent).Msg("Stream interrupted, resuming on an alternate host")
+		alternate := upstreamReq.Clone(upstreamReq.Context())
+		alternate.URL = link
+		alternate.Host = ""
+		alternate.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", start+sent, end))
+		resp, err := sharedClient.Do(alternate)
+		if err != nil {
+			streamErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			streamErr = fmt.Errorf("alternate host %s answered %d", link.Host, resp.StatusCode)
+			continue
+		}
+		body := &countingReader{r: resp.Body}
+		streamErr = f.streamBuffer(w, body)
+		resp.Body.Close()
+		sent += body.n
+		if streamErr == nil {
+			return nil
+		}
+	}
+	return streamErr
+}
+
+// upstreamRange returns the first byte and the last byte, empty if open-ended, requested by the upstream request
+func upstreamRange(req *http.Request) (int64, string, bool) {
+	header := req.Header.Get("Range")
+	if header == "" {
+		return 0, "", true
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, "", false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, "", false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return start, last, true
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
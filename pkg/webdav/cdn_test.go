@@ -0,0 +1,171 @@
+package webdav
+
+import (
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// hostOf returns the host, with its port, of a test server
+func hostOf(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// deadHost returns the host of a server that is closed, refusing connections
+func deadHost(t *testing.T) string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	host := hostOf(t, srv)
+	srv.Close()
+	return host
+}
+
+func TestDoUpstreamFailover(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int  // Answered by the host of the link, 0 if it's down
+		cdn           bool // Whether the debrid has CDN failover hosts
+		wantStatus    int
+		wantAlternate bool
+	}{
+		{name: "ok", status: http.StatusOK, cdn: true, wantStatus: http.StatusOK},
+		{name: "bad gateway", status: http.StatusBadGateway, cdn: true, wantStatus: http.StatusOK, wantAlternate: true},
+		{name: "server error", status: http.StatusInternalServerError, cdn: true, wantStatus: http.StatusOK, wantAlternate: true},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, cdn: true, wantStatus: http.StatusOK, wantAlternate: true},
+		{name: "host down", cdn: true, wantStatus: http.StatusOK, wantAlternate: true},
+		{name: "missing link", status: http.StatusNotFound, cdn: true, wantStatus: http.StatusNotFound},
+		{name: "traffic exceeded", status: http.StatusServiceUnavailable, cdn: true, wantStatus: http.StatusServiceUnavailable},
+		{name: "no failover hosts", status: http.StatusBadGateway, wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var alternateHits atomic.Int32
+			alternate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				alternateHits.Add(1)
+				_, _ = w.Write([]byte("ok"))
+			}))
+			defer alternate.Close()
+			host := deadHost(t)
+			if tt.status != 0 {
+				primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+				}))
+				defer primary.Close()
+				host = hostOf(t, primary)
+			}
+
+			f := newStreamFile(t, 2, "ok", config.MissingContentLengthChunked)
+			if tt.cdn {
+				// The host of the link itself is skipped
+				f.cdnHosts = []string{host, hostOf(t, alternate)}
+			}
+			req, err := http.NewRequest(http.MethodGet, "http://"+host+"/movie.mkv", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := f.doUpstream(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := alternateHits.Load() > 0; got != tt.wantAlternate {
+				t.Errorf("alternate host used = %v, want %v", got, tt.wantAlternate)
+			}
+		})
+	}
+}
+
+// TestResumeStream cuts the upstream stream after 3 bytes and checks it is resumed on the CDN failover hosts
+func TestResumeStream(t *testing.T) {
+	const body = "0123456789"
+	// rangeStart returns the first byte requested by r
+	rangeStart := func(r *http.Request) int {
+		spec, _ := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+		start, _ := strconv.Atoi(strings.Split(spec, "-")[0])
+		return start
+	}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := rangeStart(r)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)-start))
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		_, _ = w.Write([]byte(body[start : start+3]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer primary.Close()
+
+	tests := []struct {
+		name       string
+		rangeValue string
+		alternates []int // Status of the range answers of each failover host, 0 if it's down
+		want       string
+		wantRange  string // Range resumed on the host answering it
+		wantErr    bool
+	}{
+		{name: "full", alternates: []int{http.StatusPartialContent}, want: body, wantRange: "bytes=3-"},
+		{name: "range", rangeValue: "bytes=2-9", alternates: []int{http.StatusPartialContent}, want: body[2:], wantRange: "bytes=5-9"},
+		{name: "first host down", alternates: []int{0, http.StatusPartialContent}, want: body, wantRange: "bytes=3-"},
+		{name: "range ignored", alternates: []int{http.StatusOK}, want: body[:3], wantErr: true},
+		{name: "no failover hosts", want: body[:3], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resumed atomic.Value
+			var hosts []string
+			for _, status := range tt.alternates {
+				if status == 0 {
+					hosts = append(hosts, deadHost(t))
+					continue
+				}
+				alternate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if status != http.StatusPartialContent {
+						w.WriteHeader(status)
+						_, _ = w.Write([]byte(body))
+						return
+					}
+					resumed.Store(r.Header.Get("Range"))
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart(r), len(body)-1, len(body)))
+					w.WriteHeader(http.StatusPartialContent)
+					_, _ = w.Write([]byte(body[rangeStart(r):]))
+				}))
+				defer alternate.Close()
+				hosts = append(hosts, hostOf(t, alternate))
+			}
+
+			f := newStreamFile(t, len(body), body, config.MissingContentLengthChunked)
+			f.downloadLink = primary.URL + "/movie.mkv"
+			f.cdnHosts = hosts
+			r := httptest.NewRequest(http.MethodGet, "/movie.mkv", nil)
+			if tt.rangeValue != "" {
+				r.Header.Set("Range", tt.rangeValue)
+			}
+			w := httptest.NewRecorder()
+			err := f.streamWithRetry(w, r, 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			got, _ := resumed.Load().(string)
+			if got != tt.wantRange {
+				t.Errorf("resumed range = %q, want %q", got, tt.wantRange)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package webdav
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/sirrobot01/decypharr/pkg/debrid/store"
 )
 
@@ -60,6 +64,13 @@ type File struct {
 	children     []os.FileInfo // For directories
 	cache        *store.Cache
 	modTime      time.Time
+	readAhead    *readAheadBuffer // Nil if range coalescing is disabled
+	traffic      func(int64)      // Counts the bytes fetched from the provider against its traffic budget
+	cdnHosts     []string         // Alternate hosts serving the download links when their host fails
+	staleHandles config.StaleHandles
+	reinserted   <-chan struct{} // Closed when the torrent is reinserted during the stream
+
+	missingContentLength config.MissingContentLength
 
 	// Minimal state for interface compliance only
 	readOffset int64 // Only used for Read() method compliance
@@ -143,8 +154,92 @@ func (f *File) StreamResponse(w http.ResponseWriter, r *http.Request) error {
 		return f.servePreloadedContent(w, r)
 	}
 
+	if served, err := f.serveReadAhead(w, r); served {
+		return err
+	}
+
 	// Try streaming with retry logic
-	return f.streamWithRetry(w, r, 0)
+	return f.streamReinsertAware(w, r)
+}
+
+// serveReadAhead serves a small range request from the read-ahead buffer.
+// It returns false if the request isn't handled by the buffer, and should be streamed from the provider.
+func (f *File) serveReadAhead(w http.ResponseWriter, r *http.Request) (bool, error) {
+	rangeHeader := r.Header.Get("Range")
+	if f.readAhead == nil || rangeHeader == "" {
+		return false, nil
+	}
+	ranges, err := parseRange(rangeHeader, f.size)
+	if err != nil || len(ranges) != 1 || !f.readAhead.covers(ranges[0].end-ranges[0].start+1) {
+		return false, nil
+	}
+
+	// Chunks are keyed by download link, so they don't outlive it
+	downloadLink, err := f.getDownloadLink()
+	if err != nil || downloadLink == "" {
+		return false, nil
+	}
+
+	start, end := ranges[0].start, ranges[0].end
+	data, err := f.readAhead.get(r.Context(), downloadLink, start, end, f.size, f.fetchRange)
+	if err != nil {
+		_log := f.cache.Logger()
+		_log.Debug().Err(err).Str("file", f.name).Msg("Read-ahead failed, streaming the range instead")
+		return false, nil
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.size))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := w.Write(data); err != nil {
+		if isClientDisconnection(err) {
+			return true, &streamError{Err: err, StatusCode: 0, IsClientDisconnection: true}
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// fetchRange downloads the bytes start-end(inclusive) of the file, until ctx is done
+func (f *File) fetchRange(ctx context.Context, start, end int64) ([]byte, error) {
+	const maxRetries = 3
+	if byteRange, _ := f.getDownloadByteRange(); byteRange != nil {
+		start += byteRange[0]
+		end += byteRange[0]
+	}
+	for retryCount := 0; ; retryCount++ {
+		downloadLink, err := f.getDownloadLink()
+		if err != nil {
+			return nil, err
+		}
+		upstreamReq, err := http.NewRequestWithContext(ctx, "GET", downloadLink, nil)
+		if err != nil {
+			return nil, err
+		}
+		setVideoStreamingHeaders(upstreamReq)
+		upstreamReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+		resp, err := f.doUpstream(upstreamReq)
+		if err != nil {
+			return nil, err
+		}
+		shouldRetry, retryErr := f.handleUpstream(resp, retryCount, maxRetries)
+		if shouldRetry && retryCount < maxRetries {
+			continue
+		}
+		if retryErr != nil {
+			return nil, retryErr
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return nil, fmt.Errorf("upstream ignored the range request, status %d", resp.StatusCode)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, end-start+1))
+		resp.Body.Close()
+		f.recordTraffic(int64(len(data)))
+		return data, err
+	}
 }
 
 func (f *File) streamWithRetry(w http.ResponseWriter, r *http.Request, retryCount int) error {
@@ -161,8 +256,9 @@ func (f *File) streamWithRetry(w http.ResponseWriter, r *http.Request, retryCoun
 		return &streamError{Err: fmt.Errorf("empty download link"), StatusCode: http.StatusNotFound}
 	}
 
-	// Create upstream request with streaming optimizations
-	upstreamReq, err := http.NewRequest("GET", downloadLink, nil)
+	// Create upstream request with streaming optimizations.
+	// It's bound to the client request, so a disconnect cancels the fetch and frees the provider connection.
+	upstreamReq, err := http.NewRequestWithContext(r.Context(), "GET", downloadLink, nil)
 	if err != nil {
 		return &streamError{Err: err, StatusCode: http.StatusInternalServerError}
 	}
@@ -175,7 +271,7 @@ func (f *File) streamWithRetry(w http.ResponseWriter, r *http.Request, retryCoun
 		return &streamError{Err: fmt.Errorf("invalid range"), StatusCode: http.StatusRequestedRangeNotSatisfiable}
 	}
 
-	resp, err := sharedClient.Do(upstreamReq)
+	resp, err := f.doUpstream(upstreamReq)
 	if err != nil {
 		return &streamError{Err: err, StatusCode: http.StatusServiceUnavailable}
 	}
@@ -195,9 +291,62 @@ func (f *File) streamWithRetry(w http.ResponseWriter, r *http.Request, retryCoun
 		return retryErr
 	}
 
+	var upstreamBody io.Reader = resp.Body
+	if resp.ContentLength < 0 && f.missingContentLength == config.MissingContentLengthMetadata {
+		upstreamBody = f.metadataLength(w, r, resp, isRangeRequest == 1)
+	}
 	setVideoResponseHeaders(w, resp, isRangeRequest == 1)
 
-	return f.streamBuffer(w, resp.Body)
+	body := &countingReader{r: upstreamBody}
+	err = f.streamBuffer(w, body)
+	if err != nil && f.wasReinserted() {
+		return f.resumeReinserted(w, upstreamReq, body.n, err)
+	}
+	if err != nil && len(f.cdnHosts) > 0 {
+		return f.resumeStream(w, upstreamReq, body.n, err)
+	}
+	return err
+}
+
+// metadataLength sets the Content-Length, and the Content-Range of a range request, of a response the provider sent
+// without a length from the file size known from the torrent. It returns the body cut at that length, failing if
+// the provider ends it early so the stream is resumed or aborted instead of ending short of the announced length.
+func (f *File) metadataLength(w http.ResponseWriter, r *http.Request, resp *http.Response, isRange bool) io.Reader {
+	if f.size <= 0 || isRange && resp.StatusCode != http.StatusPartialContent {
+		// The provider ignored the range, its body doesn't match the requested one
+		return resp.Body
+	}
+	start, end := int64(0), f.size-1
+	if isRange {
+		ranges, _ := parseRange(r.Header.Get("Range"), f.size) // Already checked by handleRangeRequest
+		start, end = ranges[0].start, ranges[0].end
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.size))
+	}
+	length := end - start + 1
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	return &exactReader{r: io.LimitReader(resp.Body, length), left: length}
+}
+
+// exactReader fails with io.ErrUnexpectedEOF when r ends before left bytes are read
+type exactReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.left -= int64(n)
+	if err == io.EOF && e.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *File) recordTraffic(n int64) {
+	if f.traffic != nil {
+		f.traffic(n)
+	}
 }
 
 func (f *File) streamBuffer(w http.ResponseWriter, src io.Reader) error {
@@ -208,6 +357,7 @@ func (f *File) streamBuffer(w http.ResponseWriter, src io.Reader) error {
 
 	smallBuf := make([]byte, 64*1024) // 64 KB
 	if n, err := src.Read(smallBuf); n > 0 {
+		f.recordTraffic(int64(n))
 		if _, werr := w.Write(smallBuf[:n]); werr != nil {
 			return werr
 		}
@@ -220,6 +370,7 @@ func (f *File) streamBuffer(w http.ResponseWriter, src io.Reader) error {
 	for {
 		n, readErr := src.Read(buf)
 		if n > 0 {
+			f.recordTraffic(int64(n))
 			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
 				if isClientDisconnection(writeErr) {
 					return &streamError{Err: writeErr, StatusCode: 0, IsClientDisconnection: true}
@@ -232,7 +383,8 @@ func (f *File) streamBuffer(w http.ResponseWriter, src io.Reader) error {
 			if readErr == io.EOF {
 				return nil
 			}
-			if isClientDisconnection(readErr) {
+			// The upstream request is bound to the client one, a read error is the upstream failing unless the client left
+			if errors.Is(readErr, context.Canceled) {
 				return &streamError{Err: readErr, StatusCode: 0, IsClientDisconnection: true}
 			}
 			return readErr
@@ -0,0 +1,145 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/store"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// namedClient is a debrid client with only a name, the methods it doesn't override panic
+type namedClient struct {
+	types.Client
+}
+
+func (namedClient) Name() string { return "realdebrid" }
+
+// newStreamFile returns a file of size bytes streamed from upstream, without a Content-Length when chunked
+func newStreamFile(t *testing.T, size int, body string, policy config.MissingContentLength) *File {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(map[string]any{"debrids": []config.Debrid{{Name: "realdebrid", APIKey: "key", Folder: filepath.Join(dir, "mnt")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+	dc, _ := config.Get().GetDebrid("realdebrid")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		// Flushed before the end, the response is chunked without a Content-Length
+		_, _ = w.Write([]byte(body[:1]))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(body[1:]))
+	}))
+	t.Cleanup(upstream.Close)
+	return &File{
+		name:                 "movie.mkv",
+		size:                 int64(size),
+		downloadLink:         upstream.URL + "/movie.mkv",
+		cache:                store.NewDebridCache(dc, namedClient{}, nil),
+		missingContentLength: policy,
+	}
+}
+
+func TestMissingContentLength(t *testing.T) {
+	const body = "0123456789"
+	tests := []struct {
+		name       string
+		policy     config.MissingContentLength
+		rangeValue string
+		want       string
+		wantLength string
+		wantRange  string
+	}{
+		{name: "metadata", policy: config.MissingContentLengthMetadata, want: body, wantLength: "10"},
+		{name: "metadata range", policy: config.MissingContentLengthMetadata, rangeValue: "bytes=2-5", want: body[2:6], wantLength: "4", wantRange: "bytes 2-5/10"},
+		{name: "chunked", policy: config.MissingContentLengthChunked, want: body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newStreamFile(t, len(body), tt.want, tt.policy)
+			r := httptest.NewRequest(http.MethodGet, "/movie.mkv", nil)
+			if tt.rangeValue != "" {
+				r.Header.Set("Range", tt.rangeValue)
+			}
+			w := httptest.NewRecorder()
+			if err := f.StreamResponse(w, r); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A provider ending the stream short of the size from the metadata fails it, instead of ending short
+	f := newStreamFile(t, 20, body, config.MissingContentLengthMetadata)
+	if err := f.StreamResponse(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/movie.mkv", nil)); err == nil {
+		t.Error("stream ending before the size from the metadata succeeded")
+	}
+}
+
+// A client disconnecting mid-stream cancels the upstream request, instead of leaving it open until it times out
+func TestStreamClientDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write([]byte("0123456789"))
+		w.(http.Flusher).Flush()
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	f := newStreamFile(t, 1000, "0123456789", config.MissingContentLengthChunked)
+	f.downloadLink = upstream.URL + "/movie.mkv"
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/movie.mkv", nil).WithContext(ctx)
+	done := make(chan error, 1)
+	go func() { done <- f.streamWithRetry(httptest.NewRecorder(), r, 0) }()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not sent")
+	}
+	disconnect()
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request not cancelled after the client disconnected")
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("stream succeeded after the client disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still running after the client disconnected")
+	}
+}
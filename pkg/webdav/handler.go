@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
@@ -17,6 +19,8 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/internal/logger"
 	"github.com/sirrobot01/decypharr/internal/utils"
 	"github.com/sirrobot01/decypharr/pkg/debrid/store"
 	"github.com/sirrobot01/decypharr/pkg/version"
@@ -25,20 +29,47 @@ import (
 const DeleteAllBadTorrentKey = "DELETE_ALL_BAD_TORRENTS"
 
 type Handler struct {
-	Name     string
-	logger   zerolog.Logger
-	cache    *store.Cache
-	URLBase  string
-	RootPath string
+	Name      string
+	logger    zerolog.Logger
+	cache     *store.Cache
+	URLBase   string
+	RootPath  string
+	readAhead *readAheadBuffer
+	traffic   func(int64)
+	cdnHosts  []string
+	trusted   []netip.Prefix // Reverse proxies whose forwarded client IP is honored
+
+	propfindErrors       config.PropfindErrors
+	staleHandles         config.StaleHandles
+	directoryGet         config.DirectoryGet
+	directoryIndexFiles  config.DirectoryIndexFiles
+	missingContentLength config.MissingContentLength
 }
 
-func NewHandler(name, urlBase string, cache *store.Cache, logger zerolog.Logger) *Handler {
+// requestLogger returns the handler logger with the correlation ID of r and the debrid as attributes
+func (h *Handler) requestLogger(r *http.Request) *zerolog.Logger {
+	l := logger.Ctx(r.Context(), h.logger).With().Str("debrid", h.Name).Logger()
+	return &l
+}
+
+func NewHandler(name, urlBase string, cache *store.Cache, logger zerolog.Logger, traffic func(int64)) *Handler {
+	dc, _ := config.Get().GetDebrid(name)
 	h := &Handler{
-		Name:     name,
-		cache:    cache,
-		logger:   logger,
-		URLBase:  urlBase,
-		RootPath: path.Join(urlBase, "webdav", name),
+		Name:      name,
+		cache:     cache,
+		logger:    logger,
+		URLBase:   urlBase,
+		RootPath:  path.Join(urlBase, "webdav", name),
+		readAhead: newReadAheadBuffer(dc.GetReadAheadSize(), dc.GetRangeCoalesceWindow(), dc.GetCacheSize(), dc.GetAutoExpireLinksAfter()),
+		traffic:   traffic,
+		cdnHosts:  dc.CDNFailoverHosts,
+		trusted:   config.Get().WebDav.GetTrustedProxies(),
+
+		propfindErrors:       dc.PropfindErrors,
+		staleHandles:         dc.StaleHandles,
+		directoryGet:         dc.DirectoryGet,
+		directoryIndexFiles:  dc.DirectoryIndexFiles,
+		missingContentLength: dc.MissingContentLength,
 	}
 	return h
 }
@@ -182,7 +213,7 @@ func (h *Handler) getChildren(name string) []os.FileInfo {
 	if len(parts) == 2 && utils.Contains(h.getParentItems(), parts[0]) {
 		torrentName := parts[1]
 		if t := h.cache.GetTorrentByName(torrentName); t != nil {
-			return h.getFileInfos(t)
+			return h.getFileInfos(parts[0], t)
 		}
 	}
 	return nil
@@ -238,7 +269,7 @@ func (h *Handler) OpenFile(ctx context.Context, name string, flag int, perm os.F
 			cached := h.cache.GetTorrentByName(torrentName)
 			if cached != nil && len(parts) >= 3 {
 				filename := filepath.Clean(path.Join(parts[2:]...))
-				if file, ok := cached.GetFile(filename); ok && !file.Deleted {
+				if file, ok := cached.GetFile(filename); ok && !file.Deleted && h.isFileExposed(parts[0], cached, file.Name) {
 					return &File{
 						cache:        h.cache,
 						torrentName:  torrentName,
@@ -250,6 +281,12 @@ func (h *Handler) OpenFile(ctx context.Context, name string, flag int, perm os.F
 						metadataOnly: metadataOnly,
 						isRar:        file.IsRar,
 						modTime:      cached.AddedOn,
+						readAhead:    h.readAhead,
+						traffic:      h.traffic,
+						cdnHosts:     h.cdnHosts,
+						staleHandles: h.staleHandles,
+
+						missingContentLength: h.missingContentLength,
 					}, nil
 				}
 			}
@@ -269,18 +306,38 @@ func (h *Handler) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	return f.Stat()
 }
 
-func (h *Handler) getFileInfos(torrent *store.CachedTorrent) []os.FileInfo {
-	torrentFiles := torrent.GetFiles()
+// getExposedFiles returns the files of the torrent shown to clients in the parent folder, depending on its category settings
+func (h *Handler) getExposedFiles(parent string, torrent *store.CachedTorrent) []types.File {
+	files := torrent.GetFiles()
+	if torrent.Arr != nil {
+		if a, ok := config.Get().GetArr(torrent.Arr.Name); ok && a.MainFileOnly {
+			files = torrent.GetMainFiles(a.KeepSubtitleFiles)
+		}
+	}
+	if parent == store.DedupFolder {
+		files = slices.DeleteFunc(files, func(f types.File) bool {
+			return h.cache.IsDedupHidden(torrent.Id, f.Name)
+		})
+	}
+	return files
+}
+
+func (h *Handler) isFileExposed(parent string, torrent *store.CachedTorrent, filename string) bool {
+	return slices.ContainsFunc(h.getExposedFiles(parent, torrent), func(f types.File) bool {
+		return f.Name == filename
+	})
+}
+
+func (h *Handler) getFileInfos(parent string, torrent *store.CachedTorrent) []os.FileInfo {
+	torrentFiles := h.getExposedFiles(parent, torrent)
 	files := make([]os.FileInfo, 0, len(torrentFiles))
 
-	// Sort by file name since the order is lost when using the map
+	// Sort the files since the order is lost when using the map
 	sortedFiles := make([]*types.File, 0, len(torrentFiles))
 	for _, file := range torrentFiles {
 		sortedFiles = append(sortedFiles, &file)
 	}
-	slices.SortFunc(sortedFiles, func(a, b *types.File) int {
-		return strings.Compare(a.Name, b.Name)
-	})
+	slices.SortFunc(sortedFiles, fileComparator(h.cache.GetFileSortOrder(parent)))
 
 	for _, file := range sortedFiles {
 		files = append(files, &FileInfo{
@@ -294,6 +351,29 @@ func (h *Handler) getFileInfos(torrent *store.CachedTorrent) []os.FileInfo {
 	return files
 }
 
+func fileComparator(order config.FileSortOrder) func(a, b *types.File) int {
+	byName := func(a, b *types.File) int {
+		return strings.Compare(a.Name, b.Name)
+	}
+	switch order {
+	case config.FileSortByNatural:
+		return func(a, b *types.File) int {
+			return utils.NaturalCompare(a.Name, b.Name)
+		}
+	case config.FileSortByIndex:
+		// Provider file ids are the index of the file in the torrent
+		return func(a, b *types.File) int {
+			return cmp.Or(utils.NaturalCompare(a.Id, b.Id), byName(a, b))
+		}
+	case config.FileSortBySize:
+		return func(a, b *types.File) int {
+			return cmp.Or(cmp.Compare(b.Size, a.Size), byName(a, b))
+		}
+	default:
+		return byName
+	}
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
@@ -380,12 +460,15 @@ func (h *Handler) serveDirectory(w http.ResponseWriter, r *http.Request, file we
 		}
 	}
 
+	children = indexChildren(children, h.directoryIndexFiles)
+
 	// Clean and prepare the path
 	cleanPath := path.Clean(r.URL.Path)
-	parentPath := path.Dir(cleanPath)
-	showParent := cleanPath != "/" && parentPath != "." && parentPath != cleanPath
 	isBadPath := strings.HasSuffix(cleanPath, "__bad__")
 	_, canDelete := h.isParentPath(cleanPath)
+	cleanPath = publicPath(r, cleanPath)
+	parentPath := path.Dir(cleanPath)
+	showParent := cleanPath != "/" && parentPath != "." && parentPath != cleanPath
 
 	// Prepare template data
 	data := struct {
@@ -414,6 +497,33 @@ func (h *Handler) serveDirectory(w http.ResponseWriter, r *http.Request, file we
 	}
 }
 
+// rejectDirectoryGet answers a GET of a directory with a 405 if policy rejects them, and reports whether it did
+func rejectDirectoryGet(w http.ResponseWriter, policy config.DirectoryGet) bool {
+	if policy != config.DirectoryGetReject {
+		return false
+	}
+	w.Header().Set("Allow", "OPTIONS, HEAD, PROPFIND")
+	http.Error(w, "Directories are listed with PROPFIND", http.StatusMethodNotAllowed)
+	return true
+}
+
+// indexChildren returns the children listed by an HTML directory index. With DirectoryIndexFilesAllowed, only the
+// directories and the files of an allowed file type are, files added before the allowed file types changed are still
+// served, just not listed.
+func indexChildren(children []os.FileInfo, files config.DirectoryIndexFiles) []os.FileInfo {
+	if files != config.DirectoryIndexFilesAllowed {
+		return children
+	}
+	cfg := config.Get()
+	listed := make([]os.FileInfo, 0, len(children))
+	for _, child := range children {
+		if child.IsDir() || cfg.IsAllowedFile(child.Name()) {
+			listed = append(listed, child)
+		}
+	}
+	return listed
+}
+
 // Handlers
 
 func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
@@ -431,6 +541,9 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if fi.IsDir() {
+		if rejectDirectoryGet(w, h.directoryGet) {
+			return
+		}
 		h.serveDirectory(w, r, fRaw)
 		return
 	}
@@ -451,19 +564,14 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 	if file, ok := fRaw.(*File); ok {
 		// Handle nginx proxy (X-Accel-Redirect)
 		if file.content == nil && !file.isRar && h.cache.StreamWithRclone() {
-			link, err := file.getDownloadLink()
-			if err != nil || link == "" {
-				http.Error(w, "Could not fetch download link", http.StatusPreconditionFailed)
+			if h.redirect(w, r, file, fi.Name()) {
 				return
 			}
-
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fi.Name()))
-			w.Header().Set("X-Accel-Redirect", link)
-			w.Header().Set("X-Accel-Buffering", "no")
-			http.Redirect(w, r, link, http.StatusFound)
-			return
 		}
 
+		if file.content == nil {
+			defer h.cache.StreamStarted()()
+		}
 		if err := file.StreamResponse(w, r); err != nil {
 			var streamErr *streamError
 			if errors.As(err, &streamErr) {
@@ -475,7 +583,7 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 				if streamErr.StatusCode > 0 && !hasHeadersWritten(w) {
 					http.Error(w, streamErr.Error(), streamErr.StatusCode)
 				} else {
-					h.logger.Error().
+					h.requestLogger(r).Error().
 						Err(streamErr.Err).
 						Str("path", r.URL.Path).
 						Msg("Stream error")
@@ -485,7 +593,7 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 				if !hasHeadersWritten(w) {
 					http.Error(w, "Stream error", http.StatusInternalServerError)
 				} else {
-					h.logger.Error().
+					h.requestLogger(r).Error().
 						Err(err).
 						Str("path", r.URL.Path).
 						Msg("Stream error after headers written")
@@ -505,10 +613,43 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// redirect sends the client to the debrid link of the file, it returns false if the file should be streamed instead
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, file *File, name string) bool {
+	var link string
+	var err error
+	switch linkServingFor(h.cache.IPLockedBehavior(), h.cache.CanLinkForIP()) {
+	case serveProxy:
+		return false
+	case serveIPLocked:
+		h.requestLogger(r).Warn().Str("path", r.URL.Path).Msg("Refusing to redirect, the debrid links are IP-locked")
+		http.Error(w, "The debrid links are IP-locked to the server and can't be used by this client, set ip_locked_behavior to proxy or disable serve_from_rclone", http.StatusConflict)
+		return true
+	case serveRegenerated:
+		ip := clientIP(r, h.trusted)
+		link, err = h.cache.GetDownloadLinkForIP(file.torrentName, file.name, ip)
+		if err != nil {
+			h.logger.Debug().Err(err).Str("path", r.URL.Path).Str("ip", ip).Msg("Failed to generate a link for the client IP, proxying")
+			return false
+		}
+	default:
+		link, err = file.getDownloadLink()
+		if err != nil || link == "" {
+			http.Error(w, "Could not fetch download link", http.StatusPreconditionFailed)
+			return true
+		}
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	w.Header().Set("X-Accel-Redirect", link)
+	w.Header().Set("X-Accel-Buffering", "no")
+	http.Redirect(w, r, link, http.StatusFound)
+	return true
+}
+
 func (h *Handler) handleHead(w http.ResponseWriter, r *http.Request) {
 	f, err := h.OpenFile(r.Context(), r.URL.Path, os.O_RDONLY, 0)
 	if err != nil {
-		h.logger.Error().Err(err).Str("path", r.URL.Path).Msg("Failed to open file")
+		h.requestLogger(r).Error().Err(err).Str("path", r.URL.Path).Msg("Failed to open file")
 		http.NotFound(w, r)
 		return
 	}
@@ -521,7 +662,7 @@ func (h *Handler) handleHead(w http.ResponseWriter, r *http.Request) {
 
 	fi, err := f.Stat()
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to stat file")
+		h.requestLogger(r).Error().Err(err).Msg("Failed to stat file")
 		http.Error(w, "Server Error", http.StatusInternalServerError)
 		return
 	}
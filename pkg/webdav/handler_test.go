@@ -0,0 +1,85 @@
+package webdav
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRejectDirectoryGet(t *testing.T) {
+	w := httptest.NewRecorder()
+	if rejectDirectoryGet(w, config.DirectoryGetIndex) {
+		t.Error("directory GET rejected with the index policy")
+	}
+
+	w = httptest.NewRecorder()
+	if !rejectDirectoryGet(w, config.DirectoryGetReject) {
+		t.Fatal("directory GET not rejected with the reject policy")
+	}
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("rejected with %d, Allow %q, want 405 with the allowed methods", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestIndexChildren(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"allowed_file_types":["mkv"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfigPath(dir)
+	config.Reload()
+
+	children := []os.FileInfo{
+		&FileInfo{name: "Extras", isDir: true},
+		&FileInfo{name: "movie.mkv"},
+		&FileInfo{name: "movie.nfo"},
+	}
+	names := func(infos []os.FileInfo) []string {
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	if got := names(indexChildren(children, config.DirectoryIndexFilesAll)); len(got) != 3 {
+		t.Errorf("index = %v with all files, want every child", got)
+	}
+	if got := names(indexChildren(children, config.DirectoryIndexFilesAllowed)); !slices.Equal(got, []string{"Extras", "movie.mkv"}) {
+		t.Errorf("index = %v with the allowed files, want Extras, movie.mkv", got)
+	}
+}
+
+func TestFileComparator(t *testing.T) {
+	files := []*types.File{
+		{Id: "2", Name: "Ep10.mkv", Size: 3 << 20},
+		{Id: "10", Name: "Ep2.mkv", Size: 1 << 20},
+		{Id: "1", Name: "Ep1.mkv", Size: 2 << 20},
+		{Id: "3", Name: "Ep3.mkv", Size: 2 << 20},
+	}
+	tests := []struct {
+		order config.FileSortOrder
+		want  []string
+	}{
+		{"", []string{"Ep1.mkv", "Ep10.mkv", "Ep2.mkv", "Ep3.mkv"}},
+		{config.FileSortByName, []string{"Ep1.mkv", "Ep10.mkv", "Ep2.mkv", "Ep3.mkv"}},
+		{config.FileSortByNatural, []string{"Ep1.mkv", "Ep2.mkv", "Ep3.mkv", "Ep10.mkv"}},
+		{config.FileSortByIndex, []string{"Ep1.mkv", "Ep10.mkv", "Ep3.mkv", "Ep2.mkv"}},
+		{config.FileSortBySize, []string{"Ep10.mkv", "Ep1.mkv", "Ep3.mkv", "Ep2.mkv"}},
+	}
+	for _, tt := range tests {
+		sorted := slices.Clone(files)
+		slices.SortFunc(sorted, fileComparator(tt.order))
+		var got []string
+		for _, f := range sorted {
+			got = append(got, f.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("order %q = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
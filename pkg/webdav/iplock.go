@@ -0,0 +1,80 @@
+package webdav
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// linkServing is how a file is served when clients are redirected to the debrid
+type linkServing int
+
+const (
+	serveRedirect    linkServing = iota // Redirect to the link generated by the server
+	serveRegenerated                    // Redirect to a link generated for the client IP
+	serveProxy                          // Stream the file through Decypharr
+	serveIPLocked                       // Refuse the request, the link can't be used by the client
+)
+
+// linkServingFor decides how a file is served in redirect mode, behavior is "" if the links of the debrid aren't IP-locked
+func linkServingFor(behavior config.IPLockedLinks, canLinkForIP bool) linkServing {
+	switch behavior {
+	case "":
+		return serveRedirect
+	case config.IPLockedLinksRegenerate:
+		if canLinkForIP {
+			return serveRegenerated
+		}
+		return serveProxy
+	case config.IPLockedLinksError:
+		return serveIPLocked
+	default:
+		return serveProxy
+	}
+}
+
+// clientIP returns the IP of the client. X-Forwarded-For and X-Real-IP are only honored when the request comes from
+// a trusted proxy, anyone else could spoof them. X-Forwarded-For is read from the right, the first address that
+// isn't a trusted proxy is the client.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if i == 0 || !isTrustedProxy(hop, trusted) {
+				return hop
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip is within one of the trusted prefixes
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package webdav
+
+import (
+	"github.com/sirrobot01/decypharr/internal/config"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestLinkServingFor(t *testing.T) {
+	tests := []struct {
+		behavior     config.IPLockedLinks
+		canLinkForIP bool
+		want         linkServing
+	}{
+		{"", false, serveRedirect},
+		{"", true, serveRedirect},
+		{config.IPLockedLinksProxy, true, serveProxy},
+		{config.IPLockedLinksRegenerate, true, serveRegenerated},
+		{config.IPLockedLinksRegenerate, false, serveProxy},
+		{config.IPLockedLinksError, true, serveIPLocked},
+	}
+	for _, tt := range tests {
+		if got := linkServingFor(tt.behavior, tt.canLinkForIP); got != tt.want {
+			t.Errorf("linkServingFor(%q, %v) = %d, want %d", tt.behavior, tt.canLinkForIP, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"spoofed by an untrusted client", "203.0.113.7:5000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:5000", []string{"192.0.2.9, 198.51.100.1, 10.0.0.3"}, "", "198.51.100.1"},
+		{"split headers", "10.0.0.2:5000", []string{"192.0.2.9", "198.51.100.1"}, "", "198.51.100.1"},
+		{"only trusted hops", "10.0.0.2:5000", []string{"10.0.0.4, 10.0.0.3"}, "", "10.0.0.4"},
+		{"real IP", "10.0.0.2:5000", nil, "198.51.100.2", "198.51.100.2"},
+		{"mapped IPv4 proxy", "[::ffff:10.0.0.2]:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, forwarded := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r, trusted); got != tt.want {
+			t.Errorf("%s: clientIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
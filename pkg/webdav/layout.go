@@ -0,0 +1,204 @@
+package webdav
+
+import (
+	"context"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"github.com/sirrobot01/decypharr/pkg/debrid/store"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// publicPathKey holds the path requested by the client when the root layout serves it from a debrid folder
+	publicPathKey contextKey = "publicPath"
+
+	// uncategorizedFolder lists the torrents without a category in the category layout
+	uncategorizedFolder = "__uncategorized__"
+)
+
+// publicPath returns the path the client requested, cleanPath unless the request was routed by the root layout
+func publicPath(r *http.Request, cleanPath string) string {
+	if p, ok := r.Context().Value(publicPathKey).(string); ok {
+		return p
+	}
+	return cleanPath
+}
+
+func torrentCategory(t *store.CachedTorrent) string {
+	if t.Arr == nil || t.Arr.Name == "" {
+		return uncategorizedFolder
+	}
+	return t.Arr.Name
+}
+
+// rootChildren returns the top-level folders of the root layout
+func (wd *WebDav) rootChildren() []os.FileInfo {
+	switch wd.layout {
+	case config.RootLayoutMerged:
+		var listings [][]os.FileInfo
+		for _, h := range wd.Handlers {
+			listings = append(listings, h.cache.GetListing("__all__"))
+		}
+		return mergeListings(listings...)
+	case config.RootLayoutCategory:
+		now := time.Now()
+		seen := make(map[string]struct{})
+		var children []os.FileInfo
+		for _, h := range wd.Handlers {
+			for category := range h.cache.GetCategoryListings() {
+				name := categoryFolder(category)
+				if _, ok := seen[name]; ok {
+					continue
+				}
+				seen[name] = struct{}{}
+				children = append(children, &FileInfo{name: name, mode: 0755 | os.ModeDir, modTime: now, isDir: true})
+			}
+		}
+		return children
+	default:
+		children := make([]os.FileInfo, 0, len(wd.Handlers))
+		for _, h := range wd.Handlers {
+			children = append(children, &FileInfo{
+				name:    h.Name,
+				size:    0,
+				mode:    0755 | os.ModeDir,
+				modTime: time.Now(),
+				isDir:   true,
+			})
+		}
+		return children
+	}
+}
+
+// categoryChildren returns the torrents of a category across the debrids, nil if there are none
+func (wd *WebDav) categoryChildren(category string) []os.FileInfo {
+	key := category
+	if category == uncategorizedFolder {
+		key = ""
+	}
+	var listings [][]os.FileInfo
+	for _, h := range wd.Handlers {
+		listings = append(listings, h.cache.GetCategoryListings()[key])
+	}
+	return mergeListings(listings...)
+}
+
+// mergeListings merges torrent listings, a name listed by several debrids is kept from the first one
+func mergeListings(listings ...[]os.FileInfo) []os.FileInfo {
+	seen := make(map[string]struct{})
+	var merged []os.FileInfo
+	for _, listing := range listings {
+		for _, fi := range listing {
+			if _, ok := seen[fi.Name()]; ok {
+				continue
+			}
+			seen[fi.Name()] = struct{}{}
+			merged = append(merged, fi)
+		}
+	}
+	return merged
+}
+
+// categoryFolder returns the folder name of a category in the category layout
+func categoryFolder(category string) string {
+	if category == "" {
+		return uncategorizedFolder
+	}
+	return category
+}
+
+// resolve returns the debrid handler serving the path segments below the root, and the path within its folders
+func (wd *WebDav) resolve(segments []string) (*Handler, string, bool) {
+	switch wd.layout {
+	case config.RootLayoutMerged:
+		for _, h := range wd.Handlers {
+			if h.cache.GetTorrentByName(segments[0]) != nil {
+				return h, path.Join(append([]string{h.RootPath, "__all__"}, segments...)...), true
+			}
+		}
+	case config.RootLayoutCategory:
+		if len(segments) < 2 {
+			return nil, "", false
+		}
+		for _, h := range wd.Handlers {
+			if t := h.cache.GetTorrentByName(segments[1]); t != nil && torrentCategory(t) == segments[0] {
+				return h, path.Join(append([]string{h.RootPath, "__all__"}, segments[1:]...)...), true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// handleLayout serves the paths of the merged and category root layouts, outside the debrid folders
+func (wd *WebDav) handleLayout(w http.ResponseWriter, r *http.Request) {
+	if wd.layout == config.RootLayoutDebrid {
+		http.NotFound(w, r)
+		return
+	}
+	cleanPath := path.Clean(r.URL.Path)
+	rel := strings.Trim(strings.TrimPrefix(cleanPath, path.Join(wd.URLBase, "webdav")), "/")
+	if rel == "" {
+		http.NotFound(w, r)
+		return
+	}
+	segments := strings.Split(rel, "/")
+
+	if wd.layout == config.RootLayoutCategory && len(segments) == 1 {
+		wd.serveCategory(w, r, cleanPath, segments[0])
+		return
+	}
+
+	h, internalPath, ok := wd.resolve(segments)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	r = r.Clone(context.WithValue(r.Context(), publicPathKey, cleanPath))
+	r.URL.Path = internalPath
+	r.URL.RawPath = ""
+	h.readinessMiddleware(h).ServeHTTP(w, r)
+}
+
+// serveCategory lists the torrents of a category folder
+func (wd *WebDav) serveCategory(w http.ResponseWriter, r *http.Request, cleanPath, category string) {
+	children := wd.categoryChildren(category)
+	if children == nil {
+		http.NotFound(w, r)
+		return
+	}
+	fi := &FileInfo{name: category, mode: 0755 | os.ModeDir, modTime: time.Now(), isDir: true}
+
+	switch r.Method {
+	case "PROPFIND":
+		writeXml(w, http.StatusMultiStatus, filesToXML(cleanPath, fi, children))
+	case "GET":
+		if rejectDirectoryGet(w, config.Get().WebDav.DirectoryGet) {
+			return
+		}
+		data := struct {
+			Path                   string
+			ParentPath             string
+			ShowParent             bool
+			Children               []os.FileInfo
+			URLBase                string
+			IsBadPath              bool
+			CanDelete              bool
+			DeleteAllBadTorrentKey string
+		}{
+			Path:       cleanPath,
+			ParentPath: path.Dir(cleanPath),
+			ShowParent: true,
+			Children:   indexChildren(children, config.Get().WebDav.DirectoryIndexFiles),
+			URLBase:    wd.URLBase,
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = tplDirectory.ExecuteTemplate(w, "directory.html", data)
+	case "HEAD", "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,52 @@
+package webdav
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMergeListings(t *testing.T) {
+	listing := func(names ...string) []os.FileInfo {
+		var infos []os.FileInfo
+		for _, name := range names {
+			infos = append(infos, &FileInfo{name: name, isDir: true})
+		}
+		return infos
+	}
+	first := listing("Movie A", "Movie B")
+	merged := mergeListings(first, nil, listing("Movie B", "Movie C"))
+
+	var names []string
+	for _, fi := range merged {
+		names = append(names, fi.Name())
+	}
+	if len(names) != 3 || names[0] != "Movie A" || names[1] != "Movie B" || names[2] != "Movie C" {
+		t.Fatalf("merged = %v, want Movie A, Movie B, Movie C", names)
+	}
+	if merged[1] != first[1] {
+		t.Error("Movie B not kept from the first debrid listing it")
+	}
+	if mergeListings() != nil {
+		t.Error("mergeListings() of no listings not nil")
+	}
+}
+
+func TestLayoutPaths(t *testing.T) {
+	if got := categoryFolder(""); got != uncategorizedFolder {
+		t.Errorf("categoryFolder(\"\") = %s, want %s", got, uncategorizedFolder)
+	}
+	if got := categoryFolder("radarr"); got != "radarr" {
+		t.Errorf("categoryFolder(radarr) = %s, want radarr", got)
+	}
+
+	r := httptest.NewRequest("PROPFIND", "/webdav/realdebrid/__all__/Movie", nil)
+	if got := publicPath(r, "/webdav/realdebrid/__all__/Movie"); got != "/webdav/realdebrid/__all__/Movie" {
+		t.Errorf("publicPath() = %s, want the clean path", got)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), publicPathKey, "/webdav/radarr/Movie"))
+	if got := publicPath(r, "/webdav/realdebrid/__all__/Movie"); got != "/webdav/radarr/Movie" {
+		t.Errorf("publicPath() = %s, want the path routed by the layout", got)
+	}
+}
@@ -2,6 +2,8 @@ package webdav
 
 import (
 	"context"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
 	"github.com/stanNthe5/stringbuf"
 	"net/http"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type contextKey string
@@ -18,26 +21,27 @@ const (
 	metadataOnlyKey contextKey = "metadataOnly"
 )
 
+type propfindEntry struct {
+	escHref string // already XML-safe + percent-escaped
+	escName string
+	size    int64
+	isDir   bool
+	modTime string
+	failed  bool // Listed with an error status only
+}
+
 func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 	// Setup context for metadata only
 	ctx := context.WithValue(r.Context(), metadataOnlyKey, true)
 	r = r.WithContext(ctx)
 
 	cleanPath := path.Clean(r.URL.Path)
-
-	// Build the list of entries
-	type entry struct {
-		escHref string // already XML-safe + percent-escaped
-		escName string
-		size    int64
-		isDir   bool
-		modTime string
-	}
+	hrefPath := publicPath(r, cleanPath)
 
 	// Always include the resource itself
 	f, err := h.OpenFile(r.Context(), cleanPath, os.O_RDONLY, 0)
 	if err != nil {
-		h.logger.Error().Err(err).Str("path", cleanPath).Msg("Failed to open file")
+		h.requestLogger(r).Error().Err(err).Str("path", cleanPath).Msg("Failed to open file")
 		http.NotFound(w, r)
 		return
 	}
@@ -45,7 +49,7 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 
 	fi, err := f.Stat()
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to stat file")
+		h.requestLogger(r).Error().Err(err).Msg("Failed to stat file")
 		http.Error(w, "Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -55,31 +59,36 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 		rawEntries = append(rawEntries, h.getChildren(cleanPath)...)
 	}
 
-	entries := make([]entry, 0, len(rawEntries)+1)
+	entries := make([]propfindEntry, 0, len(rawEntries)+1)
 	// Add the current file itself
-	entries = append(entries, entry{
-		escHref: xmlEscape(fastEscapePath(cleanPath)),
+	entries = append(entries, propfindEntry{
+		escHref: xmlEscape(fastEscapePath(hrefPath)),
 		escName: xmlEscape(fi.Name()),
 		isDir:   fi.IsDir(),
 		size:    fi.Size(),
 		modTime: fi.ModTime().Format(time.RFC3339),
 	})
+	var failed []string
 	for _, info := range rawEntries {
-
-		nm := info.Name()
-		// build raw href
-		href := path.Join("/", cleanPath, nm)
-		if info.IsDir() {
-			href += "/"
+		e, err := newPropfindEntry(hrefPath, info)
+		if err == nil {
+			entries = append(entries, e)
+			continue
 		}
-
-		entries = append(entries, entry{
-			escHref: xmlEscape(fastEscapePath(href)),
-			escName: xmlEscape(nm),
-			isDir:   info.IsDir(),
-			size:    info.Size(),
-			modTime: info.ModTime().Format(time.RFC3339),
-		})
+		switch h.propfindErrors {
+		case config.PropfindErrorsFail:
+			h.requestLogger(r).Error().Err(err).Str("path", cleanPath).Msg("Failed to list an entry")
+			http.Error(w, "Server Error", http.StatusInternalServerError)
+			return
+		case config.PropfindErrorsMark:
+			if e.escHref != "" {
+				entries = append(entries, e)
+			}
+		}
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		h.requestLogger(r).Warn().Str("path", cleanPath).Strs("entries", failed).Msgf("%d entries could not be listed", len(failed))
 	}
 
 	sb := stringbuf.New("")
@@ -94,6 +103,11 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 		_, _ = sb.WriteString(`<d:href>`)
 		_, _ = sb.WriteString(e.escHref)
 		_, _ = sb.WriteString(`</d:href>`)
+		if e.failed {
+			_, _ = sb.WriteString(`<d:status>HTTP/1.1 500 Internal Server Error</d:status>`)
+			_, _ = sb.WriteString(`</d:response>`)
+			continue
+		}
 		_, _ = sb.WriteString(`<d:propstat>`)
 		_, _ = sb.WriteString(`<d:prop>`)
 
@@ -132,6 +146,54 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(sb.Bytes())
 }
 
+// newPropfindEntry describes a child of dir. On error, the entry only carries a best effort href, to be listed as failed.
+// Missing metadata, including a nil *FileInfo, is returned as an error.
+func newPropfindEntry(dir string, info os.FileInfo) (e propfindEntry, err error) {
+	if fi, ok := info.(*FileInfo); info == nil || ok && fi == nil {
+		return propfindEntry{failed: true}, fmt.Errorf("missing entry in %s", dir)
+	}
+
+	nm := info.Name()
+	// build raw href
+	href := path.Join("/", dir, strings.ToValidUTF8(nm, "\uFFFD"))
+	if info.IsDir() {
+		href += "/"
+	}
+	e = propfindEntry{
+		escHref: xmlEscape(fastEscapePath(href)),
+		escName: xmlEscape(nm),
+		isDir:   info.IsDir(),
+		size:    info.Size(),
+		modTime: info.ModTime().Format(time.RFC3339),
+	}
+
+	switch {
+	case nm == "" || nm == "." || nm == ".." || strings.Contains(nm, "/"):
+		err = fmt.Errorf("invalid name %q", nm)
+	case !validXMLText(nm):
+		err = fmt.Errorf("name %q can't be represented in XML", nm)
+	case e.size < 0:
+		err = fmt.Errorf("%s: invalid size %d", nm, e.size)
+	}
+	if err != nil {
+		e.failed = true
+	}
+	return e, err
+}
+
+// validXMLText reports whether s is valid UTF-8 made of characters allowed in XML 1.0
+func validXMLText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF {
+			return false
+		}
+	}
+	return true
+}
+
 // Basic XML escaping function
 func xmlEscape(s string) string {
 	var b strings.Builder
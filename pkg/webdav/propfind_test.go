@@ -0,0 +1,39 @@
+package webdav
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewPropfindEntry(t *testing.T) {
+	var missing *FileInfo
+	tests := []struct {
+		name     string
+		info     os.FileInfo
+		wantErr  bool
+		wantHref string
+	}{
+		{"file", &FileInfo{name: "Movie & Co.mkv", size: 10}, false, "/__all__/Movie%20%26%20Co.mkv"},
+		{"directory", &FileInfo{name: "Movie", isDir: true}, false, "/__all__/Movie/"},
+		{"nil", nil, true, ""},
+		{"nil file info", missing, true, ""},
+		{"empty name", &FileInfo{name: ""}, true, "/__all__"},
+		{"slash", &FileInfo{name: "a/b.mkv"}, true, "/__all__/a/b.mkv"},
+		{"control character", &FileInfo{name: "Movie\x01.mkv"}, true, ""},
+		{"invalid UTF-8", &FileInfo{name: "Movie\xff.mkv"}, true, "/__all__/Movie%EF%BF%BD.mkv"},
+		{"negative size", &FileInfo{name: "Movie.mkv", size: -1}, true, "/__all__/Movie.mkv"},
+	}
+	for _, tt := range tests {
+		e, err := newPropfindEntry("/__all__", tt.info)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: newPropfindEntry() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if e.failed != tt.wantErr {
+			t.Errorf("%s: failed = %v, want %v", tt.name, e.failed, tt.wantErr)
+		}
+		if tt.wantHref != "" && e.escHref != tt.wantHref {
+			t.Errorf("%s: href = %s, want %s", tt.name, e.escHref, tt.wantHref)
+		}
+	}
+}
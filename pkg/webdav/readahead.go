@@ -0,0 +1,241 @@
+package webdav
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// fetchTimeout bounds the fetch of a chunk, which isn't tied to a single client request
+const fetchTimeout = 2 * time.Minute
+
+// readAheadChunk is a part of a file fetched once for all the range requests it covers.
+// Its fields are written under readAheadBuffer.mu, data and err are only read once done is closed.
+type readAheadChunk struct {
+	key     string // Download link of the file
+	start   int64  // First byte of the chunk in the file
+	end     int64  // Last byte of the chunk in the file, lowered on a short read
+	data    []byte
+	err     error
+	done    chan struct{}
+	expires time.Time
+
+	ctx     context.Context // Of the fetch, shared by the requests waiting for it
+	cancel  context.CancelFunc
+	waiters int // Requests waiting for the fetch, it's cancelled when the last one gives up
+
+	elem    *list.Element // Position in the LRU, once fetched and cached
+	removed bool
+}
+
+// readAheadBuffer coalesces near-simultaneous range requests on the same file.
+// A small range request fetches a larger chunk starting at its offset, requests falling within that chunk
+// during the window are served from memory, or wait for the fetch in flight. Requests outside of it fetch a new chunk.
+//
+// With a cache size, the chunks are kept in an LRU bounded by that size until the download link expires, and
+// sequential reads prefetch the chunk following the one they read, so linear playback finds the next range in
+// memory. Seeks don't prefetch. Chunks are keyed by download link, a refreshed link doesn't use stale chunks.
+type readAheadBuffer struct {
+	size      int64
+	window    time.Duration
+	cacheSize int64
+	linkTTL   time.Duration
+
+	mu      sync.Mutex
+	chunks  map[string][]*readAheadChunk // download link -> chunks
+	lru     *list.List                   // cached chunks, most recently used first
+	used    int64                        // bytes of the cached chunks
+	lastEnd map[string]int64             // download link -> last byte of the last read, to detect sequential reads
+}
+
+// newReadAheadBuffer returns nil if both coalescing and the cache are disabled
+func newReadAheadBuffer(size int64, window time.Duration, cacheSize int64, linkTTL time.Duration) *readAheadBuffer {
+	if size <= 0 || (window <= 0 && cacheSize <= 0) {
+		return nil
+	}
+	return &readAheadBuffer{
+		size:      size,
+		window:    window,
+		cacheSize: cacheSize,
+		linkTTL:   linkTTL,
+		chunks:    make(map[string][]*readAheadChunk),
+		lru:       list.New(),
+		lastEnd:   make(map[string]int64),
+	}
+}
+
+// covers reports whether a range of length bytes can be served by a chunk
+func (b *readAheadBuffer) covers(length int64) bool {
+	return b != nil && length > 0 && length <= b.size
+}
+
+// get returns the bytes start-end(inclusive) of the file, fetching a chunk from start if no chunk covers them.
+// fetch must return the bytes chunkStart-chunkEnd of the file, until its context is done. The fetch of a chunk runs
+// on its own context, a request giving up doesn't fail the others waiting for it, and it's cancelled once they all
+// gave up.
+func (b *readAheadBuffer) get(ctx context.Context, key string, start, end, fileSize int64, fetch func(ctx context.Context, chunkStart, chunkEnd int64) ([]byte, error)) ([]byte, error) {
+	b.mu.Lock()
+	now := time.Now()
+	b.prune(now)
+
+	last, seen := b.lastEnd[key]
+	sequential := b.cacheSize > 0 && seen && last+1-b.size < start && start <= last+1
+	b.lastEnd[key] = end
+
+	chunk := b.find(key, start, end)
+	if chunk == nil {
+		chunk = b.add(key, start, fileSize, now)
+		go b.fetch(chunk, fetch)
+	} else if chunk.elem != nil {
+		b.lru.MoveToFront(chunk.elem)
+	}
+	chunk.waiters++
+	b.mu.Unlock()
+
+	select {
+	case <-chunk.done:
+		b.mu.Lock()
+		chunk.waiters--
+		b.mu.Unlock()
+	case <-ctx.Done():
+		b.mu.Lock()
+		chunk.waiters--
+		if chunk.waiters == 0 {
+			// Nobody wants the chunk anymore, later requests fetch a new one
+			chunk.cancel()
+			b.drop(chunk)
+		}
+		b.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	if chunk.err != nil {
+		return nil, chunk.err
+	}
+	if sequential {
+		b.prefetch(key, chunk.end+1, fileSize, fetch)
+	}
+	if end > chunk.end {
+		// The chunk came back shorter than the request, fetch the request alone
+		return fetch(ctx, start, end)
+	}
+	return chunk.data[start-chunk.start : end-chunk.start+1], nil
+}
+
+// prefetch fetches the chunk from start in the background, unless a chunk already covers start
+func (b *readAheadBuffer) prefetch(key string, start, fileSize int64, fetch func(ctx context.Context, chunkStart, chunkEnd int64) ([]byte, error)) {
+	if start >= fileSize {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.find(key, start, start) != nil {
+		return
+	}
+	chunk := b.add(key, start, fileSize, time.Now())
+	go b.fetch(chunk, fetch)
+}
+
+// find returns the chunk covering start-end, b.mu must be held
+func (b *readAheadBuffer) find(key string, start, end int64) *readAheadChunk {
+	for _, c := range b.chunks[key] {
+		if c.start <= start && end <= c.end {
+			return c
+		}
+	}
+	return nil
+}
+
+// add registers a chunk from start to be fetched, b.mu must be held.
+// Cached chunks live as long as the download link, coalescing ones for the window.
+func (b *readAheadBuffer) add(key string, start, fileSize int64, now time.Time) *readAheadChunk {
+	lifetime := b.window
+	if b.cacheSize > 0 {
+		lifetime = b.linkTTL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	chunk := &readAheadChunk{
+		key:     key,
+		start:   start,
+		end:     min(start+b.size, fileSize) - 1,
+		done:    make(chan struct{}),
+		expires: now.Add(lifetime),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	b.chunks[key] = append(b.chunks[key], chunk)
+	return chunk
+}
+
+// fetch downloads the chunk and, with a cache size, adds it to the LRU, evicting the least recently used chunks
+func (b *readAheadBuffer) fetch(chunk *readAheadChunk, fetch func(ctx context.Context, chunkStart, chunkEnd int64) ([]byte, error)) {
+	defer chunk.cancel()
+	data, err := fetch(chunk.ctx, chunk.start, chunk.end)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	chunk.data, chunk.err = data, err
+	if err == nil && int64(len(data)) < chunk.end-chunk.start+1 {
+		// Short read, only keep what was received
+		chunk.end = chunk.start + int64(len(data)) - 1
+	}
+	close(chunk.done)
+
+	if err != nil {
+		b.drop(chunk)
+		return
+	}
+	if b.cacheSize <= 0 || chunk.removed {
+		return
+	}
+	chunk.elem = b.lru.PushFront(chunk)
+	b.used += int64(len(chunk.data))
+	for b.used > b.cacheSize && b.lru.Len() > 0 {
+		b.drop(b.lru.Back().Value.(*readAheadChunk))
+	}
+}
+
+// prune drops the expired chunks, b.mu must be held
+func (b *readAheadBuffer) prune(now time.Time) {
+	var expired []*readAheadChunk
+	for _, chunks := range b.chunks {
+		for _, c := range chunks {
+			if !now.Before(c.expires) {
+				expired = append(expired, c)
+			}
+		}
+	}
+	for _, c := range expired {
+		b.drop(c)
+	}
+	for key := range b.lastEnd {
+		if _, ok := b.chunks[key]; !ok {
+			delete(b.lastEnd, key)
+		}
+	}
+}
+
+// drop removes a chunk from the buffer and the LRU, b.mu must be held.
+// Requests already holding the chunk still read it.
+func (b *readAheadBuffer) drop(chunk *readAheadChunk) {
+	if chunk.removed {
+		return
+	}
+	chunk.removed = true
+	if chunk.elem != nil {
+		b.lru.Remove(chunk.elem)
+		b.used -= int64(len(chunk.data))
+		chunk.elem = nil
+	}
+	chunks := b.chunks[chunk.key]
+	for i, c := range chunks {
+		if c == chunk {
+			b.chunks[chunk.key] = append(chunks[:i], chunks[i+1:]...)
+			break
+		}
+	}
+	if len(b.chunks[chunk.key]) == 0 {
+		delete(b.chunks, chunk.key)
+	}
+}
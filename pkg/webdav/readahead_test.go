@@ -0,0 +1,203 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFile serves ranges of its data, counting the fetches by start offset
+type fakeFile struct {
+	data    []byte
+	mu      sync.Mutex
+	fetches map[int64]int
+	delay   time.Duration
+}
+
+func newFakeFile(size int) *fakeFile {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return &fakeFile{data: data, fetches: make(map[int64]int)}
+}
+
+func (f *fakeFile) fetch(ctx context.Context, start, end int64) ([]byte, error) {
+	f.mu.Lock()
+	f.fetches[start]++
+	f.mu.Unlock()
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return f.data[start : end+1], nil
+}
+
+func (f *fakeFile) fetched(start int64) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches[start]
+}
+
+func TestReadAheadCoalesces(t *testing.T) {
+	file := newFakeFile(1000)
+	file.delay = 50 * time.Millisecond
+	b := newReadAheadBuffer(100, time.Second, 0, time.Hour)
+	size := int64(len(file.data))
+
+	var wg sync.WaitGroup
+	for _, start := range []int64{0, 10, 50} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := b.get(context.Background(), "link", start, start+9, size, file.fetch)
+			if err != nil || !bytes.Equal(data, file.data[start:start+10]) {
+				t.Errorf("get(%d) = %v, %v, want the file bytes", start, data, err)
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	if got := file.fetched(0); got != 1 {
+		t.Errorf("chunk fetched %d times, want once for the 3 requests", got)
+	}
+}
+
+func TestReadAheadRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		ranges      [][2]int64 // Requested one after the other
+		wantFetches map[int64]int
+	}{
+		{"overlapping within a chunk", [][2]int64{{0, 9}, {5, 49}, {90, 99}}, map[int64]int{0: 1}},
+		{"overlapping past the chunk end", [][2]int64{{0, 9}, {95, 104}}, map[int64]int{0: 1, 95: 1}},
+		{"non-overlapping", [][2]int64{{0, 9}, {500, 509}}, map[int64]int{0: 1, 500: 1}},
+		{"seek before the chunk", [][2]int64{{200, 209}, {150, 159}}, map[int64]int{200: 1, 150: 1}},
+		{"chunk cut at the end of the file", [][2]int64{{950, 959}, {990, 999}}, map[int64]int{950: 1}},
+	}
+	for _, tt := range tests {
+		file := newFakeFile(1000)
+		b := newReadAheadBuffer(100, time.Second, 0, time.Hour)
+		for _, r := range tt.ranges {
+			data, err := b.get(context.Background(), "link", r[0], r[1], int64(len(file.data)), file.fetch)
+			if err != nil || !bytes.Equal(data, file.data[r[0]:r[1]+1]) {
+				t.Errorf("%s: get(%d-%d) = %v, %v, want the file bytes", tt.name, r[0], r[1], data, err)
+			}
+		}
+		file.mu.Lock()
+		if !maps.Equal(file.fetches, tt.wantFetches) {
+			t.Errorf("%s: fetches = %v, want %v", tt.name, file.fetches, tt.wantFetches)
+		}
+		file.mu.Unlock()
+	}
+
+	// Past the window, an overlapping request fetches again
+	file := newFakeFile(1000)
+	b := newReadAheadBuffer(100, 10*time.Millisecond, 0, time.Hour)
+	for range 2 {
+		if _, err := b.get(context.Background(), "link", 0, 9, int64(len(file.data)), file.fetch); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := file.fetched(0); got != 2 {
+		t.Errorf("chunk fetched %d times across two windows, want twice", got)
+	}
+}
+
+func TestReadAheadPrefetch(t *testing.T) {
+	file := newFakeFile(1000)
+	b := newReadAheadBuffer(100, time.Second, 1000, time.Hour)
+	size := int64(len(file.data))
+	get := func(start, end int64) {
+		t.Helper()
+		data, err := b.get(context.Background(), "link", start, end, size, file.fetch)
+		if err != nil || !bytes.Equal(data, file.data[start:end+1]) {
+			t.Fatalf("get(%d-%d) = %v, %v, want the file bytes", start, end, data, err)
+		}
+	}
+
+	get(0, 49)
+	get(50, 99) // Sequential, prefetches the next chunk
+	waitFor(t, func() bool { return file.fetched(100) == 1 })
+	get(100, 149)
+	if got := file.fetched(100); got != 1 {
+		t.Errorf("prefetched chunk fetched %d times, want once", got)
+	}
+
+	get(700, 709) // A seek doesn't prefetch
+	time.Sleep(20 * time.Millisecond)
+	if got := file.fetched(800); got != 0 {
+		t.Errorf("chunk after a seek fetched %d times, want 0", got)
+	}
+}
+
+func TestReadAheadCacheSize(t *testing.T) {
+	file := newFakeFile(1000)
+	b := newReadAheadBuffer(100, time.Second, 250, time.Hour)
+	size := int64(len(file.data))
+	for _, start := range []int64{0, 300, 600} {
+		if _, err := b.get(context.Background(), "link", start, start+9, size, file.fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.mu.Lock()
+	used, cached := b.used, b.lru.Len()
+	b.mu.Unlock()
+	if used > 250 || cached != 2 {
+		t.Errorf("%d bytes in %d chunks cached, want at most 250 bytes in 2 chunks", used, cached)
+	}
+	if _, err := b.get(context.Background(), "link", 0, 9, size, file.fetch); err != nil {
+		t.Fatal(err)
+	}
+	if got := file.fetched(0); got != 2 {
+		t.Errorf("evicted chunk fetched %d times, want it fetched again", got)
+	}
+}
+
+// A request giving up doesn't fail the others waiting for the same chunk
+func TestReadAheadWaiterGivesUp(t *testing.T) {
+	file := newFakeFile(1000)
+	file.delay = 100 * time.Millisecond
+	b := newReadAheadBuffer(100, time.Second, 0, time.Hour)
+	size := int64(len(file.data))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.get(ctx, "link", 0, 9, size, file.fetch)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.get(context.Background(), "link", 10, 19, size, file.fetch)
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("cancelled request succeeded")
+	}
+	if err := <-result; err != nil {
+		t.Errorf("other request failed with %v", err)
+	}
+	if got := file.fetched(0); got != 1 {
+		t.Errorf("chunk fetched %d times, want once", got)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirrobot01/decypharr/internal/config"
+	"io"
+	"net/http"
+)
+
+// errStaleHandle ends the streams of a torrent reinserted by the repair, with the terminate stale_handles policy
+var errStaleHandle = errors.New("torrent reinserted, stream terminated for the client to reopen it")
+
+// streamReinsertAware streams the file, handling a reinsert of its torrent by the repair during the stream
+// according to the stale_handles policy of the debrid
+func (f *File) streamReinsertAware(w http.ResponseWriter, r *http.Request) error {
+	var release func()
+	f.reinserted, release = f.cache.Reinserted(f.torrentName)
+	defer release()
+	if f.staleHandles != config.StaleHandlesTerminate {
+		return f.streamWithRetry(w, r, 0)
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-f.reinserted:
+			cancel(errStaleHandle)
+		case <-ctx.Done():
+		}
+	}()
+	err := f.streamWithRetry(w, r.WithContext(ctx), 0)
+	if errors.Is(context.Cause(ctx), errStaleHandle) {
+		_log := f.cache.Logger()
+		_log.Info().Str("file", f.name).Msg("Torrent reinserted, terminating its stream")
+		return &streamError{Err: errStaleHandle, StatusCode: http.StatusServiceUnavailable}
+	}
+	return err
+}
+
+// wasReinserted reports whether the torrent of the file was reinserted since its stream started
+func (f *File) wasReinserted() bool {
+	select {
+	case <-f.reinserted:
+		return true
+	default:
+		return false
+	}
+}
+
+// resumeReinserted continues a stream that failed after sent bytes, once its torrent was reinserted, from the link of
+// the file in the reinserted torrent. It returns streamErr if the stream can't be resumed.
+func (f *File) resumeReinserted(w http.ResponseWriter, upstreamReq *http.Request, sent int64, streamErr error) error {
+	var se *streamError
+	if errors.As(streamErr, &se) && se.IsClientDisconnection || upstreamReq.Context().Err() != nil {
+		return streamErr
+	}
+	start, end, ok := upstreamRange(upstreamReq)
+	if !ok {
+		return streamErr
+	}
+	ct := f.cache.GetTorrentByName(f.torrentName)
+	if ct == nil {
+		return streamErr
+	}
+	file, ok := ct.GetFile(f.name)
+	if !ok || file.Link == "" {
+		return streamErr
+	}
+	f.link, f.downloadLink = file.Link, ""
+	downloadLink, err := f.getDownloadLink()
+	if err != nil {
+		return streamErr
+	}
+
+	_log := f.cache.Logger()
+	_log.Info().Str("file", f.name).Int64("offset", start+sent).Msg("Torrent reinserted, resuming the stream from its new link")
+	req, err := http.NewRequestWithContext(upstreamReq.Context(), http.MethodGet, downloadLink, nil)
+	if err != nil {
+		return streamErr
+	}
+	req.Header = upstreamReq.Header.Clone()
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", start+sent, end))
+	resp, err := f.doUpstream(req)
+	if err != nil {
+		return streamErr
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return streamErr
+	}
+	return f.streamBuffer(w, resp.Body)
+}
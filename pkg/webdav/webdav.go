@@ -87,16 +87,26 @@ func init() {
 type WebDav struct {
 	Handlers []*Handler
 	URLBase  string
+	layout   config.RootLayout
 }
 
 func New() *WebDav {
-	urlBase := config.Get().URLBase
+	cfg := config.Get()
+	urlBase := cfg.URLBase
 	w := &WebDav{
 		Handlers: make([]*Handler, 0),
 		URLBase:  urlBase,
+		layout:   cfg.WebDav.RootLayout,
 	}
-	for name, c := range store.Get().Debrid().Caches() {
-		h := NewHandler(name, urlBase, c, c.Logger())
+	debrids := store.Get().Debrid()
+	caches := debrids.Caches()
+	// Keep the order of the config, the first debrid wins when merged layouts list the same torrent twice
+	for _, dc := range cfg.Debrids {
+		c, ok := caches[dc.Name]
+		if !ok {
+			continue
+		}
+		h := NewHandler(dc.Name, urlBase, c, c.Logger(), debrids.Debrid(dc.Name).RecordTraffic)
 		w.Handlers = append(w.Handlers, h)
 	}
 	return w
@@ -162,6 +172,7 @@ func (wd *WebDav) mountHandlers(r chi.Router) {
 func (wd *WebDav) setupRootHandler(r chi.Router) {
 	r.Get("/", wd.handleGetRoot())
 	r.MethodFunc("PROPFIND", "/", wd.handleWebdavRoot())
+	r.HandleFunc("/*", wd.handleLayout)
 }
 
 func (wd *WebDav) commonMiddleware(next http.Handler) http.Handler {
@@ -178,14 +189,26 @@ func (wd *WebDav) commonMiddleware(next http.Handler) http.Handler {
 
 func (wd *WebDav) handleGetRoot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectDirectoryGet(w, config.Get().WebDav.DirectoryGet) {
+			return
+		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+		root := path.Join(wd.URLBase, "webdav")
+		type share struct {
+			Name string
+			Path string
+		}
+		var shares []share
+		for _, fi := range wd.rootChildren() {
+			shares = append(shares, share{Name: fi.Name(), Path: path.Join(root, fi.Name())})
+		}
 		data := struct {
-			Handlers []*Handler
-			URLBase  string
+			Shares  []share
+			URLBase string
 		}{
-			Handlers: wd.Handlers,
-			URLBase:  wd.URLBase,
+			Shares:  shares,
+			URLBase: wd.URLBase,
 		}
 		if err := tplRoot.Execute(w, data); err != nil {
 			return
@@ -202,17 +225,7 @@ func (wd *WebDav) handleWebdavRoot() http.HandlerFunc {
 			modTime: time.Now(),
 			isDir:   true,
 		}
-		children := make([]os.FileInfo, 0, len(wd.Handlers))
-		for _, h := range wd.Handlers {
-			children = append(children, &FileInfo{
-				name:    h.Name,
-				size:    0,
-				mode:    0755 | os.ModeDir,
-				modTime: time.Now(),
-				isDir:   true,
-			})
-		}
-		sb := filesToXML(path.Clean(r.URL.Path), fi, children)
+		sb := filesToXML(path.Clean(r.URL.Path), fi, wd.rootChildren())
 		writeXml(w, http.StatusMultiStatus, sb)
 
 	}